@@ -2,16 +2,24 @@ package main
 
 import (
 	"context"
+	"errors"
 	"os/signal"
 	"sync"
 	"syscall"
 
 	"github.com/sbxb/shorty/internal/app/api"
+	"github.com/sbxb/shorty/internal/app/auth"
 	"github.com/sbxb/shorty/internal/app/config"
 	"github.com/sbxb/shorty/internal/app/logger"
+	"github.com/sbxb/shorty/internal/app/oidc"
 	"github.com/sbxb/shorty/internal/app/storage"
+	"github.com/sbxb/shorty/internal/app/storage/bloom"
+	"github.com/sbxb/shorty/internal/app/storage/breaker"
+	"github.com/sbxb/shorty/internal/app/storage/cache"
 	"github.com/sbxb/shorty/internal/app/storage/inmemory"
 	"github.com/sbxb/shorty/internal/app/storage/psql"
+	"github.com/sbxb/shorty/internal/app/storage/redisdb"
+	"github.com/sbxb/shorty/internal/app/storage/spool"
 )
 
 func main() {
@@ -24,34 +32,157 @@ func main() {
 		logger.Fatalln(err)
 	}
 
-	var store storage.Storage
+	ctx, stop := signal.NotifyContext(
+		context.Background(), syscall.SIGTERM, syscall.SIGINT,
+	)
+	defer stop()
+
+	backend, err := newStorage(cfg)
+	if err != nil {
+		logger.Fatalln(err)
+	}
+	defer backend.Close()
+
+	store := backend
+	var caches []psql.Invalidator
 
 	if cfg.DatabaseDSN != "" {
-		store, err = psql.NewDBStorage(cfg.DatabaseDSN)
-	} else {
-		store, err = inmemory.NewFileMapStorage(cfg.FileStoragePath)
+		// Fail fast with 503 while the database is down instead of waiting
+		// for timeouts, redirects are still served from the cache (if any)
+		store = breaker.New(store, breaker.DefaultSettings, psql.IsConnectionError)
+	}
+
+	// Keep accepting writes while the database is unavailable
+	if cfg.DatabaseDSN != "" && cfg.WriteSpoolPath != "" {
+		spooled, err := spool.New(store, cfg.WriteSpoolPath, psql.IsConnectionError)
+		if err != nil {
+			logger.Fatalln(err)
+		}
+		store = spooled
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			spooled.Run(ctx, spool.DefaultReplayInterval)
+		}()
+	}
+
+	// Answer unknown ids without asking the storage, ids added by other
+	// instances sharing the database are passed by the listener below,
+	// config refuses the filter for Redis shared with no such feed
+	if cfg.BloomCapacity > 0 {
+		scanner, ok := backend.(storage.IDScanner)
+		if !ok {
+			logger.Fatalln("bloom filter is not supported by the storage")
+		}
+		filtered, err := bloom.New(ctx, store, scanner, cfg.BloomCapacity, cfg.BloomFPRate)
+		if err != nil {
+			logger.Fatalln(err)
+		}
+		store = filtered
+		caches = append(caches, filtered)
+	}
+
+	if cfg.CacheSize > 0 {
+		cached, err := cache.New(store, cfg.CacheSize)
+		if err != nil {
+			logger.Fatalln(err)
+		}
+		store = cached
+		caches = append(caches, cached)
+	}
+
+	// ACLs are checked on every redirect, so they are cached along with
+	// redirects where other instances notify about changes
+	var acls storage.ACLStorage
+	if cfg.LinkACLs {
+		var ok bool
+		if acls, ok = backend.(storage.ACLStorage); !ok {
+			logger.Fatalln("link ACLs are not supported by the storage")
+		}
+		if cfg.CacheSize > 0 && cfg.DatabaseDSN != "" {
+			cached, err := cache.NewACLCache(acls, cfg.CacheSize)
+			if err != nil {
+				logger.Fatalln(err)
+			}
+			acls = cached
+			caches = append(caches, cached)
+		}
+	}
+
+	// Passwords are checked on every redirect as well
+	var passwords storage.LinkPasswordStorage
+	if cfg.LinkPasswords {
+		var ok bool
+		if passwords, ok = backend.(storage.LinkPasswordStorage); !ok {
+			logger.Fatalln("link passwords are not supported by the storage")
+		}
+		if cfg.CacheSize > 0 && cfg.DatabaseDSN != "" {
+			cached, err := cache.NewPasswordCache(passwords, cfg.CacheSize)
+			if err != nil {
+				logger.Fatalln(err)
+			}
+			passwords = cached
+			caches = append(caches, cached)
+		}
 	}
+
+	// Keep local caches and the filter in sync with other instances sharing
+	// the database
+	if cfg.DatabaseDSN != "" && len(caches) > 0 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			psql.NewListener(cfg.DatabaseDSN, caches...).Run(ctx)
+		}()
+	}
+
+	signer, err := newSigner(cfg)
 	if err != nil {
 		logger.Fatalln(err)
 	}
-	defer store.Close()
 
-	router := api.NewRouter(store, cfg)
+	// Optional features bypass the wrappers above, they are neither cached
+	// (except for ACLs and passwords) nor spooled
+	var features api.Features
+	features.APIKeys, _ = backend.(storage.APIKeyStorage)
+	features.Users, _ = backend.(storage.UserStorage)
+	features.RecoveryCodes, _ = backend.(storage.RecoveryCodeStorage)
+	features.Roles, _ = backend.(storage.RoleStorage)
+	features.Admin, _ = backend.(storage.AdminStorage)
+	features.Workspaces, _ = backend.(storage.WorkspaceStorage)
+	features.Transfers, _ = backend.(storage.TransferStorage)
+	features.ACLs = acls
+	features.Passwords = passwords
+	features.History, _ = backend.(storage.URLHistoryStorage)
+	features.Meta, _ = backend.(storage.URLMetaStorage)
+	if err := grantAdmins(ctx, features.Roles, cfg.Admins); err != nil {
+		logger.Fatalln(err)
+	}
+	if cfg.OIDCIssuer != "" {
+		features.OIDC, err = oidc.NewProvider(ctx, oidc.Config{
+			Issuer:       cfg.OIDCIssuer,
+			ClientID:     cfg.OIDCClientID,
+			ClientSecret: cfg.OIDCClientSecret,
+			RedirectURL:  cfg.OIDCRedirectURL,
+		})
+		if err != nil {
+			logger.Fatalln(err)
+		}
+	}
+
+	router := api.NewRouter(store, cfg, signer, features)
 	server, err := api.NewHTTPServer(cfg.ServerAddress, router)
 	if err != nil {
 		logger.Fatalln(err)
 	}
 	defer server.Close()
 
-	ctx, stop := signal.NotifyContext(
-		context.Background(), syscall.SIGTERM, syscall.SIGINT,
-	)
-	defer stop()
-
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
 		server.Start(ctx)
+		stop() // stop background goroutines if the server failed to start
 	}()
 
 	wg.Wait()
@@ -59,3 +190,67 @@ func main() {
 		logger.Error(err)
 	}
 }
+
+// grantAdmins grants the admin role to the users configured
+func grantAdmins(ctx context.Context, roles storage.RoleStorage, admins []string) error {
+	if len(admins) == 0 {
+		return nil
+	}
+	if roles == nil {
+		return errors.New("roles are not supported by the storage")
+	}
+
+	for _, uid := range admins {
+		if err := roles.SetRole(ctx, uid, auth.RoleAdmin); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// newSigner creates the user_id cookie signer with the configured keys or
+// a random key if none is configured
+func newSigner(cfg config.Config) (*auth.Signer, error) {
+	signer, err := auth.LoadSigner(cfg.CookieSigningKeyFile, cfg.CookieSigningKey)
+	if err != nil || signer != nil {
+		return signer, err
+	}
+
+	logger.Warning("No cookie signing key configured, using a random one, user ids will not survive restart")
+
+	return auth.NewRandomSigner()
+}
+
+// newStorage creates the storage backend selected by cfg
+func newStorage(cfg config.Config) (storage.Storage, error) {
+	if cfg.DatabaseDSN == "" && cfg.RedisURL != "" {
+		return redisdb.NewRedisStorage(cfg.RedisURL,
+			redisdb.WithLinkTTL(cfg.RedisLinkTTL),
+			redisdb.WithMaxUserLinks(cfg.MaxUserLinks),
+		)
+	}
+
+	if cfg.DatabaseDSN == "" {
+		keyring, err := inmemory.LoadKeyring(cfg.FileStorageKeyFile, cfg.FileStorageKey)
+		if err != nil {
+			return nil, err
+		}
+
+		return inmemory.NewFileMapStorage(cfg.FileStoragePath,
+			inmemory.WithLimits(inmemory.Limits{
+				MaxRecords: cfg.MemoryMaxRecords,
+				MaxBytes:   cfg.MemoryMaxBytes,
+				Policy:     inmemory.EvictionPolicy(cfg.MemoryEvictionPolicy),
+			}),
+			inmemory.WithKeyring(keyring),
+			inmemory.WithMaxUserLinks(cfg.MaxUserLinks),
+		)
+	}
+
+	return psql.NewDBStorage(cfg.DatabaseDSN,
+		psql.WithReplicas(cfg.DatabaseReplicaDSNs...),
+		psql.WithConnectRetry(cfg.DatabaseConnectRetries, cfg.DatabaseConnectBackoff),
+		psql.WithMaxUserLinks(cfg.MaxUserLinks),
+	)
+}
@@ -0,0 +1,277 @@
+package api
+
+import (
+	"expvar"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/sbxb/shorty/internal/app/logger"
+)
+
+// metrics are published at /debug/vars as "enumguard"
+var enumGuardMetrics = expvar.NewMap("enumguard")
+
+// no more than enumGuardMaxClients are tracked, others are evicted
+const enumGuardMaxClients = 100000
+
+// guardSettings define when enumGuard considers a client an enumerator
+type guardSettings struct {
+	// Threshold is the share of misses (404 and 410 responses) among
+	// the client's requests made within Window, it's only checked once
+	// the client made MinRequests requests
+	Threshold   float64
+	MinRequests int
+	Window      time.Duration
+	// every miss over the threshold is a strike, the client's requests are
+	// delayed by DelayStep per strike up to MaxDelay, BlockAfter strikes
+	// block the client for Cooldown
+	DelayStep  time.Duration
+	MaxDelay   time.Duration
+	BlockAfter int
+	Cooldown   time.Duration
+	// Allowlist networks are never tracked
+	Allowlist []*net.IPNet
+}
+
+var defaultGuardSettings = guardSettings{
+	DelayStep:  100 * time.Millisecond,
+	MaxDelay:   2 * time.Second,
+	BlockAfter: 20,
+}
+
+// guardClient is the state of a single client
+type guardClient struct {
+	windowStart  time.Time
+	requests     int
+	misses       int
+	strikes      int
+	blockedUntil time.Time
+}
+
+// enumGuard slows down and then blocks clients which request too many
+// nonexistent or deleted short ids, i.e. probably try to enumerate them
+// Clients are identified by their IP address, IPv6 ones by their /64 network
+type enumGuard struct {
+	settings guardSettings
+
+	mu         sync.Mutex
+	clients    map[string]*guardClient
+	maxClients int
+	lastSweep  time.Time
+	now        func() time.Time
+}
+
+func newEnumGuard(settings guardSettings) *enumGuard {
+	g := &enumGuard{
+		settings:   settings,
+		clients:    make(map[string]*guardClient),
+		maxClients: enumGuardMaxClients,
+		now:        time.Now,
+	}
+	enumGuardMetrics.Set("blocked_clients", expvar.Func(func() interface{} {
+		return g.blockedClients()
+	}))
+
+	return g
+}
+
+// statusWriter remembers the response status
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (g *enumGuard) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ip := clientIP(r)
+		if ip == nil || g.isAllowed(ip) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		key := clientKey(ip)
+
+		delay, blockedFor := g.check(key)
+		if blockedFor > 0 {
+			enumGuardMetrics.Add("rejected", 1)
+			w.Header().Set("Retry-After", strconv.Itoa(ceilSeconds(blockedFor)))
+			http.Error(w, "Too many requests for unknown ids", http.StatusTooManyRequests)
+			return
+		}
+		if delay > 0 {
+			enumGuardMetrics.Add("delayed", 1)
+			timer := time.NewTimer(delay)
+			select {
+			case <-r.Context().Done():
+				timer.Stop()
+				return
+			case <-timer.C:
+			}
+		}
+
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(sw, r)
+
+		g.record(key, sw.status == http.StatusNotFound || sw.status == http.StatusGone)
+	})
+}
+
+// check returns the delay for the client's request or the time left until
+// the client is unblocked
+func (g *enumGuard) check(key string) (delay, blockedFor time.Duration) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	c, ok := g.clients[key]
+	if !ok {
+		return 0, 0
+	}
+
+	now := g.now()
+	if now.Before(c.blockedUntil) {
+		return 0, c.blockedUntil.Sub(now)
+	}
+
+	delay = time.Duration(c.strikes) * g.settings.DelayStep
+	if delay > g.settings.MaxDelay {
+		delay = g.settings.MaxDelay
+	}
+
+	return delay, 0
+}
+
+// record counts the client's request and judges the client
+func (g *enumGuard) record(key string, miss bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	now := g.now()
+	g.sweep(now)
+
+	c, ok := g.clients[key]
+	if !ok {
+		if len(g.clients) >= g.maxClients {
+			g.evict(now)
+		}
+		c = &guardClient{windowStart: now}
+		g.clients[key] = c
+	}
+	if now.Sub(c.windowStart) >= g.settings.Window {
+		// strikes survive a window only if the client is still suspicious
+		if !g.isSuspicious(c) {
+			c.strikes = 0
+		}
+		c.windowStart, c.requests, c.misses = now, 0, 0
+	}
+
+	c.requests++
+	if !miss {
+		return
+	}
+	c.misses++
+	if !g.isSuspicious(c) {
+		return
+	}
+
+	c.strikes++
+	if c.strikes < g.settings.BlockAfter {
+		return
+	}
+
+	logger.Warningf("EnumGuard: client %s blocked for %v, %d of %d requests missed",
+		key, g.settings.Cooldown, c.misses, c.requests)
+	enumGuardMetrics.Add("blocks", 1)
+	*c = guardClient{windowStart: now, blockedUntil: now.Add(g.settings.Cooldown)}
+}
+
+func (g *enumGuard) isSuspicious(c *guardClient) bool {
+	return c.requests >= g.settings.MinRequests &&
+		float64(c.misses) > g.settings.Threshold*float64(c.requests)
+}
+
+// sweep forgets clients which are neither blocked nor active within
+// the last window, it runs at most once per window, the caller must hold
+// the lock
+func (g *enumGuard) sweep(now time.Time) {
+	if now.Sub(g.lastSweep) < g.settings.Window {
+		return
+	}
+	g.lastSweep = now
+
+	for key, c := range g.clients {
+		if now.After(c.blockedUntil) && now.Sub(c.windowStart) >= 2*g.settings.Window {
+			delete(g.clients, key)
+		}
+	}
+}
+
+// evict forgets a client to make room for another one, clients which are
+// neither blocked nor suspected go first, the caller must hold the lock
+func (g *enumGuard) evict(now time.Time) {
+	victim := ""
+	for key, c := range g.clients {
+		if !now.Before(c.blockedUntil) && c.strikes == 0 {
+			delete(g.clients, key)
+			enumGuardMetrics.Add("evictions", 1)
+			return
+		}
+		if victim == "" || !now.Before(c.blockedUntil) {
+			victim = key
+		}
+	}
+	delete(g.clients, victim)
+	enumGuardMetrics.Add("evictions", 1)
+}
+
+func (g *enumGuard) blockedClients() int {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	now := g.now()
+	n := 0
+	for _, c := range g.clients {
+		if now.Before(c.blockedUntil) {
+			n++
+		}
+	}
+
+	return n
+}
+
+func (g *enumGuard) isAllowed(ip net.IP) bool {
+	for _, n := range g.settings.Allowlist {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// clientIP returns the IP address of the client, headers like
+// X-Forwarded-For are not trusted since any client can set them
+func clientIP(r *http.Request) net.IP {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+
+	return net.ParseIP(host)
+}
+
+// clientKey identifies the client by ip, IPv6 clients usually own a whole
+// /64 network, so they are identified by it
+func clientKey(ip net.IP) string {
+	if ip.To4() != nil {
+		return ip.String()
+	}
+
+	return ip.Mask(net.CIDRMask(64, 128)).String() + "/64"
+}
@@ -0,0 +1,150 @@
+package api
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestGuard(allowlist ...*net.IPNet) (*enumGuard, *time.Time) {
+	now := time.Now()
+	g := newEnumGuard(guardSettings{
+		Threshold:   0.5,
+		MinRequests: 4,
+		Window:      time.Minute,
+		DelayStep:   time.Millisecond,
+		MaxDelay:    3 * time.Millisecond,
+		BlockAfter:  3,
+		Cooldown:    10 * time.Minute,
+		Allowlist:   allowlist,
+	})
+	g.now = func() time.Time { return now }
+
+	return g, &now
+}
+
+// found answers 307 for /good and 404 for anything else
+var found = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/good" {
+		w.WriteHeader(http.StatusTemporaryRedirect)
+		return
+	}
+	http.NotFound(w, r)
+})
+
+func get(h http.Handler, remoteAddr, path string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodGet, path, nil)
+	r.RemoteAddr = remoteAddr
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+
+	return w
+}
+
+func TestEnumGuard_BlocksEnumerator(t *testing.T) {
+	g, now := newTestGuard()
+	h := g.middleware(found)
+
+	const client = "192.0.2.1:1234"
+
+	// misses below MinRequests are not judged
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, http.StatusNotFound, get(h, client, "/bad").Code)
+	}
+	delay, _ := g.check("192.0.2.1")
+	assert.Zero(t, delay)
+
+	// strikes delay the client
+	assert.Equal(t, http.StatusNotFound, get(h, client, "/bad").Code)
+	delay, _ = g.check("192.0.2.1")
+	assert.Equal(t, time.Millisecond, delay)
+	assert.Equal(t, http.StatusNotFound, get(h, client, "/bad").Code)
+
+	// the third strike blocks the client, other clients are not affected
+	assert.Equal(t, http.StatusNotFound, get(h, client, "/bad").Code)
+	w := get(h, client, "/good")
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "600", w.Header().Get("Retry-After"))
+	assert.Equal(t, http.StatusTemporaryRedirect, get(h, "192.0.2.2:1234", "/good").Code)
+	assert.Equal(t, 1, g.blockedClients())
+
+	// the block ends after the cooldown
+	*now = now.Add(10*time.Minute + time.Second)
+	assert.Equal(t, http.StatusTemporaryRedirect, get(h, client, "/good").Code)
+	assert.Equal(t, 0, g.blockedClients())
+}
+
+func TestEnumGuard_IgnoresLowMissRatio(t *testing.T) {
+	g, _ := newTestGuard()
+	h := g.middleware(found)
+
+	for i := 0; i < 50; i++ {
+		path := "/good"
+		if i%3 == 0 {
+			path = "/bad"
+		}
+		assert.NotEqual(t, http.StatusTooManyRequests, get(h, "192.0.2.1:1234", path).Code)
+	}
+	delay, blockedFor := g.check("192.0.2.1")
+	assert.Zero(t, delay)
+	assert.Zero(t, blockedFor)
+}
+
+func TestEnumGuard_Allowlist(t *testing.T) {
+	_, allowed, _ := net.ParseCIDR("10.0.0.0/8")
+	g, _ := newTestGuard(allowed)
+	h := g.middleware(found)
+
+	for i := 0; i < 50; i++ {
+		assert.Equal(t, http.StatusNotFound, get(h, "10.1.2.3:1234", "/bad").Code)
+	}
+	assert.Empty(t, g.clients)
+}
+
+func TestEnumGuard_Sweep(t *testing.T) {
+	g, now := newTestGuard()
+	h := g.middleware(found)
+
+	get(h, "192.0.2.1:1234", "/bad")
+	assert.Len(t, g.clients, 1)
+
+	*now = now.Add(3 * time.Minute)
+	get(h, "192.0.2.2:1234", "/bad")
+	assert.Len(t, g.clients, 1)
+	assert.Contains(t, g.clients, "192.0.2.2")
+}
+
+func TestEnumGuard_Bounded(t *testing.T) {
+	g, _ := newTestGuard()
+	g.maxClients = 3
+	h := g.middleware(found)
+
+	// the enumerator is blocked
+	for i := 0; i < 6; i++ {
+		get(h, "192.0.2.1:1234", "/bad")
+	}
+	for i := 0; i < 10; i++ {
+		get(h, "198.51.100."+strconv.Itoa(i)+":1234", "/good")
+	}
+	assert.Len(t, g.clients, 3)
+	// blocked clients are the last to be forgotten
+	assert.Equal(t, http.StatusTooManyRequests, get(h, "192.0.2.1:1234", "/good").Code)
+}
+
+func TestEnumGuard_IPv6_Network(t *testing.T) {
+	g, _ := newTestGuard()
+	h := g.middleware(found)
+
+	// every request comes from another address of the same /64
+	for i := 0; i < 6; i++ {
+		get(h, "[2001:db8::"+strconv.Itoa(i+1)+"]:1234", "/bad")
+	}
+	assert.Len(t, g.clients, 1)
+	assert.Equal(t, http.StatusTooManyRequests, get(h, "[2001:db8::ffff]:1234", "/good").Code)
+	assert.Equal(t, http.StatusTemporaryRedirect, get(h, "[2001:db8:0:1::1]:1234", "/good").Code)
+}
@@ -3,12 +3,19 @@ package api
 import (
 	"compress/gzip"
 	"context"
+	"errors"
 	"io"
 	"net/http"
+	"net/url"
 	"strings"
 	"time"
 
 	"github.com/sbxb/shorty/internal/app/auth"
+	"github.com/sbxb/shorty/internal/app/config"
+	"github.com/sbxb/shorty/internal/app/handlers"
+	"github.com/sbxb/shorty/internal/app/logger"
+	"github.com/sbxb/shorty/internal/app/storage"
+	u "github.com/sbxb/shorty/internal/app/url"
 )
 
 type gzipWriter struct {
@@ -50,28 +57,165 @@ func gzipMW(next http.Handler) http.Handler {
 	})
 }
 
-func authMW(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		cookie, err := r.Cookie("user_id")
-		uid := ""
-		if err != nil || !auth.CheckUserIDCookieValue(cookie.Value) {
-			uid, err = auth.GenerateUserID()
+// newSessionSettings derives cookie attributes from cfg: Secure is set if
+// BaseURL scheme is https, Path is BaseURL path ("/" if empty)
+func newSessionSettings(cfg config.Config) auth.SessionSettings {
+	settings := auth.SessionSettings{
+		Lifetime: cfg.SessionLifetime,
+		Domain:   cfg.CookieDomain,
+		Path:     "/",
+	}
+	if u, err := url.Parse(cfg.BaseURL); err == nil {
+		settings.Secure = u.Scheme == "https"
+		if u.Path != "" {
+			settings.Path = u.Path
+		}
+	}
+
+	return settings
+}
+
+// authMW identifies the user by the API key, the bearer token or, if there
+// are no such headers, by the signed user_id cookie. Invalid keys and tokens
+// are rejected with 401, while a new user id is issued if the cookie is
+// missing, invalid or expired. A valid cookie is re-issued if it's signed
+// with a key other than the current one or it's time to renew the session
+// API keys are looked up in keys, nil keys means API keys are not supported
+func authMW(signer *auth.Signer, keys storage.APIKeyStorage, settings auth.SessionSettings) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			now := time.Now()
+
+			if header := r.Header.Get(auth.APIKeyHeader); header != "" {
+				key, err := lookupAPIKey(r.Context(), keys, header)
+				if err != nil {
+					if !errors.Is(err, storage.ErrAPIKeyNotFound) {
+						logger.Warningf("authMW: API key lookup failed: %v", err)
+					}
+					handlers.JSONError(w, http.StatusUnauthorized, u.ErrorResponse{Error: "Invalid API key"})
+					return
+				}
+				if err := keys.TouchAPIKey(r.Context(), key.ID, now); err != nil {
+					logger.Warningf("authMW: TouchAPIKey failed: %v", err)
+				}
+				ctx := context.WithValue(r.Context(), auth.ContextUserIDKey, key.UserID)
+				ctx = context.WithValue(ctx, auth.ContextNewUserKey, false)
+				ctx = context.WithValue(ctx, auth.ContextScopesKey, auth.AllScopes)
+				ctx = context.WithValue(ctx, auth.ContextAPIKeyIDKey, key.ID)
+				next.ServeHTTP(w, r.WithContext(ctx))
+				return
+			}
+
+			if header := r.Header.Get("Authorization"); header != "" {
+				claims, err := verifyBearer(signer, header, now)
+				if err != nil {
+					w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+					handlers.JSONError(w, http.StatusUnauthorized, u.ErrorResponse{Error: "Invalid token"})
+					return
+				}
+				ctx := context.WithValue(r.Context(), auth.ContextUserIDKey, claims.UserID)
+				ctx = context.WithValue(ctx, auth.ContextNewUserKey, false)
+				ctx = context.WithValue(ctx, auth.ContextScopesKey, claims.Scopes)
+				next.ServeHTTP(w, r.WithContext(ctx))
+				return
+			}
+
+			var (
+				uid      string
+				issuedAt time.Time
+				stale    bool
+				ok       bool
+			)
+			if cookie, err := r.Cookie(auth.SessionCookieName); err == nil {
+				uid, issuedAt, stale, ok = signer.Verify(cookie.Value)
+			}
+			// cookies issued before sessions were introduced have no issue time
+			if ok && !issuedAt.IsZero() {
+				age := now.Sub(issuedAt)
+				ok = age < settings.Lifetime
+				stale = stale || age >= settings.Lifetime/2
+			}
+
+			newUser := !ok
+			if newUser {
+				var err error
+				uid, err = auth.GenerateUserID()
+				if err != nil {
+					io.WriteString(w, err.Error())
+					return
+				}
+			}
+			if newUser || stale {
+				http.SetCookie(w, settings.Cookie(signer, uid, now))
+			}
+			ctx := context.WithValue(r.Context(), auth.ContextUserIDKey, uid)
+			ctx = context.WithValue(ctx, auth.ContextNewUserKey, newUser)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// lookupAPIKey returns the stored key matching the one from X-API-Key header
+func lookupAPIKey(ctx context.Context, keys storage.APIKeyStorage, header string) (storage.APIKey, error) {
+	header = strings.TrimSpace(header)
+	if keys == nil || !auth.LooksLikeAPIKey(header) {
+		return storage.APIKey{}, storage.ErrAPIKeyNotFound
+	}
+
+	return keys.GetAPIKeyByHash(ctx, auth.HashAPIKey(header))
+}
+
+// verifyBearer returns the claims of the token from Authorization header
+func verifyBearer(signer *auth.Signer, header string, now time.Time) (auth.TokenClaims, error) {
+	const prefix = "bearer "
+	if len(header) <= len(prefix) || strings.ToLower(header[:len(prefix)]) != prefix {
+		return auth.TokenClaims{}, auth.ErrInvalidToken
+	}
+
+	return signer.VerifyToken(strings.TrimSpace(header[len(prefix):]), now)
+}
+
+// scopeMW rejects requests authenticated with a token which doesn't grant
+// scope, requests authenticated with the cookie are allowed everything
+func scopeMW(scope string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !auth.HasScope(r.Context(), scope) {
+				logger.Warningf("authorization failure: user %s token lacks scope %s for %s %s",
+					handlers.GetUserID(r.Context()), scope, r.Method, r.URL.Path)
+				w.Header().Set("WWW-Authenticate", `Bearer error="insufficient_scope", scope="`+scope+`"`)
+				handlers.JSONError(w, http.StatusForbidden, u.ErrorResponse{Error: "Token scope " + scope + " required"})
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// roleMW rejects requests of users whose role doesn't grant required, users
+// without a role stored are ordinary users, every failure is logged
+func roleMW(roles storage.RoleStorage, required string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			uid := handlers.GetUserID(r.Context())
+			role, err := roles.GetRole(r.Context(), uid)
 			if err != nil {
-				io.WriteString(w, err.Error())
+				logger.Warningf("roleMW: GetRole failed: %v", err)
+				http.Error(w, "Server failed to check role", http.StatusInternalServerError)
 				return
 			}
-			cookie := http.Cookie{
-				Name:    "user_id",
-				Value:   auth.GetUserIDCookieValue(uid),
-				Expires: time.Now().Add(1 * time.Hour),
+			if role == "" {
+				role = auth.RoleUser
 			}
-			http.SetCookie(w, &cookie)
-		} else {
-			uid = cookie.Value[:32]
-		}
-		ctx := context.WithValue(r.Context(), auth.ContextUserIDKey, uid)
-		next.ServeHTTP(w, r.WithContext(ctx))
-	})
+			if !auth.RoleAllows(role, required) {
+				logger.Warningf("authorization failure: user %s with role %s requires %s for %s %s",
+					uid, role, required, r.Method, r.URL.Path)
+				handlers.JSONError(w, http.StatusForbidden, u.ErrorResponse{Error: "Role " + required + " required"})
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
 }
 
 func jsonEncMW(next http.Handler) http.Handler {
@@ -0,0 +1,232 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sbxb/shorty/internal/app/auth"
+	"github.com/sbxb/shorty/internal/app/config"
+	"github.com/sbxb/shorty/internal/app/storage"
+	"github.com/sbxb/shorty/internal/app/storage/inmemory"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testSession = auth.SessionSettings{Lifetime: 24 * time.Hour, Path: "/"}
+
+// newAuthTest returns the handler wrapped by authMW and a pointer to
+// the user id seen by the handler
+func newAuthTest(signer *auth.Signer, settings auth.SessionSettings) (func(cookie string) *http.Response, *string) {
+	var gotUID string
+	h := authMW(signer, nil, settings)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotUID, _ = r.Context().Value(auth.ContextUserIDKey).(string)
+	}))
+
+	return func(cookie string) *http.Response {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		if cookie != "" {
+			r.AddCookie(&http.Cookie{Name: "user_id", Value: cookie})
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+
+		return w.Result()
+	}, &gotUID
+}
+
+func TestAuthMW_ReissuesStaleCookie(t *testing.T) {
+	old, err := auth.ParseSigningKeys(strings.NewReader("k1:first-secret-key-0001"))
+	require.NoError(t, err)
+	rotated, err := auth.ParseSigningKeys(strings.NewReader("k2:second-secret-key-002\nk1:first-secret-key-0001"))
+	require.NoError(t, err)
+	request, gotUID := newAuthTest(rotated, testSession)
+
+	uid, _ := auth.GenerateUserID()
+	now := time.Now()
+
+	// signed with the retired key: same user, new cookie
+	resp := request(old.Sign(uid, now))
+	assert.Equal(t, uid, *gotUID)
+	require.Len(t, resp.Cookies(), 1)
+	reissued, _, stale, ok := rotated.Verify(resp.Cookies()[0].Value)
+	assert.True(t, ok)
+	assert.False(t, stale)
+	assert.Equal(t, uid, reissued)
+
+	// signed with the current key: nothing to re-issue
+	resp = request(rotated.Sign(uid, now))
+	assert.Equal(t, uid, *gotUID)
+	assert.Empty(t, resp.Cookies())
+
+	// forged: new user
+	resp = request(uid + ".0.k2.00")
+	assert.NotEqual(t, uid, *gotUID)
+	require.Len(t, resp.Cookies(), 1)
+}
+
+func TestAuthMW_Session(t *testing.T) {
+	signer, err := auth.ParseSigningKeys(strings.NewReader("0123456789abcdef"))
+	require.NoError(t, err)
+	settings := auth.SessionSettings{Lifetime: 24 * time.Hour, Domain: "example.com", Path: "/s", Secure: true}
+	request, gotUID := newAuthTest(signer, settings)
+
+	uid, _ := auth.GenerateUserID()
+	now := time.Now()
+
+	// new users get a cookie with all the attributes
+	resp := request("")
+	require.Len(t, resp.Cookies(), 1)
+	cookie := resp.Cookies()[0]
+	assert.Equal(t, "example.com", cookie.Domain)
+	assert.Equal(t, "/s", cookie.Path)
+	assert.Equal(t, 24*60*60, cookie.MaxAge)
+	assert.True(t, cookie.Secure)
+	assert.True(t, cookie.HttpOnly)
+	assert.Equal(t, http.SameSiteLaxMode, cookie.SameSite)
+
+	// fresh sessions are not renewed
+	resp = request(signer.Sign(uid, now.Add(-time.Hour)))
+	assert.Equal(t, uid, *gotUID)
+	assert.Empty(t, resp.Cookies())
+
+	// older sessions are renewed
+	resp = request(signer.Sign(uid, now.Add(-13*time.Hour)))
+	assert.Equal(t, uid, *gotUID)
+	require.Len(t, resp.Cookies(), 1)
+	_, issuedAt, _, ok := signer.Verify(resp.Cookies()[0].Value)
+	assert.True(t, ok)
+	assert.WithinDuration(t, now, issuedAt, time.Minute)
+
+	// expired sessions are gone
+	request(signer.Sign(uid, now.Add(-25*time.Hour)))
+	assert.NotEqual(t, uid, *gotUID)
+}
+
+func TestNewSessionSettings(t *testing.T) {
+	settings := newSessionSettings(config.Config{BaseURL: "https://example.com/s", SessionLifetime: time.Hour})
+	assert.Equal(t, auth.SessionSettings{Lifetime: time.Hour, Path: "/s", Secure: true}, settings)
+
+	settings = newSessionSettings(config.Config{BaseURL: "http://localhost:8080", CookieDomain: "localhost"})
+	assert.Equal(t, auth.SessionSettings{Domain: "localhost", Path: "/"}, settings)
+}
+
+func TestAuthMW_Bearer(t *testing.T) {
+	signer, err := auth.ParseSigningKeys(strings.NewReader("0123456789abcdef"))
+	require.NoError(t, err)
+	uid, _ := auth.GenerateUserID()
+	now := time.Now()
+	token, err := signer.SignToken(auth.TokenClaims{
+		UserID:    uid,
+		ExpiresAt: now.Add(time.Hour).Unix(),
+		Scopes:    []string{auth.ScopeRead},
+	})
+	require.NoError(t, err)
+
+	var gotUID string
+	h := authMW(signer, nil, testSession)(scopeMW(auth.ScopeRead)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotUID, _ = r.Context().Value(auth.ContextUserIDKey).(string)
+	})))
+	deleteOnly := authMW(signer, nil, testSession)(scopeMW(auth.ScopeDelete)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})))
+	request := func(h http.Handler, authorization string) *http.Response {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set("Authorization", authorization)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+
+		return w.Result()
+	}
+
+	resp := request(h, "Bearer "+token)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, uid, gotUID)
+	assert.Empty(t, resp.Cookies())
+
+	resp = request(deleteOnly, "Bearer "+token)
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+	assert.Contains(t, resp.Header.Get("WWW-Authenticate"), "insufficient_scope")
+
+	// invalid tokens are rejected instead of getting a new user
+	for _, bad := range []string{"Bearer " + token + "x", "Bearer", "Basic dXNlcjpwYXNz"} {
+		gotUID = ""
+		resp = request(h, bad)
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode, bad)
+		assert.Empty(t, gotUID)
+		assert.Empty(t, resp.Cookies())
+	}
+}
+
+func TestAuthMW_APIKey(t *testing.T) {
+	signer, err := auth.NewRandomSigner()
+	require.NoError(t, err)
+	store, _ := inmemory.NewMapStorage()
+	generated, err := auth.GenerateAPIKey()
+	require.NoError(t, err)
+	require.NoError(t, store.AddAPIKey(context.Background(), storage.APIKey{
+		ID:     generated.ID,
+		UserID: "owner",
+		Prefix: generated.Prefix,
+		Hash:   generated.Hash,
+	}))
+
+	var gotUID string
+	h := authMW(signer, store, testSession)(scopeMW(auth.ScopeDelete)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotUID, _ = r.Context().Value(auth.ContextUserIDKey).(string)
+	})))
+	request := func(key string) *http.Response {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set(auth.APIKeyHeader, key)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+
+		return w.Result()
+	}
+
+	resp := request(generated.Key)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "owner", gotUID)
+	assert.Empty(t, resp.Cookies())
+
+	key, err := store.GetAPIKeyByHash(context.Background(), generated.Hash)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), key.Uses)
+	assert.False(t, key.LastUsedAt.IsZero())
+
+	// unknown and revoked keys are rejected instead of getting a new user
+	other, _ := auth.GenerateAPIKey()
+	require.NoError(t, store.DeleteAPIKey(context.Background(), generated.ID, "owner"))
+	for _, bad := range []string{other.Key, generated.Key, "garbage"} {
+		gotUID = ""
+		resp = request(bad)
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode, bad)
+		assert.Empty(t, gotUID)
+	}
+
+	// no key storage, no keys
+	h = authMW(signer, nil, testSession)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	assert.Equal(t, http.StatusUnauthorized, request(other.Key).StatusCode)
+}
+
+func TestRoleMW(t *testing.T) {
+	store, _ := inmemory.NewMapStorage()
+	require.NoError(t, store.SetRole(context.Background(), "mod", auth.RoleModerator))
+	require.NoError(t, store.SetRole(context.Background(), "admin", auth.RoleAdmin))
+
+	h := roleMW(store, auth.RoleModerator)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	tests := map[string]int{
+		"nobody": http.StatusForbidden,
+		"mod":    http.StatusOK,
+		"admin":  http.StatusOK,
+	}
+	for uid, want := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/api/admin/stats", nil)
+		r = r.WithContext(context.WithValue(r.Context(), auth.ContextUserIDKey, uid))
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		assert.Equal(t, want, w.Code, uid)
+	}
+}
@@ -0,0 +1,159 @@
+package api
+
+import (
+	"expvar"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/sbxb/shorty/internal/app/auth"
+	"github.com/sbxb/shorty/internal/app/config"
+)
+
+// metrics are published at /debug/vars as "ratelimit"
+var rateLimitMetrics = expvar.NewMap("ratelimit")
+
+// no more than rateLimitMaxBuckets are kept per group, others are evicted
+const rateLimitMaxBuckets = 100000
+
+// bucket is a token bucket, tokens are refilled lazily on access
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// rateLimiter limits requests of every user with a token bucket, users
+// with a valid cookie are identified by their ID, new users by their IP
+// address (otherwise dropping the cookie would reset the limit), IPv6 ones
+// by their /64 network
+type rateLimiter struct {
+	group string
+	rate  float64 // tokens per second
+	burst int
+
+	mu         sync.Mutex
+	buckets    map[string]*bucket
+	maxBuckets int
+	lastSweep  time.Time
+	now        func() time.Time
+}
+
+func newRateLimiter(group string, limit config.RateLimit) *rateLimiter {
+	return &rateLimiter{
+		group:      group,
+		rate:       float64(limit.Requests) / limit.Per.Seconds(),
+		burst:      limit.Burst,
+		buckets:    make(map[string]*bucket),
+		maxBuckets: rateLimitMaxBuckets,
+		now:        time.Now,
+	}
+}
+
+func (rl *rateLimiter) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ok, remaining, reset, retryAfter := rl.take(rateLimitKey(r))
+
+		w.Header().Set("RateLimit-Limit", strconv.Itoa(rl.burst))
+		w.Header().Set("RateLimit-Remaining", strconv.Itoa(remaining))
+		w.Header().Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(reset)))
+		if !ok {
+			rateLimitMetrics.Add(rl.group, 1)
+			w.Header().Set("Retry-After", strconv.Itoa(ceilSeconds(retryAfter)))
+			http.Error(w, "Too many requests", http.StatusTooManyRequests)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// take takes a token from the key's bucket, it returns whether a token was
+// available, the number of tokens left, the time until the bucket is full
+// and the time until the next token is available
+func (rl *rateLimiter) take(key string) (ok bool, remaining int, reset, retryAfter time.Duration) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	now := rl.now()
+	rl.sweep(now)
+
+	b, found := rl.buckets[key]
+	if !found {
+		if len(rl.buckets) >= rl.maxBuckets {
+			rl.evict(now)
+		}
+		b = &bucket{tokens: float64(rl.burst), last: now}
+		rl.buckets[key] = b
+	}
+	b.tokens = math.Min(float64(rl.burst), b.tokens+now.Sub(b.last).Seconds()*rl.rate)
+	b.last = now
+
+	if b.tokens >= 1 {
+		b.tokens--
+		ok = true
+	} else {
+		retryAfter = rl.timeFor(1 - b.tokens)
+	}
+
+	return ok, int(b.tokens), rl.timeFor(float64(rl.burst) - b.tokens), retryAfter
+}
+
+// timeFor returns the time needed to refill tokens
+func (rl *rateLimiter) timeFor(tokens float64) time.Duration {
+	return time.Duration(tokens / rl.rate * float64(time.Second))
+}
+
+// sweep forgets buckets which are full by now, it runs at most once per
+// the time needed to refill an empty bucket, the caller must hold the lock
+func (rl *rateLimiter) sweep(now time.Time) {
+	full := rl.timeFor(float64(rl.burst))
+	if now.Sub(rl.lastSweep) < full {
+		return
+	}
+	rl.lastSweep = now
+
+	for key, b := range rl.buckets {
+		if now.Sub(b.last) >= full {
+			delete(rl.buckets, key)
+		}
+	}
+}
+
+// evict forgets a bucket to make room for another one, buckets which are
+// full by now go first, then the least recently used one, the caller must
+// hold the lock
+func (rl *rateLimiter) evict(now time.Time) {
+	full := rl.timeFor(float64(rl.burst))
+	victim := ""
+	for key, b := range rl.buckets {
+		if now.Sub(b.last) >= full {
+			victim = key
+			break
+		}
+		if victim == "" || b.last.Before(rl.buckets[victim].last) {
+			victim = key
+		}
+	}
+	delete(rl.buckets, victim)
+	rateLimitMetrics.Add("evictions", 1)
+}
+
+func rateLimitKey(r *http.Request) string {
+	uid, _ := r.Context().Value(auth.ContextUserIDKey).(string)
+	newUser, _ := r.Context().Value(auth.ContextNewUserKey).(bool)
+	if uid != "" && !newUser {
+		return "user:" + uid
+	}
+
+	if ip := clientIP(r); ip != nil {
+		return "ip:" + clientKey(ip)
+	}
+
+	return "ip:" + r.RemoteAddr
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
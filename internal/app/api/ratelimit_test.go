@@ -0,0 +1,98 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/sbxb/shorty/internal/app/auth"
+	"github.com/sbxb/shorty/internal/app/config"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var created = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusCreated)
+})
+
+func post(h http.Handler, remoteAddr, uid string, newUser bool) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodPost, "/", nil)
+	r.RemoteAddr = remoteAddr
+	ctx := context.WithValue(r.Context(), auth.ContextUserIDKey, uid)
+	ctx = context.WithValue(ctx, auth.ContextNewUserKey, newUser)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r.WithContext(ctx))
+
+	return w
+}
+
+func TestRateLimiter(t *testing.T) {
+	now := time.Now()
+	rl := newRateLimiter("shorten", config.RateLimit{Requests: 1, Per: 2 * time.Second, Burst: 2})
+	rl.now = func() time.Time { return now }
+	h := rl.middleware(created)
+
+	const ip = "192.0.2.1:1234"
+
+	w := post(h, ip, "alice", false)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.Equal(t, "2", w.Header().Get("RateLimit-Limit"))
+	assert.Equal(t, "1", w.Header().Get("RateLimit-Remaining"))
+	assert.Equal(t, "2", w.Header().Get("RateLimit-Reset"))
+
+	assert.Equal(t, http.StatusCreated, post(h, ip, "alice", false).Code)
+	w = post(h, ip, "alice", false)
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "2", w.Header().Get("Retry-After"))
+	assert.Equal(t, "0", w.Header().Get("RateLimit-Remaining"))
+	assert.Equal(t, "4", w.Header().Get("RateLimit-Reset"))
+
+	// another user from the same IP has its own bucket
+	assert.Equal(t, http.StatusCreated, post(h, ip, "bob", false).Code)
+
+	// new users are limited by IP, a fresh user ID for every request
+	// doesn't help
+	assert.Equal(t, http.StatusCreated, post(h, ip, "new1", true).Code)
+	assert.Equal(t, http.StatusCreated, post(h, ip, "new2", true).Code)
+	assert.Equal(t, http.StatusTooManyRequests, post(h, ip, "new3", true).Code)
+
+	// a token is refilled in 2 seconds
+	now = now.Add(2 * time.Second)
+	assert.Equal(t, http.StatusCreated, post(h, ip, "alice", false).Code)
+	assert.Equal(t, http.StatusTooManyRequests, post(h, ip, "alice", false).Code)
+
+	// full buckets are forgotten
+	now = now.Add(time.Minute)
+	post(h, ip, "alice", false)
+	assert.Len(t, rl.buckets, 1)
+}
+
+func TestRateLimiter_Bounded(t *testing.T) {
+	now := time.Now()
+	rl := newRateLimiter("shorten", config.RateLimit{Requests: 1, Per: 2 * time.Second, Burst: 1})
+	rl.now = func() time.Time { return now }
+	rl.maxBuckets = 3
+	h := rl.middleware(created)
+
+	for i := 0; i < 10; i++ {
+		now = now.Add(time.Millisecond)
+		post(h, "192.0.2.1:1234", "user"+strconv.Itoa(i), false)
+	}
+	assert.Len(t, rl.buckets, 3)
+	// the least recently used buckets are forgotten
+	assert.Contains(t, rl.buckets, "user:user9")
+	assert.NotContains(t, rl.buckets, "user:user0")
+}
+
+func TestRateLimiter_IPv6_Network(t *testing.T) {
+	rl := newRateLimiter("shorten", config.RateLimit{Requests: 1, Per: time.Minute, Burst: 1})
+	h := rl.middleware(created)
+
+	// new users from another address of the same /64 share the bucket
+	assert.Equal(t, http.StatusCreated, post(h, "[2001:db8::1]:1234", "new1", true).Code)
+	assert.Equal(t, http.StatusTooManyRequests, post(h, "[2001:db8::2]:1234", "new2", true).Code)
+	assert.Equal(t, http.StatusCreated, post(h, "[2001:db8:0:1::1]:1234", "new3", true).Code)
+}
@@ -1,36 +1,185 @@
 package api
 
 import (
+	"expvar"
 	"net/http"
 
+	"github.com/sbxb/shorty/internal/app/auth"
 	"github.com/sbxb/shorty/internal/app/config"
 	"github.com/sbxb/shorty/internal/app/handlers"
+	"github.com/sbxb/shorty/internal/app/oidc"
 	"github.com/sbxb/shorty/internal/app/storage"
 
 	"github.com/go-chi/chi/v5"
 )
 
+// Features are optional features, routes of nil ones are not registered
+type Features struct {
+	APIKeys storage.APIKeyStorage
+	Users   storage.UserStorage
+	// RecoveryCodes let anonymous users get their ids back
+	RecoveryCodes storage.RecoveryCodeStorage
+	// Roles and Admin enable /api/admin/ routes, both are required
+	Roles storage.RoleStorage
+	Admin storage.AdminStorage
+	// Workspaces let teams share links
+	Workspaces storage.WorkspaceStorage
+	// Transfers hand links over to other users and workspaces
+	Transfers storage.TransferStorage
+	// ACLs restrict who is redirected by links
+	ACLs storage.ACLStorage
+	// Passwords protect links with passwords
+	Passwords storage.LinkPasswordStorage
+	// History lets destinations of links change
+	History storage.URLHistoryStorage
+	// Meta describes links with titles, notes and tags
+	Meta storage.URLMetaStorage
+	// OIDC is the single sign-on provider
+	OIDC *oidc.Provider
+}
+
 // NewRouter creates chi router and handlers container, register handlers and
-// pass dependencies to handlers
-func NewRouter(store storage.Storage, cfg config.Config) http.Handler {
+// pass dependencies to handlers, user_id cookies are signed by signer
+func NewRouter(store storage.Storage, cfg config.Config, signer *auth.Signer, features Features) http.Handler {
 	router := chi.NewRouter()
 
+	session := newSessionSettings(cfg)
 	urlHandler := handlers.NewURLHandler(store, cfg)
+	if features.ACLs != nil {
+		var loginURL string
+		if features.OIDC != nil {
+			loginURL = cfg.BaseURL + "/auth/oidc/login"
+		}
+		urlHandler = urlHandler.WithAccessControl(features.ACLs, features.Users, features.Workspaces, loginURL)
+	}
+	if features.Passwords != nil {
+		urlHandler = urlHandler.WithPasswords(features.Passwords)
+	}
+	if features.Meta != nil {
+		urlHandler = urlHandler.WithMeta(features.Meta)
+	}
+	tokenHandler := handlers.NewTokenHandler(signer, cfg)
 
 	router.Use(gzipMW)
-	router.Use(authMW)
+	router.Use(authMW(signer, features.APIKeys, session))
+
+	// Slow down and block clients enumerating short ids
+	redirect := router.With()
+	if cfg.EnumGuardThreshold > 0 {
+		settings := defaultGuardSettings
+		settings.Threshold = cfg.EnumGuardThreshold
+		settings.MinRequests = cfg.EnumGuardMinRequests
+		settings.Window = cfg.EnumGuardWindow
+		settings.Cooldown = cfg.EnumGuardCooldown
+		settings.Allowlist = cfg.EnumGuardAllowlist
+		redirect = router.With(newEnumGuard(settings).middleware)
+	}
+
+	redirect.Get("/{id}", urlHandler.GetHandler)
+	if features.Passwords != nil {
+		redirect.Post("/{id}", urlHandler.UnlockHandler)
+	}
 
-	router.Get("/{id}", urlHandler.GetHandler)
-	router.Post("/", urlHandler.PostHandler)
+	// Every route group has its own limiter, bearer tokens are only allowed
+	// routes their scopes grant
+	shorten := router.With(rateLimitMW(cfg, config.RateLimitShorten), scopeMW(auth.ScopeShorten))
+	shorten.Post("/", urlHandler.PostHandler)
+	shorten.With(jsonEncMW).Post("/api/shorten", urlHandler.JSONPostHandler)
 
-	router.With(jsonEncMW).Post("/api/shorten", urlHandler.JSONPostHandler)
-	router.With(jsonEncMW).Post("/api/shorten/batch", urlHandler.JSONBatchPostHandler)
+	batch := router.With(rateLimitMW(cfg, config.RateLimitBatch), scopeMW(auth.ScopeShorten))
+	batch.With(jsonEncMW).Post("/api/shorten/batch", urlHandler.JSONBatchPostHandler)
 
-	router.With(jsonEncMW).Delete("/api/user/urls", urlHandler.UserDeleteHandler)
+	user := router.With(rateLimitMW(cfg, config.RateLimitUser))
+	user.With(scopeMW(auth.ScopeDelete), jsonEncMW).Delete("/api/user/urls", urlHandler.UserDeleteHandler)
+	user.With(scopeMW(auth.ScopeRead)).Get("/api/user/urls", urlHandler.UserGetHandler)
+	user.With(scopeMW(auth.ScopeRead)).Get("/api/user/quota", urlHandler.UserQuotaHandler)
+	user.With(jsonEncMW).Post("/api/user/tokens", tokenHandler.MintHandler)
+	if features.APIKeys != nil {
+		keyHandler := handlers.NewAPIKeyHandler(features.APIKeys)
+		user.With(jsonEncMW).Post("/api/user/keys", keyHandler.CreateHandler)
+		user.Get("/api/user/keys", keyHandler.ListHandler)
+		user.Delete("/api/user/keys/{id}", keyHandler.RevokeHandler)
+	}
+	if features.Users != nil {
+		accountHandler := handlers.NewAccountHandler(features.Users, signer, session)
+		account := router.With(rateLimitMW(cfg, config.RateLimitAuth), jsonEncMW)
+		account.Post("/api/user/signup", accountHandler.SignupHandler)
+		account.Post("/api/user/login", accountHandler.LoginHandler)
+		user.Post("/api/user/logout", accountHandler.LogoutHandler)
+	}
 
-	router.Get("/api/user/urls", urlHandler.UserGetHandler)
+	if features.RecoveryCodes != nil {
+		recoveryHandler := handlers.NewRecoveryHandler(features.RecoveryCodes, signer, session)
+		user.Get("/api/user/recovery", recoveryHandler.IssueHandler)
+		router.With(rateLimitMW(cfg, config.RateLimitAuth), jsonEncMW).Post("/api/user/recover", recoveryHandler.RecoverHandler)
+	}
+	if features.Roles != nil && features.Admin != nil {
+		adminHandler := handlers.NewAdminHandler(store, features.Admin, features.Roles, cfg)
+		moderator := user.With(roleMW(features.Roles, auth.RoleModerator))
+		moderator.With(scopeMW(auth.ScopeDelete), jsonEncMW).Post("/api/admin/urls/disable", adminHandler.DisableHandler)
+		admin := user.With(roleMW(features.Roles, auth.RoleAdmin))
+		admin.With(scopeMW(auth.ScopeRead)).Get("/api/admin/users/{uid}/urls", adminHandler.UserURLsHandler)
+		admin.With(scopeMW(auth.ScopeRead)).Get("/api/admin/stats", adminHandler.StatsHandler)
+		admin.With(jsonEncMW).Put("/api/admin/users/{uid}/role", adminHandler.RoleHandler)
+	}
+	if features.Workspaces != nil {
+		workspaceHandler := handlers.NewWorkspaceHandler(store, features.Workspaces, cfg)
+		user.With(jsonEncMW).Post("/api/workspaces", workspaceHandler.CreateHandler)
+		user.With(scopeMW(auth.ScopeRead)).Get("/api/workspaces", workspaceHandler.ListHandler)
+		user.With(scopeMW(auth.ScopeRead)).Get("/api/workspaces/{id}/urls", workspaceHandler.URLsHandler)
+		shorten.With(jsonEncMW).Post("/api/workspaces/{id}/urls", workspaceHandler.ShortenHandler)
+		user.With(scopeMW(auth.ScopeRead)).Get("/api/workspaces/{id}/members", workspaceHandler.MembersHandler)
+		user.With(jsonEncMW).Put("/api/workspaces/{id}/members/{uid}", workspaceHandler.SetMemberHandler)
+		user.Delete("/api/workspaces/{id}/members/{uid}", workspaceHandler.RemoveMemberHandler)
+	}
+	if features.Transfers != nil {
+		transferHandler := handlers.NewTransferHandler(features.Transfers, features.Workspaces, cfg)
+		user.With(scopeMW(auth.ScopeDelete), jsonEncMW).Post("/api/user/urls/transfer", transferHandler.CreateHandler)
+		user.With(scopeMW(auth.ScopeRead)).Get("/api/user/transfers", transferHandler.ListHandler)
+		user.Post("/api/user/transfers/{id}/accept", transferHandler.AcceptHandler)
+		user.Post("/api/user/transfers/{id}/decline", transferHandler.DeclineHandler)
+	}
+	historyHandler := handlers.NewHistoryHandler(store, features.History, features.Meta, cfg)
+	if features.History != nil || features.Meta != nil {
+		user.With(scopeMW(auth.ScopeDelete), jsonEncMW).Patch("/api/user/urls/{id}", historyHandler.UpdateHandler)
+	}
+	if features.History != nil {
+		user.With(scopeMW(auth.ScopeRead)).Get("/api/user/urls/{id}/history", historyHandler.HistoryHandler)
+		user.With(scopeMW(auth.ScopeDelete), jsonEncMW).Post("/api/user/urls/{id}/rollback", historyHandler.RollbackHandler)
+	}
+	if features.Meta != nil {
+		user.With(scopeMW(auth.ScopeRead)).Get("/api/user/tags", urlHandler.UserTagsHandler)
+	}
+	if features.ACLs != nil {
+		aclHandler := handlers.NewACLHandler(features.ACLs, features.Workspaces)
+		user.With(scopeMW(auth.ScopeRead)).Get("/api/user/urls/{id}/acl", aclHandler.GetHandler)
+		user.With(scopeMW(auth.ScopeDelete), jsonEncMW).Put("/api/user/urls/{id}/acl", aclHandler.SetHandler)
+	}
+	if features.OIDC != nil {
+		oidcHandler := handlers.NewOIDCHandler(features.OIDC, features.Users, signer, session)
+		sso := router.With(rateLimitMW(cfg, config.RateLimitAuth))
+		sso.Get("/auth/oidc/login", oidcHandler.LoginHandler)
+		sso.Get("/auth/oidc/callback", oidcHandler.CallbackHandler)
+	}
 
 	router.Get("/ping", urlHandler.PingGetHandler)
 
+	// expvar publishes the command line with the DSN and internal counters,
+	// so only admins see it
+	if features.Roles != nil {
+		user.With(roleMW(features.Roles, auth.RoleAdmin), scopeMW(auth.ScopeRead)).Handle("/debug/vars", expvar.Handler())
+	}
+
 	return router
 }
+
+// rateLimitMW returns the rate limiting middleware for group, or a no-op
+// one if the group is not limited
+func rateLimitMW(cfg config.Config, group string) func(http.Handler) http.Handler {
+	limit, ok := cfg.RateLimits[group]
+	if !ok {
+		return func(next http.Handler) http.Handler { return next }
+	}
+
+	return newRateLimiter(group, limit).middleware
+}
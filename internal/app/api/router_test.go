@@ -0,0 +1,45 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/sbxb/shorty/internal/app/auth"
+	"github.com/sbxb/shorty/internal/app/config"
+	"github.com/sbxb/shorty/internal/app/storage/inmemory"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewRouter_DebugVars(t *testing.T) {
+	signer, err := auth.NewRandomSigner()
+	require.NoError(t, err)
+	admin, _ := auth.GenerateUserID()
+	user, _ := auth.GenerateUserID()
+	store, _ := inmemory.NewMapStorage()
+	require.NoError(t, store.SetRole(context.Background(), admin, auth.RoleAdmin))
+	cfg := config.Config{BaseURL: "http://localhost:8080", SessionLifetime: time.Hour}
+
+	request := func(router http.Handler, uid string) int {
+		r := httptest.NewRequest(http.MethodGet, "/debug/vars", nil)
+		if uid != "" {
+			r.AddCookie(&http.Cookie{Name: auth.SessionCookieName, Value: signer.Sign(uid, time.Now())})
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	// without roles nobody is an admin
+	router := NewRouter(store, cfg, signer, Features{})
+	assert.Equal(t, http.StatusNotFound, request(router, admin))
+
+	router = NewRouter(store, cfg, signer, Features{Roles: store})
+	assert.Equal(t, http.StatusForbidden, request(router, ""))
+	assert.Equal(t, http.StatusForbidden, request(router, user))
+	assert.Equal(t, http.StatusOK, request(router, admin))
+}
@@ -0,0 +1,61 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+)
+
+const (
+	// APIKeyHeader is the request header API keys are passed in
+	APIKeyHeader = "X-API-Key"
+
+	apiKeyMarker    = "shk_"
+	apiKeyBytes     = 24
+	apiKeyPrefixLen = len(apiKeyMarker) + 8
+	apiKeyIDBytes   = 8
+)
+
+// GeneratedAPIKey is a newly created API key, Key itself is shown to the
+// user once and never stored, the key is looked up by Hash later
+type GeneratedAPIKey struct {
+	ID     string
+	Key    string
+	Prefix string
+	Hash   string
+}
+
+// GenerateAPIKey returns a new random API key like "shk_<48 hex digits>"
+// along with its id, its prefix to tell the keys apart and its hash
+func GenerateAPIKey() (GeneratedAPIKey, error) {
+	b, err := generateRandomBytes(apiKeyBytes)
+	if err != nil {
+		return GeneratedAPIKey{}, err
+	}
+	id, err := generateRandomBytes(apiKeyIDBytes)
+	if err != nil {
+		return GeneratedAPIKey{}, err
+	}
+
+	key := apiKeyMarker + hex.EncodeToString(b)
+
+	return GeneratedAPIKey{
+		ID:     hex.EncodeToString(id),
+		Key:    key,
+		Prefix: key[:apiKeyPrefixLen],
+		Hash:   HashAPIKey(key),
+	}, nil
+}
+
+// HashAPIKey returns SHA-256 of the key as a hex string, keys have enough
+// entropy for a fast unsalted hash to be safe
+func HashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// LooksLikeAPIKey reports whether key has the format of the keys
+// GenerateAPIKey returns
+func LooksLikeAPIKey(key string) bool {
+	return strings.HasPrefix(key, apiKeyMarker) && len(key) == len(apiKeyMarker)+2*apiKeyBytes
+}
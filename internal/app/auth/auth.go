@@ -1,43 +1,24 @@
 package auth
 
 import (
-	"crypto/hmac"
-	"crypto/md5"
 	"crypto/rand"
-	"crypto/sha256"
 	"encoding/hex"
 )
 
 const (
-	secretKey = "my-super-secret-key"
-	uidBytes  = 16
-	uidChars  = uidBytes * 2
+	uidBytes = 16
+	uidChars = uidBytes * 2
+
+	resourceIDBytes = 8
 )
 
 type contextKey string
 
 var ContextUserIDKey = contextKey("uid")
 
-func CheckUserIDCookieValue(value string) bool {
-	if len(value) != 96 {
-		return false
-	}
-
-	uid := value[:uidChars]
-
-	sign, err := hex.DecodeString(value[uidChars:])
-	if err != nil {
-		return false
-	}
-
-	return hmac.Equal(signUserID(uid), sign)
-}
-
-func GetUserIDCookieValue(uid string) string {
-	sign := signUserID(uid)
-
-	return uid + hex.EncodeToString(sign)
-}
+// ContextNewUserKey is set to true for requests the user ID was just
+// generated for, i.e. which came without a valid cookie
+var ContextNewUserKey = contextKey("new-uid")
 
 // GenerateUserID returns 32-characters long hexadecimal string representing
 // 16 random bytes (to be used as a unique user id)
@@ -50,13 +31,14 @@ func GenerateUserID() (string, error) {
 	return hex.EncodeToString(b), nil
 }
 
-func signUserID(uid string) []byte {
-	secret := md5.Sum([]byte(secretKey))
-	h := hmac.New(sha256.New, secret[:])
-	h.Write([]byte(uid))
-	sign := h.Sum(nil)
+// GenerateResourceID returns a random id for resources such as workspaces
+func GenerateResourceID() (string, error) {
+	b, err := generateRandomBytes(resourceIDBytes)
+	if err != nil {
+		return "", err
+	}
 
-	return sign
+	return hex.EncodeToString(b), nil
 }
 
 func generateRandomBytes(size int) ([]byte, error) {
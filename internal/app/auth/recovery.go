@@ -0,0 +1,46 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/hex"
+	"strings"
+)
+
+const (
+	// recoveryCodeBytes give 120 bits of entropy, 24 base32 characters
+	recoveryCodeBytes = 15
+	recoveryGroupSize = 6
+)
+
+// GenerateRecoveryCode returns a new random recovery code like
+// "ABCDEF-GHIJKL-MNOPQR-STUVWX" along with its hash
+func GenerateRecoveryCode() (code string, hash string, err error) {
+	b, err := generateRandomBytes(recoveryCodeBytes)
+	if err != nil {
+		return "", "", err
+	}
+
+	raw := base32.StdEncoding.EncodeToString(b)
+	groups := make([]string, 0, len(raw)/recoveryGroupSize)
+	for i := 0; i < len(raw); i += recoveryGroupSize {
+		groups = append(groups, raw[i:i+recoveryGroupSize])
+	}
+	code = strings.Join(groups, "-")
+
+	return code, HashRecoveryCode(code), nil
+}
+
+// HashRecoveryCode returns SHA-256 of the code as a hex string, case,
+// dashes and spaces are ignored, so that typed in codes match
+func HashRecoveryCode(code string) string {
+	normalized := strings.Map(func(r rune) rune {
+		if r == '-' || r == ' ' {
+			return -1
+		}
+		return r
+	}, strings.ToUpper(code))
+	sum := sha256.Sum256([]byte(normalized))
+
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,50 @@
+package auth
+
+import (
+	"net/http"
+	"time"
+)
+
+// SessionCookieName is the name of the cookie carrying the signed user id
+const SessionCookieName = "user_id"
+
+// SessionSettings define the session cookie
+type SessionSettings struct {
+	// Lifetime is the time a session lasts since it was issued, sessions
+	// older than half of it are renewed on every request, so that active
+	// users never lose their sessions
+	Lifetime time.Duration
+	Domain   string
+	Path     string
+	// Secure is set for https deployments
+	Secure bool
+}
+
+// Cookie returns the session cookie for uid issued at now
+func (s SessionSettings) Cookie(signer *Signer, uid string, now time.Time) *http.Cookie {
+	return &http.Cookie{
+		Name:     SessionCookieName,
+		Value:    signer.Sign(uid, now),
+		Path:     s.Path,
+		Domain:   s.Domain,
+		Expires:  now.Add(s.Lifetime),
+		MaxAge:   int(s.Lifetime.Seconds()),
+		Secure:   s.Secure,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	}
+}
+
+// ExpiredCookie returns the cookie removing the session
+func (s SessionSettings) ExpiredCookie() *http.Cookie {
+	return &http.Cookie{
+		Name:     SessionCookieName,
+		Path:     s.Path,
+		Domain:   s.Domain,
+		Expires:  time.Unix(0, 0),
+		MaxAge:   -1,
+		Secure:   s.Secure,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	}
+}
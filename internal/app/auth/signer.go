@@ -0,0 +1,240 @@
+package auth
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// defaultKeyID is used for keys given without an id
+	defaultKeyID = "default"
+	// LegacyKeyID is the id of the key to verify cookies issued before key
+	// ids were introduced (uid followed by its signature), such cookies
+	// are always re-issued
+	LegacyKeyID = "legacy"
+	// minKeyLength is the minimum length of a signing key in bytes
+	minKeyLength = 16
+)
+
+var keyIDRegexp = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// Signer signs user ids with the current key and verifies signatures made
+// with any of its keys, so that keys can be rotated: a new key is added as
+// the current one while cookies signed with the old ones stay valid until
+// re-issued
+// Cookie values look like <uid>.<issued at>.<key id>.<hex-encoded HMAC-SHA256>
+// where issued at is a Unix time
+type Signer struct {
+	ids  []string // ids[0] is the current key
+	keys map[string][]byte
+}
+
+// ParseSigningKeys parses keys, one per line, as [id:]secret, secrets
+// should be at least 16 bytes long and ids should consist of letters,
+// digits, '-' and '_' only, the first key is the current one
+// Empty lines and lines starting with # are ignored
+func ParseSigningKeys(r io.Reader) (*Signer, error) {
+	s := &Signer{keys: make(map[string][]byte)}
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		id, secret := defaultKeyID, line
+		if i := strings.Index(line, ":"); i >= 0 {
+			id, secret = line[:i], line[i+1:]
+		}
+		if !keyIDRegexp.MatchString(id) {
+			return nil, fmt.Errorf("Signer: invalid key id %q", id)
+		}
+		if _, ok := s.keys[id]; ok {
+			return nil, fmt.Errorf("Signer: duplicate key id %q", id)
+		}
+		if len(secret) < minKeyLength {
+			return nil, fmt.Errorf("Signer: key %q is shorter than %d bytes", id, minKeyLength)
+		}
+
+		s.ids = append(s.ids, id)
+		s.keys[id] = []byte(secret)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("Signer: %v", err)
+	}
+
+	if len(s.ids) == 0 {
+		return nil, errors.New("Signer: no keys found")
+	}
+
+	return s, nil
+}
+
+// LoadSigner loads keys from keyFile (if not empty) preceded by envKey (if
+// not empty), so that a key given as env variable becomes the current one
+// Returns nil signer if both are empty
+func LoadSigner(keyFile string, envKey string) (*Signer, error) {
+	var buf bytes.Buffer
+	buf.WriteString(envKey + "\n")
+
+	if keyFile != "" {
+		data, err := os.ReadFile(keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("Signer: %v", err)
+		}
+		buf.Write(data)
+	}
+
+	if strings.TrimSpace(buf.String()) == "" {
+		return nil, nil
+	}
+
+	return ParseSigningKeys(&buf)
+}
+
+// NewRandomSigner returns a signer with a single random key, cookies
+// signed with it are valid until restart
+func NewRandomSigner() (*Signer, error) {
+	key, err := generateRandomBytes(32)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Signer{
+		ids:  []string{defaultKeyID},
+		keys: map[string][]byte{defaultKeyID: key},
+	}, nil
+}
+
+// CurrentID returns the id of the key used for signing
+func (s *Signer) CurrentID() string {
+	return s.ids[0]
+}
+
+// Sign returns the cookie value for uid issued at issuedAt signed with
+// the current key
+func (s *Signer) Sign(uid string, issuedAt time.Time) string {
+	id := s.CurrentID()
+	payload := uid + "." + strconv.FormatInt(issuedAt.Unix(), 10)
+
+	return payload + "." + id + "." + hex.EncodeToString(s.mac(id, payload))
+}
+
+// Verify returns the user id and the time it was issued at from the cookie
+// value if its signature is valid, stale is true if the value should be
+// re-issued since it's not signed with the current key
+func (s *Signer) Verify(value string) (uid string, issuedAt time.Time, stale bool, ok bool) {
+	parts := strings.Split(value, ".")
+	if len(parts) == 1 {
+		uid, ok := s.verifyLegacy(value)
+		return uid, time.Time{}, ok, ok
+	}
+	if len(parts) != 4 || len(parts[0]) != uidChars {
+		return "", time.Time{}, false, false
+	}
+
+	uid, id := parts[0], parts[2]
+	issued, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return "", time.Time{}, false, false
+	}
+	if _, found := s.keys[id]; !found {
+		return "", time.Time{}, false, false
+	}
+	sign, err := hex.DecodeString(parts[3])
+	if err != nil || !hmac.Equal(s.mac(id, parts[0]+"."+parts[1]), sign) {
+		return "", time.Time{}, false, false
+	}
+
+	return uid, time.Unix(issued, 0), id != s.CurrentID(), true
+}
+
+// verifyLegacy verifies values issued before key ids were introduced, they
+// were signed with MD5 of the key
+func (s *Signer) verifyLegacy(value string) (string, bool) {
+	key, found := s.keys[LegacyKeyID]
+	if !found || len(value) != uidChars+2*sha256.Size {
+		return "", false
+	}
+
+	uid := value[:uidChars]
+	sign, err := hex.DecodeString(value[uidChars:])
+	if err != nil {
+		return "", false
+	}
+
+	secret := md5.Sum(key)
+	h := hmac.New(sha256.New, secret[:])
+	h.Write([]byte(uid))
+	if !hmac.Equal(h.Sum(nil), sign) {
+		return "", false
+	}
+
+	return uid, true
+}
+
+// mac signs the key id along with payload, so that the id can't be swapped
+func (s *Signer) mac(id, payload string) []byte {
+	h := hmac.New(sha256.New, s.keys[id])
+	h.Write([]byte(id + "." + payload))
+
+	return h.Sum(nil)
+}
+
+// SignValue returns payload signed for purpose with the current key, values
+// signed for one purpose never verify for another one or as cookies
+// Signed values look like <base64 payload>.<key id>.<base64 HMAC-SHA256>
+func (s *Signer) SignValue(purpose, payload string) string {
+	id := s.CurrentID()
+	encoded := base64.RawURLEncoding.EncodeToString([]byte(payload))
+
+	return encoded + "." + id + "." + base64.RawURLEncoding.EncodeToString(s.valueMAC(id, purpose, encoded))
+}
+
+// VerifyValue returns the payload of value signed by SignValue for purpose
+// with any of the keys
+func (s *Signer) VerifyValue(purpose, value string) (string, bool) {
+	parts := strings.Split(value, ".")
+	if len(parts) != 3 {
+		return "", false
+	}
+	if _, found := s.keys[parts[1]]; !found {
+		return "", false
+	}
+	sign, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil || !hmac.Equal(s.valueMAC(parts[1], purpose, parts[0]), sign) {
+		return "", false
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return "", false
+	}
+
+	return string(payload), true
+}
+
+// valueMAC signs values with a key derived from the cookie signing key and
+// purpose, the same way tokenMAC does
+func (s *Signer) valueMAC(id, purpose, encoded string) []byte {
+	kh := hmac.New(sha256.New, s.keys[id])
+	kh.Write([]byte("shorty-value:" + purpose))
+
+	h := hmac.New(sha256.New, kh.Sum(nil))
+	h.Write([]byte(encoded))
+
+	return h.Sum(nil)
+}
@@ -0,0 +1,133 @@
+package auth
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSigner_Legacy(t *testing.T) {
+	// cookies issued before key ids were introduced
+	tests := []struct {
+		cookieValue  string
+		wantedResult bool
+	}{
+		{
+			cookieValue:  "27e9ed8b869da32524db39a4bc0ea185034a33ab3f0042b5d3c81e03f6ede2c1b86ce9c1ed9b53592971729ba9312b2c",
+			wantedResult: true,
+		},
+		{
+			cookieValue:  "27e9ed8b869da32524db39a4bc0ea185034a33ab3f0042b5d3c81e03f6ede2c1b86ce9c1ed9b53592971729ba9312b2d",
+			wantedResult: false,
+		},
+		{
+			cookieValue:  "1337h4x0r",
+			wantedResult: false,
+		},
+	}
+
+	signer, err := ParseSigningKeys(strings.NewReader("current:0123456789abcdef\nlegacy:my-super-secret-key"))
+	require.NoError(t, err)
+
+	for _, tt := range tests {
+		uid, issuedAt, stale, ok := signer.Verify(tt.cookieValue)
+		assert.Equal(t, tt.wantedResult, ok)
+		if ok {
+			assert.Equal(t, tt.cookieValue[:uidChars], uid)
+			assert.True(t, issuedAt.IsZero())
+			assert.True(t, stale)
+		}
+	}
+
+	// legacy cookies are not accepted unless the legacy key is configured
+	signer, err = ParseSigningKeys(strings.NewReader("0123456789abcdef"))
+	require.NoError(t, err)
+	_, _, _, ok := signer.Verify(tests[0].cookieValue)
+	assert.False(t, ok)
+}
+
+func TestSigner_Rotation(t *testing.T) {
+	uid, err := GenerateUserID()
+	require.NoError(t, err)
+
+	issued := time.Unix(1600000000, 0)
+
+	old, err := ParseSigningKeys(strings.NewReader("k1:first-secret-key-0001"))
+	require.NoError(t, err)
+	value := old.Sign(uid, issued)
+	assert.True(t, strings.HasPrefix(value, uid+".1600000000.k1."))
+
+	// the new key is added as the current one
+	rotated, err := ParseSigningKeys(strings.NewReader("k2:second-secret-key-002\nk1:first-secret-key-0001"))
+	require.NoError(t, err)
+	got, issuedAt, stale, ok := rotated.Verify(value)
+	assert.True(t, ok)
+	assert.True(t, stale)
+	assert.Equal(t, uid, got)
+	assert.Equal(t, issued, issuedAt)
+
+	got, _, stale, ok = rotated.Verify(rotated.Sign(uid, issued))
+	assert.True(t, ok)
+	assert.False(t, stale)
+	assert.Equal(t, uid, got)
+
+	// the old key is retired
+	retired, err := ParseSigningKeys(strings.NewReader("k2:second-secret-key-002"))
+	require.NoError(t, err)
+	_, _, _, ok = retired.Verify(value)
+	assert.False(t, ok)
+
+	// forged values
+	other, _ := GenerateUserID()
+	sign := value[strings.LastIndex(value, ".")+1:]
+	for _, forged := range []string{
+		other + ".1600000000.k1." + sign,
+		uid + ".1700000000.k1." + sign,
+		uid + ".1600000000.k2." + sign,
+		uid + ".1600000000.k1." + sign + "00",
+		uid + ".k1." + sign,
+		"",
+	} {
+		_, _, _, ok := rotated.Verify(forged)
+		assert.False(t, ok, forged)
+	}
+}
+
+func TestSigner_SignValue(t *testing.T) {
+	old, err := ParseSigningKeys(strings.NewReader("k1:first-secret-key-0001"))
+	require.NoError(t, err)
+	rotated, err := ParseSigningKeys(strings.NewReader("k2:second-secret-key-002\nk1:first-secret-key-0001"))
+	require.NoError(t, err)
+
+	value := old.SignValue("flow", "payload.with.dots")
+	got, ok := rotated.VerifyValue("flow", value)
+	assert.True(t, ok)
+	assert.Equal(t, "payload.with.dots", got)
+
+	// values are bound to their purpose
+	_, ok = rotated.VerifyValue("other", value)
+	assert.False(t, ok)
+	_, _, _, ok = rotated.Verify(value)
+	assert.False(t, ok)
+
+	_, ok = rotated.VerifyValue("flow", value+"x")
+	assert.False(t, ok)
+	_, ok = rotated.VerifyValue("flow", "")
+	assert.False(t, ok)
+}
+
+func TestParseSigningKeys_Invalid(t *testing.T) {
+	for _, keys := range []string{
+		"",
+		"# comment only",
+		"short",
+		"bad.id:0123456789abcdef",
+		"k1:0123456789abcdef\nk1:fedcba9876543210",
+	} {
+		_, err := ParseSigningKeys(strings.NewReader(keys))
+		assert.Error(t, err, keys)
+	}
+}
@@ -0,0 +1,162 @@
+package auth
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Scopes granted to bearer tokens
+const (
+	ScopeShorten = "shorten"
+	ScopeDelete  = "delete"
+	ScopeRead    = "read"
+)
+
+// AllScopes lists all the known scopes
+var AllScopes = []string{ScopeShorten, ScopeDelete, ScopeRead}
+
+// ContextScopesKey holds the scopes of the bearer token the request was
+// authenticated with, requests authenticated with the cookie have none
+// set and are allowed everything
+var ContextScopesKey = contextKey("scopes")
+
+// ContextAPIKeyIDKey holds the id of the API key the request was
+// authenticated with, such requests are granted all the scopes
+var ContextAPIKeyIDKey = contextKey("api-key-id")
+
+// ErrInvalidToken is returned for malformed, forged and expired tokens
+var ErrInvalidToken = errors.New("invalid token")
+
+// TokenClaims are carried by bearer tokens
+type TokenClaims struct {
+	UserID    string   `json:"sub"`
+	IssuedAt  int64    `json:"iat"`
+	ExpiresAt int64    `json:"exp"`
+	Scopes    []string `json:"scope"`
+}
+
+// HasScope reports whether the claims grant scope
+func (c TokenClaims) HasScope(scope string) bool {
+	for _, s := range c.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+
+	return false
+}
+
+type tokenHeader struct {
+	Alg string `json:"alg"`
+	Typ string `json:"typ"`
+	Kid string `json:"kid"`
+}
+
+// ValidScope reports whether scope is known
+func ValidScope(scope string) bool {
+	for _, s := range AllScopes {
+		if s == scope {
+			return true
+		}
+	}
+
+	return false
+}
+
+// HasScope reports whether the request with ctx is allowed scope
+func HasScope(ctx context.Context, scope string) bool {
+	scopes, ok := ctx.Value(ContextScopesKey).([]string)
+	if !ok {
+		return true
+	}
+
+	return TokenClaims{Scopes: scopes}.HasScope(scope)
+}
+
+// SignToken returns a JWT (HS256) carrying claims signed with the current key
+func (s *Signer) SignToken(claims TokenClaims) (string, error) {
+	id := s.CurrentID()
+	header, err := json.Marshal(tokenHeader{Alg: "HS256", Typ: "JWT", Kid: id})
+	if err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	signed := encodeSegment(header) + "." + encodeSegment(payload)
+
+	return signed + "." + encodeSegment(s.tokenMAC(id, signed)), nil
+}
+
+// VerifyToken returns the claims of token if it's signed with any of
+// the keys and not expired by now
+func (s *Signer) VerifyToken(token string, now time.Time) (TokenClaims, error) {
+	var claims TokenClaims
+
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return claims, ErrInvalidToken
+	}
+
+	var header tokenHeader
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return claims, ErrInvalidToken
+	}
+	if header.Alg != "HS256" {
+		return claims, fmt.Errorf("%w: unsupported algorithm %q", ErrInvalidToken, header.Alg)
+	}
+	if _, found := s.keys[header.Kid]; !found {
+		return claims, fmt.Errorf("%w: unknown key", ErrInvalidToken)
+	}
+
+	sign, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil || !hmac.Equal(s.tokenMAC(header.Kid, parts[0]+"."+parts[1]), sign) {
+		return claims, fmt.Errorf("%w: bad signature", ErrInvalidToken)
+	}
+
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return claims, ErrInvalidToken
+	}
+	if len(claims.UserID) != uidChars {
+		return claims, fmt.Errorf("%w: bad subject", ErrInvalidToken)
+	}
+	if now.Unix() >= claims.ExpiresAt {
+		return claims, fmt.Errorf("%w: expired", ErrInvalidToken)
+	}
+
+	return claims, nil
+}
+
+// tokenMAC signs tokens with a key derived from the cookie signing key, so
+// that a cookie signature is never a valid token signature and vice versa
+func (s *Signer) tokenMAC(id, signed string) []byte {
+	kh := hmac.New(sha256.New, s.keys[id])
+	kh.Write([]byte("shorty-token"))
+
+	h := hmac.New(sha256.New, kh.Sum(nil))
+	h.Write([]byte(signed))
+
+	return h.Sum(nil)
+}
+
+func encodeSegment(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodeSegment(seg string, v interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(b, v)
+}
@@ -0,0 +1,61 @@
+package auth
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSigner_Token(t *testing.T) {
+	signer, err := ParseSigningKeys(strings.NewReader("k1:first-secret-key-0001"))
+	require.NoError(t, err)
+	uid, _ := GenerateUserID()
+	now := time.Unix(1600000000, 0)
+
+	claims := TokenClaims{
+		UserID:    uid,
+		IssuedAt:  now.Unix(),
+		ExpiresAt: now.Add(time.Hour).Unix(),
+		Scopes:    []string{ScopeShorten, ScopeRead},
+	}
+	token, err := signer.SignToken(claims)
+	require.NoError(t, err)
+
+	got, err := signer.VerifyToken(token, now)
+	require.NoError(t, err)
+	assert.Equal(t, claims, got)
+	assert.True(t, got.HasScope(ScopeRead))
+	assert.False(t, got.HasScope(ScopeDelete))
+
+	// expired
+	_, err = signer.VerifyToken(token, now.Add(time.Hour))
+	assert.ErrorIs(t, err, ErrInvalidToken)
+
+	// the key is retired
+	other, _ := ParseSigningKeys(strings.NewReader("k2:second-secret-key-002"))
+	_, err = other.VerifyToken(token, now)
+	assert.ErrorIs(t, err, ErrInvalidToken)
+
+	// a cookie signature is not a token signature
+	cookie := signer.Sign(uid, now)
+	_, err = signer.VerifyToken(cookie, now)
+	assert.ErrorIs(t, err, ErrInvalidToken)
+
+	// tampered claims and the "none" algorithm
+	parts := strings.Split(token, ".")
+	forged := base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"` + uid + `","exp":9999999999,"scope":["delete"]}`))
+	none := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none","kid":"k1"}`))
+	for _, bad := range []string{
+		parts[0] + "." + forged + "." + parts[2],
+		none + "." + parts[1] + ".",
+		parts[0] + "." + parts[1],
+		"",
+	} {
+		_, err := signer.VerifyToken(bad, now)
+		assert.ErrorIs(t, err, ErrInvalidToken, bad)
+	}
+}
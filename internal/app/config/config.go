@@ -1,14 +1,28 @@
 package config
 
 import (
+	"errors"
 	"flag"
+	"fmt"
+	"net"
 	"os"
+	"strconv"
 	"strings"
+	"time"
 )
 
 const (
-	defaultServerAddress = "localhost:8080"
-	defaultBaseURL       = "http://localhost:8080"
+	defaultServerAddress          = "localhost:8080"
+	defaultBaseURL                = "http://localhost:8080"
+	defaultDatabaseConnectRetries = 5
+	defaultDatabaseConnectBackoff = 1 * time.Second
+	defaultMemoryEvictionPolicy   = "reject"
+	defaultBloomFPRate            = 0.01
+	defaultEnumGuardMinRequests   = 20
+	defaultEnumGuardWindow        = 1 * time.Minute
+	defaultEnumGuardCooldown      = 15 * time.Minute
+	defaultSessionLifetime        = 30 * 24 * time.Hour
+	defaultTokenLifetime          = 30 * 24 * time.Hour
 )
 
 // Config contains application settings
@@ -16,23 +30,117 @@ type Config struct {
 	ServerAddress   string
 	BaseURL         string
 	FileStoragePath string
-	DatabaseDSN     string
+	// FileStorageKey (env only) and keys in FileStorageKeyFile, one per line
+	// as [id:]base64-key, enable encryption of FileStoragePath, the first
+	// key is used for encryption, others are only used for decryption
+	FileStorageKey     string
+	FileStorageKeyFile string
+	DatabaseDSN        string
+	// DatabaseReplicaDSNs lists optional read-only replicas, reads are
+	// load-balanced across them while writes always go to DatabaseDSN
+	DatabaseReplicaDSNs []string
+	// DatabaseConnectRetries is the number of extra attempts to reach the
+	// database at startup, the delay between attempts starts with
+	// DatabaseConnectBackoff and doubles every time
+	DatabaseConnectRetries int
+	DatabaseConnectBackoff time.Duration
+	// RedisURL selects Redis storage if DatabaseDSN is empty, links expire
+	// after RedisLinkTTL (0 means never)
+	RedisURL     string
+	RedisLinkTTL time.Duration
+	// WriteSpoolPath is a file to spool writes to while the database is
+	// unavailable, empty string disables spooling
+	WriteSpoolPath string
+	// CacheSize is the number of redirects cached in memory, 0 disables cache
+	CacheSize int
+	// MemoryMaxRecords and MemoryMaxBytes limit the in-memory storage (0 means
+	// no limit), when a limit is reached new records are either rejected
+	// or least recently accessed ones are evicted (see MemoryEvictionPolicy)
+	MemoryMaxRecords     int
+	MemoryMaxBytes       int64
+	MemoryEvictionPolicy string
+	// BloomCapacity is the expected number of short ids, it enables a Bloom
+	// filter answering 404 for unknown ids without asking the storage (0
+	// disables the filter), BloomFPRate is its target false positive rate.
+	// The filter must see every id created, so it is not supported with
+	// Redis storage, which is shared by instances with no change feed
+	BloomCapacity int
+	BloomFPRate   float64
+	// EnumGuardThreshold is the share of GET /{id} requests answered with
+	// 404 or 410 which makes a client suspected of enumerating short ids (0
+	// disables the guard), the share is counted over EnumGuardWindow once
+	// the client made EnumGuardMinRequests requests. Suspected clients are
+	// delayed and then blocked for EnumGuardCooldown, clients from
+	// EnumGuardAllowlist networks are never tracked
+	EnumGuardThreshold   float64
+	EnumGuardMinRequests int
+	EnumGuardWindow      time.Duration
+	EnumGuardCooldown    time.Duration
+	EnumGuardAllowlist   []*net.IPNet
+	// RateLimits maps route groups (see RateLimitShorten etc.) to their
+	// per-user (or per-IP for new users) limits, groups missing here are not
+	// limited
+	RateLimits map[string]RateLimit
+	// MaxUserLinks limits the number of active links per user and
+	// MaxBatchSize the number of urls in a single batch (0 means no limit)
+	MaxUserLinks int
+	MaxBatchSize int
+	// LinkACLs enables ACLs of links, which are checked on every redirect,
+	// ACLs stored are not enforced while disabled
+	LinkACLs bool
+	// LinkPasswords enables passwords of links, which are checked on every
+	// redirect, passwords stored are not asked for while disabled
+	LinkPasswords bool
+	// CookieSigningKey (env only) and keys in CookieSigningKeyFile, one per
+	// line as [id:]secret, sign user_id cookies, the first key is used for
+	// signing, others are only used to verify cookies to be re-issued
+	CookieSigningKey     string
+	CookieSigningKeyFile string
+	// SessionLifetime is the time a user_id cookie is valid for since it was
+	// issued, cookies are renewed while the user is active
+	SessionLifetime time.Duration
+	// CookieDomain is the user_id cookie domain, empty string means the host
+	// the cookie came from, the cookie path is the path of BaseURL
+	CookieDomain string
+	// TokenLifetime is the maximum lifetime of bearer tokens
+	TokenLifetime time.Duration
+	// OIDCIssuer enables single sign-on with the OpenID Connect provider,
+	// the client is OIDCClientID with OIDCClientSecret (env only, empty
+	// for public clients), OIDCRedirectURL defaults to
+	// BaseURL/auth/oidc/callback
+	OIDCIssuer       string
+	OIDCClientID     string
+	OIDCClientSecret string
+	OIDCRedirectURL  string
+	// Admins are user ids granted the admin role on start
+	Admins []string
 }
 
 var defaultConfig = Config{
-	ServerAddress: defaultServerAddress,
-	BaseURL:       defaultBaseURL,
+	ServerAddress:          defaultServerAddress,
+	BaseURL:                defaultBaseURL,
+	DatabaseConnectRetries: defaultDatabaseConnectRetries,
+	DatabaseConnectBackoff: defaultDatabaseConnectBackoff,
+	MemoryEvictionPolicy:   defaultMemoryEvictionPolicy,
+	BloomFPRate:            defaultBloomFPRate,
+	EnumGuardMinRequests:   defaultEnumGuardMinRequests,
+	EnumGuardWindow:        defaultEnumGuardWindow,
+	EnumGuardCooldown:      defaultEnumGuardCooldown,
+	SessionLifetime:        defaultSessionLifetime,
+	TokenLifetime:          defaultTokenLifetime,
 }
 
 // New creates config by merging default settings with flags, then with env variables
 // The last nonempty value takes precedence (default < flag < env) except for
-// FILE_STORAGE_PATH / DATABASE_DSN env variables which overrides -f / -d flags
-// even if empty
+// FILE_STORAGE_PATH / DATABASE_DSN / DATABASE_REPLICA_DSNS / REDIS_URL env
+// variables which overrides -f / -d / -r / -redis flags even if empty
 // New also handles validation and returns non-nil error if validation failed
 func New() (Config, error) {
 	c := defaultConfig
 	c.parseFlags()
-	c.parseEnvVars()
+	if err := c.parseEnvVars(); err != nil {
+		return c, err
+	}
 	err := c.Validate()
 	return c, err
 }
@@ -41,12 +149,58 @@ func (c *Config) parseFlags() {
 	flag.StringVar(&c.ServerAddress, "a", defaultServerAddress, "network address the server listens on")
 	flag.StringVar(&c.BaseURL, "b", defaultBaseURL, "resulting base URL")
 	flag.StringVar(&c.FileStoragePath, "f", "", `storage file (default "")`)
+	flag.StringVar(&c.FileStorageKeyFile, "fk", "", `storage file encryption keys file (default "")`)
 	flag.StringVar(&c.DatabaseDSN, "d", "", `database dsn (default "")`)
+	flag.Func("r", "comma-separated list of database replica dsns", func(s string) error {
+		c.DatabaseReplicaDSNs = splitList(s)
+		return nil
+	})
+	flag.IntVar(&c.DatabaseConnectRetries, "db-retries", defaultDatabaseConnectRetries, "number of extra attempts to reach the database at startup")
+	flag.DurationVar(&c.DatabaseConnectBackoff, "db-backoff", defaultDatabaseConnectBackoff, "initial delay between attempts to reach the database")
+	flag.StringVar(&c.RedisURL, "redis", "", `redis url, e.g. redis://localhost:6379/0 (default "")`)
+	flag.DurationVar(&c.RedisLinkTTL, "redis-ttl", 0, "time after which links expire in redis storage (default never)")
+	flag.StringVar(&c.WriteSpoolPath, "spool", "", `file to spool writes to while the database is unavailable (default "")`)
+	flag.IntVar(&c.CacheSize, "c", 0, "number of redirects cached in memory")
+	flag.IntVar(&c.MemoryMaxRecords, "mem-records", 0, "maximum number of records in the in-memory storage (default no limit)")
+	flag.Int64Var(&c.MemoryMaxBytes, "mem-bytes", 0, "approximate maximum memory used by the in-memory storage (default no limit)")
+	flag.StringVar(&c.MemoryEvictionPolicy, "mem-policy", defaultMemoryEvictionPolicy, "what to do when the in-memory storage is full: reject or lru")
+
+	flag.IntVar(&c.BloomCapacity, "bloom", 0, "expected number of short ids, enables the bloom filter for unknown ids (default disabled)")
+	flag.Float64Var(&c.BloomFPRate, "bloom-fp", defaultBloomFPRate, "target false positive rate of the bloom filter")
+	flag.Float64Var(&c.EnumGuardThreshold, "enum-threshold", 0, "share of not found responses which makes a client suspected of enumerating ids (default disabled)")
+	flag.IntVar(&c.EnumGuardMinRequests, "enum-min", defaultEnumGuardMinRequests, "number of requests a client makes before the enumeration guard judges it")
+	flag.DurationVar(&c.EnumGuardWindow, "enum-window", defaultEnumGuardWindow, "time window the enumeration guard counts requests over")
+	flag.DurationVar(&c.EnumGuardCooldown, "enum-cooldown", defaultEnumGuardCooldown, "time a client suspected of enumerating ids is blocked for")
+	flag.Func("enum-allow", "comma-separated list of networks (CIDR) never blocked by the enumeration guard", func(s string) error {
+		nets, err := parseCIDRList(s)
+		c.EnumGuardAllowlist = nets
+		return err
+	})
+	flag.Func("rate", `comma-separated list of rate limits as group=requests/per[:burst], e.g. "shorten=10/1s:20,batch=5/1m"`, func(s string) error {
+		limits, err := parseRateLimits(s)
+		c.RateLimits = limits
+		return err
+	})
+	flag.StringVar(&c.CookieSigningKeyFile, "ck", "", `cookie signing keys file (default "")`)
+	flag.DurationVar(&c.SessionLifetime, "session", defaultSessionLifetime, "lifetime of a user session, sessions of active users are renewed")
+	flag.StringVar(&c.CookieDomain, "cookie-domain", "", `user_id cookie domain (default "")`)
+	flag.DurationVar(&c.TokenLifetime, "token-ttl", defaultTokenLifetime, "maximum lifetime of bearer tokens")
+	flag.Func("admins", "comma-separated list of user ids granted the admin role", func(s string) error {
+		c.Admins = splitList(s)
+		return nil
+	})
+	flag.StringVar(&c.OIDCIssuer, "oidc-issuer", "", `OpenID Connect provider issuer URL (default "" means no single sign-on)`)
+	flag.StringVar(&c.OIDCClientID, "oidc-client-id", "", `OpenID Connect client id (default "")`)
+	flag.StringVar(&c.OIDCRedirectURL, "oidc-redirect", "", `OpenID Connect redirect URL (default "" means BaseURL/auth/oidc/callback)`)
+	flag.IntVar(&c.MaxUserLinks, "max-links", 0, "maximum number of active links per user (default no limit)")
+	flag.IntVar(&c.MaxBatchSize, "max-batch", 0, "maximum number of urls in a batch (default no limit)")
+	flag.BoolVar(&c.LinkACLs, "acl", false, "enable link ACLs, ACLs stored are not enforced while disabled")
+	flag.BoolVar(&c.LinkPasswords, "link-passwords", false, "enable link passwords, passwords stored are not asked for while disabled")
 
 	flag.Parse()
 }
 
-func (c *Config) parseEnvVars() {
+func (c *Config) parseEnvVars() error {
 	sa := os.Getenv("SERVER_ADDRESS")
 	if sa != "" {
 		c.ServerAddress = sa
@@ -63,11 +217,251 @@ func (c *Config) parseEnvVars() {
 		c.FileStoragePath = fsp
 	}
 
+	// the key is never taken from flags, command line is not a secret
+	c.FileStorageKey = os.Getenv("FILE_STORAGE_KEY")
+
+	fk := os.Getenv("FILE_STORAGE_KEY_FILE")
+	if fk != "" {
+		c.FileStorageKeyFile = fk
+	}
+
 	dd, ok := os.LookupEnv("DATABASE_DSN")
 	if ok {
 		// empty string is valid here, overrides -d flag and returns the default ""
 		c.DatabaseDSN = dd
 	}
+
+	rd, ok := os.LookupEnv("DATABASE_REPLICA_DSNS")
+	if ok {
+		// empty string is valid here, overrides -r flag and disables replicas
+		c.DatabaseReplicaDSNs = splitList(rd)
+	}
+
+	dr := os.Getenv("DATABASE_CONNECT_RETRIES")
+	if dr != "" {
+		retries, err := strconv.Atoi(dr)
+		if err != nil {
+			return fmt.Errorf("invalid DATABASE_CONNECT_RETRIES: %v", err)
+		}
+		c.DatabaseConnectRetries = retries
+	}
+
+	db := os.Getenv("DATABASE_CONNECT_BACKOFF")
+	if db != "" {
+		backoff, err := time.ParseDuration(db)
+		if err != nil {
+			return fmt.Errorf("invalid DATABASE_CONNECT_BACKOFF: %v", err)
+		}
+		c.DatabaseConnectBackoff = backoff
+	}
+
+	ru, ok := os.LookupEnv("REDIS_URL")
+	if ok {
+		// empty string is valid here, overrides -redis flag
+		c.RedisURL = ru
+	}
+
+	rt := os.Getenv("REDIS_LINK_TTL")
+	if rt != "" {
+		ttl, err := time.ParseDuration(rt)
+		if err != nil {
+			return fmt.Errorf("invalid REDIS_LINK_TTL: %v", err)
+		}
+		c.RedisLinkTTL = ttl
+	}
+
+	ws := os.Getenv("WRITE_SPOOL_PATH")
+	if ws != "" {
+		c.WriteSpoolPath = ws
+	}
+
+	cs := os.Getenv("CACHE_SIZE")
+	if cs != "" {
+		size, err := strconv.Atoi(cs)
+		if err != nil {
+			return fmt.Errorf("invalid CACHE_SIZE: %v", err)
+		}
+		c.CacheSize = size
+	}
+
+	mr := os.Getenv("MEMORY_MAX_RECORDS")
+	if mr != "" {
+		records, err := strconv.Atoi(mr)
+		if err != nil {
+			return fmt.Errorf("invalid MEMORY_MAX_RECORDS: %v", err)
+		}
+		c.MemoryMaxRecords = records
+	}
+
+	mb := os.Getenv("MEMORY_MAX_BYTES")
+	if mb != "" {
+		bytes, err := strconv.ParseInt(mb, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid MEMORY_MAX_BYTES: %v", err)
+		}
+		c.MemoryMaxBytes = bytes
+	}
+
+	mp := os.Getenv("MEMORY_EVICTION_POLICY")
+	if mp != "" {
+		c.MemoryEvictionPolicy = mp
+	}
+
+	bc := os.Getenv("BLOOM_CAPACITY")
+	if bc != "" {
+		capacity, err := strconv.Atoi(bc)
+		if err != nil {
+			return fmt.Errorf("invalid BLOOM_CAPACITY: %v", err)
+		}
+		c.BloomCapacity = capacity
+	}
+
+	bf := os.Getenv("BLOOM_FP_RATE")
+	if bf != "" {
+		rate, err := strconv.ParseFloat(bf, 64)
+		if err != nil {
+			return fmt.Errorf("invalid BLOOM_FP_RATE: %v", err)
+		}
+		c.BloomFPRate = rate
+	}
+
+	gt := os.Getenv("ENUM_GUARD_THRESHOLD")
+	if gt != "" {
+		threshold, err := strconv.ParseFloat(gt, 64)
+		if err != nil {
+			return fmt.Errorf("invalid ENUM_GUARD_THRESHOLD: %v", err)
+		}
+		c.EnumGuardThreshold = threshold
+	}
+
+	gm := os.Getenv("ENUM_GUARD_MIN_REQUESTS")
+	if gm != "" {
+		requests, err := strconv.Atoi(gm)
+		if err != nil {
+			return fmt.Errorf("invalid ENUM_GUARD_MIN_REQUESTS: %v", err)
+		}
+		c.EnumGuardMinRequests = requests
+	}
+
+	gw := os.Getenv("ENUM_GUARD_WINDOW")
+	if gw != "" {
+		window, err := time.ParseDuration(gw)
+		if err != nil {
+			return fmt.Errorf("invalid ENUM_GUARD_WINDOW: %v", err)
+		}
+		c.EnumGuardWindow = window
+	}
+
+	gc := os.Getenv("ENUM_GUARD_COOLDOWN")
+	if gc != "" {
+		cooldown, err := time.ParseDuration(gc)
+		if err != nil {
+			return fmt.Errorf("invalid ENUM_GUARD_COOLDOWN: %v", err)
+		}
+		c.EnumGuardCooldown = cooldown
+	}
+
+	if al := os.Getenv("ENUM_GUARD_ALLOWLIST"); al != "" {
+		nets, err := parseCIDRList(al)
+		if err != nil {
+			return fmt.Errorf("invalid ENUM_GUARD_ALLOWLIST: %v", err)
+		}
+		c.EnumGuardAllowlist = nets
+	}
+
+	if rl := os.Getenv("RATE_LIMITS"); rl != "" {
+		limits, err := parseRateLimits(rl)
+		if err != nil {
+			return fmt.Errorf("invalid RATE_LIMITS: %v", err)
+		}
+		c.RateLimits = limits
+	}
+
+	// the key is never taken from flags, command line is not a secret
+	c.CookieSigningKey = os.Getenv("COOKIE_SIGNING_KEY")
+
+	if ck := os.Getenv("COOKIE_SIGNING_KEY_FILE"); ck != "" {
+		c.CookieSigningKeyFile = ck
+	}
+
+	sl := os.Getenv("SESSION_LIFETIME")
+	if sl != "" {
+		lifetime, err := time.ParseDuration(sl)
+		if err != nil {
+			return fmt.Errorf("invalid SESSION_LIFETIME: %v", err)
+		}
+		c.SessionLifetime = lifetime
+	}
+
+	if cd := os.Getenv("COOKIE_DOMAIN"); cd != "" {
+		c.CookieDomain = cd
+	}
+
+	tl := os.Getenv("TOKEN_LIFETIME")
+	if tl != "" {
+		lifetime, err := time.ParseDuration(tl)
+		if err != nil {
+			return fmt.Errorf("invalid TOKEN_LIFETIME: %v", err)
+		}
+		c.TokenLifetime = lifetime
+	}
+
+	if oi := os.Getenv("OIDC_ISSUER"); oi != "" {
+		c.OIDCIssuer = oi
+	}
+
+	if oc := os.Getenv("OIDC_CLIENT_ID"); oc != "" {
+		c.OIDCClientID = oc
+	}
+
+	// the secret is never taken from flags, command line is not a secret
+	c.OIDCClientSecret = os.Getenv("OIDC_CLIENT_SECRET")
+
+	if or := os.Getenv("OIDC_REDIRECT_URL"); or != "" {
+		c.OIDCRedirectURL = or
+	}
+
+	if admins := os.Getenv("ADMIN_USER_IDS"); admins != "" {
+		c.Admins = splitList(admins)
+	}
+
+	ml := os.Getenv("MAX_USER_LINKS")
+	if ml != "" {
+		links, err := strconv.Atoi(ml)
+		if err != nil {
+			return fmt.Errorf("invalid MAX_USER_LINKS: %v", err)
+		}
+		c.MaxUserLinks = links
+	}
+
+	mbs := os.Getenv("MAX_BATCH_SIZE")
+	if mbs != "" {
+		size, err := strconv.Atoi(mbs)
+		if err != nil {
+			return fmt.Errorf("invalid MAX_BATCH_SIZE: %v", err)
+		}
+		c.MaxBatchSize = size
+	}
+
+	la := os.Getenv("LINK_ACLS")
+	if la != "" {
+		enabled, err := strconv.ParseBool(la)
+		if err != nil {
+			return fmt.Errorf("invalid LINK_ACLS: %v", err)
+		}
+		c.LinkACLs = enabled
+	}
+
+	lp := os.Getenv("LINK_PASSWORDS")
+	if lp != "" {
+		enabled, err := strconv.ParseBool(lp)
+		if err != nil {
+			return fmt.Errorf("invalid LINK_PASSWORDS: %v", err)
+		}
+		c.LinkPasswords = enabled
+	}
+
+	return nil
 }
 
 func (c *Config) Validate() error {
@@ -76,6 +470,12 @@ func (c *Config) Validate() error {
 	c.ServerAddress = strings.TrimSpace(c.ServerAddress)
 	c.BaseURL = strings.TrimSpace(c.BaseURL)
 	c.FileStoragePath = strings.TrimSpace(c.FileStoragePath)
+	c.WriteSpoolPath = strings.TrimSpace(c.WriteSpoolPath)
+	c.CookieDomain = strings.TrimSpace(c.CookieDomain)
+	c.OIDCIssuer = strings.TrimSpace(c.OIDCIssuer)
+	c.OIDCClientID = strings.TrimSpace(c.OIDCClientID)
+	c.OIDCRedirectURL = strings.TrimSpace(c.OIDCRedirectURL)
+	c.MemoryEvictionPolicy = strings.ToLower(strings.TrimSpace(c.MemoryEvictionPolicy))
 
 	if err := ValidateServerAddress(c.ServerAddress); err != nil {
 		return err
@@ -85,6 +485,109 @@ func (c *Config) Validate() error {
 		return err
 	}
 
+	if c.DatabaseConnectRetries < 0 || c.DatabaseConnectBackoff < 0 {
+		return errors.New("invalid database connect retries or backoff, should not be negative")
+	}
+
+	if c.RedisLinkTTL < 0 {
+		return errors.New("invalid redis link ttl, should not be negative")
+	}
+
+	if c.CacheSize < 0 {
+		return errors.New("invalid cache size, should not be negative")
+	}
+
+	if c.MemoryMaxRecords < 0 || c.MemoryMaxBytes < 0 {
+		return errors.New("invalid in-memory storage limits, should not be negative")
+	}
+
+	if c.MemoryEvictionPolicy != "reject" && c.MemoryEvictionPolicy != "lru" {
+		return errors.New("invalid in-memory storage eviction policy, should be reject or lru")
+	}
+
+	if c.BloomCapacity < 0 {
+		return errors.New("invalid bloom filter capacity, should not be negative")
+	}
+
+	if c.BloomFPRate <= 0 || c.BloomFPRate >= 1 {
+		return errors.New("invalid bloom filter false positive rate, should be between 0 and 1")
+	}
+
+	if c.BloomCapacity > 0 && c.DatabaseDSN == "" && c.RedisURL != "" {
+		return errors.New("bloom filter is not supported with redis storage, ids added by other instances would be missed")
+	}
+
+	if c.EnumGuardThreshold < 0 || c.EnumGuardThreshold > 1 {
+		return errors.New("invalid enumeration guard threshold, should be between 0 and 1")
+	}
+
+	if c.EnumGuardMinRequests < 1 || c.EnumGuardWindow <= 0 || c.EnumGuardCooldown <= 0 {
+		return errors.New("invalid enumeration guard min requests, window or cooldown, should be positive")
+	}
+
+	if c.SessionLifetime < time.Minute || c.TokenLifetime < time.Minute {
+		return errors.New("invalid session or token lifetime, should be at least a minute")
+	}
+
+	if c.MaxUserLinks < 0 || c.MaxBatchSize < 0 {
+		return errors.New("invalid user links quota or batch size, should not be negative")
+	}
+
+	if c.OIDCIssuer != "" {
+		if err := ValidateBaseURL(c.OIDCIssuer); err != nil {
+			return fmt.Errorf("invalid OpenID Connect issuer: %v", err)
+		}
+		if c.OIDCClientID == "" {
+			return errors.New("OpenID Connect client id is required")
+		}
+		if c.OIDCRedirectURL == "" {
+			c.OIDCRedirectURL = strings.TrimSuffix(c.BaseURL, "/") + "/auth/oidc/callback"
+		}
+		if err := ValidateBaseURL(c.OIDCRedirectURL); err != nil {
+			return fmt.Errorf("invalid OpenID Connect redirect URL: %v", err)
+		}
+	}
+
 	// No need to validate c.FileStoragePath, storage itself will do the job
 	return nil
 }
+
+// splitList splits comma-separated list into its nonempty trimmed elements
+func splitList(s string) []string {
+	var res []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			res = append(res, item)
+		}
+	}
+
+	return res
+}
+
+// parseCIDRList parses comma-separated list of networks in CIDR notation,
+// a bare IP address means a network of this single address
+func parseCIDRList(s string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, item := range splitList(s) {
+		if !strings.Contains(item, "/") {
+			ip := net.ParseIP(item)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid IP address %q", item)
+			}
+			bits := 8 * net.IPv6len
+			if ip4 := ip.To4(); ip4 != nil {
+				ip, bits = ip4, 8*net.IPv4len
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, n, err := net.ParseCIDR(item)
+		if err != nil {
+			return nil, err
+		}
+		nets = append(nets, n)
+	}
+
+	return nets, nil
+}
@@ -0,0 +1,47 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseCIDRList(t *testing.T) {
+	nets, err := parseCIDRList("10.0.0.0/8, 192.0.2.1,2001:db8::/32,,")
+	require.NoError(t, err)
+	require.Len(t, nets, 3)
+	assert.Equal(t, "10.0.0.0/8", nets[0].String())
+	assert.Equal(t, "192.0.2.1/32", nets[1].String())
+	assert.Equal(t, "2001:db8::/32", nets[2].String())
+
+	for _, bad := range []string{"10.0.0.0/33", "localhost", "192.0.2.300"} {
+		_, err := parseCIDRList(bad)
+		assert.Error(t, err, bad)
+	}
+}
+
+func TestValidate_OIDC(t *testing.T) {
+	c := defaultConfig
+	c.OIDCIssuer = "https://idp.example.com"
+	assert.Error(t, c.Validate(), "client id is required")
+
+	c.OIDCClientID = "shorty"
+	require.NoError(t, c.Validate())
+	assert.Equal(t, defaultBaseURL+"/auth/oidc/callback", c.OIDCRedirectURL)
+
+	c.OIDCRedirectURL = "not a url"
+	assert.Error(t, c.Validate())
+}
+
+func TestValidate_Bloom(t *testing.T) {
+	c := defaultConfig
+	c.BloomCapacity = 1000
+	require.NoError(t, c.Validate())
+
+	c.RedisURL = "redis://localhost:6379/0"
+	assert.Error(t, c.Validate(), "redis has no change feed")
+
+	c.DatabaseDSN = "postgres://localhost/shorty"
+	require.NoError(t, c.Validate())
+}
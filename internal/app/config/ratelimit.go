@@ -0,0 +1,99 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Route groups rate limits can be set for
+const (
+	// RateLimitShorten covers POST / and POST /api/shorten
+	RateLimitShorten = "shorten"
+	// RateLimitBatch covers POST /api/shorten/batch
+	RateLimitBatch = "batch"
+	// RateLimitUser covers /api/user/ routes
+	RateLimitUser = "user"
+	// RateLimitAuth covers signup, login, single sign-on and recovery, it's
+	// worth setting to slow down password guessing
+	RateLimitAuth = "auth"
+)
+
+// RateLimit allows Requests requests per Per on average with bursts of up
+// to Burst requests
+type RateLimit struct {
+	Requests int
+	Per      time.Duration
+	Burst    int
+}
+
+// parseRateLimits parses comma-separated list of group=requests/per[:burst]
+// items, e.g. "shorten=10/1s:20,batch=5/1m", burst defaults to requests
+func parseRateLimits(s string) (map[string]RateLimit, error) {
+	limits := make(map[string]RateLimit)
+	for _, item := range splitList(s) {
+		group, spec, ok := cut(item, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid rate limit %q, should be group=requests/per[:burst]", item)
+		}
+		group = strings.TrimSpace(group)
+		switch group {
+		case RateLimitShorten, RateLimitBatch, RateLimitUser, RateLimitAuth:
+		default:
+			return nil, fmt.Errorf("unknown rate limit group %q", group)
+		}
+
+		rl, err := parseRateLimit(strings.TrimSpace(spec))
+		if err != nil {
+			return nil, fmt.Errorf("invalid rate limit %q: %v", item, err)
+		}
+		limits[group] = rl
+	}
+
+	return limits, nil
+}
+
+func parseRateLimit(spec string) (RateLimit, error) {
+	var rl RateLimit
+
+	spec, burst, hasBurst := cut(spec, ":")
+	requests, per, ok := cut(spec, "/")
+	if !ok {
+		return rl, fmt.Errorf("no period")
+	}
+
+	var err error
+	if rl.Requests, err = strconv.Atoi(requests); err != nil {
+		return rl, err
+	}
+	// "10/s" means "10/1s"
+	if per != "" && (per[0] < '0' || per[0] > '9') {
+		per = "1" + per
+	}
+	if rl.Per, err = time.ParseDuration(per); err != nil {
+		return rl, err
+	}
+	rl.Burst = rl.Requests
+	if hasBurst {
+		if rl.Burst, err = strconv.Atoi(burst); err != nil {
+			return rl, err
+		}
+	}
+
+	if rl.Requests < 1 || rl.Per <= 0 || rl.Burst < 1 {
+		return rl, fmt.Errorf("requests, period and burst should be positive")
+	}
+
+	return rl, nil
+}
+
+// cut slices s around the first instance of sep (strings.Cut is not
+// available in Go 1.17)
+func cut(s, sep string) (before, after string, found bool) {
+	if i := strings.Index(s, sep); i >= 0 {
+		return s[:i], s[i+len(sep):], true
+	}
+
+	return s, "", false
+}
@@ -0,0 +1,24 @@
+package config
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseRateLimits(t *testing.T) {
+	limits, err := parseRateLimits("shorten=10/s:20, batch=5/1m, user = 100/1h")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]RateLimit{
+		RateLimitShorten: {Requests: 10, Per: time.Second, Burst: 20},
+		RateLimitBatch:   {Requests: 5, Per: time.Minute, Burst: 5},
+		RateLimitUser:    {Requests: 100, Per: time.Hour, Burst: 100},
+	}, limits)
+
+	for _, bad := range []string{"shorten", "redirect=1/s", "shorten=1", "shorten=0/s", "shorten=1/s:0", "shorten=1/x", "shorten=-1/s"} {
+		_, err := parseRateLimits(bad)
+		assert.Error(t, err, bad)
+	}
+}
@@ -0,0 +1,150 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html"
+	"net/http"
+	"strings"
+
+	"github.com/sbxb/shorty/internal/app/auth"
+	"github.com/sbxb/shorty/internal/app/logger"
+	"github.com/sbxb/shorty/internal/app/storage"
+	u "github.com/sbxb/shorty/internal/app/url"
+)
+
+// accessControl enforces ACLs of urls on redirects
+type accessControl struct {
+	acls       storage.ACLStorage
+	users      storage.UserStorage
+	workspaces storage.WorkspaceStorage
+	loginURL   string
+}
+
+// allow reports whether the visitor may be redirected by the url, otherwise
+// it replies with 401 asking anonymous visitors to sign in, or with 403.
+// Errors deny access
+func (ac *accessControl) allow(w http.ResponseWriter, r *http.Request, id string) bool {
+	ctx := r.Context()
+
+	acl, owner, err := ac.acls.GetACL(ctx, id)
+	if errors.Is(err, storage.ErrURLNotFound) {
+		// the url is resolved, so it's spooled and can't have an ACL yet
+		return true
+	} else if IsUnavailableError(err) {
+		ServiceUnavailable(w, err)
+		return false
+	} else if err != nil {
+		logger.Warningf("URLHandler: GetACL failed: %v", err)
+		http.Error(w, "Server failed to check access", http.StatusInternalServerError)
+		return false
+	}
+	if acl.IsPublic() {
+		return true
+	}
+	// restricted redirects differ by visitor
+	w.Header().Set("Cache-Control", "private")
+
+	// anonymous visitors have just been given their ids
+	userID, _ := ctx.Value(auth.ContextUserIDKey).(string)
+	if newUser, _ := ctx.Value(auth.ContextNewUserKey).(bool); newUser {
+		userID = ""
+	}
+	if userID != "" && userID == owner {
+		return true
+	}
+
+	signedIn, err := ac.signedIn(ctx, userID)
+	if err != nil {
+		logger.Warningf("URLHandler: access check failed: %v", err)
+		http.Error(w, "Server failed to check access", http.StatusInternalServerError)
+		return false
+	}
+	allowed, err := ac.allowed(ctx, acl, userID, signedIn)
+	if err != nil {
+		logger.Warningf("URLHandler: access check failed: %v", err)
+		http.Error(w, "Server failed to check access", http.StatusInternalServerError)
+		return false
+	}
+	if allowed {
+		return true
+	}
+	if !signedIn {
+		ac.loginPrompt(w, r)
+		return false
+	}
+	logger.Warningf("authorization failure: user %s is not allowed by %s visibility of %s", userID, acl.Visibility, id)
+	JSONError(w, http.StatusForbidden, u.ErrorResponse{Error: "Access to the link is restricted"})
+
+	return false
+}
+
+// allowed reports whether acl lets the user in, userID is empty for
+// anonymous visitors
+func (ac *accessControl) allowed(ctx context.Context, acl storage.ACL, userID string, signedIn bool) (bool, error) {
+	if userID == "" {
+		return false, nil
+	}
+
+	switch acl.Visibility {
+	case storage.VisibilityAuthenticated:
+		return signedIn, nil
+	case storage.VisibilityUsers:
+		for _, id := range acl.UserIDs {
+			if id == userID {
+				return true, nil
+			}
+		}
+	case storage.VisibilityWorkspace:
+		if ac.workspaces == nil {
+			return false, nil
+		}
+		role, err := ac.workspaces.GetWorkspaceRole(ctx, acl.WorkspaceID, userID)
+		if errors.Is(err, storage.ErrWorkspaceNotFound) {
+			return false, nil
+		}
+		return role != "", err
+	}
+
+	return false, nil
+}
+
+// signedIn reports whether the user has signed in, that is the request
+// carries a bearer token or an API key, or the user id is an account's one
+// (which single sign-on users get as well)
+func (ac *accessControl) signedIn(ctx context.Context, userID string) (bool, error) {
+	if userID == "" {
+		return false, nil
+	}
+	if !isCookieAuthenticated(ctx) {
+		return true, nil
+	}
+	if ac.users == nil {
+		return false, nil
+	}
+
+	_, err := ac.users.GetUser(ctx, userID)
+	if errors.Is(err, storage.ErrUserNotFound) {
+		return false, nil
+	}
+
+	return err == nil, err
+}
+
+// loginPrompt replies with 401, API clients get JSON, browsers get a page
+// linking to the login page (if any)
+func (ac *accessControl) loginPrompt(w http.ResponseWriter, r *http.Request) {
+	if strings.Contains(r.Header.Get("Accept"), "application/json") {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		JSONError(w, http.StatusUnauthorized, u.ErrorResponse{Error: "Sign in to follow the link"})
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusUnauthorized)
+	fmt.Fprint(w, "<!DOCTYPE html>\n<title>Sign in required</title>\n<p>Sign in to follow the link.</p>\n")
+	if ac.loginURL != "" {
+		fmt.Fprintf(w, "<p><a href=\"%s\">Sign in</a></p>\n", html.EscapeString(ac.loginURL))
+	}
+}
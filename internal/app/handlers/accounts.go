@@ -0,0 +1,182 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/sbxb/shorty/internal/app/auth"
+	"github.com/sbxb/shorty/internal/app/logger"
+	"github.com/sbxb/shorty/internal/app/storage"
+	u "github.com/sbxb/shorty/internal/app/url"
+)
+
+// validLogin matches logins after they are lowercased
+var validLogin = regexp.MustCompile(`^[a-z0-9._@-]{3,64}$`)
+
+// ssoLoginPrefix is reserved for the accounts of single sign-on users
+const ssoLoginPrefix = "sso-"
+
+// AccountHandler defines a container for account handlers and their
+// dependencies, accounts are ordinary user ids bound to a login and
+// a password, logging in issues the session cookie for the account's id
+type AccountHandler struct {
+	users   storage.UserStorage
+	signer  *auth.Signer
+	session auth.SessionSettings
+}
+
+func NewAccountHandler(users storage.UserStorage, signer *auth.Signer, session auth.SessionSettings) AccountHandler {
+	return AccountHandler{
+		users:   users,
+		signer:  signer,
+		session: session,
+	}
+}
+
+// SignupHandler process POST /api/user/signup request with JSON payload
+// {"login": "alice", "password": "...", "claim": true}, it creates the
+// account and logs in replying with 201 and {"login": "alice", "claimed": 3}
+// or 409 if the login is taken
+func (ah AccountHandler) SignupHandler(w http.ResponseWriter, r *http.Request) {
+	req, ok := ah.decodeRequest(w, r)
+	if !ok {
+		return
+	}
+	if strings.HasPrefix(req.Login, ssoLoginPrefix) {
+		http.Error(w, "Bad request: logins starting with "+ssoLoginPrefix+" are reserved", http.StatusBadRequest)
+		return
+	}
+
+	hash, err := auth.HashPassword(req.Password)
+	if errors.Is(err, auth.ErrInvalidPassword) {
+		http.Error(w, "Bad request: "+err.Error(), http.StatusBadRequest)
+		return
+	} else if err != nil {
+		http.Error(w, "Server failed to hash password", http.StatusInternalServerError)
+		return
+	}
+	uid, err := auth.GenerateUserID()
+	if err != nil {
+		http.Error(w, "Server failed to generate user ID", http.StatusInternalServerError)
+		return
+	}
+
+	user := storage.User{
+		ID:           uid,
+		Login:        req.Login,
+		PasswordHash: hash,
+		CreatedAt:    time.Now().UTC(),
+	}
+	err = ah.users.AddUser(r.Context(), user)
+	if errors.Is(err, storage.ErrLoginTaken) {
+		JSONError(w, http.StatusConflict, u.ErrorResponse{Error: "Login already taken"})
+		return
+	} else if err != nil {
+		http.Error(w, "Server failed to create account", http.StatusInternalServerError)
+		return
+	}
+
+	ah.logIn(w, r, user, req.Claim, http.StatusCreated)
+}
+
+// LoginHandler process POST /api/user/login request with JSON payload
+// {"login": "alice", "password": "...", "claim": true}, it replies with
+// {"login": "alice", "claimed": 3} or 401 for wrong credentials
+func (ah AccountHandler) LoginHandler(w http.ResponseWriter, r *http.Request) {
+	req, ok := ah.decodeRequest(w, r)
+	if !ok {
+		return
+	}
+
+	user, err := ah.users.GetUserByLogin(r.Context(), req.Login)
+	if err != nil && !errors.Is(err, storage.ErrUserNotFound) {
+		http.Error(w, "Server failed to find account", http.StatusInternalServerError)
+		return
+	}
+	// unknown logins are checked against an empty hash to take the same time
+	if !auth.CheckPassword(user.PasswordHash, req.Password) {
+		JSONError(w, http.StatusUnauthorized, u.ErrorResponse{Error: "Wrong login or password"})
+		return
+	}
+
+	ah.logIn(w, r, user, req.Claim, http.StatusOK)
+}
+
+// LogoutHandler process POST /api/user/logout request, it removes
+// the session cookie, so the next request gets a new anonymous user id
+func (ah AccountHandler) LogoutHandler(w http.ResponseWriter, r *http.Request) {
+	http.SetCookie(w, ah.session.ExpiredCookie())
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// decodeRequest decodes and validates the request replying with an error
+// if it's not ok, requests authenticated with a token or an API key are
+// rejected since the session cookie is what they log in
+func (ah AccountHandler) decodeRequest(w http.ResponseWriter, r *http.Request) (u.AccountRequest, bool) {
+	var req u.AccountRequest
+
+	if !isCookieAuthenticated(r.Context()) {
+		JSONError(w, http.StatusForbidden, u.ErrorResponse{Error: "Accounts can only be used with the cookie"})
+		return req, false
+	}
+
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&req); err != nil {
+		http.Error(w, "Bad request: "+err.Error(), http.StatusBadRequest)
+		return req, false
+	}
+	req.Login = strings.ToLower(strings.TrimSpace(req.Login))
+	if !validLogin.MatchString(req.Login) {
+		http.Error(w, "Bad request: login should be 3 to 64 letters, digits or ._@-", http.StatusBadRequest)
+		return req, false
+	}
+
+	return req, true
+}
+
+// logIn claims the links of the current user if asked and issues
+// the session cookie for the account
+func (ah AccountHandler) logIn(w http.ResponseWriter, r *http.Request, user storage.User, claim bool, status int) {
+	res := u.AccountResponse{Login: user.Login}
+
+	if claim {
+		claimed, err := ah.claim(r.Context(), user.ID)
+		if IsQuotaExceededError(err) {
+			QuotaExceeded(w, err)
+			return
+		} else if err != nil {
+			logger.Warningf("AccountHandler: ClaimURLs failed: %v", err)
+			http.Error(w, "Server failed to claim URLs", http.StatusInternalServerError)
+			return
+		}
+		res.Claimed = claimed
+	}
+
+	http.SetCookie(w, ah.session.Cookie(ah.signer, user.ID, time.Now()))
+	writeJSON(w, status, res)
+}
+
+// claim moves the links of the current user to the account, only links of
+// anonymous users can be claimed, those of other accounts are left alone
+func (ah AccountHandler) claim(ctx context.Context, accountID string) (int, error) {
+	if newUser, _ := ctx.Value(auth.ContextNewUserKey).(bool); newUser {
+		return 0, nil
+	}
+
+	uid := GetUserID(ctx)
+	_, err := ah.users.GetUser(ctx, uid)
+	if err == nil {
+		return 0, nil
+	}
+	if !errors.Is(err, storage.ErrUserNotFound) {
+		return 0, err
+	}
+
+	return ah.users.ClaimURLs(ctx, uid, accountID)
+}
@@ -0,0 +1,115 @@
+package handlers_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/sbxb/shorty/internal/app/auth"
+	"github.com/sbxb/shorty/internal/app/handlers"
+	"github.com/sbxb/shorty/internal/app/storage/inmemory"
+	u "github.com/sbxb/shorty/internal/app/url"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAccountHandler(t *testing.T) {
+	auth.PasswordCost = bcrypt.MinCost
+
+	store, _ := inmemory.NewMapStorage()
+	signer, err := auth.ParseSigningKeys(strings.NewReader("0123456789abcdef"))
+	require.NoError(t, err)
+	session := auth.SessionSettings{Lifetime: time.Hour, Path: "/"}
+	ah := handlers.NewAccountHandler(store, signer, session)
+
+	router := chi.NewRouter()
+	router.Post("/api/user/signup", ah.SignupHandler)
+	router.Post("/api/user/login", ah.LoginHandler)
+	router.Post("/api/user/logout", ah.LogoutHandler)
+
+	// do sends the request as uid and returns the response along with
+	// the user id of the session cookie issued
+	do := func(target, body, uid string) (*httptest.ResponseRecorder, string) {
+		req := httptest.NewRequest(http.MethodPost, cfg.BaseURL+target, strings.NewReader(body))
+		ctx := context.WithValue(req.Context(), auth.ContextUserIDKey, uid)
+		ctx = context.WithValue(ctx, auth.ContextNewUserKey, false)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req.WithContext(ctx))
+
+		for _, c := range w.Result().Cookies() {
+			if c.Name == auth.SessionCookieName && c.Value != "" {
+				sessionUID, _, _, ok := signer.Verify(c.Value)
+				require.True(t, ok)
+				return w, sessionUID
+			}
+		}
+
+		return w, ""
+	}
+
+	anonymous, _ := auth.GenerateUserID()
+	require.NoError(t, store.AddURL(context.Background(), u.URLEntry{ShortURL: "a", OriginalURL: "http://a.com"}, anonymous))
+
+	// signing up with claim moves anonymous links to the account
+	w, account := do("/api/user/signup", `{"login": " Alice ", "password": "correct horse", "claim": true}`, anonymous)
+	require.Equal(t, http.StatusCreated, w.Code)
+	var resp u.AccountResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, u.AccountResponse{Login: "alice", Claimed: 1}, resp)
+	require.NotEmpty(t, account)
+	assert.NotEqual(t, anonymous, account)
+	urls, _ := store.GetUserURLs(context.Background(), account)
+	assert.Len(t, urls, 1)
+
+	stored, err := store.GetUserByLogin(context.Background(), "alice")
+	require.NoError(t, err)
+	assert.NotContains(t, stored.PasswordHash, "correct horse")
+
+	w, _ = do("/api/user/signup", `{"login": "alice", "password": "another one"}`, anonymous)
+	assert.Equal(t, http.StatusConflict, w.Code)
+	// single sign-on logins are reserved
+	w, _ = do("/api/user/signup", `{"login": "sso-alice", "password": "another one"}`, anonymous)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	w, _ = do("/api/user/signup", `{"login": "bob", "password": "short"}`, anonymous)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	w, _ = do("/api/user/signup", `{"login": "b", "password": "long enough"}`, anonymous)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	// logging in from another device gets the same user id
+	w, sessionUID := do("/api/user/login", `{"login": "alice", "password": "correct horse"}`, "device")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, account, sessionUID)
+
+	for _, bad := range []string{
+		`{"login": "alice", "password": "wrong password"}`,
+		`{"login": "nobody", "password": "correct horse"}`,
+	} {
+		w, sessionUID = do("/api/user/login", bad, "device")
+		assert.Equal(t, http.StatusUnauthorized, w.Code, bad)
+		assert.Empty(t, sessionUID)
+	}
+
+	// links of another account are never claimed
+	w, _ = do("/api/user/signup", `{"login": "bob", "password": "bob's password"}`, "device")
+	require.Equal(t, http.StatusCreated, w.Code)
+	w, _ = do("/api/user/login", `{"login": "bob", "password": "bob's password", "claim": true}`, account)
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, 0, resp.Claimed)
+	urls, _ = store.GetUserURLs(context.Background(), account)
+	assert.Len(t, urls, 1)
+
+	w, _ = do("/api/user/logout", "", account)
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	cookies := w.Result().Cookies()
+	require.Len(t, cookies, 1)
+	assert.Equal(t, -1, cookies[0].MaxAge)
+}
@@ -0,0 +1,143 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/sbxb/shorty/internal/app/logger"
+	"github.com/sbxb/shorty/internal/app/storage"
+	u "github.com/sbxb/shorty/internal/app/url"
+)
+
+// maxACLUsers limits the number of users a url is shared with
+const maxACLUsers = 100
+
+// ACLHandler defines a container for url ACL handlers and their
+// dependencies, nil workspaces means urls can't be restricted to
+// a workspace
+type ACLHandler struct {
+	acls       storage.ACLStorage
+	workspaces storage.WorkspaceStorage
+}
+
+func NewACLHandler(acls storage.ACLStorage, workspaces storage.WorkspaceStorage) ACLHandler {
+	return ACLHandler{
+		acls:       acls,
+		workspaces: workspaces,
+	}
+}
+
+// GetHandler process GET /api/user/urls/{id}/acl request, it returns
+// {"visibility": "users", "users": ["..."]} for the url created by the user
+// or 404
+func (ah ACLHandler) GetHandler(w http.ResponseWriter, r *http.Request) {
+	acl, owner, err := ah.acls.GetACL(r.Context(), chi.URLParam(r, "id"))
+	if errors.Is(err, storage.ErrURLNotFound) || (err == nil && owner != GetUserID(r.Context())) {
+		http.Error(w, "URL not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "Server failed to get ACL", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, u.ACL{
+		Visibility: acl.Visibility,
+		Users:      acl.UserIDs,
+		Workspace:  acl.WorkspaceID,
+	})
+}
+
+// SetHandler process PUT /api/user/urls/{id}/acl request with JSON payload
+// {"visibility": "public|authenticated|users|workspace", "users": [...],
+// "workspace": "..."}, users are required for "users" visibility and
+// the workspace, which the user should be a member of, for "workspace"
+// visibility. It returns the ACL stored or 404 if the url wasn't created
+// by the user
+func (ah ACLHandler) SetHandler(w http.ResponseWriter, r *http.Request) {
+	var req u.ACL
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&req); err != nil {
+		http.Error(w, "Bad request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	acl := storage.ACL{
+		Visibility:  req.Visibility,
+		UserIDs:     uniqueIDs(req.Users),
+		WorkspaceID: req.Workspace,
+	}
+	if !storage.ValidVisibility(acl.Visibility) {
+		http.Error(w, "Bad request: unknown visibility", http.StatusBadRequest)
+		return
+	}
+	if (acl.Visibility == storage.VisibilityUsers) != (len(acl.UserIDs) > 0) {
+		http.Error(w, "Bad request: users are expected for users visibility only", http.StatusBadRequest)
+		return
+	}
+	if len(acl.UserIDs) > maxACLUsers {
+		JSONError(w, http.StatusRequestEntityTooLarge, u.ErrorResponse{
+			Error: "Too many users",
+			Limit: maxACLUsers,
+		})
+		return
+	}
+	if (acl.Visibility == storage.VisibilityWorkspace) != (acl.WorkspaceID != "") {
+		http.Error(w, "Bad request: workspace is expected for workspace visibility only", http.StatusBadRequest)
+		return
+	}
+	if len(acl.UserIDs) == 0 {
+		acl.UserIDs = nil
+	}
+
+	userID := GetUserID(r.Context())
+	if acl.WorkspaceID != "" && !ah.checkWorkspace(w, r, acl.WorkspaceID, userID) {
+		return
+	}
+
+	id := chi.URLParam(r, "id")
+	err := ah.acls.SetACL(r.Context(), id, userID, acl)
+	if errors.Is(err, storage.ErrURLNotFound) {
+		http.Error(w, "URL not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		logger.Warningf("ACLHandler: SetACL failed: %v", err)
+		http.Error(w, "Server failed to store ACL", http.StatusInternalServerError)
+		return
+	}
+	logger.Infof("ACLHandler: user %s set visibility of %s to %s", userID, id, acl.Visibility)
+
+	writeJSON(w, http.StatusOK, u.ACL{
+		Visibility: acl.Visibility,
+		Users:      acl.UserIDs,
+		Workspace:  acl.WorkspaceID,
+	})
+}
+
+// checkWorkspace replies with an error unless the user is a member of the
+// workspace, so that urls aren't shared with strangers' workspaces
+func (ah ACLHandler) checkWorkspace(w http.ResponseWriter, r *http.Request, id string, userID string) bool {
+	if ah.workspaces == nil {
+		http.Error(w, "Bad request: workspaces are not supported", http.StatusBadRequest)
+		return false
+	}
+
+	role, err := ah.workspaces.GetWorkspaceRole(r.Context(), id, userID)
+	if errors.Is(err, storage.ErrWorkspaceNotFound) {
+		http.Error(w, "Bad request: unknown workspace", http.StatusBadRequest)
+		return false
+	} else if err != nil {
+		http.Error(w, "Server failed to check workspace", http.StatusInternalServerError)
+		return false
+	}
+	if role == "" {
+		logger.Warningf("authorization failure: user %s is not a member of workspace %s", userID, id)
+		JSONError(w, http.StatusForbidden, u.ErrorResponse{Error: "Not a member of the workspace"})
+		return false
+	}
+
+	return true
+}
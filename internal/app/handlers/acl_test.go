@@ -0,0 +1,147 @@
+package handlers_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/sbxb/shorty/internal/app/auth"
+	"github.com/sbxb/shorty/internal/app/handlers"
+	"github.com/sbxb/shorty/internal/app/storage"
+	"github.com/sbxb/shorty/internal/app/storage/inmemory"
+	u "github.com/sbxb/shorty/internal/app/url"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestACLHandler(t *testing.T) {
+	ctx := context.Background()
+	store, _ := inmemory.NewMapStorage()
+	ah := handlers.NewACLHandler(store, store)
+
+	router := chi.NewRouter()
+	router.Get("/api/user/urls/{id}/acl", ah.GetHandler)
+	router.Put("/api/user/urls/{id}/acl", ah.SetHandler)
+
+	do := func(method, target, body, uid string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, cfg.BaseURL+target, strings.NewReader(body))
+		req = req.WithContext(context.WithValue(req.Context(), auth.ContextUserIDKey, uid))
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		return w
+	}
+
+	require.NoError(t, store.AddURL(ctx, u.URLEntry{ShortURL: "a", OriginalURL: "http://a.com"}, "owner"))
+	require.NoError(t, store.AddWorkspace(ctx, storage.Workspace{ID: "ws", Name: "Team"}, "owner"))
+	require.NoError(t, store.AddWorkspace(ctx, storage.Workspace{ID: "strangers", Name: "Strangers"}, "other"))
+
+	w := do(http.MethodGet, "/api/user/urls/a/acl", "", "owner")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"visibility": "public"}`, w.Body.String())
+	assert.Equal(t, http.StatusNotFound, do(http.MethodGet, "/api/user/urls/a/acl", "", "other").Code)
+
+	for _, body := range []string{
+		`{"visibility": "secret"}`,
+		`{"visibility": "users"}`,
+		`{"visibility": "public", "users": ["friend"]}`,
+		`{"visibility": "workspace"}`,
+		`{"visibility": "authenticated", "workspace": "ws"}`,
+		`{"visibility": "workspace", "workspace": "unknown"}`,
+	} {
+		assert.Equal(t, http.StatusBadRequest, do(http.MethodPut, "/api/user/urls/a/acl", body, "owner").Code, body)
+	}
+	// links are only restricted to the user's own workspaces
+	w = do(http.MethodPut, "/api/user/urls/a/acl", `{"visibility": "workspace", "workspace": "strangers"}`, "owner")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	w = do(http.MethodPut, "/api/user/urls/a/acl", `{"visibility": "authenticated"}`, "other")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	w = do(http.MethodPut, "/api/user/urls/a/acl", `{"visibility": "users", "users": ["friend", "friend"]}`, "owner")
+	require.Equal(t, http.StatusOK, w.Code)
+	var acl u.ACL
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &acl))
+	assert.Equal(t, u.ACL{Visibility: "users", Users: []string{"friend"}}, acl)
+
+	stored, _, err := store.GetACL(ctx, "a")
+	require.NoError(t, err)
+	assert.Equal(t, storage.ACL{Visibility: "users", UserIDs: []string{"friend"}}, stored)
+}
+
+func TestGetHandler_AccessControl(t *testing.T) {
+	ctx := context.Background()
+	store, _ := inmemory.NewMapStorage()
+	uh := handlers.NewURLHandler(store, cfg).WithAccessControl(store, store, store, cfg.BaseURL+"/auth/oidc/login")
+
+	router := chi.NewRouter()
+	router.Get("/{id}", uh.GetHandler)
+
+	// uid is empty for new anonymous visitors
+	do := func(id, uid string, byToken bool, accept string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, cfg.BaseURL+"/"+id, nil)
+		reqCtx := context.WithValue(req.Context(), auth.ContextUserIDKey, uid)
+		if uid == "" {
+			reqCtx = context.WithValue(reqCtx, auth.ContextUserIDKey, "fresh")
+			reqCtx = context.WithValue(reqCtx, auth.ContextNewUserKey, true)
+		}
+		if byToken {
+			reqCtx = context.WithValue(reqCtx, auth.ContextScopesKey, auth.AllScopes)
+		}
+		req = req.WithContext(reqCtx)
+		req.Header.Set("Accept", accept)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		return w
+	}
+
+	require.NoError(t, store.AddUser(ctx, storage.User{ID: "member", Login: "member", CreatedAt: time.Now()}))
+	require.NoError(t, store.AddUser(ctx, storage.User{ID: "outsider", Login: "outsider", CreatedAt: time.Now()}))
+	require.NoError(t, store.AddWorkspace(ctx, storage.Workspace{ID: "ws", Name: "Team"}, "member"))
+	for _, id := range []string{"public", "authenticated", "users", "workspace"} {
+		require.NoError(t, store.AddURL(ctx, u.URLEntry{ShortURL: id, OriginalURL: "http://" + id + ".com"}, "owner"))
+	}
+	require.NoError(t, store.SetACL(ctx, "authenticated", "owner", storage.ACL{Visibility: storage.VisibilityAuthenticated}))
+	require.NoError(t, store.SetACL(ctx, "users", "owner", storage.ACL{Visibility: storage.VisibilityUsers, UserIDs: []string{"anonymous-friend"}}))
+	require.NoError(t, store.SetACL(ctx, "workspace", "owner", storage.ACL{Visibility: storage.VisibilityWorkspace, WorkspaceID: "ws"}))
+
+	tests := []struct {
+		id      string
+		uid     string
+		byToken bool
+		want    int
+	}{
+		{"public", "", false, http.StatusTemporaryRedirect},
+		{"authenticated", "", false, http.StatusUnauthorized},
+		{"authenticated", "anonymous", false, http.StatusUnauthorized},
+		{"authenticated", "anonymous", true, http.StatusTemporaryRedirect},
+		{"authenticated", "outsider", false, http.StatusTemporaryRedirect},
+		{"users", "anonymous-friend", false, http.StatusTemporaryRedirect},
+		{"users", "outsider", false, http.StatusForbidden},
+		{"users", "owner", false, http.StatusTemporaryRedirect},
+		{"workspace", "member", false, http.StatusTemporaryRedirect},
+		{"workspace", "outsider", false, http.StatusForbidden},
+		{"workspace", "", false, http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		w := do(tt.id, tt.uid, tt.byToken, "")
+		assert.Equal(t, tt.want, w.Code, "%s by %q", tt.id, tt.uid)
+		if tt.id != "public" {
+			assert.Equal(t, "private", w.Header().Get("Cache-Control"))
+		}
+	}
+
+	// browsers are prompted to sign in, API clients get JSON
+	w := do("authenticated", "", false, "text/html")
+	assert.Contains(t, w.Body.String(), `href="`+cfg.BaseURL+`/auth/oidc/login"`)
+	w = do("authenticated", "", false, "application/json")
+	assert.Equal(t, "Bearer", w.Header().Get("WWW-Authenticate"))
+	assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+}
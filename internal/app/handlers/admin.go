@@ -0,0 +1,109 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/sbxb/shorty/internal/app/auth"
+	"github.com/sbxb/shorty/internal/app/config"
+	"github.com/sbxb/shorty/internal/app/logger"
+	"github.com/sbxb/shorty/internal/app/storage"
+	u "github.com/sbxb/shorty/internal/app/url"
+)
+
+// AdminHandler defines a container for administrative handlers and their
+// dependencies, access is checked by the router
+type AdminHandler struct {
+	urls  URLHandler
+	store storage.Storage
+	admin storage.AdminStorage
+	roles storage.RoleStorage
+}
+
+func NewAdminHandler(st storage.Storage, admin storage.AdminStorage, roles storage.RoleStorage, cfg config.Config) AdminHandler {
+	return AdminHandler{
+		urls:  NewURLHandler(st, cfg),
+		store: st,
+		admin: admin,
+		roles: roles,
+	}
+}
+
+// UserURLsHandler process GET /api/admin/users/{uid}/urls request, it
+// replies as GET /api/user/urls would reply to the user
+func (ah AdminHandler) UserURLsHandler(w http.ResponseWriter, r *http.Request) {
+	ah.urls.writeUserURLs(w, r, chi.URLParam(r, "uid"))
+}
+
+// DisableHandler process POST /api/admin/urls/disable request with JSON
+// payload ["id1", "id2", ...], the urls are marked as deleted whoever
+// they belong to, it replies with {"disabled": 2}
+func (ah AdminHandler) DisableHandler(w http.ResponseWriter, r *http.Request) {
+	var ids []string
+	if err := json.NewDecoder(r.Body).Decode(&ids); err != nil || len(ids) == 0 {
+		http.Error(w, "Bad request: list of ids expected", http.StatusBadRequest)
+		return
+	}
+
+	disabled, err := ah.admin.DisableURLs(r.Context(), ids)
+	if err != nil {
+		logger.Warningf("AdminHandler: DisableURLs failed: %v", err)
+		http.Error(w, "Server failed to disable URLs", http.StatusInternalServerError)
+		return
+	}
+	evictCached(ah.store, ids...)
+	logger.Infof("AdminHandler: user %s disabled %d of %v", GetUserID(r.Context()), disabled, ids)
+
+	writeJSON(w, http.StatusOK, u.DisableResponse{Disabled: disabled})
+}
+
+// StatsHandler process GET /api/admin/stats request, it replies with
+// {"urls": 42, "deleted_urls": 2, "users": 7}
+func (ah AdminHandler) StatsHandler(w http.ResponseWriter, r *http.Request) {
+	stats, err := ah.admin.Stats(r.Context())
+	if err != nil {
+		logger.Warningf("AdminHandler: Stats failed: %v", err)
+		http.Error(w, "Server failed to collect stats", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, u.StatsResponse{
+		URLs:        stats.URLs,
+		DeletedURLs: stats.DeletedURLs,
+		Users:       stats.Users,
+	})
+}
+
+// RoleHandler process PUT /api/admin/users/{uid}/role request with JSON
+// payload {"role": "moderator"}, it replies with 204, roles can't be
+// changed with a token or an API key
+func (ah AdminHandler) RoleHandler(w http.ResponseWriter, r *http.Request) {
+	if !isCookieAuthenticated(r.Context()) {
+		JSONError(w, http.StatusForbidden, u.ErrorResponse{Error: "Roles can only be changed with the cookie"})
+		return
+	}
+
+	var req u.RoleRequest
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&req); err != nil {
+		http.Error(w, "Bad request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !auth.ValidRole(req.Role) {
+		http.Error(w, "Bad request: role should be user, moderator or admin", http.StatusBadRequest)
+		return
+	}
+
+	uid := chi.URLParam(r, "uid")
+	if err := ah.roles.SetRole(r.Context(), uid, req.Role); err != nil {
+		logger.Warningf("AdminHandler: SetRole failed: %v", err)
+		http.Error(w, "Server failed to set role", http.StatusInternalServerError)
+		return
+	}
+	logger.Infof("AdminHandler: user %s set role of %s to %s", GetUserID(r.Context()), uid, req.Role)
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,71 @@
+package handlers_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/sbxb/shorty/internal/app/auth"
+	"github.com/sbxb/shorty/internal/app/handlers"
+	"github.com/sbxb/shorty/internal/app/storage/inmemory"
+	u "github.com/sbxb/shorty/internal/app/url"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAdminHandler(t *testing.T) {
+	ctx := context.Background()
+	store, _ := inmemory.NewMapStorage()
+	ah := handlers.NewAdminHandler(store, store, store, cfg)
+
+	router := chi.NewRouter()
+	router.Get("/api/admin/users/{uid}/urls", ah.UserURLsHandler)
+	router.Post("/api/admin/urls/disable", ah.DisableHandler)
+	router.Get("/api/admin/stats", ah.StatsHandler)
+	router.Put("/api/admin/users/{uid}/role", ah.RoleHandler)
+
+	do := func(method, target, body string, byToken bool) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, cfg.BaseURL+target, strings.NewReader(body))
+		ctx := context.WithValue(req.Context(), auth.ContextUserIDKey, "admin")
+		if byToken {
+			ctx = context.WithValue(ctx, auth.ContextScopesKey, auth.AllScopes)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req.WithContext(ctx))
+
+		return w
+	}
+
+	require.NoError(t, store.AddURL(ctx, u.URLEntry{ShortURL: "a", OriginalURL: "http://a.com"}, "someone"))
+	require.NoError(t, store.AddURL(ctx, u.URLEntry{ShortURL: "b", OriginalURL: "http://b.com"}, "someone"))
+
+	w := do(http.MethodGet, "/api/admin/users/someone/urls", "", false)
+	require.Equal(t, http.StatusOK, w.Code)
+	var urls []u.URLEntry
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &urls))
+	assert.Len(t, urls, 2)
+
+	w = do(http.MethodPost, "/api/admin/urls/disable", `["a", "unknown"]`, false)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"disabled": 1}`, w.Body.String())
+	assert.Equal(t, http.StatusBadRequest, do(http.MethodPost, "/api/admin/urls/disable", `[]`, false).Code)
+
+	w = do(http.MethodGet, "/api/admin/stats", "", false)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"urls": 2, "deleted_urls": 1, "users": 1}`, w.Body.String())
+
+	assert.Equal(t, http.StatusNoContent, do(http.MethodPut, "/api/admin/users/someone/role", `{"role": "moderator"}`, false).Code)
+	role, _ := store.GetRole(ctx, "someone")
+	assert.Equal(t, "moderator", role)
+	assert.Equal(t, http.StatusBadRequest, do(http.MethodPut, "/api/admin/users/someone/role", `{"role": "root"}`, false).Code)
+	assert.Equal(t, http.StatusBadRequest, do(http.MethodPut, "/api/admin/users/someone/role", `{"role": "moderator", "rol": "admin"}`, false).Code)
+	assert.Equal(t, http.StatusForbidden, do(http.MethodPut, "/api/admin/users/someone/role", `{"role": "admin"}`, true).Code)
+	role, _ = store.GetRole(ctx, "someone")
+	assert.Equal(t, "moderator", role)
+}
@@ -0,0 +1,150 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+	"unicode/utf8"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/sbxb/shorty/internal/app/auth"
+	"github.com/sbxb/shorty/internal/app/storage"
+	u "github.com/sbxb/shorty/internal/app/url"
+)
+
+// maxAPIKeyNameLength limits the length of API key names in characters
+const maxAPIKeyNameLength = 100
+
+// APIKeyHandler defines a container for API key handlers and their
+// dependencies
+type APIKeyHandler struct {
+	keys storage.APIKeyStorage
+}
+
+func NewAPIKeyHandler(keys storage.APIKeyStorage) APIKeyHandler {
+	return APIKeyHandler{
+		keys: keys,
+	}
+}
+
+// CreateHandler process POST /api/user/keys request with optional JSON
+// payload {"name": "CI"}, it returns the new key as
+// {"id": "...", "name": "CI", "prefix": "shk_1a2b3c4d", "key": "shk_...",
+// "created_at": "...", "uses": 0}
+// The key itself is never shown again
+func (kh APIKeyHandler) CreateHandler(w http.ResponseWriter, r *http.Request) {
+	if !kh.allowed(w, r) {
+		return
+	}
+
+	var req u.APIKeyRequest
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		http.Error(w, "Bad request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if utf8.RuneCountInString(req.Name) > maxAPIKeyNameLength {
+		http.Error(w, "Bad request: name is too long", http.StatusBadRequest)
+		return
+	}
+
+	generated, err := auth.GenerateAPIKey()
+	if err != nil {
+		http.Error(w, "Server failed to generate API key", http.StatusInternalServerError)
+		return
+	}
+	key := storage.APIKey{
+		ID:        generated.ID,
+		UserID:    GetUserID(r.Context()),
+		Name:      req.Name,
+		Prefix:    generated.Prefix,
+		Hash:      generated.Hash,
+		CreatedAt: time.Now().UTC(),
+	}
+	if err := kh.keys.AddAPIKey(r.Context(), key); err != nil {
+		http.Error(w, "Server failed to store API key", http.StatusInternalServerError)
+		return
+	}
+
+	res := newAPIKeyResponse(key)
+	res.Key = generated.Key
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusCreated, res)
+}
+
+// ListHandler process GET /api/user/keys request, it returns the user's
+// keys (without the keys themselves) as
+// [{"id": "...", "name": "CI", "prefix": "shk_1a2b3c4d",
+// "created_at": "...", "last_used_at": "...", "uses": 42}, ...]
+// or 204 No Content if the user has no keys
+func (kh APIKeyHandler) ListHandler(w http.ResponseWriter, r *http.Request) {
+	if !kh.allowed(w, r) {
+		return
+	}
+
+	keys, err := kh.keys.GetUserAPIKeys(r.Context(), GetUserID(r.Context()))
+	if err != nil {
+		http.Error(w, "Server failed to list API keys", http.StatusInternalServerError)
+		return
+	}
+	if len(keys) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	res := make([]u.APIKeyResponse, 0, len(keys))
+	for _, key := range keys {
+		res = append(res, newAPIKeyResponse(key))
+	}
+	writeJSON(w, http.StatusOK, res)
+}
+
+// RevokeHandler process DELETE /api/user/keys/{id} request, the key stops
+// working immediately, it returns 404 if the user has no such key
+func (kh APIKeyHandler) RevokeHandler(w http.ResponseWriter, r *http.Request) {
+	if !kh.allowed(w, r) {
+		return
+	}
+
+	err := kh.keys.DeleteAPIKey(r.Context(), chi.URLParam(r, "id"), GetUserID(r.Context()))
+	if errors.Is(err, storage.ErrAPIKeyNotFound) {
+		http.Error(w, "API key not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "Server failed to revoke API key", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// allowed rejects requests authenticated with a token or an API key, so
+// that a leaked credential can't be used to create long-lived ones
+func (kh APIKeyHandler) allowed(w http.ResponseWriter, r *http.Request) bool {
+	if !isCookieAuthenticated(r.Context()) {
+		JSONError(w, http.StatusForbidden, u.ErrorResponse{Error: "API keys can only be managed with the cookie"})
+		return false
+	}
+
+	return true
+}
+
+func newAPIKeyResponse(key storage.APIKey) u.APIKeyResponse {
+	res := u.APIKeyResponse{
+		ID:        key.ID,
+		Name:      key.Name,
+		Prefix:    key.Prefix,
+		CreatedAt: key.CreatedAt,
+		Uses:      key.Uses,
+	}
+	if !key.LastUsedAt.IsZero() {
+		lastUsedAt := key.LastUsedAt
+		res.LastUsedAt = &lastUsedAt
+	}
+
+	return res
+}
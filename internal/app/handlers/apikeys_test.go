@@ -0,0 +1,75 @@
+package handlers_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/sbxb/shorty/internal/app/auth"
+	"github.com/sbxb/shorty/internal/app/handlers"
+	"github.com/sbxb/shorty/internal/app/storage/inmemory"
+	u "github.com/sbxb/shorty/internal/app/url"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAPIKeyHandler(t *testing.T) {
+	store, _ := inmemory.NewMapStorage()
+	kh := handlers.NewAPIKeyHandler(store)
+
+	router := chi.NewRouter()
+	router.Post("/api/user/keys", kh.CreateHandler)
+	router.Get("/api/user/keys", kh.ListHandler)
+	router.Delete("/api/user/keys/{id}", kh.RevokeHandler)
+
+	do := func(method, target, body, uid string, byToken bool) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, cfg.BaseURL+target, strings.NewReader(body))
+		ctx := context.WithValue(req.Context(), auth.ContextUserIDKey, uid)
+		if byToken {
+			ctx = context.WithValue(ctx, auth.ContextScopesKey, auth.AllScopes)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req.WithContext(ctx))
+
+		return w
+	}
+
+	assert.Equal(t, http.StatusNoContent, do(http.MethodGet, "/api/user/keys", "", "user", false).Code)
+
+	w := do(http.MethodPost, "/api/user/keys", `{"name": "CI"}`, "user", false)
+	require.Equal(t, http.StatusCreated, w.Code)
+	var created u.APIKeyResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &created))
+	assert.Equal(t, "CI", created.Name)
+	assert.True(t, auth.LooksLikeAPIKey(created.Key))
+	assert.True(t, strings.HasPrefix(created.Key, created.Prefix))
+
+	// only the hash is stored
+	stored, err := store.GetAPIKeyByHash(context.Background(), auth.HashAPIKey(created.Key))
+	require.NoError(t, err)
+	assert.Equal(t, "user", stored.UserID)
+	assert.NotContains(t, stored.Hash, created.Key)
+
+	w = do(http.MethodGet, "/api/user/keys", "", "user", false)
+	require.Equal(t, http.StatusOK, w.Code)
+	var listed []u.APIKeyResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &listed))
+	require.Len(t, listed, 1)
+	assert.Equal(t, created.ID, listed[0].ID)
+	assert.Empty(t, listed[0].Key)
+	assert.Nil(t, listed[0].LastUsedAt)
+
+	assert.Equal(t, http.StatusBadRequest, do(http.MethodPost, "/api/user/keys", `{"name": "`+strings.Repeat("x", 101)+`"}`, "user", false).Code)
+	assert.Equal(t, http.StatusForbidden, do(http.MethodPost, "/api/user/keys", "", "user", true).Code)
+	assert.Equal(t, http.StatusForbidden, do(http.MethodDelete, "/api/user/keys/"+created.ID, "", "user", true).Code)
+
+	assert.Equal(t, http.StatusNotFound, do(http.MethodDelete, "/api/user/keys/"+created.ID, "", "other", false).Code)
+	assert.Equal(t, http.StatusNoContent, do(http.MethodDelete, "/api/user/keys/"+created.ID, "", "user", false).Code)
+	assert.Equal(t, http.StatusNotFound, do(http.MethodDelete, "/api/user/keys/"+created.ID, "", "user", false).Code)
+}
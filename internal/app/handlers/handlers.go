@@ -3,15 +3,17 @@ package handlers
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"strings"
 
 	"github.com/go-chi/chi/v5"
+	"github.com/sbxb/shorty/internal/app/auth"
 	"github.com/sbxb/shorty/internal/app/config"
 	"github.com/sbxb/shorty/internal/app/logger"
 	"github.com/sbxb/shorty/internal/app/storage"
-	"github.com/sbxb/shorty/internal/app/storage/psql"
 	u "github.com/sbxb/shorty/internal/app/url"
 )
 
@@ -19,6 +21,12 @@ import (
 type URLHandler struct {
 	store  storage.Storage
 	config config.Config
+	access *accessControl
+	// passwords (if any) protect urls, guard throttles wrong ones
+	passwords storage.LinkPasswordStorage
+	guard     *passwordGuard
+	// meta (if any) keeps titles, notes and tags of urls
+	meta storage.URLMetaStorage
 }
 
 func NewURLHandler(st storage.Storage, cfg config.Config) URLHandler {
@@ -28,28 +36,97 @@ func NewURLHandler(st storage.Storage, cfg config.Config) URLHandler {
 	}
 }
 
+// WithAccessControl makes GetHandler enforce ACLs of urls, users (if any)
+// tell signed-in users from anonymous ones, workspaces (if any) let members
+// in, visitors who should sign in are prompted to with loginURL (if any)
+func (uh URLHandler) WithAccessControl(acls storage.ACLStorage, users storage.UserStorage,
+	workspaces storage.WorkspaceStorage, loginURL string) URLHandler {
+	uh.access = &accessControl{
+		acls:       acls,
+		users:      users,
+		workspaces: workspaces,
+		loginURL:   loginURL,
+	}
+
+	return uh
+}
+
+// WithPasswords lets urls be created with a password, which GetHandler and
+// UnlockHandler ask for
+func (uh URLHandler) WithPasswords(passwords storage.LinkPasswordStorage) URLHandler {
+	uh.passwords = passwords
+	uh.guard = newPasswordGuard()
+
+	return uh
+}
+
+// WithMeta lets urls be created with a title, notes and tags, which are
+// listed along with the user's urls
+func (uh URLHandler) WithMeta(meta storage.URLMetaStorage) URLHandler {
+	uh.meta = meta
+
+	return uh
+}
+
 // GetHandler process GET /{id} request
 // ... Эндпоинт GET /{id} принимает в качестве URL-параметра идентификатор
 // сокращённого URL и возвращает ответ с кодом 307 и оригинальным URL
 // в HTTP-заголовке Location ...
+// Password-protected urls reply with the password form instead, API clients
+// supply the password in LinkPasswordHeader
 func (uh URLHandler) GetHandler(w http.ResponseWriter, r *http.Request) {
 	id := chi.URLParam(r, "id")
 
+	url, ok := uh.resolve(w, r, id)
+	if !ok {
+		return
+	}
+	if uh.passwords != nil && !uh.unlock(w, r, id, r.Header.Get(LinkPasswordHeader), false) {
+		return
+	}
+	w.Header().Set("Location", url)
+	w.WriteHeader(http.StatusTemporaryRedirect)
+}
+
+// UnlockHandler process POST /{id} request the password form submits, it
+// redirects with 303 if the password is right
+func (uh URLHandler) UnlockHandler(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+
+	url, ok := uh.resolve(w, r, id)
+	if !ok {
+		return
+	}
+	if !uh.unlock(w, r, id, r.PostFormValue("password"), true) {
+		return
+	}
+	w.Header().Set("Location", url)
+	w.WriteHeader(http.StatusSeeOther)
+}
+
+// resolve returns the url the visitor may be redirected by, otherwise it
+// replies with an error
+func (uh URLHandler) resolve(w http.ResponseWriter, r *http.Request, id string) (string, bool) {
 	url, err := uh.store.GetURL(r.Context(), id)
 	if err != nil {
 		if IsDeletedError(err) {
 			http.Error(w, "Record deleted", http.StatusGone)
+		} else if IsUnavailableError(err) {
+			ServiceUnavailable(w, err)
 		} else {
 			http.Error(w, "Server failed to process URL", http.StatusInternalServerError)
 		}
-		return
+		return "", false
 	}
 	if url == "" {
 		http.NotFound(w, r)
-		return
+		return "", false
 	}
-	w.Header().Set("Location", url)
-	w.WriteHeader(http.StatusTemporaryRedirect)
+	if uh.access != nil && !uh.access.allow(w, r, id) {
+		return "", false
+	}
+
+	return url, true
 }
 
 // PostHandler process POST / request
@@ -74,22 +151,29 @@ func (uh URLHandler) PostHandler(w http.ResponseWriter, r *http.Request) {
 
 	userID := GetUserID(r.Context())
 
-	ue := u.URLEntry{
-		ShortURL:    u.ShortID(url),
-		OriginalURL: url,
-	}
-
-	err = uh.store.AddURL(r.Context(), ue, userID)
+	id, err := addURL(r.Context(), uh.store, url, userID)
 
 	if IsConflictError(err) {
 		status = http.StatusConflict
+	} else if IsUnavailableError(err) {
+		ServiceUnavailable(w, err)
+		return
+	} else if IsInsufficientStorageError(err) {
+		http.Error(w, "Server storage is full", http.StatusInsufficientStorage)
+		return
+	} else if IsQuotaExceededError(err) {
+		QuotaExceeded(w, err)
+		return
 	} else if err != nil {
 		http.Error(w, "Server failed to store URL", http.StatusInternalServerError)
 		return
 	}
 
+	if status == http.StatusCreated {
+		markRecentWrite(w, uh.config)
+	}
 	w.WriteHeader(status)
-	fmt.Fprintf(w, "%s/%s", uh.config.BaseURL, ue.ShortURL)
+	fmt.Fprintf(w, "%s/%s", uh.config.BaseURL, id)
 }
 
 // JSONBatchPostHandler process POST /api/shorten/batch request with JSON array payload
@@ -128,21 +212,45 @@ func (uh URLHandler) JSONBatchPostHandler(w http.ResponseWriter, r *http.Request
 		return
 	}
 
+	if uh.config.MaxBatchSize > 0 && len(batch) > uh.config.MaxBatchSize {
+		JSONError(w, http.StatusRequestEntityTooLarge, u.ErrorResponse{
+			Error: "Batch size exceeded",
+			Limit: uh.config.MaxBatchSize,
+		})
+		return
+	}
+
 	userID := GetUserID(r.Context())
 
 	// we're ready to start processing
 	respBatch := make([]u.BatchURLEntry, 0, len(batch))
+	var err error
 	for _, entry := range batch {
-		ne := u.BatchURLEntry{
+		var id string
+		if id, err = batchID(r.Context(), uh.store, entry.OriginalURL); err != nil {
+			break
+		}
+		respBatch = append(respBatch, u.BatchURLEntry{
 			CorrelationID: entry.CorrelationID,
 			OriginalURL:   entry.OriginalURL,
-			ShortURL:      u.ShortID(entry.OriginalURL),
-		}
-		respBatch = append(respBatch, ne)
+			ShortURL:      id,
+		})
 	}
-
-	if err := uh.store.AddBatchURL(r.Context(), respBatch, userID); err != nil {
-		http.Error(w, "Server failed to store URL(s)", http.StatusInternalServerError)
+	if err == nil {
+		err = uh.store.AddBatchURL(r.Context(), respBatch, userID)
+	}
+	if err != nil {
+		if IsConflictError(err) {
+			http.Error(w, "Conflict: no free id found", http.StatusConflict)
+		} else if IsUnavailableError(err) {
+			ServiceUnavailable(w, err)
+		} else if IsInsufficientStorageError(err) {
+			http.Error(w, "Server storage is full", http.StatusInsufficientStorage)
+		} else if IsQuotaExceededError(err) {
+			QuotaExceeded(w, err)
+		} else {
+			http.Error(w, "Server failed to store URL(s)", http.StatusInternalServerError)
+		}
 		return
 	}
 
@@ -156,6 +264,7 @@ func (uh URLHandler) JSONBatchPostHandler(w http.ResponseWriter, r *http.Request
 		return
 	}
 
+	markRecentWrite(w, uh.config)
 	w.Header().Set("Content-Type", ContentType)
 	w.WriteHeader(http.StatusCreated)
 	w.Write(jr)
@@ -164,6 +273,8 @@ func (uh URLHandler) JSONBatchPostHandler(w http.ResponseWriter, r *http.Request
 // JSONPostHandler process POST /api/shorten request with JSON payload
 // ... эндпоинт POST /api/shorten, принимающий в теле запроса JSON-объект
 // {"url": "<some_url>"} и возвращающий в ответ объект {"result": "<shorten_url>"}
+// An optional "password" protects the url, urls shortened before are not
+// protected, so such requests are answered with 409 and no short url
 func (uh URLHandler) JSONPostHandler(w http.ResponseWriter, r *http.Request) {
 	const ContentType = "application/json"
 	var req u.URLRequest
@@ -176,33 +287,103 @@ func (uh URLHandler) JSONPostHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// is request an empty struct
-	if req == (u.URLRequest{}) || !u.IsValidInputURL(req.URL) {
+	if !u.IsValidInputURL(req.URL) {
 		http.Error(w, "Bad request: non-valid object received", http.StatusBadRequest)
 		return
 	}
 
+	meta := storage.URLMeta{Title: req.Title, Notes: req.Notes, Tags: req.Tags}
+	if !meta.IsEmpty() {
+		var err error
+		if uh.meta == nil {
+			err = errMetaNotSupported
+		} else {
+			meta, err = normalizeMeta(meta)
+		}
+		if err != nil {
+			http.Error(w, "Bad request: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	var hash string
+	if req.Password != "" {
+		if uh.passwords == nil {
+			http.Error(w, "Bad request: passwords are not supported", http.StatusBadRequest)
+			return
+		}
+		var err error
+		if hash, err = auth.HashPassword(req.Password); errors.Is(err, auth.ErrInvalidPassword) {
+			http.Error(w, "Bad request: "+err.Error(), http.StatusBadRequest)
+			return
+		} else if err != nil {
+			http.Error(w, "Server failed to hash password", http.StatusInternalServerError)
+			return
+		}
+	}
+
 	status := http.StatusCreated
 
 	userID := GetUserID(r.Context())
 
-	ue := u.URLEntry{
-		ShortURL:    u.ShortID(req.URL),
-		OriginalURL: req.URL,
+	// the password must be set right after the url is created, so the url
+	// is not spooled while the storage is down
+	ctx := r.Context()
+	if hash != "" {
+		ctx = storage.WithImmediateWrite(ctx)
 	}
 
-	err := uh.store.AddURL(r.Context(), ue, userID)
+	id, err := addURL(ctx, uh.store, req.URL, userID)
 
-	if IsConflictError(err) {
+	if IsConflictError(err) && hash != "" {
+		// the url shortened before is not protected, its short url would
+		// be shared as if it were
+		http.Error(w, "Conflict: URL already shortened without the password", http.StatusConflict)
+		return
+	} else if IsConflictError(err) {
 		status = http.StatusConflict
+	} else if IsUnavailableError(err) {
+		ServiceUnavailable(w, err)
+		return
+	} else if IsInsufficientStorageError(err) {
+		http.Error(w, "Server storage is full", http.StatusInsufficientStorage)
+		return
+	} else if IsQuotaExceededError(err) {
+		QuotaExceeded(w, err)
+		return
 	} else if err != nil {
 		http.Error(w, "Server failed to store URL", http.StatusInternalServerError)
 		return
 	}
 
+	// the password is only set on the url just created, the url is deleted
+	// rather than left unprotected if that fails
+	if hash != "" {
+		if err := uh.passwords.SetURLPassword(r.Context(), id, userID, hash); err != nil {
+			logger.Warningf("URLHandler: SetURLPassword failed: %v", err)
+			if err := uh.store.DeleteBatch(r.Context(), []string{id}, userID); err != nil {
+				logger.Warningf("URLHandler: DeleteBatch failed: %v", err)
+			}
+			http.Error(w, "Server failed to store password", http.StatusInternalServerError)
+			return
+		}
+	}
+	// the url is there anyway, so details which are not stored are only
+	// reported, they can be set later with PATCH /api/user/urls/{id}
+	var warning string
+	if !meta.IsEmpty() && status == http.StatusConflict {
+		warning = "URL details not stored, the URL is already shortened"
+	} else if !meta.IsEmpty() {
+		if err := uh.meta.SetURLMeta(r.Context(), id, userID, meta); err != nil {
+			logger.Warningf("URLHandler: SetURLMeta failed: %v", err)
+			warning = "URL details not stored, server failed to store them"
+		}
+	}
+
 	jr, err := json.Marshal(
 		u.URLResponse{
-			Result: fmt.Sprintf("%s/%s", uh.config.BaseURL, ue.ShortURL),
+			Result:  fmt.Sprintf("%s/%s", uh.config.BaseURL, id),
+			Warning: warning,
 		},
 	)
 
@@ -211,6 +392,9 @@ func (uh URLHandler) JSONPostHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if status == http.StatusCreated {
+		markRecentWrite(w, uh.config)
+	}
 	w.Header().Set("Content-Type", ContentType)
 	w.WriteHeader(status)
 	w.Write(jr)
@@ -227,6 +411,7 @@ func (uh URLHandler) JSONPostHandler(w http.ResponseWriter, r *http.Request) {
 // Успешно удалить URL может пользователь, его создавший.
 // При запросе удалённого URL с помощью хендлера GET /{id} нужно вернуть
 // статус 410 Gone.
+// Editors and owners of the workspace a URL belongs to may delete it as well
 func (uh URLHandler) UserDeleteHandler(w http.ResponseWriter, r *http.Request) {
 	var deleteIDs []string
 
@@ -251,6 +436,8 @@ func (uh URLHandler) UserDeleteHandler(w http.ResponseWriter, r *http.Request) {
 			// purposes only), delete batch straightforward since map-based
 			// storage can not really benefit from concurrency due to heavy
 			// locking and fullscan
+			// RedisStorage deletes the whole batch with a single script,
+			// so there is nothing to gain from concurrency either
 			err := uh.store.DeleteBatch(context.Background(), deleteIDs, userID)
 			if err != nil {
 				logger.Warningf("UserDeleteHandler : DeleteBatch failed: %v", err)
@@ -261,6 +448,7 @@ func (uh URLHandler) UserDeleteHandler(w http.ResponseWriter, r *http.Request) {
 		}
 	}()
 
+	markRecentWrite(w, uh.config)
 	w.WriteHeader(http.StatusAccepted)
 }
 
@@ -275,12 +463,38 @@ func (uh URLHandler) UserDeleteHandler(w http.ResponseWriter, r *http.Request) {
 // ]
 // При отсутствии сокращённых пользователем URL хендлер должен отдавать
 // HTTP-статус 204 No Content ...
+// Titles, notes and tags are given if set, ?tag=docs keeps only urls tagged
+// with it
 func (uh URLHandler) UserGetHandler(w http.ResponseWriter, r *http.Request) {
+	// users expect to see urls they have just changed, replicas may not
+	// have them yet
+	if hasRecentWrite(r) {
+		r = r.WithContext(storage.WithPrimaryRead(r.Context()))
+	}
+	uh.writeUserURLs(w, r, GetUserID(r.Context()))
+}
+
+// writeUserURLs replies with urls of the user as UserGetHandler does
+func (uh URLHandler) writeUserURLs(w http.ResponseWriter, r *http.Request, userID string) {
 	const ContentType = "application/json"
 
-	userID := GetUserID(r.Context())
+	tag := strings.ToLower(r.URL.Query().Get("tag"))
+	if tag != "" && uh.meta == nil {
+		http.Error(w, "Bad request: "+errMetaNotSupported.Error(), http.StatusBadRequest)
+		return
+	}
 
-	urls, _ := uh.store.GetUserURLs(r.Context(), userID)
+	urls, err := uh.store.GetUserURLs(r.Context(), userID)
+	if IsUnavailableError(err) {
+		ServiceUnavailable(w, err)
+		return
+	}
+	if uh.meta != nil && len(urls) > 0 {
+		if urls, err = uh.describe(r.Context(), urls, userID, tag); err != nil {
+			http.Error(w, "Server failed to get URL details", http.StatusInternalServerError)
+			return
+		}
+	}
 
 	if len(urls) == 0 {
 		w.WriteHeader(http.StatusNoContent)
@@ -301,18 +515,82 @@ func (uh URLHandler) UserGetHandler(w http.ResponseWriter, r *http.Request) {
 	w.Write(jr)
 }
 
+// describe adds titles, notes and tags to urls of the user keeping only
+// those tagged with tag, unless it's empty
+func (uh URLHandler) describe(ctx context.Context, urls []u.URLEntry, userID string, tag string) ([]u.URLEntry, error) {
+	metas, err := uh.meta.GetUserURLMeta(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	res := urls[:0]
+	for _, entry := range urls {
+		meta := metas[entry.ShortURL]
+		if tag != "" && !hasTag(meta.Tags, tag) {
+			continue
+		}
+		entry.Title, entry.Notes, entry.Tags = meta.Title, meta.Notes, meta.Tags
+		res = append(res, entry)
+	}
+
+	return res, nil
+}
+
+func hasTag(tags []string, tag string) bool {
+	for _, t := range tags {
+		if t == tag {
+			return true
+		}
+	}
+
+	return false
+}
+
+// UserQuotaHandler process GET /api/user/quota request, it returns
+// the user's quota and usage as
+// {"max_links": 100, "active_links": 42, "max_batch_size": 10}
+// where zero limits mean no limit
+func (uh URLHandler) UserQuotaHandler(w http.ResponseWriter, r *http.Request) {
+	const ContentType = "application/json"
+
+	userID := GetUserID(r.Context())
+
+	count, err := uh.store.CountUserURLs(r.Context(), userID)
+	if IsUnavailableError(err) {
+		ServiceUnavailable(w, err)
+		return
+	} else if err != nil {
+		http.Error(w, "Server failed to count URLs", http.StatusInternalServerError)
+		return
+	}
+
+	jr, err := json.Marshal(u.QuotaResponse{
+		MaxLinks:     uh.config.MaxUserLinks,
+		ActiveLinks:  count,
+		MaxBatchSize: uh.config.MaxBatchSize,
+	})
+	if err != nil {
+		http.Error(w, "Server failed to process response result", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", ContentType)
+	w.WriteHeader(http.StatusOK)
+	w.Write(jr)
+}
+
 // PingGetHandler process GET /ping request
 // ... хендлер GET /ping, который при запросе проверяет соединение с базой
 // данных. При успешной проверке хендлер должен вернуть HTTP-статус 200 OK,
 // при неуспешной — 500 Internal Server Error ...
 func (uh URLHandler) PingGetHandler(w http.ResponseWriter, r *http.Request) {
-	dbStore, ok := uh.store.(*psql.DBStorage)
+	pinger, ok := uh.store.(storage.Pinger)
 	if !ok {
 		http.Error(w, "Server failed to open DB", http.StatusInternalServerError)
 		return
 	}
 
-	if err := dbStore.Ping(); err != nil {
+	if err := pinger.Ping(); err != nil {
 		http.Error(w, "Server failed to ping DB: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
@@ -10,9 +10,12 @@ import (
 	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
 
+	"github.com/sbxb/shorty/internal/app/auth"
 	"github.com/sbxb/shorty/internal/app/config"
 	"github.com/sbxb/shorty/internal/app/handlers"
+	"github.com/sbxb/shorty/internal/app/storage"
 	"github.com/sbxb/shorty/internal/app/storage/inmemory"
 	u "github.com/sbxb/shorty/internal/app/url"
 
@@ -483,6 +486,59 @@ func TestGetHandler_ValidCases(t *testing.T) {
 	}
 }
 
+// unavailableStorage fails every write as if the data store is down
+type unavailableStorage struct {
+	*inmemory.MapStorage
+}
+
+func (st unavailableStorage) AddURL(ctx context.Context, ue u.URLEntry, userID string) error {
+	return storage.NewUnavailableError(1500 * time.Millisecond)
+}
+
+func TestPostHandler_StorageUnavailable(t *testing.T) {
+	wantCode := 503
+	wantRetryAfter := "2"
+
+	ms, _ := inmemory.NewMapStorage()
+	store := unavailableStorage{ms}
+
+	router := chi.NewRouter()
+	urlHandler := handlers.NewURLHandler(store, cfg)
+	router.Post("/", urlHandler.PostHandler)
+
+	req := httptest.NewRequest(http.MethodPost, cfg.BaseURL+"/", strings.NewReader("http://example.com"))
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	resp := w.Result()
+	defer resp.Body.Close()
+
+	assert.Equal(t, resp.StatusCode, wantCode)
+	assert.Equal(t, resp.Header.Get("Retry-After"), wantRetryAfter)
+}
+
+func TestJSONBatchPostHandler_StorageFull(t *testing.T) {
+	wantCode := 507
+	sendBody := `[{"correlation_id": "123", "original_url": "http://example.com"},
+		{"correlation_id": "456", "original_url": "http://example.org"}]`
+
+	store, _ := inmemory.NewMapStorage(inmemory.WithLimits(inmemory.Limits{MaxRecords: 1}))
+
+	router := chi.NewRouter()
+	urlHandler := handlers.NewURLHandler(store, cfg)
+	router.Post("/api/shorten/batch", urlHandler.JSONBatchPostHandler)
+
+	req := httptest.NewRequest(http.MethodPost, cfg.BaseURL+"/api/shorten/batch", strings.NewReader(sendBody))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	resp := w.Result()
+	defer resp.Body.Close()
+
+	assert.Equal(t, resp.StatusCode, wantCode)
+}
+
 func getRequestResponse(url string, result string) (u.URLRequest, u.URLResponse) {
 	return u.URLRequest{
 			URL: url,
@@ -491,3 +547,108 @@ func getRequestResponse(url string, result string) (u.URLRequest, u.URLResponse)
 			Result: result,
 		}
 }
+
+func TestQuotas(t *testing.T) {
+	quotaCfg := cfg
+	quotaCfg.MaxUserLinks = 1
+	quotaCfg.MaxBatchSize = 1
+
+	store, _ := inmemory.NewMapStorage(inmemory.WithMaxUserLinks(quotaCfg.MaxUserLinks))
+
+	router := chi.NewRouter()
+	urlHandler := handlers.NewURLHandler(store, quotaCfg)
+	router.Post("/", urlHandler.PostHandler)
+	router.Post("/api/shorten/batch", urlHandler.JSONBatchPostHandler)
+	router.Get("/api/user/quota", urlHandler.UserQuotaHandler)
+
+	do := func(method, target, body string) (int, string) {
+		req := httptest.NewRequest(method, cfg.BaseURL+target, strings.NewReader(body))
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		resp := w.Result()
+		defer resp.Body.Close()
+		b, _ := io.ReadAll(resp.Body)
+
+		return resp.StatusCode, strings.TrimSpace(string(b))
+	}
+
+	code, body := do(http.MethodPost, "/api/shorten/batch", `[{"correlation_id": "1", "original_url": "http://example.com"},
+		{"correlation_id": "2", "original_url": "http://example.org"}]`)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, code)
+	assert.JSONEq(t, `{"error": "Batch size exceeded", "limit": 1}`, body)
+
+	code, _ = do(http.MethodPost, "/", "http://example.com")
+	assert.Equal(t, http.StatusCreated, code)
+
+	code, body = do(http.MethodPost, "/", "http://example.org")
+	assert.Equal(t, http.StatusForbidden, code)
+	assert.JSONEq(t, `{"error": "Active links quota exceeded", "limit": 1}`, body)
+
+	code, body = do(http.MethodGet, "/api/user/quota", "")
+	assert.Equal(t, http.StatusOK, code)
+	assert.JSONEq(t, `{"max_links": 1, "active_links": 1, "max_batch_size": 1}`, body)
+}
+
+// staleReplicaStorage serves reads as a replica which hasn't got any writes
+// yet, unless the primary is asked for
+type staleReplicaStorage struct {
+	*inmemory.MapStorage
+}
+
+func (st staleReplicaStorage) GetURL(ctx context.Context, id string) (string, error) {
+	if !storage.IsPrimaryRead(ctx) {
+		return "", nil
+	}
+	return st.MapStorage.GetURL(ctx, id)
+}
+
+func (st staleReplicaStorage) GetUserURLs(ctx context.Context, userID string) ([]u.URLEntry, error) {
+	if !storage.IsPrimaryRead(ctx) {
+		return []u.URLEntry{}, nil
+	}
+	return st.MapStorage.GetUserURLs(ctx, userID)
+}
+
+func TestURLHandler_ReadYourWrites(t *testing.T) {
+	ms, _ := inmemory.NewMapStorage()
+	store := staleReplicaStorage{ms}
+
+	router := chi.NewRouter()
+	urlHandler := handlers.NewURLHandler(store, cfg)
+	router.Post("/", urlHandler.PostHandler)
+	router.Get("/api/user/urls", urlHandler.UserGetHandler)
+
+	do := func(method, target, body string, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, cfg.BaseURL+target, strings.NewReader(body))
+		req = req.WithContext(context.WithValue(req.Context(), auth.ContextUserIDKey, "user"))
+		for _, c := range cookies {
+			req.AddCookie(c)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		return w
+	}
+
+	// replicas serve users who haven't changed their urls lately
+	assert.Equal(t, http.StatusNoContent, do(http.MethodGet, "/api/user/urls", "").Code)
+
+	w := do(http.MethodPost, "/", "http://example.com")
+	require.Equal(t, http.StatusCreated, w.Code)
+	created := w.Body.String()
+	cookies := w.Result().Cookies()
+	require.Len(t, cookies, 1)
+	assert.Equal(t, handlers.RecentWriteCookieName, cookies[0].Name)
+	assert.Equal(t, 30, cookies[0].MaxAge)
+
+	// the repeated url is found under the same id rather than a new one
+	w = do(http.MethodPost, "/", "http://example.com")
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.Equal(t, created, w.Body.String())
+
+	w = do(http.MethodGet, "/api/user/urls", "", cookies...)
+	require.Equal(t, http.StatusOK, w.Code)
+	var urls []u.URLEntry
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &urls))
+	assert.Len(t, urls, 1)
+}
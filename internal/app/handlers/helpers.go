@@ -2,11 +2,19 @@ package handlers
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/sbxb/shorty/internal/app/auth"
+	"github.com/sbxb/shorty/internal/app/config"
 	"github.com/sbxb/shorty/internal/app/logger"
 	"github.com/sbxb/shorty/internal/app/storage"
+	u "github.com/sbxb/shorty/internal/app/url"
 )
 
 func GetUserID(ctx context.Context) string {
@@ -18,6 +26,45 @@ func GetUserID(ctx context.Context) string {
 	return UserID
 }
 
+// RecentWriteCookieName marks users who changed their urls within
+// recentWriteWindow, their urls are listed from the primary rather than
+// replicas which may lag behind
+const RecentWriteCookieName = "recent_write"
+
+// recentWriteWindow is well beyond the usual replication lag
+const recentWriteWindow = 30 * time.Second
+
+// markRecentWrite sets RecentWriteCookieName, it must be called before
+// the response is written
+func markRecentWrite(w http.ResponseWriter, cfg config.Config) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     RecentWriteCookieName,
+		Value:    "1",
+		Path:     "/",
+		Domain:   cfg.CookieDomain,
+		MaxAge:   int(recentWriteWindow.Seconds()),
+		Secure:   strings.HasPrefix(cfg.BaseURL, "https://"),
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// hasRecentWrite reports whether the user changed their urls within
+// recentWriteWindow
+func hasRecentWrite(r *http.Request) bool {
+	_, err := r.Cookie(RecentWriteCookieName)
+
+	return err == nil
+}
+
+// isCookieAuthenticated reports whether the request was authenticated with
+// the session cookie rather than a bearer token or an API key
+func isCookieAuthenticated(ctx context.Context) bool {
+	_, byToken := ctx.Value(auth.ContextScopesKey).([]string)
+
+	return !byToken
+}
+
 func IsConflictError(err error) bool {
 	var conflictError *storage.IDConflictError
 
@@ -30,6 +77,136 @@ func IsDeletedError(err error) bool {
 	return errors.As(err, &deletedError)
 }
 
+func IsInsufficientStorageError(err error) bool {
+	var insufficientStorageError *storage.InsufficientStorageError
+
+	return errors.As(err, &insufficientStorageError)
+}
+
+func IsUnavailableError(err error) bool {
+	var unavailableError *storage.UnavailableError
+
+	return errors.As(err, &unavailableError)
+}
+
+func IsQuotaExceededError(err error) bool {
+	var quotaExceededError *storage.QuotaExceededError
+
+	return errors.As(err, &quotaExceededError)
+}
+
+// JSONError replies with status and resp encoded as JSON
+func JSONError(w http.ResponseWriter, status int, resp u.ErrorResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(resp)
+}
+
+// writeJSON replies with status and v encoded as JSON
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	jr, err := json.Marshal(v)
+	if err != nil {
+		http.Error(w, "Server failed to process response result", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(jr)
+}
+
+// QuotaExceeded replies with 403 and the limit taken from
+// storage.QuotaExceededError err
+func QuotaExceeded(w http.ResponseWriter, err error) {
+	resp := u.ErrorResponse{Error: "Active links quota exceeded"}
+
+	var quotaExceededError *storage.QuotaExceededError
+	if errors.As(err, &quotaExceededError) {
+		resp.Limit = quotaExceededError.Limit
+	}
+
+	JSONError(w, http.StatusForbidden, resp)
+}
+
+// ServiceUnavailable replies with 503 and Retry-After header taken from
+// storage.UnavailableError err
+func ServiceUnavailable(w http.ResponseWriter, err error) {
+	var unavailableError *storage.UnavailableError
+
+	retryAfter := 1
+	if errors.As(err, &unavailableError) {
+		if secs := int(math.Ceil(unavailableError.RetryAfter.Seconds())); secs > retryAfter {
+			retryAfter = secs
+		}
+	}
+
+	w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+	http.Error(w, "Storage is temporarily unavailable", http.StatusServiceUnavailable)
+}
+
+// maxIDProbes limits alternative ids tried for a url
+const maxIDProbes = 8
+
+// addURL stores url under the id derived from it and returns the id.
+// Links edited to point elsewhere keep their ids, so an id taken by another
+// url is skipped for the next alternative id derived from the url, whereas
+// IDConflictError means the url is stored under the id returned already
+func addURL(ctx context.Context, store storage.Storage, url string, userID string) (string, error) {
+	for probe := 0; ; probe++ {
+		id := probeID(url, probe)
+		err := store.AddURL(ctx, u.URLEntry{ShortURL: id, OriginalURL: url}, userID)
+		if !IsConflictError(err) || probe == maxIDProbes {
+			return id, err
+		}
+		// deleted links are never reused, the id was just found taken, so
+		// replicas may not have it yet
+		if existing, getErr := store.GetURL(storage.WithPrimaryRead(ctx), id); getErr != nil || existing == url {
+			return id, err
+		}
+	}
+}
+
+// batchID returns the id url is stored or is to be stored under in a batch,
+// ids taken by other urls are skipped as addURL does. Ids are looked up on
+// the primary, replicas may miss links just created or edited
+func batchID(ctx context.Context, store storage.Storage, url string) (string, error) {
+	ctx = storage.WithPrimaryRead(ctx)
+	for probe := 0; ; probe++ {
+		id := probeID(url, probe)
+		existing, err := store.GetURL(ctx, id)
+		// deleted links are never reused
+		if IsDeletedError(err) {
+			return id, nil
+		} else if err != nil {
+			return "", err
+		}
+		if existing == "" || existing == url {
+			return id, nil
+		}
+		if probe == maxIDProbes {
+			return "", storage.NewIDConflictError(id)
+		}
+	}
+}
+
+// probeID returns the id derived from url for probe
+func probeID(url string, probe int) string {
+	if probe == 0 {
+		return u.ShortID(url)
+	}
+	// urls can't contain NUL, so alternative ids are never derived from
+	// other urls
+	return u.ShortID(url + "\x00" + strconv.Itoa(probe))
+}
+
+// evictCached drops changed urls from the local cache (if any) wrapping
+// store, other instances are notified by the storage
+func evictCached(store storage.Storage, ids ...string) {
+	if cache, ok := store.(interface{ Evict(ids ...string) }); ok {
+		cache.Evict(ids...)
+	}
+}
+
 // ConcurrentDeleteBatch takes a slice of ids to be deleted, process the
 // slice chunk by chunk starting several (this number is limited by
 // concurrentWorkers constant) concurrent workers that call Storage.DeleteBatch()
@@ -0,0 +1,235 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/sbxb/shorty/internal/app/config"
+	"github.com/sbxb/shorty/internal/app/logger"
+	"github.com/sbxb/shorty/internal/app/storage"
+	u "github.com/sbxb/shorty/internal/app/url"
+)
+
+// HistoryHandler defines a container for handlers editing urls and their
+// dependencies, nil history means destinations can't change and nil meta
+// means urls can't be described. store is only used to evict changed urls
+// from the cache
+type HistoryHandler struct {
+	store   storage.Storage
+	history storage.URLHistoryStorage
+	meta    storage.URLMetaStorage
+	config  config.Config
+}
+
+func NewHistoryHandler(store storage.Storage, history storage.URLHistoryStorage, meta storage.URLMetaStorage, cfg config.Config) HistoryHandler {
+	return HistoryHandler{
+		store:   store,
+		history: history,
+		meta:    meta,
+		config:  cfg,
+	}
+}
+
+// UpdateHandler process PATCH /api/user/urls/{id} request with JSON payload
+// {"original_url": "...", "title": "...", "notes": "...", "tags": [...]},
+// fields left out are not changed. The url created by the user is pointed
+// to original_url keeping the previous destination in the history, it
+// replies with {"short_url": "...", "original_url": "...", "version": 2}
+// along with the description if it changed
+func (hh HistoryHandler) UpdateHandler(w http.ResponseWriter, r *http.Request) {
+	var req u.URLUpdateRequest
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&req); err != nil {
+		http.Error(w, "Bad request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	describe := req.Title != nil || req.Notes != nil || req.Tags != nil
+	if req.OriginalURL == "" && !describe {
+		http.Error(w, "Bad request: nothing to update", http.StatusBadRequest)
+		return
+	}
+	if req.OriginalURL != "" && hh.history == nil {
+		http.Error(w, "Bad request: destinations can't be changed", http.StatusBadRequest)
+		return
+	}
+	if req.OriginalURL != "" && !u.IsValidInputURL(req.OriginalURL) {
+		http.Error(w, "Bad request: non-valid url received", http.StatusBadRequest)
+		return
+	}
+	if describe && hh.meta == nil {
+		http.Error(w, "Bad request: "+errMetaNotSupported.Error(), http.StatusBadRequest)
+		return
+	}
+
+	id := chi.URLParam(r, "id")
+	userID := GetUserID(r.Context())
+	res := u.URLUpdateResponse{ShortURL: hh.config.BaseURL + "/" + id}
+
+	// the description is checked before anything changes
+	var meta storage.URLMeta
+	if describe {
+		var ok bool
+		if meta, ok = hh.describe(w, r, id, userID, req); !ok {
+			return
+		}
+	}
+	if req.OriginalURL != "" {
+		version, ok := hh.update(w, r, id, req.OriginalURL)
+		if !ok {
+			return
+		}
+		res.OriginalURL, res.Version = req.OriginalURL, version
+	}
+	if describe {
+		err := hh.meta.SetURLMeta(r.Context(), id, userID, meta)
+		if errors.Is(err, storage.ErrURLNotFound) {
+			http.Error(w, "URL not found", http.StatusNotFound)
+			return
+		} else if err != nil {
+			logger.Warningf("HistoryHandler: SetURLMeta failed: %v", err)
+			http.Error(w, "Server failed to store URL details", http.StatusInternalServerError)
+			return
+		}
+		res.Title, res.Notes, res.Tags = meta.Title, meta.Notes, meta.Tags
+	}
+
+	markRecentWrite(w, hh.config)
+	writeJSON(w, http.StatusOK, res)
+}
+
+// describe returns the current description of the url changed by req,
+// otherwise it replies with an error
+func (hh HistoryHandler) describe(w http.ResponseWriter, r *http.Request, id string, userID string, req u.URLUpdateRequest) (storage.URLMeta, bool) {
+	meta, err := hh.meta.GetURLMeta(r.Context(), id, userID)
+	if errors.Is(err, storage.ErrURLNotFound) {
+		http.Error(w, "URL not found", http.StatusNotFound)
+		return meta, false
+	} else if err != nil {
+		logger.Warningf("HistoryHandler: GetURLMeta failed: %v", err)
+		http.Error(w, "Server failed to get URL details", http.StatusInternalServerError)
+		return meta, false
+	}
+
+	if req.Title != nil {
+		meta.Title = *req.Title
+	}
+	if req.Notes != nil {
+		meta.Notes = *req.Notes
+	}
+	if req.Tags != nil {
+		meta.Tags = *req.Tags
+	}
+	if meta, err = normalizeMeta(meta); err != nil {
+		http.Error(w, "Bad request: "+err.Error(), http.StatusBadRequest)
+		return meta, false
+	}
+
+	return meta, true
+}
+
+// HistoryHandler process GET /api/user/urls/{id}/history request, it
+// returns the versions of the url created by the user, the current one
+// last, or 404
+func (hh HistoryHandler) HistoryHandler(w http.ResponseWriter, r *http.Request) {
+	versions, ok := hh.versions(w, r, chi.URLParam(r, "id"))
+	if !ok {
+		return
+	}
+
+	res := make([]u.URLVersionResponse, 0, len(versions))
+	for _, v := range versions {
+		item := u.URLVersionResponse{Version: v.Version, OriginalURL: v.URL}
+		if !v.ReplacedAt.IsZero() {
+			replacedAt := v.ReplacedAt
+			item.ReplacedAt = &replacedAt
+		}
+		res = append(res, item)
+	}
+	writeJSON(w, http.StatusOK, res)
+}
+
+// RollbackHandler process POST /api/user/urls/{id}/rollback request with
+// JSON payload {"version": 1}, the url is pointed to the destination of
+// that version, which becomes a new version, so nothing is lost
+func (hh HistoryHandler) RollbackHandler(w http.ResponseWriter, r *http.Request) {
+	var req u.RollbackRequest
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&req); err != nil {
+		http.Error(w, "Bad request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	id := chi.URLParam(r, "id")
+	versions, ok := hh.versions(w, r, id)
+	if !ok {
+		return
+	}
+	if req.Version < 1 || req.Version > len(versions) {
+		http.Error(w, "Bad request: unknown version", http.StatusBadRequest)
+		return
+	}
+
+	originalURL := versions[req.Version-1].URL
+	version, ok := hh.update(w, r, id, originalURL)
+	if !ok {
+		return
+	}
+
+	markRecentWrite(w, hh.config)
+	writeJSON(w, http.StatusOK, u.URLUpdateResponse{
+		ShortURL:    hh.config.BaseURL + "/" + id,
+		OriginalURL: originalURL,
+		Version:     version,
+	})
+}
+
+// versions returns the versions of the url created by the user, otherwise
+// it replies with an error
+func (hh HistoryHandler) versions(w http.ResponseWriter, r *http.Request, id string) ([]storage.URLVersion, bool) {
+	versions, err := hh.history.GetURLHistory(r.Context(), id, GetUserID(r.Context()))
+	if errors.Is(err, storage.ErrURLNotFound) {
+		http.Error(w, "URL not found", http.StatusNotFound)
+		return nil, false
+	} else if err != nil {
+		logger.Warningf("HistoryHandler: GetURLHistory failed: %v", err)
+		http.Error(w, "Server failed to get URL history", http.StatusInternalServerError)
+		return nil, false
+	}
+
+	return versions, true
+}
+
+// update points the url to originalURL and returns its version, otherwise
+// it replies with an error
+func (hh HistoryHandler) update(w http.ResponseWriter, r *http.Request, id string, originalURL string) (int, bool) {
+	userID := GetUserID(r.Context())
+	version, err := hh.history.UpdateURL(r.Context(), id, userID, originalURL, time.Now().UTC())
+	if errors.Is(err, storage.ErrURLNotFound) {
+		http.Error(w, "URL not found", http.StatusNotFound)
+		return 0, false
+	} else if IsDeletedError(err) {
+		http.Error(w, "Record deleted", http.StatusGone)
+		return 0, false
+	} else if IsInsufficientStorageError(err) {
+		http.Error(w, "Server storage is full", http.StatusInsufficientStorage)
+		return 0, false
+	} else if IsUnavailableError(err) {
+		ServiceUnavailable(w, err)
+		return 0, false
+	} else if err != nil {
+		logger.Warningf("HistoryHandler: UpdateURL failed: %v", err)
+		http.Error(w, "Server failed to update URL", http.StatusInternalServerError)
+		return 0, false
+	}
+	evictCached(hh.store, id)
+	logger.Infof("HistoryHandler: user %s pointed %s to version %d", userID, id, version)
+
+	return version, true
+}
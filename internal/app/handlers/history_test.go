@@ -0,0 +1,95 @@
+package handlers_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/sbxb/shorty/internal/app/auth"
+	"github.com/sbxb/shorty/internal/app/handlers"
+	"github.com/sbxb/shorty/internal/app/storage/inmemory"
+	u "github.com/sbxb/shorty/internal/app/url"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHistoryHandler(t *testing.T) {
+	store, _ := inmemory.NewMapStorage()
+	hh := handlers.NewHistoryHandler(store, store, nil, cfg)
+	uh := handlers.NewURLHandler(store, cfg)
+
+	router := chi.NewRouter()
+	router.Post("/api/shorten", uh.JSONPostHandler)
+	router.Post("/api/shorten/batch", uh.JSONBatchPostHandler)
+	router.Get("/{id}", uh.GetHandler)
+	router.Patch("/api/user/urls/{id}", hh.UpdateHandler)
+	router.Get("/api/user/urls/{id}/history", hh.HistoryHandler)
+	router.Post("/api/user/urls/{id}/rollback", hh.RollbackHandler)
+
+	do := func(method, target, body, uid string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, cfg.BaseURL+target, strings.NewReader(body))
+		req = req.WithContext(context.WithValue(req.Context(), auth.ContextUserIDKey, uid))
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		return w
+	}
+	location := func(id string) string {
+		return do(http.MethodGet, "/"+id, "", "visitor").Header().Get("Location")
+	}
+
+	w := do(http.MethodPost, "/api/shorten", `{"url": "http://typo.com"}`, "owner")
+	require.Equal(t, http.StatusCreated, w.Code)
+	id := u.ShortID("http://typo.com")
+
+	assert.Equal(t, http.StatusBadRequest, do(http.MethodPatch, "/api/user/urls/"+id, `{"original_url": "not a url"}`, "owner").Code)
+	assert.Equal(t, http.StatusNotFound, do(http.MethodPatch, "/api/user/urls/"+id, `{"original_url": "http://a.com"}`, "other").Code)
+
+	w = do(http.MethodPatch, "/api/user/urls/"+id, `{"original_url": "http://a.com"}`, "owner")
+	require.Equal(t, http.StatusOK, w.Code)
+	var updated u.URLUpdateResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &updated))
+	assert.Equal(t, u.URLUpdateResponse{ShortURL: cfg.BaseURL + "/" + id, OriginalURL: "http://a.com", Version: 2}, updated)
+	assert.Equal(t, "http://a.com", location(id))
+
+	w = do(http.MethodGet, "/api/user/urls/"+id+"/history", "", "owner")
+	require.Equal(t, http.StatusOK, w.Code)
+	var history []u.URLVersionResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &history))
+	require.Len(t, history, 2)
+	assert.Equal(t, "http://typo.com", history[0].OriginalURL)
+	assert.NotNil(t, history[0].ReplacedAt)
+	assert.Nil(t, history[1].ReplacedAt)
+	assert.Equal(t, http.StatusNotFound, do(http.MethodGet, "/api/user/urls/"+id+"/history", "", "other").Code)
+
+	// the id of the edited link is no longer the typo's one
+	w = do(http.MethodPost, "/api/shorten", `{"url": "http://typo.com"}`, "other")
+	require.Equal(t, http.StatusCreated, w.Code)
+	var created u.URLResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &created))
+	assert.NotEqual(t, cfg.BaseURL+"/"+id, created.Result)
+	assert.Equal(t, "http://typo.com", location(strings.TrimPrefix(created.Result, cfg.BaseURL+"/")))
+	assert.Equal(t, http.StatusConflict, do(http.MethodPost, "/api/shorten", `{"url": "http://typo.com"}`, "other").Code)
+
+	// so are batches, the edited link is left as it is
+	w = do(http.MethodPost, "/api/shorten/batch", `[{"correlation_id": "1", "original_url": "http://typo.com"}]`, "batcher")
+	require.Equal(t, http.StatusCreated, w.Code)
+	var batch []u.BatchURLEntry
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &batch))
+	require.Len(t, batch, 1)
+	assert.Equal(t, created.Result, batch[0].ShortURL)
+	assert.Equal(t, "http://a.com", location(id))
+
+	assert.Equal(t, http.StatusBadRequest, do(http.MethodPost, "/api/user/urls/"+id+"/rollback", `{"version": 3}`, "owner").Code)
+	w = do(http.MethodPost, "/api/user/urls/"+id+"/rollback", `{"version": 1}`, "owner")
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &updated))
+	assert.Equal(t, 3, updated.Version)
+	assert.Equal(t, "http://typo.com", location(id))
+}
@@ -0,0 +1,85 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/sbxb/shorty/internal/app/storage"
+	u "github.com/sbxb/shorty/internal/app/url"
+)
+
+const (
+	maxTitleLength = 200
+	maxNotesLength = 2000
+	maxTags        = 20
+)
+
+// validTag matches tags after they are lowercased
+var validTag = regexp.MustCompile(`^[a-z0-9][a-z0-9._-]{0,63}$`)
+
+var errMetaNotSupported = errors.New("titles, notes and tags are not supported")
+
+// normalizeMeta validates meta, tags are trimmed, lowercased, sorted and
+// their repeats are dropped
+func normalizeMeta(meta storage.URLMeta) (storage.URLMeta, error) {
+	meta.Title = strings.TrimSpace(meta.Title)
+	if len(meta.Title) > maxTitleLength {
+		return meta, fmt.Errorf("title should be at most %d bytes long", maxTitleLength)
+	}
+	if len(meta.Notes) > maxNotesLength {
+		return meta, fmt.Errorf("notes should be at most %d bytes long", maxNotesLength)
+	}
+
+	seen := make(map[string]struct{}, len(meta.Tags))
+	tags := make([]string, 0, len(meta.Tags))
+	for _, tag := range meta.Tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if !validTag.MatchString(tag) {
+			return meta, fmt.Errorf("tag %q should be 1 to 64 letters, digits or ._- starting with a letter or a digit", tag)
+		}
+		if _, ok := seen[tag]; ok {
+			continue
+		}
+		seen[tag] = struct{}{}
+		tags = append(tags, tag)
+	}
+	if len(tags) > maxTags {
+		return meta, fmt.Errorf("at most %d tags are allowed", maxTags)
+	}
+	sort.Strings(tags)
+	meta.Tags = nil
+	if len(tags) > 0 {
+		meta.Tags = tags
+	}
+
+	return meta, nil
+}
+
+// UserTagsHandler process GET /api/user/tags request, it returns the tags
+// of the user's urls as [{"tag": "docs", "count": 3}, ...] ordered by tag,
+// or 204 No Content
+func (uh URLHandler) UserTagsHandler(w http.ResponseWriter, r *http.Request) {
+	tags, err := uh.meta.GetUserTags(r.Context(), GetUserID(r.Context()))
+	if IsUnavailableError(err) {
+		ServiceUnavailable(w, err)
+		return
+	} else if err != nil {
+		http.Error(w, "Server failed to list tags", http.StatusInternalServerError)
+		return
+	}
+	if len(tags) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	res := make([]u.TagResponse, 0, len(tags))
+	for tag, count := range tags {
+		res = append(res, u.TagResponse{Tag: tag, Count: count})
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Tag < res[j].Tag })
+	writeJSON(w, http.StatusOK, res)
+}
@@ -0,0 +1,108 @@
+package handlers_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/sbxb/shorty/internal/app/auth"
+	"github.com/sbxb/shorty/internal/app/handlers"
+	"github.com/sbxb/shorty/internal/app/storage/inmemory"
+	u "github.com/sbxb/shorty/internal/app/url"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestURLHandler_Meta(t *testing.T) {
+	store, _ := inmemory.NewMapStorage()
+	uh := handlers.NewURLHandler(store, cfg).WithMeta(store)
+	hh := handlers.NewHistoryHandler(store, nil, store, cfg)
+
+	router := chi.NewRouter()
+	router.Post("/api/shorten", uh.JSONPostHandler)
+	router.Get("/api/user/urls", uh.UserGetHandler)
+	router.Get("/api/user/tags", uh.UserTagsHandler)
+	router.Patch("/api/user/urls/{id}", hh.UpdateHandler)
+
+	do := func(method, target, body, uid string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, cfg.BaseURL+target, strings.NewReader(body))
+		req = req.WithContext(context.WithValue(req.Context(), auth.ContextUserIDKey, uid))
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		return w
+	}
+	list := func(target string) []u.URLEntry {
+		w := do(http.MethodGet, target, "", "owner")
+		require.Equal(t, http.StatusOK, w.Code)
+		var urls []u.URLEntry
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &urls))
+		return urls
+	}
+
+	assert.Equal(t, http.StatusBadRequest, do(http.MethodPost, "/api/shorten", `{"url": "http://a.com", "tags": ["no spaces"]}`, "owner").Code)
+	assert.Equal(t, http.StatusNoContent, do(http.MethodGet, "/api/user/tags", "", "owner").Code)
+
+	require.Equal(t, http.StatusCreated, do(http.MethodPost, "/api/shorten", `{"url": "http://a.com", "title": " A ", "tags": ["Docs", "go", "docs"]}`, "owner").Code)
+	require.Equal(t, http.StatusCreated, do(http.MethodPost, "/api/shorten", `{"url": "http://b.com", "tags": ["docs"]}`, "owner").Code)
+	require.Equal(t, http.StatusCreated, do(http.MethodPost, "/api/shorten", `{"url": "http://c.com"}`, "owner").Code)
+
+	// details of a url shortened before are not stored, the client is told
+	w := do(http.MethodPost, "/api/shorten", `{"url": "http://c.com", "title": "C"}`, "owner")
+	require.Equal(t, http.StatusConflict, w.Code)
+	var created u.URLResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &created))
+	assert.Equal(t, cfg.BaseURL+"/"+u.ShortID("http://c.com"), created.Result)
+	assert.NotEmpty(t, created.Warning)
+
+	assert.Len(t, list("/api/user/urls"), 3)
+	urls := list("/api/user/urls?tag=go")
+	require.Len(t, urls, 1)
+	assert.Equal(t, u.URLEntry{
+		ShortURL:    cfg.BaseURL + "/" + u.ShortID("http://a.com"),
+		OriginalURL: "http://a.com",
+		Title:       "A",
+		Tags:        []string{"docs", "go"},
+	}, urls[0])
+	assert.Equal(t, http.StatusNoContent, do(http.MethodGet, "/api/user/urls?tag=none", "", "owner").Code)
+
+	w = do(http.MethodGet, "/api/user/tags", "", "owner")
+	require.Equal(t, http.StatusOK, w.Code)
+	var tags []u.TagResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &tags))
+	assert.Equal(t, []u.TagResponse{{Tag: "docs", Count: 2}, {Tag: "go", Count: 1}}, tags)
+
+	// fields left out are kept, destinations can't change without history
+	id := u.ShortID("http://a.com")
+	assert.Equal(t, http.StatusBadRequest, do(http.MethodPatch, "/api/user/urls/"+id, `{"original_url": "http://x.com"}`, "owner").Code)
+	assert.Equal(t, http.StatusBadRequest, do(http.MethodPatch, "/api/user/urls/"+id, `{}`, "owner").Code)
+	assert.Equal(t, http.StatusNotFound, do(http.MethodPatch, "/api/user/urls/"+id, `{"notes": "n"}`, "other").Code)
+	w = do(http.MethodPatch, "/api/user/urls/"+id, `{"notes": "read later", "tags": []}`, "owner")
+	require.Equal(t, http.StatusOK, w.Code)
+	var updated u.URLUpdateResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &updated))
+	assert.Equal(t, u.URLUpdateResponse{ShortURL: cfg.BaseURL + "/" + id, Title: "A", Notes: "read later"}, updated)
+	assert.Equal(t, http.StatusNoContent, do(http.MethodGet, "/api/user/urls?tag=go", "", "owner").Code)
+}
+
+func TestURLHandler_Meta_NotSupported(t *testing.T) {
+	store, _ := inmemory.NewMapStorage()
+	uh := handlers.NewURLHandler(store, cfg)
+
+	router := chi.NewRouter()
+	router.Post("/api/shorten", uh.JSONPostHandler)
+	router.Get("/api/user/urls", uh.UserGetHandler)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, cfg.BaseURL+"/api/shorten", strings.NewReader(`{"url": "http://a.com", "tags": ["docs"]}`)))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, cfg.BaseURL+"/api/user/urls?tag=docs", nil))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
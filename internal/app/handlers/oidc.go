@@ -0,0 +1,173 @@
+package handlers
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/sbxb/shorty/internal/app/auth"
+	"github.com/sbxb/shorty/internal/app/logger"
+	"github.com/sbxb/shorty/internal/app/oidc"
+	"github.com/sbxb/shorty/internal/app/storage"
+	u "github.com/sbxb/shorty/internal/app/url"
+)
+
+const (
+	// oidcFlowCookie carries the state of the login in progress between
+	// LoginHandler and CallbackHandler
+	oidcFlowCookie = "oidc_flow"
+	oidcFlowTTL    = 10 * time.Minute
+	// oidcFlowPurpose binds signed flow cookies to their purpose
+	oidcFlowPurpose = "oidc-flow"
+)
+
+// OIDCHandler defines a container for single sign-on handlers and their
+// dependencies, a successful login issues the session cookie for the user
+// id the provider's subject maps to, users who never log in keep their
+// anonymous ids. Given users, the ids are recorded as password-less
+// accounts on the first login, so they count as signed in
+type OIDCHandler struct {
+	provider *oidc.Provider
+	users    storage.UserStorage
+	signer   *auth.Signer
+	session  auth.SessionSettings
+}
+
+func NewOIDCHandler(provider *oidc.Provider, users storage.UserStorage, signer *auth.Signer, session auth.SessionSettings) OIDCHandler {
+	return OIDCHandler{
+		provider: provider,
+		users:    users,
+		signer:   signer,
+		session:  session,
+	}
+}
+
+// oidcFlow is kept in the signed flow cookie
+type oidcFlow struct {
+	State     string `json:"state"`
+	Nonce     string `json:"nonce"`
+	Verifier  string `json:"verifier"`
+	ExpiresAt int64  `json:"exp"`
+}
+
+// LoginHandler process GET /auth/oidc/login request, it redirects to
+// the provider's login page
+func (oh OIDCHandler) LoginHandler(w http.ResponseWriter, r *http.Request) {
+	var flow oidcFlow
+	for _, s := range []*string{&flow.State, &flow.Nonce, &flow.Verifier} {
+		var err error
+		if *s, err = oidc.RandomString(); err != nil {
+			http.Error(w, "Server failed to start login", http.StatusInternalServerError)
+			return
+		}
+	}
+	now := time.Now()
+	flow.ExpiresAt = now.Add(oidcFlowTTL).Unix()
+
+	payload, err := json.Marshal(flow)
+	if err != nil {
+		http.Error(w, "Server failed to start login", http.StatusInternalServerError)
+		return
+	}
+	http.SetCookie(w, oh.flowCookie(oh.signer.SignValue(oidcFlowPurpose, string(payload)), int(oidcFlowTTL.Seconds())))
+
+	w.Header().Set("Cache-Control", "no-store")
+	http.Redirect(w, r, oh.provider.AuthCodeURL(flow.State, flow.Nonce, flow.Verifier), http.StatusFound)
+}
+
+// CallbackHandler process GET /auth/oidc/callback?code=...&state=... request
+// the provider redirects to, it logs the user in and redirects to the base
+// path, 401 is returned if the login failed or was tampered with
+func (oh OIDCHandler) CallbackHandler(w http.ResponseWriter, r *http.Request) {
+	// the flow is single use
+	http.SetCookie(w, oh.flowCookie("", -1))
+
+	flow, ok := oh.readFlow(r)
+	q := r.URL.Query()
+	if !ok || subtle.ConstantTimeCompare([]byte(q.Get("state")), []byte(flow.State)) != 1 {
+		JSONError(w, http.StatusUnauthorized, u.ErrorResponse{Error: "Login expired or tampered with"})
+		return
+	}
+	if e := q.Get("error"); e != "" {
+		JSONError(w, http.StatusUnauthorized, u.ErrorResponse{Error: "Login failed: " + e})
+		return
+	}
+
+	claims, err := oh.provider.Exchange(r.Context(), q.Get("code"), flow.Verifier, flow.Nonce)
+	if err != nil {
+		logger.Warningf("OIDCHandler: %v", err)
+		JSONError(w, http.StatusUnauthorized, u.ErrorResponse{Error: "Login failed"})
+		return
+	}
+
+	uid := oidc.UserID(claims)
+	if err := oh.recordAccount(r.Context(), uid); err != nil {
+		logger.Warningf("OIDCHandler: recordAccount failed: %v", err)
+		http.Error(w, "Server failed to record account", http.StatusInternalServerError)
+		return
+	}
+
+	http.SetCookie(w, oh.session.Cookie(oh.signer, uid, time.Now()))
+	w.Header().Set("Cache-Control", "no-store")
+	http.Redirect(w, r, oh.session.Path, http.StatusFound)
+}
+
+// recordAccount adds the password-less account of the user unless it
+// exists, its login can't be signed up for
+func (oh OIDCHandler) recordAccount(ctx context.Context, uid string) error {
+	if oh.users == nil {
+		return nil
+	}
+
+	_, err := oh.users.GetUser(ctx, uid)
+	if !errors.Is(err, storage.ErrUserNotFound) {
+		return err
+	}
+	err = oh.users.AddUser(ctx, storage.User{
+		ID:        uid,
+		Login:     ssoLoginPrefix + uid,
+		CreatedAt: time.Now().UTC(),
+	})
+	// a concurrent login got there first
+	if errors.Is(err, storage.ErrLoginTaken) {
+		return nil
+	}
+
+	return err
+}
+
+// readFlow returns the flow from the cookie if it's valid and not expired
+func (oh OIDCHandler) readFlow(r *http.Request) (oidcFlow, bool) {
+	var flow oidcFlow
+
+	cookie, err := r.Cookie(oidcFlowCookie)
+	if err != nil {
+		return flow, false
+	}
+	payload, ok := oh.signer.VerifyValue(oidcFlowPurpose, cookie.Value)
+	if !ok {
+		return flow, false
+	}
+	if err := json.Unmarshal([]byte(payload), &flow); err != nil {
+		return flow, false
+	}
+
+	return flow, flow.State != "" && time.Now().Unix() < flow.ExpiresAt
+}
+
+// flowCookie returns the flow cookie, negative maxAge removes it, it's sent
+// on the top-level redirect from the provider since it's SameSite Lax
+func (oh OIDCHandler) flowCookie(value string, maxAge int) *http.Cookie {
+	return &http.Cookie{
+		Name:     oidcFlowCookie,
+		Value:    value,
+		Path:     oh.session.Path,
+		MaxAge:   maxAge,
+		Secure:   oh.session.Secure,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	}
+}
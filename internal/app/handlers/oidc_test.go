@@ -0,0 +1,110 @@
+package handlers_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/sbxb/shorty/internal/app/auth"
+	"github.com/sbxb/shorty/internal/app/handlers"
+	"github.com/sbxb/shorty/internal/app/oidc"
+	"github.com/sbxb/shorty/internal/app/oidc/oidctest"
+	"github.com/sbxb/shorty/internal/app/storage/inmemory"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOIDCHandler(t *testing.T) {
+	fake := oidctest.NewProvider(t, "alice")
+	signer, err := auth.ParseSigningKeys(strings.NewReader("0123456789abcdef"))
+	require.NoError(t, err)
+
+	router := chi.NewRouter()
+	srv := httptest.NewServer(router)
+	defer srv.Close()
+
+	provider, err := oidc.NewProvider(context.Background(), oidc.Config{
+		Issuer:       fake.Issuer,
+		ClientID:     oidctest.ClientID,
+		ClientSecret: oidctest.ClientSecret,
+		RedirectURL:  srv.URL + "/auth/oidc/callback",
+	})
+	require.NoError(t, err)
+	store, _ := inmemory.NewMapStorage()
+	oh := handlers.NewOIDCHandler(provider, store, signer, auth.SessionSettings{Lifetime: time.Hour, Path: "/"})
+	router.Get("/auth/oidc/login", oh.LoginHandler)
+	router.Get("/auth/oidc/callback", oh.CallbackHandler)
+	router.Get("/", func(w http.ResponseWriter, r *http.Request) {})
+
+	jar, _ := cookiejar.New(nil)
+	client := &http.Client{Jar: jar}
+	sessionUID := func() string {
+		for _, c := range jar.Cookies(mustParse(t, srv.URL)) {
+			if c.Name == auth.SessionCookieName {
+				uid, _, _, ok := signer.Verify(c.Value)
+				require.True(t, ok)
+				return uid
+			}
+		}
+		return ""
+	}
+
+	// an anonymous user stays anonymous until logged in
+	anonymous, _ := auth.GenerateUserID()
+	jar.SetCookies(mustParse(t, srv.URL), []*http.Cookie{{Name: auth.SessionCookieName, Value: signer.Sign(anonymous, time.Now())}})
+	assert.Equal(t, anonymous, sessionUID())
+
+	resp, err := client.Get(srv.URL + "/auth/oidc/login")
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "/", resp.Request.URL.Path)
+
+	alice := sessionUID()
+	assert.Len(t, alice, 32)
+	assert.NotEqual(t, anonymous, alice)
+	// single sign-on users get password-less accounts
+	account, err := store.GetUser(context.Background(), alice)
+	require.NoError(t, err)
+	assert.Equal(t, "sso-"+alice, account.Login)
+	assert.Empty(t, account.PasswordHash)
+
+	// logging in again gets the same user id
+	resp, err = client.Get(srv.URL + "/auth/oidc/login")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, alice, sessionUID())
+
+	// the callback is rejected without the flow cookie or with a wrong state
+	resp, err = http.Get(srv.URL + "/auth/oidc/callback?code=x&state=y")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	noRedirect := &http.Client{Jar: jar, CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	resp, err = noRedirect.Get(srv.URL + "/auth/oidc/login")
+	require.NoError(t, err)
+	resp.Body.Close()
+	resp, err = noRedirect.Get(srv.URL + "/auth/oidc/callback?code=x&state=forged")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	assert.Equal(t, alice, sessionUID())
+}
+
+func mustParse(t *testing.T, raw string) *url.URL {
+	u, err := url.Parse(raw)
+	require.NoError(t, err)
+
+	return u
+}
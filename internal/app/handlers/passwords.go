@@ -0,0 +1,197 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sbxb/shorty/internal/app/auth"
+	"github.com/sbxb/shorty/internal/app/logger"
+	"github.com/sbxb/shorty/internal/app/storage"
+	u "github.com/sbxb/shorty/internal/app/url"
+)
+
+// LinkPasswordHeader carries the password of a url for API clients
+const LinkPasswordHeader = "X-Link-Password"
+
+const (
+	// passwordAttempts wrong passwords per url and client are allowed
+	// within passwordWindow, then the client is blocked until it ends
+	passwordAttempts = 5
+	passwordWindow   = 15 * time.Minute
+	// expired clients are dropped at most once per passwordGuardSweep,
+	// no more than passwordGuardMaxClients are tracked anyway
+	passwordGuardSweep      = time.Minute
+	passwordGuardMaxClients = 100000
+)
+
+// passwordGuard counts wrong passwords per url and client
+type passwordGuard struct {
+	sync.Mutex
+	failures   map[string]*passwordFailures
+	maxClients int
+	lastSweep  time.Time
+}
+
+type passwordFailures struct {
+	count   int
+	resetAt time.Time
+}
+
+func newPasswordGuard() *passwordGuard {
+	return &passwordGuard{
+		failures:   make(map[string]*passwordFailures),
+		maxClients: passwordGuardMaxClients,
+	}
+}
+
+// blocked returns how long the client is blocked for, if it is
+func (g *passwordGuard) blocked(key string, now time.Time) (time.Duration, bool) {
+	g.Lock()
+	defer g.Unlock()
+
+	f, ok := g.failures[key]
+	if !ok || !now.Before(f.resetAt) {
+		return 0, false
+	}
+
+	return f.resetAt.Sub(now), f.count >= passwordAttempts
+}
+
+func (g *passwordGuard) fail(key string, now time.Time) {
+	g.Lock()
+	defer g.Unlock()
+
+	g.sweep(now)
+
+	f, ok := g.failures[key]
+	if !ok && len(g.failures) >= g.maxClients {
+		g.evict()
+	}
+	if !ok || !now.Before(f.resetAt) {
+		f = &passwordFailures{resetAt: now.Add(passwordWindow)}
+		g.failures[key] = f
+	}
+	f.count++
+}
+
+// sweep forgets clients whose window has ended, it runs at most once per
+// passwordGuardSweep, the caller must hold the lock
+func (g *passwordGuard) sweep(now time.Time) {
+	if now.Sub(g.lastSweep) < passwordGuardSweep {
+		return
+	}
+	g.lastSweep = now
+
+	for key, f := range g.failures {
+		if !now.Before(f.resetAt) {
+			delete(g.failures, key)
+		}
+	}
+}
+
+// evict forgets a client to make room for another one, clients which are
+// not blocked go first, the caller must hold the lock
+func (g *passwordGuard) evict() {
+	victim := ""
+	for key, f := range g.failures {
+		if f.count < passwordAttempts {
+			delete(g.failures, key)
+			return
+		}
+		if victim == "" {
+			victim = key
+		}
+	}
+	delete(g.failures, victim)
+}
+
+func (g *passwordGuard) reset(key string) {
+	g.Lock()
+	defer g.Unlock()
+
+	delete(g.failures, key)
+}
+
+// unlock reports whether the visitor may be redirected by the url given
+// password, empty unless supplied, urls without a password are unlocked.
+// Otherwise it replies with the password form for browsers and 401 for API
+// clients, or 429 if the client is blocked
+func (uh URLHandler) unlock(w http.ResponseWriter, r *http.Request, id string, password string, submitted bool) bool {
+	hash, err := uh.passwords.GetURLPassword(r.Context(), id)
+	if errors.Is(err, storage.ErrURLNotFound) {
+		// the url is resolved, so it's spooled, which urls with a password
+		// never are
+		return true
+	} else if IsUnavailableError(err) {
+		ServiceUnavailable(w, err)
+		return false
+	} else if err != nil {
+		logger.Warningf("URLHandler: GetURLPassword failed: %v", err)
+		http.Error(w, "Server failed to check password", http.StatusInternalServerError)
+		return false
+	}
+	if hash == "" {
+		return true
+	}
+	w.Header().Set("Cache-Control", "no-store")
+
+	jsonClient := strings.Contains(r.Header.Get("Accept"), "application/json")
+	if password == "" && !submitted {
+		if jsonClient {
+			JSONError(w, http.StatusUnauthorized, u.ErrorResponse{Error: "Password required in " + LinkPasswordHeader})
+		} else {
+			passwordForm(w, http.StatusOK, "")
+		}
+		return false
+	}
+
+	key := id + " " + remoteIP(r)
+	now := time.Now()
+	if retryAfter, blocked := uh.guard.blocked(key, now); blocked {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+		JSONError(w, http.StatusTooManyRequests, u.ErrorResponse{Error: "Too many wrong passwords"})
+		return false
+	}
+	if !auth.CheckPassword(hash, password) {
+		uh.guard.fail(key, now)
+		if jsonClient {
+			JSONError(w, http.StatusUnauthorized, u.ErrorResponse{Error: "Wrong password"})
+		} else {
+			passwordForm(w, http.StatusUnauthorized, "Wrong password, try again.")
+		}
+		return false
+	}
+	uh.guard.reset(key)
+
+	return true
+}
+
+// passwordForm replies with the form posting the password to the url
+// itself, message is plain text
+func passwordForm(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	fmt.Fprint(w, "<!DOCTYPE html>\n<title>Password required</title>\n<p>The link is protected with a password.</p>\n")
+	if message != "" {
+		fmt.Fprintf(w, "<p>%s</p>\n", message)
+	}
+	fmt.Fprint(w, "<form method=\"post\">\n<input type=\"password\" name=\"password\" autofocus required>\n<button type=\"submit\">Open</button>\n</form>\n")
+}
+
+// remoteIP returns the IP address of the client, headers like
+// X-Forwarded-For are not trusted since any client can set them
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
@@ -0,0 +1,30 @@
+package handlers
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPasswordGuard_Bounded(t *testing.T) {
+	g := newPasswordGuard()
+	g.maxClients = 3
+	now := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	for i := 0; i < passwordAttempts; i++ {
+		g.fail("blocked", now)
+	}
+	for i := 0; i < 10; i++ {
+		g.fail("client"+strconv.Itoa(i), now)
+	}
+	assert.Len(t, g.failures, 3)
+	// blocked clients are the last to be forgotten
+	_, blocked := g.blocked("blocked", now)
+	assert.True(t, blocked)
+
+	// expired clients are swept
+	g.fail("late", now.Add(passwordWindow))
+	assert.Len(t, g.failures, 1)
+}
@@ -0,0 +1,99 @@
+package handlers_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/sbxb/shorty/internal/app/auth"
+	"github.com/sbxb/shorty/internal/app/handlers"
+	"github.com/sbxb/shorty/internal/app/storage/inmemory"
+	u "github.com/sbxb/shorty/internal/app/url"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestURLHandler_Passwords(t *testing.T) {
+	auth.PasswordCost = bcrypt.MinCost
+
+	store, _ := inmemory.NewMapStorage()
+	uh := handlers.NewURLHandler(store, cfg).WithPasswords(store)
+
+	router := chi.NewRouter()
+	router.Get("/{id}", uh.GetHandler)
+	router.Post("/{id}", uh.UnlockHandler)
+	router.Post("/api/shorten", uh.JSONPostHandler)
+
+	do := func(req *http.Request) *httptest.ResponseRecorder {
+		req = req.WithContext(context.WithValue(req.Context(), auth.ContextUserIDKey, "owner"))
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		return w
+	}
+	shorten := func(body string) *httptest.ResponseRecorder {
+		return do(httptest.NewRequest(http.MethodPost, cfg.BaseURL+"/api/shorten", strings.NewReader(body)))
+	}
+	submit := func(id, password string) *httptest.ResponseRecorder {
+		form := url.Values{"password": {password}}
+		req := httptest.NewRequest(http.MethodPost, cfg.BaseURL+"/"+id, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		return do(req)
+	}
+
+	assert.Equal(t, http.StatusBadRequest, shorten(`{"url": "http://secret.com", "password": "short"}`).Code)
+
+	w := shorten(`{"url": "http://secret.com", "password": "open sesame"}`)
+	require.Equal(t, http.StatusCreated, w.Code)
+	var resp u.URLResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	id := strings.TrimPrefix(resp.Result, cfg.BaseURL+"/")
+	// the password isn't set on existing urls, which are not given out as
+	// if they were protected
+	assert.Equal(t, http.StatusConflict, shorten(`{"url": "http://secret.com", "password": "another one"}`).Code)
+	require.Equal(t, http.StatusCreated, shorten(`{"url": "http://public.com"}`).Code)
+	w = shorten(`{"url": "http://public.com", "password": "open sesame"}`)
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.NotContains(t, w.Body.String(), u.ShortID("http://public.com"))
+
+	// browsers get the form, API clients are asked for the header
+	w = do(httptest.NewRequest(http.MethodGet, cfg.BaseURL+"/"+id, nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `<form method="post">`)
+	assert.Equal(t, "no-store", w.Header().Get("Cache-Control"))
+	req := httptest.NewRequest(http.MethodGet, cfg.BaseURL+"/"+id, nil)
+	req.Header.Set("Accept", "application/json")
+	assert.Equal(t, http.StatusUnauthorized, do(req).Code)
+
+	req = httptest.NewRequest(http.MethodGet, cfg.BaseURL+"/"+id, nil)
+	req.Header.Set(handlers.LinkPasswordHeader, "open sesame")
+	w = do(req)
+	assert.Equal(t, http.StatusTemporaryRedirect, w.Code)
+	assert.Equal(t, "http://secret.com", w.Header().Get("Location"))
+
+	w = submit(id, "open sesame")
+	assert.Equal(t, http.StatusSeeOther, w.Code)
+	assert.Equal(t, "http://secret.com", w.Header().Get("Location"))
+
+	// wrong passwords are throttled per url and client
+	for i := 0; i < 5; i++ {
+		w = submit(id, "wrong password")
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+		assert.Contains(t, w.Body.String(), "Wrong password")
+	}
+	w = submit(id, "open sesame")
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.NotEmpty(t, w.Header().Get("Retry-After"))
+
+	// urls without a password just redirect
+	w = do(httptest.NewRequest(http.MethodGet, cfg.BaseURL+"/"+u.ShortID("http://public.com"), nil))
+	assert.Equal(t, http.StatusTemporaryRedirect, w.Code)
+}
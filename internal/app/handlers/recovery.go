@@ -0,0 +1,89 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/sbxb/shorty/internal/app/auth"
+	"github.com/sbxb/shorty/internal/app/logger"
+	"github.com/sbxb/shorty/internal/app/storage"
+	u "github.com/sbxb/shorty/internal/app/url"
+)
+
+// RecoveryHandler defines a container for recovery code handlers and their
+// dependencies
+type RecoveryHandler struct {
+	codes   storage.RecoveryCodeStorage
+	signer  *auth.Signer
+	session auth.SessionSettings
+}
+
+func NewRecoveryHandler(codes storage.RecoveryCodeStorage, signer *auth.Signer, session auth.SessionSettings) RecoveryHandler {
+	return RecoveryHandler{
+		codes:   codes,
+		signer:  signer,
+		session: session,
+	}
+}
+
+// IssueHandler process GET /api/user/recovery request, it returns a new
+// recovery code for the current user as {"code": "ABCDEF-GHIJKL-..."},
+// the code replaces the previous one and is never shown again
+func (rh RecoveryHandler) IssueHandler(w http.ResponseWriter, r *http.Request) {
+	if !isCookieAuthenticated(r.Context()) {
+		JSONError(w, http.StatusForbidden, u.ErrorResponse{Error: "Recovery codes can only be used with the cookie"})
+		return
+	}
+
+	code, hash, err := auth.GenerateRecoveryCode()
+	if err != nil {
+		http.Error(w, "Server failed to generate recovery code", http.StatusInternalServerError)
+		return
+	}
+	err = rh.codes.AddRecoveryCode(r.Context(), storage.RecoveryCode{
+		UserID:    GetUserID(r.Context()),
+		Hash:      hash,
+		CreatedAt: time.Now().UTC(),
+	})
+	if err != nil {
+		logger.Warningf("RecoveryHandler: AddRecoveryCode failed: %v", err)
+		http.Error(w, "Server failed to store recovery code", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusOK, u.RecoveryResponse{Code: code})
+}
+
+// RecoverHandler process POST /api/user/recover request with JSON payload
+// {"code": "ABCDEF-GHIJKL-..."}, it re-issues the session cookie for the user
+// the code was issued for and replies with 204, the code is used up
+func (rh RecoveryHandler) RecoverHandler(w http.ResponseWriter, r *http.Request) {
+	if !isCookieAuthenticated(r.Context()) {
+		JSONError(w, http.StatusForbidden, u.ErrorResponse{Error: "Recovery codes can only be used with the cookie"})
+		return
+	}
+
+	var req u.RecoveryRequest
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&req); err != nil || req.Code == "" {
+		http.Error(w, "Bad request: code is required", http.StatusBadRequest)
+		return
+	}
+
+	uid, err := rh.codes.RedeemRecoveryCode(r.Context(), auth.HashRecoveryCode(req.Code))
+	if errors.Is(err, storage.ErrRecoveryCodeNotFound) {
+		JSONError(w, http.StatusUnauthorized, u.ErrorResponse{Error: "Invalid recovery code"})
+		return
+	} else if err != nil {
+		logger.Warningf("RecoveryHandler: RedeemRecoveryCode failed: %v", err)
+		http.Error(w, "Server failed to redeem recovery code", http.StatusInternalServerError)
+		return
+	}
+
+	http.SetCookie(w, rh.session.Cookie(rh.signer, uid, time.Now()))
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,76 @@
+package handlers_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/sbxb/shorty/internal/app/auth"
+	"github.com/sbxb/shorty/internal/app/handlers"
+	"github.com/sbxb/shorty/internal/app/storage/inmemory"
+	u "github.com/sbxb/shorty/internal/app/url"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecoveryHandler(t *testing.T) {
+	store, _ := inmemory.NewMapStorage()
+	signer, err := auth.ParseSigningKeys(strings.NewReader("0123456789abcdef"))
+	require.NoError(t, err)
+	rh := handlers.NewRecoveryHandler(store, signer, auth.SessionSettings{Lifetime: time.Hour, Path: "/"})
+
+	router := chi.NewRouter()
+	router.Get("/api/user/recovery", rh.IssueHandler)
+	router.Post("/api/user/recover", rh.RecoverHandler)
+
+	do := func(method, target, body, uid string, byToken bool) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, cfg.BaseURL+target, strings.NewReader(body))
+		ctx := context.WithValue(req.Context(), auth.ContextUserIDKey, uid)
+		if byToken {
+			ctx = context.WithValue(ctx, auth.ContextScopesKey, auth.AllScopes)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req.WithContext(ctx))
+
+		return w
+	}
+
+	original, _ := auth.GenerateUserID()
+	w := do(http.MethodGet, "/api/user/recovery", "", original, false)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "no-store", w.Header().Get("Cache-Control"))
+	var resp u.RecoveryResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Len(t, resp.Code, 27)
+
+	assert.Equal(t, http.StatusForbidden, do(http.MethodGet, "/api/user/recovery", "", original, true).Code)
+
+	// codes are hashed at rest
+	_, err = store.RedeemRecoveryCode(context.Background(), resp.Code)
+	assert.Error(t, err)
+
+	// the code is typed in on another device, case and dashes don't matter
+	device, _ := auth.GenerateUserID()
+	typed := strings.ToLower(strings.ReplaceAll(resp.Code, "-", " "))
+	w = do(http.MethodPost, "/api/user/recover", `{"code": "`+typed+`"}`, device, false)
+	require.Equal(t, http.StatusNoContent, w.Code)
+	cookies := w.Result().Cookies()
+	require.Len(t, cookies, 1)
+	uid, _, _, ok := signer.Verify(cookies[0].Value)
+	require.True(t, ok)
+	assert.Equal(t, original, uid)
+
+	// codes work once
+	w = do(http.MethodPost, "/api/user/recover", `{"code": "`+resp.Code+`"}`, device, false)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Empty(t, w.Result().Cookies())
+
+	assert.Equal(t, http.StatusBadRequest, do(http.MethodPost, "/api/user/recover", `{}`, device, false).Code)
+}
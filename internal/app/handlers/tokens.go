@@ -0,0 +1,92 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/sbxb/shorty/internal/app/auth"
+	"github.com/sbxb/shorty/internal/app/config"
+	u "github.com/sbxb/shorty/internal/app/url"
+)
+
+// TokenHandler defines a container for bearer token handlers and their
+// dependencies
+type TokenHandler struct {
+	signer *auth.Signer
+	config config.Config
+}
+
+func NewTokenHandler(signer *auth.Signer, cfg config.Config) TokenHandler {
+	return TokenHandler{
+		signer: signer,
+		config: cfg,
+	}
+}
+
+// MintHandler process POST /api/user/tokens request with optional JSON
+// payload {"scopes": ["shorten", "read"], "expires_in": 3600}, it returns
+// a bearer token for the user identified by the cookie as
+// {"token": "...", "token_type": "Bearer", "expires_in": 3600, "scopes": [...]}
+// Tokens can't be used to mint other tokens
+func (th TokenHandler) MintHandler(w http.ResponseWriter, r *http.Request) {
+	const ContentType = "application/json"
+
+	if !isCookieAuthenticated(r.Context()) {
+		JSONError(w, http.StatusForbidden, u.ErrorResponse{Error: "Tokens can't be minted with a token"})
+		return
+	}
+
+	var req u.TokenRequest
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		http.Error(w, "Bad request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if len(req.Scopes) == 0 {
+		req.Scopes = auth.AllScopes
+	}
+	for _, scope := range req.Scopes {
+		if !auth.ValidScope(scope) {
+			http.Error(w, "Bad request: unknown scope "+scope, http.StatusBadRequest)
+			return
+		}
+	}
+
+	maxExpiresIn := int64(th.config.TokenLifetime.Seconds())
+	if req.ExpiresIn <= 0 || req.ExpiresIn > maxExpiresIn {
+		req.ExpiresIn = maxExpiresIn
+	}
+
+	now := time.Now()
+	token, err := th.signer.SignToken(auth.TokenClaims{
+		UserID:    GetUserID(r.Context()),
+		IssuedAt:  now.Unix(),
+		ExpiresAt: now.Unix() + req.ExpiresIn,
+		Scopes:    req.Scopes,
+	})
+	if err != nil {
+		http.Error(w, "Server failed to sign token", http.StatusInternalServerError)
+		return
+	}
+
+	jr, err := json.Marshal(u.TokenResponse{
+		Token:     token,
+		TokenType: "Bearer",
+		ExpiresIn: req.ExpiresIn,
+		Scopes:    req.Scopes,
+	})
+	if err != nil {
+		http.Error(w, "Server failed to process response result", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", ContentType)
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusCreated)
+	w.Write(jr)
+}
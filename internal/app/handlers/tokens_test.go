@@ -0,0 +1,61 @@
+package handlers_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sbxb/shorty/internal/app/auth"
+	"github.com/sbxb/shorty/internal/app/handlers"
+	u "github.com/sbxb/shorty/internal/app/url"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMintHandler(t *testing.T) {
+	signer, err := auth.ParseSigningKeys(strings.NewReader("0123456789abcdef"))
+	require.NoError(t, err)
+	uid, _ := auth.GenerateUserID()
+	th := handlers.NewTokenHandler(signer, cfg)
+
+	mint := func(body string, byToken bool) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, cfg.BaseURL+"/api/user/tokens", strings.NewReader(body))
+		ctx := context.WithValue(req.Context(), auth.ContextUserIDKey, uid)
+		if byToken {
+			ctx = context.WithValue(ctx, auth.ContextScopesKey, auth.AllScopes)
+		}
+		w := httptest.NewRecorder()
+		th.MintHandler(w, req.WithContext(ctx))
+
+		return w
+	}
+
+	// all scopes and the maximum lifetime by default
+	w := mint("", false)
+	require.Equal(t, http.StatusCreated, w.Code)
+	var resp u.TokenResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, "Bearer", resp.TokenType)
+	assert.Equal(t, auth.AllScopes, resp.Scopes)
+	assert.Equal(t, int64(cfg.TokenLifetime.Seconds()), resp.ExpiresIn)
+
+	claims, err := signer.VerifyToken(resp.Token, time.Now())
+	require.NoError(t, err)
+	assert.Equal(t, uid, claims.UserID)
+
+	w = mint(`{"scopes": ["read"], "expires_in": 60}`, false)
+	require.Equal(t, http.StatusCreated, w.Code)
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	claims, err = signer.VerifyToken(resp.Token, time.Now())
+	require.NoError(t, err)
+	assert.Equal(t, []string{auth.ScopeRead}, claims.Scopes)
+	assert.Equal(t, int64(60), claims.ExpiresAt-claims.IssuedAt)
+
+	assert.Equal(t, http.StatusBadRequest, mint(`{"scopes": ["admin"]}`, false).Code)
+	assert.Equal(t, http.StatusForbidden, mint("", true).Code)
+}
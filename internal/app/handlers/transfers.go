@@ -0,0 +1,214 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/sbxb/shorty/internal/app/auth"
+	"github.com/sbxb/shorty/internal/app/config"
+	"github.com/sbxb/shorty/internal/app/logger"
+	"github.com/sbxb/shorty/internal/app/storage"
+	u "github.com/sbxb/shorty/internal/app/url"
+)
+
+// TransferHandler defines a container for link transfer handlers and their
+// dependencies, nil workspaces means links are only transferred to users
+type TransferHandler struct {
+	transfers  storage.TransferStorage
+	workspaces storage.WorkspaceStorage
+	config     config.Config
+}
+
+func NewTransferHandler(transfers storage.TransferStorage, workspaces storage.WorkspaceStorage, cfg config.Config) TransferHandler {
+	return TransferHandler{
+		transfers:  transfers,
+		workspaces: workspaces,
+		config:     cfg,
+	}
+}
+
+// CreateHandler process POST /api/user/urls/transfer request with JSON
+// payload {"urls": ["id1", "id2"], "to_user": "..."} or
+// {"urls": [...], "to_workspace": "..."}, it returns the pending transfer
+// with 201. Nothing is moved until the receiver accepts the transfer, and
+// only the urls the user still owns by then are moved
+func (th TransferHandler) CreateHandler(w http.ResponseWriter, r *http.Request) {
+	var req u.TransferRequest
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&req); err != nil {
+		http.Error(w, "Bad request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	urlIDs := uniqueIDs(req.URLs)
+	if len(urlIDs) == 0 {
+		http.Error(w, "Bad request: no ids provided", http.StatusBadRequest)
+		return
+	}
+	if th.config.MaxBatchSize > 0 && len(urlIDs) > th.config.MaxBatchSize {
+		JSONError(w, http.StatusRequestEntityTooLarge, u.ErrorResponse{
+			Error: "Batch size exceeded",
+			Limit: th.config.MaxBatchSize,
+		})
+		return
+	}
+	if (req.ToUser == "") == (req.ToWorkspace == "") {
+		http.Error(w, "Bad request: either to_user or to_workspace expected", http.StatusBadRequest)
+		return
+	}
+
+	userID := GetUserID(r.Context())
+	if req.ToUser == userID {
+		http.Error(w, "Bad request: links can't be transferred to their owner", http.StatusBadRequest)
+		return
+	}
+	if req.ToWorkspace != "" {
+		if th.workspaces == nil {
+			http.Error(w, "Bad request: workspaces are not supported", http.StatusBadRequest)
+			return
+		}
+		_, err := th.workspaces.GetWorkspace(r.Context(), req.ToWorkspace)
+		if errors.Is(err, storage.ErrWorkspaceNotFound) {
+			http.Error(w, "Bad request: unknown workspace", http.StatusBadRequest)
+			return
+		} else if err != nil {
+			http.Error(w, "Server failed to check workspace", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	id, err := auth.GenerateResourceID()
+	if err != nil {
+		http.Error(w, "Server failed to generate transfer id", http.StatusInternalServerError)
+		return
+	}
+	transfer := storage.Transfer{
+		ID:            id,
+		FromUserID:    userID,
+		ToUserID:      req.ToUser,
+		ToWorkspaceID: req.ToWorkspace,
+		URLIDs:        urlIDs,
+		Status:        storage.TransferPending,
+		CreatedAt:     time.Now().UTC(),
+	}
+	if err := th.transfers.AddTransfer(r.Context(), transfer); err != nil {
+		logger.Warningf("TransferHandler: AddTransfer failed: %v", err)
+		http.Error(w, "Server failed to store transfer", http.StatusInternalServerError)
+		return
+	}
+	logger.Infof("TransferHandler: user %s offered %d urls to %s (transfer %s)",
+		userID, len(urlIDs), transferReceiver(transfer), id)
+
+	writeJSON(w, http.StatusCreated, newTransferResponse(transfer))
+}
+
+// ListHandler process GET /api/user/transfers request, it returns transfers
+// sent by the user, sent to the user and sent to workspaces the user owns,
+// resolved ones included, or 204 No Content
+func (th TransferHandler) ListHandler(w http.ResponseWriter, r *http.Request) {
+	transfers, err := th.transfers.GetUserTransfers(r.Context(), GetUserID(r.Context()))
+	if err != nil {
+		http.Error(w, "Server failed to list transfers", http.StatusInternalServerError)
+		return
+	}
+	if len(transfers) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	res := make([]u.TransferResponse, 0, len(transfers))
+	for _, transfer := range transfers {
+		res = append(res, newTransferResponse(transfer))
+	}
+	writeJSON(w, http.StatusOK, res)
+}
+
+// AcceptHandler process POST /api/user/transfers/{id}/accept request, the
+// user becomes the owner of the urls, it returns the accepted transfer or
+// 404 if the user can't accept it
+func (th TransferHandler) AcceptHandler(w http.ResponseWriter, r *http.Request) {
+	userID := GetUserID(r.Context())
+	transfer, err := th.transfers.AcceptTransfer(r.Context(), chi.URLParam(r, "id"), userID, time.Now().UTC())
+	if errors.Is(err, storage.ErrTransferNotFound) {
+		http.Error(w, "Transfer not found", http.StatusNotFound)
+		return
+	} else if IsQuotaExceededError(err) {
+		QuotaExceeded(w, err)
+		return
+	} else if err != nil {
+		logger.Warningf("TransferHandler: AcceptTransfer failed: %v", err)
+		http.Error(w, "Server failed to accept transfer", http.StatusInternalServerError)
+		return
+	}
+	logger.Infof("TransferHandler: user %s accepted transfer %s of %d urls from %s to %s",
+		userID, transfer.ID, transfer.Moved, transfer.FromUserID, transferReceiver(transfer))
+
+	writeJSON(w, http.StatusOK, newTransferResponse(transfer))
+}
+
+// DeclineHandler process POST /api/user/transfers/{id}/decline request, the
+// receiver declines the transfer or the sender cancels it, it returns the
+// transfer or 404 if the user can't decline it
+func (th TransferHandler) DeclineHandler(w http.ResponseWriter, r *http.Request) {
+	userID := GetUserID(r.Context())
+	transfer, err := th.transfers.DeclineTransfer(r.Context(), chi.URLParam(r, "id"), userID, time.Now().UTC())
+	if errors.Is(err, storage.ErrTransferNotFound) {
+		http.Error(w, "Transfer not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		logger.Warningf("TransferHandler: DeclineTransfer failed: %v", err)
+		http.Error(w, "Server failed to decline transfer", http.StatusInternalServerError)
+		return
+	}
+	logger.Infof("TransferHandler: user %s %s transfer %s", userID, transfer.Status, transfer.ID)
+
+	writeJSON(w, http.StatusOK, newTransferResponse(transfer))
+}
+
+// uniqueIDs returns non-empty ids in their original order without repeats
+func uniqueIDs(ids []string) []string {
+	seen := make(map[string]struct{}, len(ids))
+	res := make([]string, 0, len(ids))
+	for _, id := range ids {
+		if _, ok := seen[id]; ok || id == "" {
+			continue
+		}
+		seen[id] = struct{}{}
+		res = append(res, id)
+	}
+
+	return res
+}
+
+func transferReceiver(transfer storage.Transfer) string {
+	if transfer.ToWorkspaceID != "" {
+		return "workspace " + transfer.ToWorkspaceID
+	}
+
+	return "user " + transfer.ToUserID
+}
+
+func newTransferResponse(transfer storage.Transfer) u.TransferResponse {
+	res := u.TransferResponse{
+		ID:          transfer.ID,
+		FromUser:    transfer.FromUserID,
+		ToUser:      transfer.ToUserID,
+		ToWorkspace: transfer.ToWorkspaceID,
+		URLs:        transfer.URLIDs,
+		Status:      transfer.Status,
+		CreatedAt:   transfer.CreatedAt,
+		ResolvedBy:  transfer.ResolvedBy,
+		Moved:       transfer.Moved,
+	}
+	if !transfer.ResolvedAt.IsZero() {
+		resolvedAt := transfer.ResolvedAt
+		res.ResolvedAt = &resolvedAt
+	}
+
+	return res
+}
@@ -0,0 +1,95 @@
+package handlers_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/sbxb/shorty/internal/app/auth"
+	"github.com/sbxb/shorty/internal/app/handlers"
+	"github.com/sbxb/shorty/internal/app/storage"
+	"github.com/sbxb/shorty/internal/app/storage/inmemory"
+	u "github.com/sbxb/shorty/internal/app/url"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTransferHandler(t *testing.T) {
+	ctx := context.Background()
+	store, _ := inmemory.NewMapStorage()
+	th := handlers.NewTransferHandler(store, store, cfg)
+
+	router := chi.NewRouter()
+	router.Post("/api/user/urls/transfer", th.CreateHandler)
+	router.Get("/api/user/transfers", th.ListHandler)
+	router.Post("/api/user/transfers/{id}/accept", th.AcceptHandler)
+	router.Post("/api/user/transfers/{id}/decline", th.DeclineHandler)
+
+	do := func(method, target, body, uid string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, cfg.BaseURL+target, strings.NewReader(body))
+		req = req.WithContext(context.WithValue(req.Context(), auth.ContextUserIDKey, uid))
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		return w
+	}
+
+	require.NoError(t, store.AddURL(ctx, u.URLEntry{ShortURL: "a", OriginalURL: "http://a.com"}, "leaver"))
+	require.NoError(t, store.AddURL(ctx, u.URLEntry{ShortURL: "b", OriginalURL: "http://b.com"}, "leaver"))
+	require.NoError(t, store.AddWorkspace(ctx, storage.Workspace{ID: "ws", Name: "Team"}, "owner"))
+
+	for _, body := range []string{
+		`{"urls": [], "to_user": "receiver"}`,
+		`{"urls": ["a"]}`,
+		`{"urls": ["a"], "to_user": "receiver", "to_workspace": "ws"}`,
+		`{"urls": ["a"], "to_user": "leaver"}`,
+		`{"urls": ["a"], "to_workspace": "unknown"}`,
+	} {
+		assert.Equal(t, http.StatusBadRequest, do(http.MethodPost, "/api/user/urls/transfer", body, "leaver").Code, body)
+	}
+
+	w := do(http.MethodPost, "/api/user/urls/transfer", `{"urls": ["a", "a"], "to_user": "receiver"}`, "leaver")
+	require.Equal(t, http.StatusCreated, w.Code)
+	var transfer u.TransferResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &transfer))
+	assert.Equal(t, []string{"a"}, transfer.URLs)
+	assert.Equal(t, "pending", transfer.Status)
+	assert.Nil(t, transfer.ResolvedAt)
+
+	// nothing moves until accepted
+	urls, _ := store.GetUserURLs(ctx, "leaver")
+	assert.Len(t, urls, 2)
+	assert.Equal(t, http.StatusNoContent, do(http.MethodGet, "/api/user/transfers", "", "stranger").Code)
+	assert.Equal(t, http.StatusNotFound, do(http.MethodPost, "/api/user/transfers/"+transfer.ID+"/accept", "", "stranger").Code)
+
+	w = do(http.MethodPost, "/api/user/transfers/"+transfer.ID+"/accept", "", "receiver")
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &transfer))
+	assert.Equal(t, "accepted", transfer.Status)
+	assert.Equal(t, 1, transfer.Moved)
+	assert.NotNil(t, transfer.ResolvedAt)
+	urls, _ = store.GetUserURLs(ctx, "receiver")
+	assert.Equal(t, []u.URLEntry{{ShortURL: "a", OriginalURL: "http://a.com"}}, urls)
+	assert.Equal(t, http.StatusNotFound, do(http.MethodPost, "/api/user/transfers/"+transfer.ID+"/decline", "", "receiver").Code)
+
+	// transfers to a workspace are declined by its owners
+	w = do(http.MethodPost, "/api/user/urls/transfer", `{"urls": ["b"], "to_workspace": "ws"}`, "leaver")
+	require.Equal(t, http.StatusCreated, w.Code)
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &transfer))
+	w = do(http.MethodPost, "/api/user/transfers/"+transfer.ID+"/decline", "", "owner")
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &transfer))
+	assert.Equal(t, "declined", transfer.Status)
+
+	w = do(http.MethodGet, "/api/user/transfers", "", "leaver")
+	require.Equal(t, http.StatusOK, w.Code)
+	var transfers []u.TransferResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &transfers))
+	assert.Len(t, transfers, 2)
+}
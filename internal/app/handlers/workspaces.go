@@ -0,0 +1,289 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/sbxb/shorty/internal/app/auth"
+	"github.com/sbxb/shorty/internal/app/config"
+	"github.com/sbxb/shorty/internal/app/logger"
+	"github.com/sbxb/shorty/internal/app/storage"
+	u "github.com/sbxb/shorty/internal/app/url"
+)
+
+// maxWorkspaceNameLength limits the length of workspace names in characters
+const maxWorkspaceNameLength = 100
+
+// WorkspaceHandler defines a container for workspace handlers and their
+// dependencies, links are created in store and then moved into workspaces
+type WorkspaceHandler struct {
+	store      storage.Storage
+	workspaces storage.WorkspaceStorage
+	config     config.Config
+}
+
+func NewWorkspaceHandler(st storage.Storage, workspaces storage.WorkspaceStorage, cfg config.Config) WorkspaceHandler {
+	return WorkspaceHandler{
+		store:      st,
+		workspaces: workspaces,
+		config:     cfg,
+	}
+}
+
+// CreateHandler process POST /api/workspaces request with JSON payload
+// {"name": "Marketing"}, the user becomes the owner of the new workspace
+// returned as {"id": "...", "name": "Marketing", "role": "owner",
+// "created_at": "..."}
+func (wh WorkspaceHandler) CreateHandler(w http.ResponseWriter, r *http.Request) {
+	var req u.WorkspaceRequest
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&req); err != nil {
+		http.Error(w, "Bad request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" || utf8.RuneCountInString(req.Name) > maxWorkspaceNameLength {
+		http.Error(w, "Bad request: name is empty or too long", http.StatusBadRequest)
+		return
+	}
+
+	id, err := auth.GenerateResourceID()
+	if err != nil {
+		http.Error(w, "Server failed to generate workspace id", http.StatusInternalServerError)
+		return
+	}
+	ws := storage.Workspace{ID: id, Name: req.Name, CreatedAt: time.Now().UTC()}
+	if err := wh.workspaces.AddWorkspace(r.Context(), ws, GetUserID(r.Context())); err != nil {
+		logger.Warningf("WorkspaceHandler: AddWorkspace failed: %v", err)
+		http.Error(w, "Server failed to create workspace", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, newWorkspaceResponse(storage.UserWorkspace{
+		Workspace: ws,
+		Role:      storage.WorkspaceOwner,
+	}))
+}
+
+// ListHandler process GET /api/workspaces request, it returns workspaces
+// the user is a member of as [{"id": "...", "name": "Marketing",
+// "role": "editor", "created_at": "..."}, ...] or 204 No Content
+func (wh WorkspaceHandler) ListHandler(w http.ResponseWriter, r *http.Request) {
+	workspaces, err := wh.workspaces.GetUserWorkspaces(r.Context(), GetUserID(r.Context()))
+	if err != nil {
+		http.Error(w, "Server failed to list workspaces", http.StatusInternalServerError)
+		return
+	}
+	if len(workspaces) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	res := make([]u.WorkspaceResponse, 0, len(workspaces))
+	for _, ws := range workspaces {
+		res = append(res, newWorkspaceResponse(ws))
+	}
+	writeJSON(w, http.StatusOK, res)
+}
+
+// URLsHandler process GET /api/workspaces/{id}/urls request, it replies to
+// members as GET /api/user/urls would reply with the workspace's urls
+func (wh WorkspaceHandler) URLsHandler(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	if _, ok := wh.authorize(w, r, id, storage.WorkspaceViewer); !ok {
+		return
+	}
+
+	urls, err := wh.workspaces.GetWorkspaceURLs(r.Context(), id)
+	if err != nil {
+		http.Error(w, "Server failed to list URLs", http.StatusInternalServerError)
+		return
+	}
+	if len(urls) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	for i := range urls {
+		urls[i].ShortURL = wh.config.BaseURL + "/" + urls[i].ShortURL
+	}
+	writeJSON(w, http.StatusOK, urls)
+}
+
+// ShortenHandler process POST /api/workspaces/{id}/urls request with JSON
+// payload {"url": "<some_url>"}, it replies as POST /api/shorten does and
+// moves the link into the workspace. The user's own existing link is moved
+// as well, while a link of someone else is left where it is
+func (wh WorkspaceHandler) ShortenHandler(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	if _, ok := wh.authorize(w, r, id, storage.WorkspaceEditor); !ok {
+		return
+	}
+
+	var req u.URLRequest
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&req); err != nil {
+		http.Error(w, "Bad request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !u.IsValidInputURL(req.URL) {
+		http.Error(w, "Bad request: non-valid object received", http.StatusBadRequest)
+		return
+	}
+
+	status := http.StatusCreated
+	userID := GetUserID(r.Context())
+	urlID, err := addURL(r.Context(), wh.store, req.URL, userID)
+	if IsConflictError(err) {
+		status = http.StatusConflict
+	} else if IsUnavailableError(err) {
+		ServiceUnavailable(w, err)
+		return
+	} else if IsInsufficientStorageError(err) {
+		http.Error(w, "Server storage is full", http.StatusInsufficientStorage)
+		return
+	} else if IsQuotaExceededError(err) {
+		QuotaExceeded(w, err)
+		return
+	} else if err != nil {
+		http.Error(w, "Server failed to store URL", http.StatusInternalServerError)
+		return
+	}
+
+	if _, err := wh.workspaces.AddURLsToWorkspace(r.Context(), id, []string{urlID}, userID); err != nil {
+		logger.Warningf("WorkspaceHandler: AddURLsToWorkspace failed: %v", err)
+		http.Error(w, "Server failed to add URL to workspace", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, status, u.URLResponse{Result: wh.config.BaseURL + "/" + urlID})
+}
+
+// MembersHandler process GET /api/workspaces/{id}/members request, it
+// returns the members as [{"user_id": "...", "role": "owner"}, ...]
+func (wh WorkspaceHandler) MembersHandler(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	if _, ok := wh.authorize(w, r, id, storage.WorkspaceViewer); !ok {
+		return
+	}
+
+	members, err := wh.workspaces.GetWorkspaceMembers(r.Context(), id)
+	if err != nil {
+		http.Error(w, "Server failed to list members", http.StatusInternalServerError)
+		return
+	}
+
+	res := make([]u.WorkspaceMemberResponse, 0, len(members))
+	for _, member := range members {
+		res = append(res, u.WorkspaceMemberResponse{UserID: member.UserID, Role: member.Role})
+	}
+	writeJSON(w, http.StatusOK, res)
+}
+
+// SetMemberHandler process PUT /api/workspaces/{id}/members/{uid} request
+// with JSON payload {"role": "editor"}, only owners add members and change
+// their roles, owners can't change their own role so that the workspace is
+// never left without an owner
+func (wh WorkspaceHandler) SetMemberHandler(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	if _, ok := wh.authorize(w, r, id, storage.WorkspaceOwner); !ok {
+		return
+	}
+
+	var req u.RoleRequest
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&req); err != nil {
+		http.Error(w, "Bad request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !storage.ValidWorkspaceRole(req.Role) {
+		http.Error(w, "Bad request: role should be viewer, editor or owner", http.StatusBadRequest)
+		return
+	}
+	uid := chi.URLParam(r, "uid")
+	if uid == GetUserID(r.Context()) {
+		JSONError(w, http.StatusConflict, u.ErrorResponse{Error: "Owners can't change their own role"})
+		return
+	}
+
+	member := storage.WorkspaceMember{WorkspaceID: id, UserID: uid, Role: req.Role}
+	if err := wh.workspaces.SetWorkspaceMember(r.Context(), member); err != nil {
+		logger.Warningf("WorkspaceHandler: SetWorkspaceMember failed: %v", err)
+		http.Error(w, "Server failed to set member", http.StatusInternalServerError)
+		return
+	}
+	logger.Infof("WorkspaceHandler: user %s set role of %s in %s to %s", GetUserID(r.Context()), uid, id, req.Role)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// RemoveMemberHandler process DELETE /api/workspaces/{id}/members/{uid}
+// request, owners remove other members, while any member but an owner may
+// leave the workspace
+func (wh WorkspaceHandler) RemoveMemberHandler(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	uid := chi.URLParam(r, "uid")
+	self := uid == GetUserID(r.Context())
+
+	required := storage.WorkspaceOwner
+	if self {
+		required = storage.WorkspaceViewer
+	}
+	role, ok := wh.authorize(w, r, id, required)
+	if !ok {
+		return
+	}
+	if self && role == storage.WorkspaceOwner {
+		JSONError(w, http.StatusConflict, u.ErrorResponse{Error: "Owners can't leave their workspace"})
+		return
+	}
+
+	if err := wh.workspaces.RemoveWorkspaceMember(r.Context(), id, uid); err != nil {
+		logger.Warningf("WorkspaceHandler: RemoveWorkspaceMember failed: %v", err)
+		http.Error(w, "Server failed to remove member", http.StatusInternalServerError)
+		return
+	}
+	logger.Infof("WorkspaceHandler: user %s removed %s from %s", GetUserID(r.Context()), uid, id)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// authorize returns the user's role in the workspace if it grants required,
+// otherwise it replies with 404 for unknown workspaces or 403
+func (wh WorkspaceHandler) authorize(w http.ResponseWriter, r *http.Request, id string, required string) (string, bool) {
+	uid := GetUserID(r.Context())
+	role, err := wh.workspaces.GetWorkspaceRole(r.Context(), id, uid)
+	if errors.Is(err, storage.ErrWorkspaceNotFound) {
+		http.Error(w, "Workspace not found", http.StatusNotFound)
+		return "", false
+	} else if err != nil {
+		logger.Warningf("WorkspaceHandler: GetWorkspaceRole failed: %v", err)
+		http.Error(w, "Server failed to check membership", http.StatusInternalServerError)
+		return "", false
+	}
+	if !storage.WorkspaceRoleAllows(role, required) {
+		logger.Warningf("authorization failure: user %s with workspace role %q requires %s for %s %s",
+			uid, role, required, r.Method, r.URL.Path)
+		JSONError(w, http.StatusForbidden, u.ErrorResponse{Error: "Workspace role " + required + " required"})
+		return "", false
+	}
+
+	return role, true
+}
+
+func newWorkspaceResponse(ws storage.UserWorkspace) u.WorkspaceResponse {
+	return u.WorkspaceResponse{
+		ID:        ws.ID,
+		Name:      ws.Name,
+		Role:      ws.Role,
+		CreatedAt: ws.CreatedAt,
+	}
+}
@@ -0,0 +1,99 @@
+package handlers_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/sbxb/shorty/internal/app/auth"
+	"github.com/sbxb/shorty/internal/app/handlers"
+	"github.com/sbxb/shorty/internal/app/storage/inmemory"
+	u "github.com/sbxb/shorty/internal/app/url"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWorkspaceHandler(t *testing.T) {
+	store, _ := inmemory.NewMapStorage()
+	wh := handlers.NewWorkspaceHandler(store, store, cfg)
+
+	router := chi.NewRouter()
+	router.Post("/api/workspaces", wh.CreateHandler)
+	router.Get("/api/workspaces", wh.ListHandler)
+	router.Get("/api/workspaces/{id}/urls", wh.URLsHandler)
+	router.Post("/api/workspaces/{id}/urls", wh.ShortenHandler)
+	router.Get("/api/workspaces/{id}/members", wh.MembersHandler)
+	router.Put("/api/workspaces/{id}/members/{uid}", wh.SetMemberHandler)
+	router.Delete("/api/workspaces/{id}/members/{uid}", wh.RemoveMemberHandler)
+
+	do := func(method, target, body, uid string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, cfg.BaseURL+target, strings.NewReader(body))
+		req = req.WithContext(context.WithValue(req.Context(), auth.ContextUserIDKey, uid))
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		return w
+	}
+
+	assert.Equal(t, http.StatusBadRequest, do(http.MethodPost, "/api/workspaces", `{"name": " "}`, "owner").Code)
+	w := do(http.MethodPost, "/api/workspaces", `{"name": "Marketing"}`, "owner")
+	require.Equal(t, http.StatusCreated, w.Code)
+	var ws u.WorkspaceResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &ws))
+	assert.Equal(t, "Marketing", ws.Name)
+	assert.Equal(t, "owner", ws.Role)
+	base := "/api/workspaces/" + ws.ID
+
+	assert.Equal(t, http.StatusNoContent, do(http.MethodGet, "/api/workspaces", "", "editor").Code)
+	assert.Equal(t, http.StatusNotFound, do(http.MethodGet, "/api/workspaces/unknown/urls", "", "owner").Code)
+	assert.Equal(t, http.StatusForbidden, do(http.MethodGet, base+"/urls", "", "editor").Code)
+
+	// only owners manage members
+	assert.Equal(t, http.StatusNoContent, do(http.MethodPut, base+"/members/editor", `{"role": "editor"}`, "owner").Code)
+	assert.Equal(t, http.StatusNoContent, do(http.MethodPut, base+"/members/viewer", `{"role": "viewer"}`, "owner").Code)
+	assert.Equal(t, http.StatusForbidden, do(http.MethodPut, base+"/members/viewer", `{"role": "owner"}`, "editor").Code)
+	assert.Equal(t, http.StatusBadRequest, do(http.MethodPut, base+"/members/viewer", `{"role": "admin"}`, "owner").Code)
+	assert.Equal(t, http.StatusBadRequest, do(http.MethodPut, base+"/members/viewer", `{"role": "viewer", "rol": "owner"}`, "owner").Code)
+	assert.Equal(t, http.StatusConflict, do(http.MethodPut, base+"/members/owner", `{"role": "viewer"}`, "owner").Code)
+
+	w = do(http.MethodGet, "/api/workspaces", "", "editor")
+	require.Equal(t, http.StatusOK, w.Code)
+	var list []u.WorkspaceResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &list))
+	require.Len(t, list, 1)
+	assert.Equal(t, "editor", list[0].Role)
+
+	w = do(http.MethodGet, base+"/members", "", "viewer")
+	require.Equal(t, http.StatusOK, w.Code)
+	var members []u.WorkspaceMemberResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &members))
+	assert.Len(t, members, 3)
+
+	// links are created by editors
+	assert.Equal(t, http.StatusNoContent, do(http.MethodGet, base+"/urls", "", "viewer").Code)
+	assert.Equal(t, http.StatusForbidden, do(http.MethodPost, base+"/urls", `{"url": "http://example.com"}`, "viewer").Code)
+	w = do(http.MethodPost, base+"/urls", `{"url": "http://example.com"}`, "editor")
+	require.Equal(t, http.StatusCreated, w.Code)
+	var res u.URLResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &res))
+	assert.Equal(t, cfg.BaseURL+"/"+u.ShortID("http://example.com"), res.Result)
+
+	w = do(http.MethodGet, base+"/urls", "", "viewer")
+	require.Equal(t, http.StatusOK, w.Code)
+	var urls []u.URLEntry
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &urls))
+	assert.Equal(t, []u.URLEntry{{ShortURL: res.Result, OriginalURL: "http://example.com"}}, urls)
+
+	// members leave, owners stay
+	assert.Equal(t, http.StatusForbidden, do(http.MethodDelete, base+"/members/editor", "", "viewer").Code)
+	assert.Equal(t, http.StatusNoContent, do(http.MethodDelete, base+"/members/viewer", "", "viewer").Code)
+	assert.Equal(t, http.StatusForbidden, do(http.MethodGet, base+"/urls", "", "viewer").Code)
+	assert.Equal(t, http.StatusConflict, do(http.MethodDelete, base+"/members/owner", "", "owner").Code)
+	assert.Equal(t, http.StatusNoContent, do(http.MethodDelete, base+"/members/editor", "", "owner").Code)
+}
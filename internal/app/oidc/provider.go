@@ -0,0 +1,165 @@
+// Package oidc implements the OpenID Connect authorization code flow with
+// PKCE against a single provider, ID tokens are verified against
+// the provider's JWKS
+package oidc
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// requestTimeout bounds every request to the provider
+const requestTimeout = 10 * time.Second
+
+// Config defines the client registered at the provider
+type Config struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+}
+
+// Provider is an OpenID Connect provider discovered at its issuer URL
+type Provider struct {
+	config Config
+	client *http.Client
+
+	authEndpoint  string
+	tokenEndpoint string
+	keys          *keySet
+}
+
+type discovery struct {
+	Issuer        string `json:"issuer"`
+	AuthEndpoint  string `json:"authorization_endpoint"`
+	TokenEndpoint string `json:"token_endpoint"`
+	JWKSURI       string `json:"jwks_uri"`
+}
+
+// NewProvider fetches the provider's configuration from
+// <issuer>/.well-known/openid-configuration
+func NewProvider(ctx context.Context, cfg Config) (*Provider, error) {
+	client := &http.Client{Timeout: requestTimeout}
+	wellKnown := strings.TrimSuffix(cfg.Issuer, "/") + "/.well-known/openid-configuration"
+
+	var d discovery
+	if err := getJSON(ctx, client, wellKnown, &d); err != nil {
+		return nil, fmt.Errorf("oidc: discovery: %w", err)
+	}
+	if d.Issuer != cfg.Issuer {
+		return nil, fmt.Errorf("oidc: discovery: issuer %q does not match %q", d.Issuer, cfg.Issuer)
+	}
+	if d.AuthEndpoint == "" || d.TokenEndpoint == "" || d.JWKSURI == "" {
+		return nil, errors.New("oidc: discovery: endpoints missing")
+	}
+
+	return &Provider{
+		config:        cfg,
+		client:        client,
+		authEndpoint:  d.AuthEndpoint,
+		tokenEndpoint: d.TokenEndpoint,
+		keys:          newKeySet(client, d.JWKSURI),
+	}, nil
+}
+
+// AuthCodeURL returns the URL of the provider's login page, the provider
+// redirects back with the code and state, nonce ends up in the ID token
+func (p *Provider) AuthCodeURL(state, nonce, verifier string) string {
+	v := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {p.config.ClientID},
+		"redirect_uri":          {p.config.RedirectURL},
+		"scope":                 {"openid"},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {Challenge(verifier)},
+		"code_challenge_method": {"S256"},
+	}
+
+	sep := "?"
+	if strings.Contains(p.authEndpoint, "?") {
+		sep = "&"
+	}
+
+	return p.authEndpoint + sep + v.Encode()
+}
+
+type tokenResponse struct {
+	IDToken string `json:"id_token"`
+	Error   string `json:"error"`
+}
+
+// Exchange trades the code for tokens and returns the verified ID token
+// claims, nonce should be the one passed to AuthCodeURL
+func (p *Provider) Exchange(ctx context.Context, code, verifier, nonce string) (Claims, error) {
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.config.RedirectURL},
+		"code_verifier": {verifier},
+	}
+	// public clients have no secret, PKCE protects them
+	if p.config.ClientSecret == "" {
+		form.Set("client_id", p.config.ClientID)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.tokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return Claims{}, fmt.Errorf("oidc: exchange: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if p.config.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(p.config.ClientID), url.QueryEscape(p.config.ClientSecret))
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return Claims{}, fmt.Errorf("oidc: exchange: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var tr tokenResponse
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&tr); err != nil {
+		return Claims{}, fmt.Errorf("oidc: exchange: %s: %w", resp.Status, err)
+	}
+	if resp.StatusCode != http.StatusOK || tr.IDToken == "" {
+		return Claims{}, fmt.Errorf("oidc: exchange: %s: %s", resp.Status, tr.Error)
+	}
+
+	return p.VerifyIDToken(ctx, tr.IDToken, nonce, time.Now())
+}
+
+// UserID maps the subject of claims to a user id, the same subject of
+// the same issuer always gets the same id which looks like the ids of
+// anonymous users
+func UserID(claims Claims) string {
+	sum := sha256.Sum256([]byte(claims.Issuer + "\x00" + claims.Subject))
+
+	return hex.EncodeToString(sum[:16])
+}
+
+func getJSON(ctx context.Context, client *http.Client, target string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", target, resp.Status)
+	}
+
+	return json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(v)
+}
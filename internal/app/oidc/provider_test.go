@@ -0,0 +1,128 @@
+package oidc_test
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/sbxb/shorty/internal/app/oidc"
+	"github.com/sbxb/shorty/internal/app/oidc/oidctest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const redirectURL = "http://localhost:8080/auth/oidc/callback"
+
+func newProvider(t *testing.T, fake *oidctest.Provider) *oidc.Provider {
+	p, err := oidc.NewProvider(context.Background(), oidc.Config{
+		Issuer:       fake.Issuer,
+		ClientID:     oidctest.ClientID,
+		ClientSecret: oidctest.ClientSecret,
+		RedirectURL:  redirectURL,
+	})
+	require.NoError(t, err)
+
+	return p
+}
+
+// authorize follows the auth code URL and returns the code and state
+// the fake provider redirects back with
+func authorize(t *testing.T, p *oidc.Provider, state, nonce, verifier string) (string, string) {
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	resp, err := client.Get(p.AuthCodeURL(state, nonce, verifier))
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusFound, resp.StatusCode)
+
+	back, err := url.Parse(resp.Header.Get("Location"))
+	require.NoError(t, err)
+
+	return back.Query().Get("code"), back.Query().Get("state")
+}
+
+func TestProvider_Exchange(t *testing.T) {
+	fake := oidctest.NewProvider(t, "alice")
+	p := newProvider(t, fake)
+
+	verifier, _ := oidc.RandomString()
+	code, state := authorize(t, p, "state", "nonce", verifier)
+	assert.Equal(t, "state", state)
+
+	claims, err := p.Exchange(context.Background(), code, verifier, "nonce")
+	require.NoError(t, err)
+	assert.Equal(t, "alice", claims.Subject)
+	assert.Equal(t, fake.Issuer, claims.Issuer)
+
+	// the same subject always maps to the same user id
+	code, _ = authorize(t, p, "state", "nonce", verifier)
+	again, err := p.Exchange(context.Background(), code, verifier, "nonce")
+	require.NoError(t, err)
+	assert.Equal(t, oidc.UserID(claims), oidc.UserID(again))
+	assert.Len(t, oidc.UserID(claims), 32)
+
+	fake.SetSubject("bob")
+	code, _ = authorize(t, p, "state", "nonce", verifier)
+	bob, err := p.Exchange(context.Background(), code, verifier, "nonce")
+	require.NoError(t, err)
+	assert.NotEqual(t, oidc.UserID(claims), oidc.UserID(bob))
+
+	// codes are single use
+	_, err = p.Exchange(context.Background(), code, verifier, "nonce")
+	assert.Error(t, err)
+
+	// PKCE verifier and nonce have to match
+	code, _ = authorize(t, p, "state", "nonce", verifier)
+	_, err = p.Exchange(context.Background(), code, "wrong verifier", "nonce")
+	assert.Error(t, err)
+	code, _ = authorize(t, p, "state", "nonce", verifier)
+	_, err = p.Exchange(context.Background(), code, verifier, "other nonce")
+	assert.ErrorIs(t, err, oidc.ErrInvalidIDToken)
+}
+
+func TestProvider_VerifyIDToken(t *testing.T) {
+	fake := oidctest.NewProvider(t, "alice")
+	p := newProvider(t, fake)
+	now := time.Now()
+
+	valid := func() map[string]interface{} {
+		return map[string]interface{}{
+			"iss":   fake.Issuer,
+			"sub":   "alice",
+			"aud":   []string{"other", oidctest.ClientID},
+			"iat":   now.Unix(),
+			"exp":   now.Add(time.Hour).Unix(),
+			"nonce": "nonce",
+		}
+	}
+
+	_, err := p.VerifyIDToken(context.Background(), fake.SignIDToken(valid()), "nonce", now)
+	require.NoError(t, err)
+
+	for name, tamper := range map[string]func(map[string]interface{}){
+		"issuer":   func(c map[string]interface{}) { c["iss"] = "https://evil.example.com" },
+		"audience": func(c map[string]interface{}) { c["aud"] = "other" },
+		"subject":  func(c map[string]interface{}) { c["sub"] = "" },
+		"expired":  func(c map[string]interface{}) { c["exp"] = now.Add(-time.Hour).Unix() },
+		"future":   func(c map[string]interface{}) { c["iat"] = now.Add(time.Hour).Unix() },
+		"nonce":    func(c map[string]interface{}) { c["nonce"] = "other" },
+	} {
+		claims := valid()
+		tamper(claims)
+		_, err := p.VerifyIDToken(context.Background(), fake.SignIDToken(claims), "nonce", now)
+		assert.ErrorIs(t, err, oidc.ErrInvalidIDToken, name)
+	}
+
+	// forged signature
+	token := fake.SignIDToken(valid())
+	other := oidctest.NewProvider(t, "alice")
+	forged := other.SignIDToken(valid())
+	_, err = p.VerifyIDToken(context.Background(), token[:len(token)-10]+forged[len(forged)-10:], "nonce", now)
+	assert.ErrorIs(t, err, oidc.ErrInvalidIDToken)
+	_, err = p.VerifyIDToken(context.Background(), "not.a.token", "nonce", now)
+	assert.ErrorIs(t, err, oidc.ErrInvalidIDToken)
+}
@@ -0,0 +1,51 @@
+package storage
+
+import (
+	"context"
+	"errors"
+)
+
+// Visibilities of urls, urls without an ACL stored are public
+const (
+	VisibilityPublic        = "public"
+	VisibilityAuthenticated = "authenticated"
+	VisibilityUsers         = "users"
+	VisibilityWorkspace     = "workspace"
+)
+
+// ValidVisibility reports whether visibility is known
+func ValidVisibility(visibility string) bool {
+	switch visibility {
+	case VisibilityPublic, VisibilityAuthenticated, VisibilityUsers, VisibilityWorkspace:
+		return true
+	}
+
+	return false
+}
+
+// ACL restricts who is redirected by a url, its owner always is
+type ACL struct {
+	Visibility string
+	// UserIDs are allowed if Visibility is VisibilityUsers
+	UserIDs []string `json:",omitempty"`
+	// Members of WorkspaceID are allowed if Visibility is VisibilityWorkspace
+	WorkspaceID string `json:",omitempty"`
+}
+
+// IsPublic reports whether the ACL lets everyone in
+func (acl ACL) IsPublic() bool {
+	return acl.Visibility == "" || acl.Visibility == VisibilityPublic
+}
+
+// ErrURLNotFound is returned for unknown urls and urls of other users
+var ErrURLNotFound = errors.New("url not found")
+
+// ACLStorage is implemented by storages which are able to keep ACLs of urls
+type ACLStorage interface {
+	// GetACL returns the ACL of the url along with its owner, or
+	// ErrURLNotFound for unknown urls
+	GetACL(ctx context.Context, id string) (ACL, string, error)
+	// SetACL replaces the ACL of the url created by the user, a public ACL
+	// removes the one stored
+	SetACL(ctx context.Context, id string, userID string, acl ACL) error
+}
@@ -0,0 +1,28 @@
+package storage
+
+import "context"
+
+// RoleStorage is implemented by storages which are able to keep roles of
+// users, users without a role stored are ordinary users
+type RoleStorage interface {
+	// GetRole returns an empty string for users without a role stored
+	GetRole(ctx context.Context, userID string) (string, error)
+	SetRole(ctx context.Context, userID string, role string) error
+}
+
+// Stats describe the whole storage
+type Stats struct {
+	URLs        int
+	DeletedURLs int
+	// Users is the number of users having urls
+	Users int
+}
+
+// AdminStorage is implemented by storages which allow administrative
+// operations regardless of the owners of urls
+type AdminStorage interface {
+	// DisableURLs marks urls as deleted whoever they belong to, returns
+	// the number of urls disabled
+	DisableURLs(ctx context.Context, ids []string) (int, error)
+	Stats(ctx context.Context) (Stats, error)
+}
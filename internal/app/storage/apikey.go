@@ -0,0 +1,35 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// APIKey is a long-lived credential of a user, only the hash of the key
+// itself is stored, Prefix is kept to tell the keys apart
+type APIKey struct {
+	ID         string
+	UserID     string
+	Name       string
+	Prefix     string
+	Hash       string
+	CreatedAt  time.Time
+	LastUsedAt time.Time // zero if never used
+	Uses       int64
+}
+
+// ErrAPIKeyNotFound is returned for unknown, revoked or foreign keys
+var ErrAPIKeyNotFound = errors.New("API key not found")
+
+// APIKeyStorage is implemented by storages which are able to keep API keys
+type APIKeyStorage interface {
+	AddAPIKey(ctx context.Context, key APIKey) error
+	// GetAPIKeyByHash returns ErrAPIKeyNotFound for unknown hashes
+	GetAPIKeyByHash(ctx context.Context, hash string) (APIKey, error)
+	GetUserAPIKeys(ctx context.Context, userID string) ([]APIKey, error)
+	// DeleteAPIKey returns ErrAPIKeyNotFound unless the user has the key
+	DeleteAPIKey(ctx context.Context, id string, userID string) error
+	// TouchAPIKey records the key usage at the time given
+	TouchAPIKey(ctx context.Context, id string, usedAt time.Time) error
+}
@@ -0,0 +1,164 @@
+package bloom
+
+import (
+	"context"
+	"expvar"
+	"fmt"
+	"sync"
+
+	"github.com/sbxb/shorty/internal/app/logger"
+	"github.com/sbxb/shorty/internal/app/storage"
+	"github.com/sbxb/shorty/internal/app/url"
+)
+
+// metrics are published at /debug/vars as "bloom"
+var metrics = expvar.NewMap("bloom")
+
+// FilteredStorage defines a storage wrapper which answers GetURL for ids
+// that definitely do not exist without asking the underlying storage
+// The filter is built from the underlying storage on creation and updated
+// on every write, ids added by other instances should be passed to Add
+type FilteredStorage struct {
+	storage.Storage
+
+	scanner  storage.IDScanner
+	capacity int
+	fpRate   float64
+
+	mu     sync.RWMutex
+	filter *Filter
+	next   *Filter // being rebuilt, gets all the ids added meanwhile
+}
+
+// FilteredStorage implements Storage interface
+var _ storage.Storage = (*FilteredStorage)(nil)
+
+// New wraps store with a filter sized for capacity ids with fpRate false
+// positive rate, the filter is filled with all the ids known to scanner
+// (normally the storage store is built upon)
+func New(ctx context.Context, store storage.Storage, scanner storage.IDScanner, capacity int, fpRate float64) (*FilteredStorage, error) {
+	st := &FilteredStorage{
+		Storage:  store,
+		scanner:  scanner,
+		capacity: capacity,
+		fpRate:   fpRate,
+	}
+	metrics.Set("stats", expvar.Func(func() interface{} {
+		return st.Stats()
+	}))
+
+	if err := st.Rebuild(ctx); err != nil {
+		return nil, err
+	}
+
+	return st, nil
+}
+
+// Rebuild builds a new filter from scratch, the old one is used meanwhile
+func (st *FilteredStorage) Rebuild(ctx context.Context) error {
+	filter := NewFilter(st.capacity, st.fpRate)
+
+	st.mu.Lock()
+	st.next = filter
+	st.mu.Unlock()
+
+	if err := st.scanner.ScanIDs(ctx, filter.Add); err != nil {
+		st.mu.Lock()
+		st.next = nil
+		st.mu.Unlock()
+		return fmt.Errorf("FilteredStorage: %w", err)
+	}
+
+	stats := filter.Stats()
+	if stats.Items > st.capacity {
+		logger.Warningf("FilteredStorage: %d ids exceed the capacity of %d, false positive rate is %.4f",
+			stats.Items, st.capacity, stats.Estimated)
+	}
+
+	st.mu.Lock()
+	st.filter, st.next = filter, nil
+	st.mu.Unlock()
+
+	return nil
+}
+
+func (st *FilteredStorage) current() *Filter {
+	st.mu.RLock()
+	defer st.mu.RUnlock()
+
+	return st.filter
+}
+
+// AddURL adds the id to the filter before storing, so that it is never
+// missed by concurrent GetURL
+func (st *FilteredStorage) AddURL(ctx context.Context, ue url.URLEntry, userID string) error {
+	st.Add(ue.ShortURL)
+
+	return st.Storage.AddURL(ctx, ue, userID)
+}
+
+func (st *FilteredStorage) AddBatchURL(ctx context.Context, batch []url.BatchURLEntry, userID string) error {
+	ids := make([]string, 0, len(batch))
+	for _, e := range batch {
+		ids = append(ids, e.ShortURL)
+	}
+	st.Add(ids...)
+
+	return st.Storage.AddBatchURL(ctx, batch, userID)
+}
+
+// GetURL returns an empty string for ids definitely unknown to the filter
+func (st *FilteredStorage) GetURL(ctx context.Context, id string) (string, error) {
+	if !st.current().MayContain(id) {
+		metrics.Add("skipped", 1)
+		return "", nil
+	}
+
+	u, err := st.Storage.GetURL(ctx, id)
+	if u == "" && err == nil {
+		metrics.Add("false_positives", 1)
+	}
+
+	return u, err
+}
+
+// Ping pings the underlying storage if it supports pinging
+func (st *FilteredStorage) Ping() error {
+	pinger, ok := st.Storage.(storage.Pinger)
+	if !ok {
+		return storage.ErrPingNotSupported
+	}
+
+	return pinger.Ping()
+}
+
+// Stats returns the current filter stats
+func (st *FilteredStorage) Stats() Stats {
+	return st.current().Stats()
+}
+
+// Add adds ids created elsewhere (e.g. by another instance) to the filter
+func (st *FilteredStorage) Add(ids ...string) {
+	st.mu.RLock()
+	defer st.mu.RUnlock()
+
+	for _, id := range ids {
+		if st.filter != nil {
+			st.filter.Add(id)
+		}
+		if st.next != nil {
+			st.next.Add(id)
+		}
+	}
+}
+
+// Evict does nothing, ids can't be removed from a Bloom filter, deleted
+// ids are still passed to the underlying storage
+func (st *FilteredStorage) Evict(ids ...string) {}
+
+// Flush rebuilds the filter since some ids might have been missed
+func (st *FilteredStorage) Flush() {
+	if err := st.Rebuild(context.Background()); err != nil {
+		logger.Warningf("FilteredStorage: rebuild failed: %v", err)
+	}
+}
@@ -0,0 +1,81 @@
+package bloom_test
+
+import (
+	"context"
+	"strconv"
+	"testing"
+
+	"github.com/sbxb/shorty/internal/app/storage"
+	"github.com/sbxb/shorty/internal/app/storage/bloom"
+	"github.com/sbxb/shorty/internal/app/storage/inmemory"
+	"github.com/sbxb/shorty/internal/app/url"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// countingStorage counts GetURL calls reaching the underlying storage
+type countingStorage struct {
+	storage.Storage
+	gets int
+}
+
+func (cs *countingStorage) GetURL(ctx context.Context, id string) (string, error) {
+	cs.gets++
+	return cs.Storage.GetURL(ctx, id)
+}
+
+func TestFilteredStorage(t *testing.T) {
+	ctx := context.Background()
+	ms, _ := inmemory.NewMapStorage() // NewMapStorage() never returns non-nil error
+	existing := url.URLEntry{ShortURL: "5agFZWrIb6Ej21QvYUNBL3", OriginalURL: "http://example.com"}
+	require.NoError(t, ms.AddURL(ctx, existing, "user"))
+
+	cs := &countingStorage{Storage: ms}
+	store, err := bloom.New(ctx, cs, ms, 1000, 0.001)
+	require.NoError(t, err)
+	assert.Equal(t, 1, store.Stats().Items)
+
+	// the id stored before the filter was built
+	u, err := store.GetURL(ctx, existing.ShortURL)
+	require.NoError(t, err)
+	assert.Equal(t, existing.OriginalURL, u)
+	assert.Equal(t, 1, cs.gets)
+
+	// unknown ids never reach the underlying storage (the false positive
+	// rate is low enough for 100 lookups)
+	for i := 0; i < 100; i++ {
+		u, err := store.GetURL(ctx, url.ShortID("http://example.org/"+strconv.Itoa(i)))
+		require.NoError(t, err)
+		assert.Empty(t, u)
+	}
+	assert.Equal(t, 1, cs.gets)
+
+	// ids added through the filter and by other instances
+	added := url.URLEntry{ShortURL: "6EH6vwAy9dOyyNbopTS6M4", OriginalURL: "http://example.org"}
+	require.NoError(t, store.AddURL(ctx, added, "user"))
+	batch := []url.BatchURLEntry{{CorrelationID: "1", ShortURL: "batched", OriginalURL: "http://example.net"}}
+	require.NoError(t, store.AddBatchURL(ctx, batch, "user"))
+	elsewhere := url.URLEntry{ShortURL: "elsewhere", OriginalURL: "http://example.info"}
+	require.NoError(t, ms.AddURL(ctx, elsewhere, "user"))
+	store.Add(elsewhere.ShortURL)
+
+	for id, want := range map[string]string{
+		added.ShortURL:     added.OriginalURL,
+		"batched":          "http://example.net",
+		elsewhere.ShortURL: elsewhere.OriginalURL,
+	} {
+		u, err := store.GetURL(ctx, id)
+		require.NoError(t, err)
+		assert.Equal(t, want, u)
+	}
+
+	// a missed id is found after the filter is rebuilt
+	missed := url.URLEntry{ShortURL: "missed", OriginalURL: "http://example.biz"}
+	require.NoError(t, ms.AddURL(ctx, missed, "user"))
+	store.Flush()
+	u, err = store.GetURL(ctx, missed.ShortURL)
+	require.NoError(t, err)
+	assert.Equal(t, missed.OriginalURL, u)
+	assert.Equal(t, 5, store.Stats().Items)
+}
@@ -0,0 +1,115 @@
+package bloom
+
+import (
+	"hash/fnv"
+	"math"
+	"sync"
+)
+
+// Filter is a concurrency-safe Bloom filter over strings: MayContain never
+// returns false for an added item, but may return true for an item never
+// added with a probability depending on the filter size and fill
+type Filter struct {
+	mu     sync.RWMutex
+	bits   []uint64
+	m      uint64 // number of bits
+	k      uint64 // number of hash functions
+	items  int
+	fpRate float64 // target false positive rate
+}
+
+// NewFilter creates a filter sized to hold capacity items with fpRate
+// false positive rate
+func NewFilter(capacity int, fpRate float64) *Filter {
+	if capacity < 1 {
+		capacity = 1
+	}
+	if fpRate <= 0 || fpRate >= 1 {
+		fpRate = 0.01
+	}
+
+	// the optimal m and k for the given n and p
+	m := uint64(math.Ceil(-float64(capacity) * math.Log(fpRate) / (math.Ln2 * math.Ln2)))
+	if m < 64 {
+		m = 64
+	}
+	k := uint64(math.Round(float64(m) / float64(capacity) * math.Ln2))
+	if k < 1 {
+		k = 1
+	}
+
+	return &Filter{
+		bits:   make([]uint64, (m+63)/64),
+		m:      m,
+		k:      k,
+		fpRate: fpRate,
+	}
+}
+
+// Add adds item to the filter
+func (f *Filter) Add(item string) {
+	h1, h2 := hashes(item)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for i := uint64(0); i < f.k; i++ {
+		bit := (h1 + i*h2) % f.m
+		f.bits[bit/64] |= 1 << (bit % 64)
+	}
+	f.items++
+}
+
+// MayContain returns false if item was definitely never added
+func (f *Filter) MayContain(item string) bool {
+	h1, h2 := hashes(item)
+
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	for i := uint64(0); i < f.k; i++ {
+		bit := (h1 + i*h2) % f.m
+		if f.bits[bit/64]&(1<<(bit%64)) == 0 {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Stats describe the filter state
+type Stats struct {
+	Items  int     `json:"items"`
+	Bits   uint64  `json:"bits"`
+	Hashes uint64  `json:"hashes"`
+	Target float64 `json:"target_fp_rate"`
+	// Estimated false positive rate for the current number of items
+	Estimated float64 `json:"estimated_fp_rate"`
+}
+
+func (f *Filter) Stats() Stats {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	return Stats{
+		Items:     f.items,
+		Bits:      f.m,
+		Hashes:    f.k,
+		Target:    f.fpRate,
+		Estimated: math.Pow(1-math.Exp(-float64(f.k)*float64(f.items)/float64(f.m)), float64(f.k)),
+	}
+}
+
+// hashes returns two independent hashes of item, the k hash functions are
+// derived from them as h1 + i*h2 (Kirsch-Mitzenmacher)
+func hashes(item string) (uint64, uint64) {
+	h := fnv.New64a()
+	h.Write([]byte(item))
+	h1 := h.Sum64()
+
+	h = fnv.New64()
+	h.Write([]byte(item))
+	h2 := h.Sum64() | 1 // odd, so it never degrades to a single bit
+
+	return h1, h2
+}
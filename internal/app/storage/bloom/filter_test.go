@@ -0,0 +1,44 @@
+package bloom_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/sbxb/shorty/internal/app/storage/bloom"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFilter_No_False_Negatives(t *testing.T) {
+	f := bloom.NewFilter(1000, 0.01)
+
+	for i := 0; i < 1000; i++ {
+		f.Add(fmt.Sprintf("id-%d", i))
+	}
+
+	for i := 0; i < 1000; i++ {
+		assert.True(t, f.MayContain(fmt.Sprintf("id-%d", i)))
+	}
+}
+
+func TestFilter_False_Positive_Rate(t *testing.T) {
+	f := bloom.NewFilter(1000, 0.01)
+
+	for i := 0; i < 1000; i++ {
+		f.Add(fmt.Sprintf("id-%d", i))
+	}
+
+	falsePositives := 0
+	for i := 0; i < 10000; i++ {
+		if f.MayContain(fmt.Sprintf("unknown-%d", i)) {
+			falsePositives++
+		}
+	}
+
+	// 1% expected, allow for some deviation
+	assert.Less(t, falsePositives, 300)
+
+	stats := f.Stats()
+	assert.Equal(t, stats.Items, 1000)
+	assert.InDelta(t, stats.Estimated, 0.01, 0.005)
+}
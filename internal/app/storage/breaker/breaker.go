@@ -0,0 +1,195 @@
+package breaker
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/sbxb/shorty/internal/app/logger"
+	"github.com/sbxb/shorty/internal/app/storage"
+	"github.com/sbxb/shorty/internal/app/url"
+)
+
+// State of the circuit breaker
+type State int
+
+const (
+	// Closed - calls are passed to the underlying storage
+	Closed State = iota
+	// Open - calls are rejected with storage.UnavailableError
+	Open
+	// HalfOpen - a single probe call is passed to check if the underlying
+	// storage is back
+	HalfOpen
+)
+
+func (s State) String() string {
+	return [...]string{"closed", "open", "half-open"}[s]
+}
+
+// Settings define when the breaker opens and for how long
+type Settings struct {
+	// FailureThreshold consecutive failures open the breaker
+	FailureThreshold int
+	// OpenTimeout is the time the breaker stays open before a probe call
+	OpenTimeout time.Duration
+}
+
+var DefaultSettings = Settings{
+	FailureThreshold: 5,
+	OpenTimeout:      10 * time.Second,
+}
+
+// BreakerStorage defines a storage wrapper which stops calling the
+// underlying storage for a while after several consecutive failures,
+// so that callers fail fast instead of waiting for timeouts
+type BreakerStorage struct {
+	storage.Storage
+
+	settings  Settings
+	isFailure func(error) bool
+
+	mu       sync.Mutex
+	state    State
+	failures int
+	openedAt time.Time
+	now      func() time.Time
+}
+
+// BreakerStorage implements Storage interface
+var _ storage.Storage = (*BreakerStorage)(nil)
+
+// New wraps store with a circuit breaker, isFailure tells which errors
+// mean the underlying data store is unavailable
+func New(store storage.Storage, settings Settings, isFailure func(error) bool) *BreakerStorage {
+	return &BreakerStorage{
+		Storage:   store,
+		settings:  settings,
+		isFailure: isFailure,
+		now:       time.Now,
+	}
+}
+
+func (st *BreakerStorage) AddURL(ctx context.Context, ue url.URLEntry, userID string) error {
+	if err := st.allow(); err != nil {
+		return err
+	}
+	err := st.Storage.AddURL(ctx, ue, userID)
+	st.done(err)
+
+	return err
+}
+
+func (st *BreakerStorage) AddBatchURL(ctx context.Context, batch []url.BatchURLEntry, userID string) error {
+	if err := st.allow(); err != nil {
+		return err
+	}
+	err := st.Storage.AddBatchURL(ctx, batch, userID)
+	st.done(err)
+
+	return err
+}
+
+func (st *BreakerStorage) GetURL(ctx context.Context, id string) (string, error) {
+	if err := st.allow(); err != nil {
+		return "", err
+	}
+	u, err := st.Storage.GetURL(ctx, id)
+	st.done(err)
+
+	return u, err
+}
+
+func (st *BreakerStorage) GetUserURLs(ctx context.Context, userID string) ([]url.URLEntry, error) {
+	if err := st.allow(); err != nil {
+		return nil, err
+	}
+	urls, err := st.Storage.GetUserURLs(ctx, userID)
+	st.done(err)
+
+	return urls, err
+}
+
+func (st *BreakerStorage) CountUserURLs(ctx context.Context, userID string) (int, error) {
+	if err := st.allow(); err != nil {
+		return 0, err
+	}
+	count, err := st.Storage.CountUserURLs(ctx, userID)
+	st.done(err)
+
+	return count, err
+}
+
+func (st *BreakerStorage) DeleteBatch(ctx context.Context, ids []string, userID string) error {
+	if err := st.allow(); err != nil {
+		return err
+	}
+	err := st.Storage.DeleteBatch(ctx, ids, userID)
+	st.done(err)
+
+	return err
+}
+
+// Ping pings the underlying storage bypassing the breaker
+func (st *BreakerStorage) Ping() error {
+	pinger, ok := st.Storage.(storage.Pinger)
+	if !ok {
+		return storage.ErrPingNotSupported
+	}
+
+	return pinger.Ping()
+}
+
+// State returns the current state of the breaker
+func (st *BreakerStorage) State() State {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	return st.state
+}
+
+// allow returns storage.UnavailableError if the call should be rejected
+func (st *BreakerStorage) allow() error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	switch st.state {
+	case Open:
+		elapsed := st.now().Sub(st.openedAt)
+		if elapsed < st.settings.OpenTimeout {
+			return storage.NewUnavailableError(st.settings.OpenTimeout - elapsed)
+		}
+		// let this call probe the underlying storage
+		st.state = HalfOpen
+		return nil
+	case HalfOpen:
+		// the probe is still running
+		return storage.NewUnavailableError(st.settings.OpenTimeout)
+	default:
+		return nil
+	}
+}
+
+// done records the result of an allowed call
+func (st *BreakerStorage) done(err error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	if !st.isFailure(err) {
+		if st.state != Closed {
+			logger.Info("BreakerStorage: storage is back, breaker closed")
+		}
+		st.state = Closed
+		st.failures = 0
+		return
+	}
+
+	st.failures++
+	if st.state == HalfOpen || st.failures >= st.settings.FailureThreshold {
+		if st.state != Open {
+			logger.Warningf("BreakerStorage: breaker open for %v: %v", st.settings.OpenTimeout, err)
+		}
+		st.state = Open
+		st.openedAt = st.now()
+	}
+}
@@ -0,0 +1,82 @@
+package breaker
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/sbxb/shorty/internal/app/storage"
+	"github.com/sbxb/shorty/internal/app/storage/inmemory"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var errDown = errors.New("connection refused")
+
+// flakyStorage fails every call while down is true
+type flakyStorage struct {
+	*inmemory.MapStorage
+	down bool
+}
+
+func (st *flakyStorage) GetURL(ctx context.Context, id string) (string, error) {
+	if st.down {
+		return "", errDown
+	}
+	return st.MapStorage.GetURL(ctx, id)
+}
+
+func TestBreakerStorage_Opens_And_Recovers(t *testing.T) {
+	ctx := context.Background()
+	ms, _ := inmemory.NewMapStorage() // NewMapStorage() never returns non-nil error
+	flaky := &flakyStorage{MapStorage: ms, down: true}
+
+	now := time.Now()
+	st := New(flaky, Settings{FailureThreshold: 2, OpenTimeout: 10 * time.Second},
+		func(err error) bool { return errors.Is(err, errDown) })
+	st.now = func() time.Time { return now }
+
+	// failures below the threshold are passed through as is
+	_, err := st.GetURL(ctx, "id")
+	require.ErrorIs(t, err, errDown)
+	assert.Equal(t, st.State(), Closed)
+
+	_, err = st.GetURL(ctx, "id")
+	require.ErrorIs(t, err, errDown)
+	assert.Equal(t, st.State(), Open)
+
+	// the open breaker fails fast
+	now = now.Add(4 * time.Second)
+	_, err = st.GetURL(ctx, "id")
+	var unavailableError *storage.UnavailableError
+	require.ErrorAs(t, err, &unavailableError)
+	assert.Equal(t, unavailableError.RetryAfter, 6*time.Second)
+
+	// the probe fails, the breaker opens again
+	now = now.Add(6 * time.Second)
+	_, err = st.GetURL(ctx, "id")
+	require.ErrorIs(t, err, errDown)
+	assert.Equal(t, st.State(), Open)
+
+	// the probe succeeds, the breaker closes
+	flaky.down = false
+	now = now.Add(10 * time.Second)
+	_, err = st.GetURL(ctx, "id")
+	require.NoError(t, err)
+	assert.Equal(t, st.State(), Closed)
+}
+
+func TestBreakerStorage_Ignores_Other_Errors(t *testing.T) {
+	ctx := context.Background()
+	ms, _ := inmemory.NewMapStorage() // NewMapStorage() never returns non-nil error
+
+	st := New(ms, Settings{FailureThreshold: 1, OpenTimeout: time.Second},
+		func(err error) bool { return errors.Is(err, errDown) })
+
+	_ = st.DeleteBatch(ctx, []string{"id"}, "user")
+	_, err := st.GetURL(ctx, "id")
+	require.NoError(t, err)
+	assert.Equal(t, st.State(), Closed)
+}
@@ -0,0 +1,78 @@
+package cache
+
+import (
+	"context"
+	"errors"
+
+	"github.com/sbxb/shorty/internal/app/storage"
+)
+
+// ACLCache defines an ACLStorage wrapper which keeps up to size most
+// recently requested ACLs in memory, so that redirects are not checked
+// against the database every time
+// Entries changed by other instances should be evicted with Evict / Flush
+type ACLCache struct {
+	storage.ACLStorage
+
+	acls *lru
+}
+
+// ACLCache implements ACLStorage interface
+var _ storage.ACLStorage = (*ACLCache)(nil)
+
+type aclEntry struct {
+	acl   storage.ACL
+	owner string
+}
+
+// NewACLCache wraps acls with a cache of the given size, size should be
+// positive
+func NewACLCache(acls storage.ACLStorage, size int) (*ACLCache, error) {
+	if size <= 0 {
+		return nil, errors.New("ACLCache: size should be positive")
+	}
+
+	return &ACLCache{
+		ACLStorage: acls,
+		acls:       newLRU(size),
+	}, nil
+}
+
+// GetACL returns cached ACL if any, otherwise asks the underlying storage
+// and caches ACLs of existing urls only
+func (st *ACLCache) GetACL(ctx context.Context, id string) (storage.ACL, string, error) {
+	if e, ok := st.acls.get(id); ok {
+		return e.(aclEntry).acl, e.(aclEntry).owner, nil
+	}
+
+	acl, owner, err := st.ACLStorage.GetACL(ctx, id)
+	if err != nil {
+		return acl, owner, err
+	}
+	st.acls.put(id, aclEntry{acl: acl, owner: owner})
+
+	return acl, owner, nil
+}
+
+// SetACL sets the ACL in the underlying storage and evicts it locally
+func (st *ACLCache) SetACL(ctx context.Context, id string, userID string, acl storage.ACL) error {
+	err := st.ACLStorage.SetACL(ctx, id, userID, acl)
+	st.Evict(id)
+
+	return err
+}
+
+// Evict removes ACLs of ids from the cache
+func (st *ACLCache) Evict(ids ...string) {
+	st.acls.evict(ids...)
+}
+
+// Flush removes everything from the cache
+func (st *ACLCache) Flush() {
+	st.acls.flush()
+}
+
+// Len returns the number of cached entries
+func (st *ACLCache) Len() int {
+	return st.acls.len()
+}
@@ -0,0 +1,61 @@
+package cache_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/sbxb/shorty/internal/app/storage"
+	"github.com/sbxb/shorty/internal/app/storage/cache"
+	"github.com/sbxb/shorty/internal/app/storage/inmemory"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// countingACLs counts GetACL calls
+type countingACLs struct {
+	*inmemory.MapStorage
+	calls int
+}
+
+func (st *countingACLs) GetACL(ctx context.Context, id string) (storage.ACL, string, error) {
+	st.calls++
+	return st.MapStorage.GetACL(ctx, id)
+}
+
+func TestACLCache(t *testing.T) {
+	ctx := context.Background()
+	ms, _ := inmemory.NewMapStorage() // NewMapStorage() never returns non-nil error
+	acls := &countingACLs{MapStorage: ms}
+	store, err := cache.NewACLCache(acls, 10)
+	require.NoError(t, err)
+
+	require.NoError(t, ms.AddURL(ctx, entries[0], "user"))
+	id := entries[0].ShortURL
+
+	// unknown urls are not cached
+	_, _, err = store.GetACL(ctx, "nonexistent_id")
+	require.ErrorIs(t, err, storage.ErrURLNotFound)
+	assert.Equal(t, store.Len(), 0)
+
+	for i := 0; i < 3; i++ {
+		acl, owner, err := store.GetACL(ctx, id)
+		require.NoError(t, err)
+		assert.True(t, acl.IsPublic())
+		assert.Equal(t, "user", owner)
+	}
+	assert.Equal(t, 2, acls.calls)
+
+	// a changed ACL is evicted
+	restricted := storage.ACL{Visibility: storage.VisibilityAuthenticated}
+	require.NoError(t, store.SetACL(ctx, id, "user", restricted))
+	acl, _, err := store.GetACL(ctx, id)
+	require.NoError(t, err)
+	assert.Equal(t, restricted, acl)
+
+	store.Flush()
+	assert.Equal(t, store.Len(), 0)
+
+	_, err = cache.NewACLCache(acls, 0)
+	require.Error(t, err)
+}
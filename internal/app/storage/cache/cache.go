@@ -0,0 +1,82 @@
+package cache
+
+import (
+	"context"
+	"errors"
+
+	"github.com/sbxb/shorty/internal/app/storage"
+)
+
+// CachedStorage defines a storage wrapper which keeps up to size most
+// recently requested redirects in memory, all the other calls are passed
+// to the underlying storage as is
+// Entries changed by other instances should be evicted with Evict / Flush
+type CachedStorage struct {
+	storage.Storage
+
+	urls *lru
+}
+
+// CachedStorage implements Storage interface
+var _ storage.Storage = (*CachedStorage)(nil)
+
+// New wraps store with a cache of the given size, size should be positive
+func New(store storage.Storage, size int) (*CachedStorage, error) {
+	if size <= 0 {
+		return nil, errors.New("CachedStorage: size should be positive")
+	}
+
+	return &CachedStorage{
+		Storage: store,
+		urls:    newLRU(size),
+	}, nil
+}
+
+// GetURL returns cached url if any, otherwise asks the underlying storage
+// and caches existing urls only (neither deleted nor nonexistent ones)
+func (st *CachedStorage) GetURL(ctx context.Context, id string) (string, error) {
+	if u, ok := st.urls.get(id); ok {
+		return u.(string), nil
+	}
+
+	u, err := st.Storage.GetURL(ctx, id)
+	if err != nil || u == "" {
+		return u, err
+	}
+	st.urls.put(id, u)
+
+	return u, nil
+}
+
+// DeleteBatch deletes ids in the underlying storage and evicts them locally
+func (st *CachedStorage) DeleteBatch(ctx context.Context, ids []string, userID string) error {
+	err := st.Storage.DeleteBatch(ctx, ids, userID)
+	st.Evict(ids...)
+
+	return err
+}
+
+// Ping pings the underlying storage if it supports pinging
+func (st *CachedStorage) Ping() error {
+	pinger, ok := st.Storage.(storage.Pinger)
+	if !ok {
+		return storage.ErrPingNotSupported
+	}
+
+	return pinger.Ping()
+}
+
+// Evict removes ids from the cache
+func (st *CachedStorage) Evict(ids ...string) {
+	st.urls.evict(ids...)
+}
+
+// Flush removes everything from the cache
+func (st *CachedStorage) Flush() {
+	st.urls.flush()
+}
+
+// Len returns the number of cached entries
+func (st *CachedStorage) Len() int {
+	return st.urls.len()
+}
@@ -0,0 +1,98 @@
+package cache_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/sbxb/shorty/internal/app/storage"
+	"github.com/sbxb/shorty/internal/app/storage/cache"
+	"github.com/sbxb/shorty/internal/app/storage/inmemory"
+	"github.com/sbxb/shorty/internal/app/url"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var entries = []url.URLEntry{
+	{
+		ShortURL:    "5agFZWrIb6Ej21QvYUNBL3",
+		OriginalURL: "http://example.com",
+	},
+	{
+		ShortURL:    "6EH6vwAy9dOyyNbopTS6M4",
+		OriginalURL: "http://example.org",
+	},
+}
+
+func TestCachedStorage_Get_Cached(t *testing.T) {
+	ctx := context.Background()
+	ms, _ := inmemory.NewMapStorage() // NewMapStorage() never returns non-nil error
+	store, err := cache.New(ms, 10)
+	require.NoError(t, err)
+
+	for _, ue := range entries {
+		require.NoError(t, store.AddURL(ctx, ue, "user"))
+	}
+
+	urlReturned, err := store.GetURL(ctx, "nonexistent_id")
+	require.NoError(t, err)
+	assert.Empty(t, urlReturned)
+	assert.Equal(t, store.Len(), 0)
+
+	for _, ue := range entries {
+		urlReturned, err := store.GetURL(ctx, ue.ShortURL)
+		require.NoError(t, err)
+		assert.Equal(t, urlReturned, ue.OriginalURL)
+	}
+	assert.Equal(t, store.Len(), 2)
+
+	// the underlying storage is changed behind the cache's back, the stale
+	// cached value is served until evicted
+	require.NoError(t, ms.DeleteBatch(ctx, []string{entries[0].ShortURL}, "user"))
+
+	urlReturned, err = store.GetURL(ctx, entries[0].ShortURL)
+	require.NoError(t, err)
+	assert.Equal(t, urlReturned, entries[0].OriginalURL)
+
+	store.Evict(entries[0].ShortURL)
+
+	_, err = store.GetURL(ctx, entries[0].ShortURL)
+	var deletedError *storage.URLDeletedError
+	require.ErrorAs(t, err, &deletedError)
+
+	store.Flush()
+	assert.Equal(t, store.Len(), 0)
+}
+
+func TestCachedStorage_DeleteBatch_Evicts(t *testing.T) {
+	ctx := context.Background()
+	ms, _ := inmemory.NewMapStorage() // NewMapStorage() never returns non-nil error
+	store, err := cache.New(ms, 10)
+	require.NoError(t, err)
+
+	require.NoError(t, store.AddURL(ctx, entries[0], "user"))
+	_, _ = store.GetURL(ctx, entries[0].ShortURL)
+
+	require.NoError(t, store.DeleteBatch(ctx, []string{entries[0].ShortURL}, "user"))
+
+	_, err = store.GetURL(ctx, entries[0].ShortURL)
+	var deletedError *storage.URLDeletedError
+	require.ErrorAs(t, err, &deletedError)
+}
+
+func TestCachedStorage_Size_Limited(t *testing.T) {
+	ctx := context.Background()
+	ms, _ := inmemory.NewMapStorage() // NewMapStorage() never returns non-nil error
+	store, err := cache.New(ms, 1)
+	require.NoError(t, err)
+
+	for _, ue := range entries {
+		require.NoError(t, store.AddURL(ctx, ue, "user"))
+		_, _ = store.GetURL(ctx, ue.ShortURL)
+	}
+
+	assert.Equal(t, store.Len(), 1)
+
+	_, err = cache.New(ms, 0)
+	require.Error(t, err)
+}
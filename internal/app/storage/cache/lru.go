@@ -0,0 +1,86 @@
+package cache
+
+import (
+	"container/list"
+	"sync"
+)
+
+// lru keeps up to size most recently used values by id, it's safe for
+// concurrent use
+type lru struct {
+	mu      sync.Mutex
+	size    int
+	order   *list.List // front is the most recently used entry
+	entries map[string]*list.Element
+}
+
+type entry struct {
+	id    string
+	value interface{}
+}
+
+func newLRU(size int) *lru {
+	return &lru{
+		size:    size,
+		order:   list.New(),
+		entries: make(map[string]*list.Element, size),
+	}
+}
+
+func (c *lru) get(id string) (interface{}, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.entries[id]
+	if !ok {
+		return nil, false
+	}
+	c.order.MoveToFront(el)
+
+	return el.Value.(*entry).value, true
+}
+
+func (c *lru) put(id string, value interface{}) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.entries[id]; ok {
+		el.Value.(*entry).value = value
+		c.order.MoveToFront(el)
+		return
+	}
+
+	c.entries[id] = c.order.PushFront(&entry{id: id, value: value})
+	if c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*entry).id)
+	}
+}
+
+func (c *lru) evict(ids ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, id := range ids {
+		if el, ok := c.entries[id]; ok {
+			c.order.Remove(el)
+			delete(c.entries, id)
+		}
+	}
+}
+
+func (c *lru) flush() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.order.Init()
+	c.entries = make(map[string]*list.Element, c.size)
+}
+
+func (c *lru) len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.order.Len()
+}
@@ -0,0 +1,74 @@
+package cache
+
+import (
+	"context"
+	"errors"
+
+	"github.com/sbxb/shorty/internal/app/storage"
+)
+
+// PasswordCache defines a LinkPasswordStorage wrapper which keeps up to size
+// most recently requested password hashes (empty for urls without
+// a password) in memory, so that redirects don't ask the database for them
+// Entries changed by other instances should be evicted with Evict / Flush
+type PasswordCache struct {
+	storage.LinkPasswordStorage
+
+	hashes *lru
+}
+
+// PasswordCache implements LinkPasswordStorage interface
+var _ storage.LinkPasswordStorage = (*PasswordCache)(nil)
+
+// NewPasswordCache wraps passwords with a cache of the given size, size
+// should be positive
+func NewPasswordCache(passwords storage.LinkPasswordStorage, size int) (*PasswordCache, error) {
+	if size <= 0 {
+		return nil, errors.New("PasswordCache: size should be positive")
+	}
+
+	return &PasswordCache{
+		LinkPasswordStorage: passwords,
+		hashes:              newLRU(size),
+	}, nil
+}
+
+// GetURLPassword returns cached hash if any, otherwise asks the underlying
+// storage and caches hashes of existing urls only
+func (st *PasswordCache) GetURLPassword(ctx context.Context, id string) (string, error) {
+	if hash, ok := st.hashes.get(id); ok {
+		return hash.(string), nil
+	}
+
+	hash, err := st.LinkPasswordStorage.GetURLPassword(ctx, id)
+	if err != nil {
+		return hash, err
+	}
+	st.hashes.put(id, hash)
+
+	return hash, nil
+}
+
+// SetURLPassword sets the hash in the underlying storage and evicts it
+// locally
+func (st *PasswordCache) SetURLPassword(ctx context.Context, id string, userID string, hash string) error {
+	err := st.LinkPasswordStorage.SetURLPassword(ctx, id, userID, hash)
+	st.Evict(id)
+
+	return err
+}
+
+// Evict removes hashes of ids from the cache
+func (st *PasswordCache) Evict(ids ...string) {
+	st.hashes.evict(ids...)
+}
+
+// Flush removes everything from the cache
+func (st *PasswordCache) Flush() {
+	st.hashes.flush()
+}
+
+// Len returns the number of cached entries
+func (st *PasswordCache) Len() int {
+	return st.hashes.len()
+}
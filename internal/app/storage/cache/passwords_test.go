@@ -0,0 +1,60 @@
+package cache_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/sbxb/shorty/internal/app/storage"
+	"github.com/sbxb/shorty/internal/app/storage/cache"
+	"github.com/sbxb/shorty/internal/app/storage/inmemory"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// countingPasswords counts GetURLPassword calls
+type countingPasswords struct {
+	*inmemory.MapStorage
+	calls int
+}
+
+func (st *countingPasswords) GetURLPassword(ctx context.Context, id string) (string, error) {
+	st.calls++
+	return st.MapStorage.GetURLPassword(ctx, id)
+}
+
+func TestPasswordCache(t *testing.T) {
+	ctx := context.Background()
+	ms, _ := inmemory.NewMapStorage() // NewMapStorage() never returns non-nil error
+	passwords := &countingPasswords{MapStorage: ms}
+	store, err := cache.NewPasswordCache(passwords, 10)
+	require.NoError(t, err)
+
+	require.NoError(t, ms.AddURL(ctx, entries[0], "user"))
+	id := entries[0].ShortURL
+
+	// unknown urls are not cached
+	_, err = store.GetURLPassword(ctx, "nonexistent_id")
+	require.ErrorIs(t, err, storage.ErrURLNotFound)
+	assert.Equal(t, store.Len(), 0)
+
+	// urls without a password are cached as well
+	for i := 0; i < 3; i++ {
+		hash, err := store.GetURLPassword(ctx, id)
+		require.NoError(t, err)
+		assert.Empty(t, hash)
+	}
+	assert.Equal(t, 2, passwords.calls)
+
+	// a changed hash is evicted
+	require.NoError(t, store.SetURLPassword(ctx, id, "user", "hash"))
+	hash, err := store.GetURLPassword(ctx, id)
+	require.NoError(t, err)
+	assert.Equal(t, "hash", hash)
+
+	store.Flush()
+	assert.Equal(t, store.Len(), 0)
+
+	_, err = cache.NewPasswordCache(passwords, 0)
+	require.Error(t, err)
+}
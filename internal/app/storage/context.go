@@ -0,0 +1,38 @@
+package storage
+
+import "context"
+
+type contextKey string
+
+var (
+	primaryReadKey    = contextKey("primary-read")
+	immediateWriteKey = contextKey("immediate-write")
+)
+
+// WithPrimaryRead returns a copy of ctx which makes storages with read
+// replicas serve reads from the primary, so that the caller is guaranteed
+// to see its own writes (e.g. right after a shorten)
+func WithPrimaryRead(ctx context.Context) context.Context {
+	return context.WithValue(ctx, primaryReadKey, true)
+}
+
+// IsPrimaryRead reports whether ctx was created with WithPrimaryRead
+func IsPrimaryRead(ctx context.Context) bool {
+	primary, _ := ctx.Value(primaryReadKey).(bool)
+
+	return primary
+}
+
+// WithImmediateWrite returns a copy of ctx which makes storages deferring
+// writes (e.g. while the database is down) fail instead, so that the caller
+// may follow the write with others depending on it (e.g. a password)
+func WithImmediateWrite(ctx context.Context) context.Context {
+	return context.WithValue(ctx, immediateWriteKey, true)
+}
+
+// IsImmediateWrite reports whether ctx was created with WithImmediateWrite
+func IsImmediateWrite(ctx context.Context) bool {
+	immediate, _ := ctx.Value(immediateWriteKey).(bool)
+
+	return immediate
+}
@@ -1,6 +1,9 @@
 package storage
 
-import "fmt"
+import (
+	"fmt"
+	"time"
+)
 
 // IDConflictError represents "Record already exists" error
 type IDConflictError struct {
@@ -27,3 +30,41 @@ func (ude *URLDeletedError) Error() string {
 func NewURLDeletedError(id string) error {
 	return &URLDeletedError{id}
 }
+
+// UnavailableError represents "Data store is temporarily unavailable" error,
+// RetryAfter is a hint when the call is worth retrying
+type UnavailableError struct {
+	RetryAfter time.Duration
+}
+
+func (ue *UnavailableError) Error() string {
+	return fmt.Sprintf("Storage is temporarily unavailable, retry after %v", ue.RetryAfter)
+}
+
+func NewUnavailableError(retryAfter time.Duration) error {
+	return &UnavailableError{retryAfter}
+}
+
+// InsufficientStorageError represents "Storage limits reached" error
+type InsufficientStorageError struct{}
+
+func (ise *InsufficientStorageError) Error() string {
+	return "Storage limits reached, record rejected"
+}
+
+func NewInsufficientStorageError() error {
+	return &InsufficientStorageError{}
+}
+
+// QuotaExceededError represents "User has too many active links" error
+type QuotaExceededError struct {
+	Limit int
+}
+
+func (qee *QuotaExceededError) Error() string {
+	return fmt.Sprintf("User quota of %d active links exceeded", qee.Limit)
+}
+
+func NewQuotaExceededError(limit int) error {
+	return &QuotaExceededError{limit}
+}
@@ -0,0 +1,27 @@
+package storage
+
+import (
+	"context"
+	"time"
+)
+
+// URLVersion is a destination a url has pointed to, versions are numbered
+// from 1, the current one has zero ReplacedAt
+type URLVersion struct {
+	Version    int
+	URL        string
+	ReplacedAt time.Time
+}
+
+// URLHistoryStorage is implemented by storages which are able to change
+// destinations of urls keeping the previous ones
+type URLHistoryStorage interface {
+	// UpdateURL points the url created by the user to newURL keeping
+	// the current destination in the history, it returns the version of
+	// newURL, ErrURLNotFound or URLDeletedError. Nothing changes if newURL
+	// is the current destination
+	UpdateURL(ctx context.Context, id string, userID string, newURL string, at time.Time) (int, error)
+	// GetURLHistory returns the versions of the url created by the user,
+	// the current one last, or ErrURLNotFound
+	GetURLHistory(ctx context.Context, id string, userID string) ([]URLVersion, error)
+}
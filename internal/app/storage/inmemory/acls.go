@@ -0,0 +1,61 @@
+package inmemory
+
+import (
+	"context"
+
+	"github.com/sbxb/shorty/internal/app/storage"
+)
+
+// urlACL is the record of a url's ACL
+type urlACL struct {
+	ID  string
+	ACL storage.ACL
+}
+
+// MapStorage implements ACLStorage interface
+var _ storage.ACLStorage = (*MapStorage)(nil)
+
+func (st *MapStorage) GetACL(ctx context.Context, id string) (storage.ACL, string, error) {
+	st.RLock()
+	defer st.RUnlock()
+
+	rec, ok := st.data[id]
+	if !ok {
+		return storage.ACL{}, "", storage.ErrURLNotFound
+	}
+	if rec.acl == nil {
+		return storage.ACL{Visibility: storage.VisibilityPublic}, rec.userID, nil
+	}
+
+	return copyACL(*rec.acl), rec.userID, nil
+}
+
+func (st *MapStorage) SetACL(ctx context.Context, id string, userID string, acl storage.ACL) error {
+	st.Lock()
+	defer st.Unlock()
+
+	rec, ok := st.data[id]
+	if !ok || rec.userID != userID {
+		return storage.ErrURLNotFound
+	}
+	st.setACL(id, rec, acl)
+
+	return nil
+}
+
+// setACL re-puts rec with acl since its size depends on the ACL, the caller
+// must hold the lock
+func (st *MapStorage) setACL(id string, rec *record, acl storage.ACL) {
+	updated := rec.clone()
+	updated.acl = nil
+	if !acl.IsPublic() {
+		acl = copyACL(acl)
+		updated.acl = &acl
+	}
+	st.put(id, updated)
+}
+
+func copyACL(acl storage.ACL) storage.ACL {
+	acl.UserIDs = append([]string(nil), acl.UserIDs...)
+	return acl
+}
@@ -0,0 +1,79 @@
+package inmemory_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/sbxb/shorty/internal/app/storage"
+	"github.com/sbxb/shorty/internal/app/storage/inmemory"
+	"github.com/sbxb/shorty/internal/app/url"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemoryStore_ACLs(t *testing.T) {
+	ctx := context.Background()
+	store, _ := inmemory.NewMapStorage()
+
+	_, _, err := store.GetACL(ctx, "a")
+	assert.Equal(t, storage.ErrURLNotFound, err)
+
+	require.NoError(t, store.AddURL(ctx, url.URLEntry{ShortURL: "a", OriginalURL: "http://a.com"}, "owner"))
+	acl, owner, err := store.GetACL(ctx, "a")
+	require.NoError(t, err)
+	assert.Equal(t, storage.ACL{Visibility: storage.VisibilityPublic}, acl)
+	assert.Equal(t, "owner", owner)
+
+	// only the owner sets the ACL
+	restricted := storage.ACL{Visibility: storage.VisibilityUsers, UserIDs: []string{"friend"}}
+	assert.Equal(t, storage.ErrURLNotFound, store.SetACL(ctx, "a", "other", restricted))
+	assert.Equal(t, storage.ErrURLNotFound, store.SetACL(ctx, "unknown", "owner", restricted))
+	require.NoError(t, store.SetACL(ctx, "a", "owner", restricted))
+	restricted.UserIDs[0] = "changed"
+
+	acl, _, err = store.GetACL(ctx, "a")
+	require.NoError(t, err)
+	assert.Equal(t, storage.ACL{Visibility: storage.VisibilityUsers, UserIDs: []string{"friend"}}, acl)
+	// the ACL doesn't affect the url
+	u, err := store.GetURL(ctx, "a")
+	require.NoError(t, err)
+	assert.Equal(t, "http://a.com", u)
+
+	require.NoError(t, store.SetACL(ctx, "a", "owner", storage.ACL{Visibility: storage.VisibilityPublic}))
+	acl, _, err = store.GetACL(ctx, "a")
+	require.NoError(t, err)
+	assert.True(t, acl.IsPublic())
+}
+
+func TestMemoryStore_ACLs_ForeignBatch(t *testing.T) {
+	ctx := context.Background()
+	store, _ := inmemory.NewMapStorage()
+
+	restricted := storage.ACL{Visibility: storage.VisibilityUsers, UserIDs: []string{"friend"}}
+	require.NoError(t, store.AddURL(ctx, url.URLEntry{ShortURL: "a", OriginalURL: "http://a.com"}, "owner"))
+	require.NoError(t, store.SetACL(ctx, "a", "owner", restricted))
+
+	// a batch of another user skips the existing id
+	require.NoError(t, store.AddBatchURL(ctx, []url.BatchURLEntry{
+		{CorrelationID: "1", ShortURL: "a", OriginalURL: "http://a.com"},
+		{CorrelationID: "2", ShortURL: "b", OriginalURL: "http://b.com"},
+	}, "mallory"))
+
+	acl, owner, err := store.GetACL(ctx, "a")
+	require.NoError(t, err)
+	assert.Equal(t, restricted, acl)
+	assert.Equal(t, "owner", owner)
+	_, owner, err = store.GetACL(ctx, "b")
+	require.NoError(t, err)
+	assert.Equal(t, "mallory", owner)
+
+	// deleted urls stay deleted
+	require.NoError(t, store.DeleteBatch(ctx, []string{"a"}, "owner"))
+	require.NoError(t, store.AddBatchURL(ctx, []url.BatchURLEntry{
+		{CorrelationID: "1", ShortURL: "a", OriginalURL: "http://a.com"},
+	}, "mallory"))
+	_, err = store.GetURL(ctx, "a")
+	var deleted *storage.URLDeletedError
+	assert.ErrorAs(t, err, &deleted)
+}
@@ -0,0 +1,64 @@
+package inmemory
+
+import (
+	"context"
+
+	"github.com/sbxb/shorty/internal/app/storage"
+)
+
+// MapStorage implements RoleStorage and AdminStorage interfaces
+var (
+	_ storage.RoleStorage  = (*MapStorage)(nil)
+	_ storage.AdminStorage = (*MapStorage)(nil)
+)
+
+func (st *MapStorage) GetRole(ctx context.Context, userID string) (string, error) {
+	st.RLock()
+	defer st.RUnlock()
+
+	return st.roles[userID], nil
+}
+
+func (st *MapStorage) SetRole(ctx context.Context, userID string, role string) error {
+	st.Lock()
+	defer st.Unlock()
+
+	st.roles[userID] = role
+
+	return nil
+}
+
+func (st *MapStorage) DisableURLs(ctx context.Context, ids []string) (int, error) {
+	st.Lock()
+	defer st.Unlock()
+
+	disabled := 0
+	for _, id := range ids {
+		rec, ok := st.data[id]
+		if !ok || rec.deleted {
+			continue
+		}
+		rec.deleted = true
+		st.deactivate(rec.userID)
+		disabled++
+	}
+
+	return disabled, nil
+}
+
+func (st *MapStorage) Stats(ctx context.Context) (storage.Stats, error) {
+	st.RLock()
+	defer st.RUnlock()
+
+	stats := storage.Stats{URLs: len(st.data)}
+	users := make(map[string]struct{})
+	for _, rec := range st.data {
+		if rec.deleted {
+			stats.DeletedURLs++
+		}
+		users[rec.userID] = struct{}{}
+	}
+	stats.Users = len(users)
+
+	return stats, nil
+}
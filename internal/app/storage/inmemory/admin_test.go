@@ -0,0 +1,44 @@
+package inmemory_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/sbxb/shorty/internal/app/storage"
+	"github.com/sbxb/shorty/internal/app/storage/inmemory"
+	"github.com/sbxb/shorty/internal/app/url"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemoryStore_Admin(t *testing.T) {
+	ctx := context.Background()
+	store, _ := inmemory.NewMapStorage()
+
+	role, err := store.GetRole(ctx, "user")
+	require.NoError(t, err)
+	assert.Empty(t, role)
+	require.NoError(t, store.SetRole(ctx, "user", "moderator"))
+	role, _ = store.GetRole(ctx, "user")
+	assert.Equal(t, "moderator", role)
+
+	require.NoError(t, store.AddURL(ctx, url.URLEntry{ShortURL: "a", OriginalURL: "http://a.com"}, "user"))
+	require.NoError(t, store.AddURL(ctx, url.URLEntry{ShortURL: "b", OriginalURL: "http://b.com"}, "other"))
+	require.NoError(t, store.AddURL(ctx, url.URLEntry{ShortURL: "c", OriginalURL: "http://c.com"}, "other"))
+
+	// unknown and already disabled ids are not counted
+	disabled, err := store.DisableURLs(ctx, []string{"b", "b", "unknown"})
+	require.NoError(t, err)
+	assert.Equal(t, 1, disabled)
+
+	_, err = store.GetURL(ctx, "b")
+	var deleted *storage.URLDeletedError
+	assert.ErrorAs(t, err, &deleted)
+	count, _ := store.CountUserURLs(ctx, "other")
+	assert.Equal(t, 1, count)
+
+	stats, err := store.Stats(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, storage.Stats{URLs: 3, DeletedURLs: 1, Users: 2}, stats)
+}
@@ -0,0 +1,101 @@
+package inmemory
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/sbxb/shorty/internal/app/storage"
+)
+
+// apiKeys keeps API keys by their ids along with the hash index, it's
+// guarded by the MapStorage lock
+type apiKeys struct {
+	byID   map[string]*storage.APIKey
+	byHash map[string]*storage.APIKey
+}
+
+func newAPIKeys() apiKeys {
+	return apiKeys{
+		byID:   make(map[string]*storage.APIKey),
+		byHash: make(map[string]*storage.APIKey),
+	}
+}
+
+func (ak apiKeys) put(key storage.APIKey) {
+	ak.byID[key.ID] = &key
+	ak.byHash[key.Hash] = &key
+}
+
+// MapStorage implements APIKeyStorage interface
+var _ storage.APIKeyStorage = (*MapStorage)(nil)
+
+func (st *MapStorage) AddAPIKey(ctx context.Context, key storage.APIKey) error {
+	st.Lock()
+	defer st.Unlock()
+
+	if _, ok := st.apiKeys.byID[key.ID]; ok {
+		return storage.NewIDConflictError(key.ID)
+	}
+	st.apiKeys.put(key)
+
+	return nil
+}
+
+func (st *MapStorage) GetAPIKeyByHash(ctx context.Context, hash string) (storage.APIKey, error) {
+	st.RLock()
+	defer st.RUnlock()
+
+	key, ok := st.apiKeys.byHash[hash]
+	if !ok {
+		return storage.APIKey{}, storage.ErrAPIKeyNotFound
+	}
+
+	return *key, nil
+}
+
+// GetUserAPIKeys returns the user's keys, the oldest first
+func (st *MapStorage) GetUserAPIKeys(ctx context.Context, userID string) ([]storage.APIKey, error) {
+	st.RLock()
+	defer st.RUnlock()
+
+	res := []storage.APIKey{}
+	for _, key := range st.apiKeys.byID {
+		if key.UserID == userID {
+			res = append(res, *key)
+		}
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].CreatedAt.Before(res[j].CreatedAt)
+	})
+
+	return res, nil
+}
+
+func (st *MapStorage) DeleteAPIKey(ctx context.Context, id string, userID string) error {
+	st.Lock()
+	defer st.Unlock()
+
+	key, ok := st.apiKeys.byID[id]
+	if !ok || key.UserID != userID {
+		return storage.ErrAPIKeyNotFound
+	}
+	delete(st.apiKeys.byID, id)
+	delete(st.apiKeys.byHash, key.Hash)
+
+	return nil
+}
+
+func (st *MapStorage) TouchAPIKey(ctx context.Context, id string, usedAt time.Time) error {
+	st.Lock()
+	defer st.Unlock()
+
+	key, ok := st.apiKeys.byID[id]
+	if !ok {
+		return storage.ErrAPIKeyNotFound
+	}
+	key.LastUsedAt = usedAt
+	key.Uses++
+
+	return nil
+}
@@ -0,0 +1,140 @@
+package inmemory_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/sbxb/shorty/internal/app/storage"
+	"github.com/sbxb/shorty/internal/app/storage/inmemory"
+	"github.com/sbxb/shorty/internal/app/url"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemoryStore_APIKeys(t *testing.T) {
+	ctx := context.Background()
+	store, _ := inmemory.NewMapStorage()
+	created := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	newer := storage.APIKey{ID: "k2", UserID: "user", Name: "CI", Prefix: "shk_2", Hash: "h2", CreatedAt: created.Add(time.Hour)}
+	older := storage.APIKey{ID: "k1", UserID: "user", Name: "laptop", Prefix: "shk_1", Hash: "h1", CreatedAt: created}
+	foreign := storage.APIKey{ID: "k3", UserID: "other", Prefix: "shk_3", Hash: "h3", CreatedAt: created}
+	for _, key := range []storage.APIKey{newer, older, foreign} {
+		require.NoError(t, store.AddAPIKey(ctx, key))
+	}
+	assert.Error(t, store.AddAPIKey(ctx, older))
+
+	keys, err := store.GetUserAPIKeys(ctx, "user")
+	require.NoError(t, err)
+	assert.Equal(t, []storage.APIKey{older, newer}, keys)
+
+	used := created.Add(2 * time.Hour)
+	require.NoError(t, store.TouchAPIKey(ctx, "k1", used))
+	require.NoError(t, store.TouchAPIKey(ctx, "k1", used))
+	key, err := store.GetAPIKeyByHash(ctx, "h1")
+	require.NoError(t, err)
+	assert.Equal(t, used, key.LastUsedAt)
+	assert.Equal(t, int64(2), key.Uses)
+
+	// a user can't revoke someone else's key
+	assert.Equal(t, storage.ErrAPIKeyNotFound, store.DeleteAPIKey(ctx, "k3", "user"))
+	require.NoError(t, store.DeleteAPIKey(ctx, "k1", "user"))
+	_, err = store.GetAPIKeyByHash(ctx, "h1")
+	assert.Equal(t, storage.ErrAPIKeyNotFound, err)
+	assert.Equal(t, storage.ErrAPIKeyNotFound, store.TouchAPIKey(ctx, "k1", used))
+}
+
+func TestFileMapStorage_Extra_Records_Persisted(t *testing.T) {
+	ctx := context.Background()
+	tmpFileName := t.TempDir() + "/" + "test.db"
+
+	store, err := inmemory.NewFileMapStorage(tmpFileName)
+	require.NoError(t, err)
+
+	key := storage.APIKey{
+		ID:        "k1",
+		UserID:    "user",
+		Name:      "name with\ttab and | pipe",
+		Prefix:    "shk_1",
+		Hash:      "h1",
+		CreatedAt: time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC),
+	}
+	require.NoError(t, store.AddAPIKey(ctx, key))
+	require.NoError(t, store.AddUser(ctx, storage.User{ID: "user", Login: "alice", PasswordHash: "hash"}))
+	require.NoError(t, store.AddRecoveryCode(ctx, storage.RecoveryCode{UserID: "user", Hash: "rh"}))
+	require.NoError(t, store.SetRole(ctx, "user", "moderator"))
+	require.NoError(t, store.SetRole(ctx, "user", "admin"))
+	require.NoError(t, store.AddWorkspace(ctx, storage.Workspace{ID: "ws", Name: "Team"}, "user"))
+	require.NoError(t, store.SetWorkspaceMember(ctx, storage.WorkspaceMember{WorkspaceID: "ws", UserID: "other", Role: "viewer"}))
+	require.NoError(t, store.AddURL(ctx, url.URLEntry{ShortURL: "shared", OriginalURL: "http://shared.com"}, "other"))
+	moved, err := store.AddURLsToWorkspace(ctx, "ws", []string{"shared"}, "other")
+	require.NoError(t, err)
+	require.Equal(t, 1, moved)
+	require.NoError(t, store.AddTransfer(ctx, storage.Transfer{ID: "t1", FromUserID: "user", ToUserID: "other", URLIDs: []string{"x"}, Status: "pending"}))
+	require.NoError(t, store.SetACL(ctx, "shared", "other", storage.ACL{Visibility: "users", UserIDs: []string{"user"}}))
+	require.NoError(t, store.SetURLPassword(ctx, "shared", "other", "ph"))
+	_, err = store.UpdateURL(ctx, "shared", "other", "http://shared.org", key.CreatedAt)
+	require.NoError(t, err)
+	require.NoError(t, store.SetURLMeta(ctx, "shared", "other", storage.URLMeta{Title: "Shared", Tags: []string{"team"}}))
+	require.NoError(t, store.TouchAPIKey(ctx, "k1", key.CreatedAt.Add(time.Minute)))
+	store.Close()
+
+	store, err = inmemory.NewFileMapStorage(tmpFileName)
+	require.NoError(t, err)
+	defer store.Close()
+
+	got, err := store.GetAPIKeyByHash(ctx, "h1")
+	require.NoError(t, err)
+	assert.Equal(t, key.Name, got.Name)
+	assert.Equal(t, key.UserID, got.UserID)
+	assert.True(t, key.CreatedAt.Equal(got.CreatedAt))
+	assert.Equal(t, int64(1), got.Uses)
+
+	user, err := store.GetUserByLogin(ctx, "alice")
+	require.NoError(t, err)
+	assert.Equal(t, "user", user.ID)
+	assert.Equal(t, "hash", user.PasswordHash)
+
+	uid, err := store.RedeemRecoveryCode(ctx, "rh")
+	require.NoError(t, err)
+	assert.Equal(t, "user", uid)
+
+	role, err := store.GetRole(ctx, "user")
+	require.NoError(t, err)
+	assert.Equal(t, "admin", role)
+
+	role, err = store.GetWorkspaceRole(ctx, "ws", "other")
+	require.NoError(t, err)
+	assert.Equal(t, "viewer", role)
+	urls, err := store.GetWorkspaceURLs(ctx, "ws")
+	require.NoError(t, err)
+	assert.Equal(t, []url.URLEntry{{ShortURL: "shared", OriginalURL: "http://shared.org"}}, urls)
+
+	acl, _, err := store.GetACL(ctx, "shared")
+	require.NoError(t, err)
+	assert.Equal(t, storage.ACL{Visibility: "users", UserIDs: []string{"user"}}, acl)
+	hash, err := store.GetURLPassword(ctx, "shared")
+	require.NoError(t, err)
+	assert.Equal(t, "ph", hash)
+	history, err := store.GetURLHistory(ctx, "shared", "other")
+	require.NoError(t, err)
+	require.Len(t, history, 2)
+	assert.Equal(t, "http://shared.com", history[0].URL)
+	assert.True(t, key.CreatedAt.Equal(history[0].ReplacedAt))
+	assert.Equal(t, "http://shared.org", history[1].URL)
+	meta, err := store.GetURLMeta(ctx, "shared", "other")
+	require.NoError(t, err)
+	assert.Equal(t, storage.URLMeta{Title: "Shared", Tags: []string{"team"}}, meta)
+
+	transfers, err := store.GetUserTransfers(ctx, "other")
+	require.NoError(t, err)
+	require.Len(t, transfers, 1)
+	assert.Equal(t, []string{"x"}, transfers[0].URLIDs)
+
+	// extra records are not urls
+	urls, err = store.GetUserURLs(ctx, "user")
+	require.NoError(t, err)
+	assert.Empty(t, urls)
+}
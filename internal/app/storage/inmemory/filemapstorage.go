@@ -2,40 +2,68 @@ package inmemory
 
 import (
 	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"strings"
 
 	"github.com/sbxb/shorty/internal/app/logger"
 	"github.com/sbxb/shorty/internal/app/storage"
-	"github.com/sbxb/shorty/internal/app/url"
 )
 
 // FileMapStorage defines a persistent in-memory storage that loads / saves data
 // from / to a file during storage construction / close
+// The file is optionally encrypted with AES-GCM (see WithKeyring), every save
+// rewrites the whole file with the current key, so that key rotation takes
+// effect on the next save
 type FileMapStorage struct {
 	*MapStorage
 
-	file *os.File
+	file    *os.File
+	keyring *Keyring
 }
 
 // FileMapStorage implements Storage interface
 var _ storage.Storage = (*FileMapStorage)(nil)
 
-func NewFileMapStorage(filename string) (*FileMapStorage, error) {
-	ms, _ := NewMapStorage()
+// Records other than urls are saved as tag followed by base64-encoded JSON,
+// tags start with "!" which is never a part of a short id
+const (
+	apiKeyTag       = "!apikey"
+	userTag         = "!user"
+	recoveryCodeTag = "!recovery"
+	roleTag         = "!role"
+	workspaceTag    = "!workspace"
+	memberTag       = "!member"
+	workspaceURLTag = "!wsurl"
+	transferTag     = "!transfer"
+	aclTag          = "!acl"
+	passwordTag     = "!password"
+	historyTag      = "!history"
+	metaTag         = "!meta"
+)
+
+func NewFileMapStorage(filename string, opts ...Option) (*FileMapStorage, error) {
+	ms, _ := NewMapStorage(opts...)
 	if filename == "" {
 		return &FileMapStorage{MapStorage: ms}, nil
 	}
+	keyring := newOptions(opts).keyring
 
 	f, err := os.OpenFile(filename, os.O_CREATE|os.O_RDWR, 0660)
 	if err != nil {
 		return nil, err
 	}
 	logger.Info("FileMapStorage opened", f.Name())
-	storage := &FileMapStorage{MapStorage: ms, file: f}
+	storage := &FileMapStorage{MapStorage: ms, file: f, keyring: keyring}
 	if err := storage.LoadRecordsFromFile(); err != nil {
-		return nil, err
+		f.Close()
+		return nil, fmt.Errorf("FileMapStorage failed to load data from %s: %w",
+			f.Name(), err)
 	}
 
 	return storage, nil
@@ -43,21 +71,179 @@ func NewFileMapStorage(filename string) (*FileMapStorage, error) {
 
 // tryLoadRecords tries to load the content of the opened file ignoring any errors
 func (st *FileMapStorage) LoadRecordsFromFile() error {
-	scanner := bufio.NewScanner(st.file)
+	data, err := io.ReadAll(st.file)
+	if err != nil {
+		return err
+	}
+
+	if isEncrypted(data) {
+		if st.keyring == nil {
+			return errors.New("file is encrypted, but no key is configured")
+		}
+		if data, err = st.keyring.decrypt(data); err != nil {
+			return err
+		}
+	} else if len(data) > 0 && st.keyring != nil {
+		logger.Warning("FileMapStorage: plain text file found, it will be encrypted on save")
+	}
+
+	// memberships, shared urls, ACLs, passwords, histories and meta refer
+	// to records which might follow them
+	var (
+		members   []storage.WorkspaceMember
+		shared    []workspaceURL
+		acls      []urlACL
+		passwords []urlPassword
+		histories []urlHistory
+		metas     []urlMeta
+	)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(nil, 1<<24) // ACLs, histories and meta may be long
 	for scanner.Scan() {
 		input := strings.Fields(scanner.Text())
 		if len(input) != 2 {
 			continue
 		}
+		switch input[0] {
+		case apiKeyTag:
+			var key storage.APIKey
+			if err := decodeExtra(input[1], &key); err != nil {
+				return fmt.Errorf("bad API key record: %w", err)
+			}
+			st.apiKeys.put(key)
+			continue
+		case userTag:
+			var user storage.User
+			if err := decodeExtra(input[1], &user); err != nil {
+				return fmt.Errorf("bad user record: %w", err)
+			}
+			st.users.put(user)
+			continue
+		case recoveryCodeTag:
+			var code storage.RecoveryCode
+			if err := decodeExtra(input[1], &code); err != nil {
+				return fmt.Errorf("bad recovery code record: %w", err)
+			}
+			st.recoveryCodes.put(code)
+			continue
+		case roleTag:
+			var role userRole
+			if err := decodeExtra(input[1], &role); err != nil {
+				return fmt.Errorf("bad role record: %w", err)
+			}
+			st.roles[role.UserID] = role.Role
+			continue
+		case workspaceTag:
+			var ws storage.Workspace
+			if err := decodeExtra(input[1], &ws); err != nil {
+				return fmt.Errorf("bad workspace record: %w", err)
+			}
+			st.workspaces.put(ws)
+			continue
+		case memberTag:
+			var member storage.WorkspaceMember
+			if err := decodeExtra(input[1], &member); err != nil {
+				return fmt.Errorf("bad workspace member record: %w", err)
+			}
+			members = append(members, member)
+			continue
+		case workspaceURLTag:
+			var wu workspaceURL
+			if err := decodeExtra(input[1], &wu); err != nil {
+				return fmt.Errorf("bad workspace url record: %w", err)
+			}
+			shared = append(shared, wu)
+			continue
+		case transferTag:
+			var transfer storage.Transfer
+			if err := decodeExtra(input[1], &transfer); err != nil {
+				return fmt.Errorf("bad transfer record: %w", err)
+			}
+			st.transfers[transfer.ID] = &transfer
+			continue
+		case aclTag:
+			var ua urlACL
+			if err := decodeExtra(input[1], &ua); err != nil {
+				return fmt.Errorf("bad ACL record: %w", err)
+			}
+			acls = append(acls, ua)
+			continue
+		case passwordTag:
+			var up urlPassword
+			if err := decodeExtra(input[1], &up); err != nil {
+				return fmt.Errorf("bad password record: %w", err)
+			}
+			passwords = append(passwords, up)
+			continue
+		case historyTag:
+			var uh urlHistory
+			if err := decodeExtra(input[1], &uh); err != nil {
+				return fmt.Errorf("bad history record: %w", err)
+			}
+			histories = append(histories, uh)
+			continue
+		case metaTag:
+			var um urlMeta
+			if err := decodeExtra(input[1], &um); err != nil {
+				return fmt.Errorf("bad meta record: %w", err)
+			}
+			metas = append(metas, um)
+			continue
+		}
 		parts := strings.SplitN(input[1], "|", 3)
-		ue := url.URLEntry{
-			ShortURL:    input[0],
-			OriginalURL: parts[2],
+		if len(parts) != 3 {
+			continue
+		}
+		rec := &record{
+			userID:  parts[0],
+			deleted: parts[1] == "true",
+			url:     parts[2],
+		}
+
+		st.put(input[0], rec)
+		logger.Debugf("Loaded from file ==> [%s] :: [%s]", input[0], input[1])
+	}
+
+	for _, member := range members {
+		if m, ok := st.workspaces.members[member.WorkspaceID]; ok {
+			m[member.UserID] = member.Role
+		}
+	}
+	for _, wu := range shared {
+		if rec, ok := st.data[wu.ID]; ok {
+			updated := rec.clone()
+			updated.workspaceID = wu.WorkspaceID
+			st.put(wu.ID, updated)
+		}
+	}
+	for _, ua := range acls {
+		if rec, ok := st.data[ua.ID]; ok {
+			st.setACL(ua.ID, rec, ua.ACL)
+		}
+	}
+	for _, up := range passwords {
+		if rec, ok := st.data[up.ID]; ok {
+			updated := rec.clone()
+			updated.password = up.Hash
+			st.put(up.ID, updated)
 		}
-		userID := parts[0]
+	}
+	for _, uh := range histories {
+		if rec, ok := st.data[uh.ID]; ok {
+			updated := rec.clone()
+			updated.history = uh.Versions
+			st.put(uh.ID, updated)
+		}
+	}
+	for _, um := range metas {
+		if rec, ok := st.data[um.ID]; ok {
+			st.setMeta(um.ID, rec, um.Meta)
+		}
+	}
 
-		st.data[ue.ShortURL] = userID + "|" + parts[1] + "|" + ue.OriginalURL
-		logger.Debugf("Loaded from file ==> [%s] :: [%s]", ue.ShortURL, st.data[ue.ShortURL])
+	// Records loaded are kept regardless of limits unless they can be evicted
+	if st.limits.Policy == LRUPolicy {
+		st.makeRoom(0, 0)
 	}
 
 	if err := scanner.Err(); err != nil {
@@ -89,6 +275,85 @@ func (st *FileMapStorage) Close() error {
 }
 
 func (st *FileMapStorage) SaveRecordsToFile() error {
+	st.RLock()
+	defer st.RUnlock()
+
+	var buf bytes.Buffer
+	for id, rec := range st.data {
+		buf.WriteString(fmt.Sprintf("%s\t%s|%t|%s\n", id, rec.userID, rec.deleted, rec.url))
+		if rec.workspaceID != "" {
+			if err := encodeExtra(&buf, workspaceURLTag, workspaceURL{id, rec.workspaceID}); err != nil {
+				return err
+			}
+		}
+		if rec.acl != nil {
+			if err := encodeExtra(&buf, aclTag, urlACL{id, *rec.acl}); err != nil {
+				return err
+			}
+		}
+		if rec.password != "" {
+			if err := encodeExtra(&buf, passwordTag, urlPassword{id, rec.password}); err != nil {
+				return err
+			}
+		}
+		if len(rec.history) > 0 {
+			if err := encodeExtra(&buf, historyTag, urlHistory{id, rec.history}); err != nil {
+				return err
+			}
+		}
+		if rec.meta != nil {
+			if err := encodeExtra(&buf, metaTag, urlMeta{id, *rec.meta}); err != nil {
+				return err
+			}
+		}
+	}
+	for _, key := range st.apiKeys.byID {
+		if err := encodeExtra(&buf, apiKeyTag, key); err != nil {
+			return err
+		}
+	}
+	for _, user := range st.users.byID {
+		if err := encodeExtra(&buf, userTag, user); err != nil {
+			return err
+		}
+	}
+	for _, code := range st.recoveryCodes.byHash {
+		if err := encodeExtra(&buf, recoveryCodeTag, code); err != nil {
+			return err
+		}
+	}
+	for userID, role := range st.roles {
+		if err := encodeExtra(&buf, roleTag, userRole{userID, role}); err != nil {
+			return err
+		}
+	}
+
+	for _, ws := range st.workspaces.byID {
+		if err := encodeExtra(&buf, workspaceTag, ws); err != nil {
+			return err
+		}
+		for userID, role := range st.workspaces.members[ws.ID] {
+			if err := encodeExtra(&buf, memberTag, storage.WorkspaceMember{WorkspaceID: ws.ID, UserID: userID, Role: role}); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, transfer := range st.transfers {
+		if err := encodeExtra(&buf, transferTag, transfer); err != nil {
+			return err
+		}
+	}
+
+	// Encrypt before touching the file, so it's left intact on failure
+	data := buf.Bytes()
+	if st.keyring != nil {
+		var err error
+		if data, err = st.keyring.encrypt(data); err != nil {
+			return err
+		}
+	}
+
 	// Will catch every possible error to make sure the data properly written
 	// and the file is in a consistent state
 	if err := st.file.Truncate(0); err != nil {
@@ -99,18 +364,36 @@ func (st *FileMapStorage) SaveRecordsToFile() error {
 		return err
 	}
 
-	var wErr error = nil
-	w := bufio.NewWriter(st.file)
-	for id, uu := range st.data {
-		_, wErr = w.WriteString(fmt.Sprintf("%s\t%s\n", id, uu))
-		if wErr != nil {
-			break
-		}
+	if _, err := st.file.Write(data); err != nil {
+		return err
+	}
+
+	return st.file.Sync()
+}
+
+// userRole is the record of a user's role
+type userRole struct {
+	UserID string
+	Role   string
+}
+
+// encodeExtra writes v as a tagged record line
+func encodeExtra(buf *bytes.Buffer, tag string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
 	}
+	buf.WriteString(tag + "\t" + base64.StdEncoding.EncodeToString(data) + "\n")
 
-	if err := w.Flush(); err != nil {
+	return nil
+}
+
+// decodeExtra reads a tagged record written by encodeExtra
+func decodeExtra(encoded string, v interface{}) error {
+	data, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
 		return err
 	}
 
-	return wErr
+	return json.Unmarshal(data, v)
 }
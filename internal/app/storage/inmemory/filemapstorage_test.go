@@ -1,9 +1,15 @@
 package inmemory_test
 
 import (
+	"bytes"
 	"context"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"strings"
 	"testing"
 
+	"github.com/sbxb/shorty/internal/app/storage"
 	"github.com/sbxb/shorty/internal/app/storage/inmemory"
 	"github.com/sbxb/shorty/internal/app/url"
 
@@ -48,3 +54,82 @@ func TestFileMapStorage_Write_And_Read_File(t *testing.T) {
 
 	store.Close()
 }
+
+func TestFileMapStorage_Encrypted(t *testing.T) {
+	tmpFileName := t.TempDir() + "/" + "test.db"
+	ue := url.URLEntry{
+		ShortURL:    "5agFZWrIb6Ej21QvYUNBL3",
+		OriginalURL: "http://example.com/?token=secret",
+	}
+
+	oldKeyring, err := inmemory.ParseKeyring(strings.NewReader(
+		"k1:" + base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{1}, 32)),
+	))
+	require.NoError(t, err)
+
+	store, err := inmemory.NewFileMapStorage(tmpFileName, inmemory.WithKeyring(oldKeyring))
+	require.NoError(t, err)
+	require.NoError(t, store.AddURL(context.Background(), ue, "user"))
+	require.NoError(t, store.Close())
+
+	data, err := os.ReadFile(tmpFileName)
+	require.NoError(t, err)
+	assert.NotContains(t, string(data), "secret")
+	assert.NotContains(t, string(data), "user")
+
+	// no key at all
+	_, err = inmemory.NewFileMapStorage(tmpFileName)
+	require.Error(t, err)
+
+	// wrong key with the same id
+	wrongKeyring, _ := inmemory.ParseKeyring(strings.NewReader(
+		"k1:" + base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{2}, 32)),
+	))
+	_, err = inmemory.NewFileMapStorage(tmpFileName, inmemory.WithKeyring(wrongKeyring))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "wrong key")
+
+	// rotation: the new key is current, the old one is still known
+	rotatedKeyring, _ := inmemory.ParseKeyring(strings.NewReader(
+		"k2:" + base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{3}, 32)) + "\n" +
+			"k1:" + base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{1}, 32)),
+	))
+	store, err = inmemory.NewFileMapStorage(tmpFileName, inmemory.WithKeyring(rotatedKeyring))
+	require.NoError(t, err)
+	urlReturned, _ := store.GetURL(context.Background(), ue.ShortURL)
+	assert.Equal(t, urlReturned, ue.OriginalURL)
+	require.NoError(t, store.Close())
+
+	// re-encrypted with the new key, the old one is no longer needed
+	newKeyring, _ := inmemory.ParseKeyring(strings.NewReader(
+		"k2:" + base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{3}, 32)),
+	))
+	store, err = inmemory.NewFileMapStorage(tmpFileName, inmemory.WithKeyring(newKeyring))
+	require.NoError(t, err)
+	urlReturned, _ = store.GetURL(context.Background(), ue.ShortURL)
+	assert.Equal(t, urlReturned, ue.OriginalURL)
+	store.Close()
+}
+
+func TestFileMapStorage_Long_Records(t *testing.T) {
+	ctx := context.Background()
+	tmpFileName := t.TempDir() + "/" + "test.db"
+
+	store, err := inmemory.NewFileMapStorage(tmpFileName)
+	require.NoError(t, err)
+	require.NoError(t, store.AddURL(ctx, url.URLEntry{ShortURL: "a", OriginalURL: "http://a.com"}, "owner"))
+	// the record is well over the default line limit of bufio.Scanner
+	acl := storage.ACL{Visibility: storage.VisibilityUsers}
+	for i := 0; i < 5000; i++ {
+		acl.UserIDs = append(acl.UserIDs, fmt.Sprintf("user%016d", i))
+	}
+	require.NoError(t, store.SetACL(ctx, "a", "owner", acl))
+	store.Close()
+
+	store, err = inmemory.NewFileMapStorage(tmpFileName)
+	require.NoError(t, err)
+	defer store.Close()
+	got, _, err := store.GetACL(ctx, "a")
+	require.NoError(t, err)
+	assert.Len(t, got.UserIDs, 5000)
+}
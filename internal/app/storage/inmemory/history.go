@@ -0,0 +1,69 @@
+package inmemory
+
+import (
+	"context"
+	"time"
+
+	"github.com/sbxb/shorty/internal/app/storage"
+)
+
+// urlHistory is the record of a url's previous versions
+type urlHistory struct {
+	ID       string
+	Versions []storage.URLVersion
+}
+
+// MapStorage implements URLHistoryStorage interface
+var _ storage.URLHistoryStorage = (*MapStorage)(nil)
+
+func (st *MapStorage) UpdateURL(ctx context.Context, id string, userID string, newURL string, at time.Time) (int, error) {
+	st.Lock()
+	defer st.Unlock()
+
+	rec, ok := st.data[id]
+	if !ok || rec.userID != userID {
+		return 0, storage.ErrURLNotFound
+	}
+	if rec.deleted {
+		return 0, storage.NewURLDeletedError(id)
+	}
+	if rec.url == newURL {
+		return len(rec.history) + 1, nil
+	}
+
+	// records are re-put since their size depends on the url and history
+	updated := rec.clone()
+	updated.url = newURL
+	updated.history = make([]storage.URLVersion, len(rec.history), len(rec.history)+1)
+	copy(updated.history, rec.history)
+	updated.history = append(updated.history, storage.URLVersion{
+		Version:    len(rec.history) + 1,
+		URL:        rec.url,
+		ReplacedAt: at,
+	})
+
+	// the record itself is the last one to be evicted
+	st.lru.MoveToFront(rec.elem)
+	if !st.makeRoom(0, updated.size(id)-rec.size(id)) || st.data[id] != rec {
+		return 0, storage.NewInsufficientStorageError()
+	}
+	st.put(id, updated)
+
+	return len(updated.history) + 1, nil
+}
+
+func (st *MapStorage) GetURLHistory(ctx context.Context, id string, userID string) ([]storage.URLVersion, error) {
+	st.RLock()
+	defer st.RUnlock()
+
+	rec, ok := st.data[id]
+	if !ok || rec.userID != userID {
+		return nil, storage.ErrURLNotFound
+	}
+
+	res := make([]storage.URLVersion, 0, len(rec.history)+1)
+	res = append(res, rec.history...)
+	res = append(res, storage.URLVersion{Version: len(rec.history) + 1, URL: rec.url})
+
+	return res, nil
+}
@@ -0,0 +1,51 @@
+package inmemory_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/sbxb/shorty/internal/app/storage"
+	"github.com/sbxb/shorty/internal/app/storage/inmemory"
+	"github.com/sbxb/shorty/internal/app/url"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemoryStore_History(t *testing.T) {
+	ctx := context.Background()
+	store, _ := inmemory.NewMapStorage()
+	at := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	require.NoError(t, store.AddURL(ctx, url.URLEntry{ShortURL: "a", OriginalURL: "http://typo.com"}, "owner"))
+
+	_, err := store.UpdateURL(ctx, "a", "other", "http://a.com", at)
+	assert.Equal(t, storage.ErrURLNotFound, err)
+	_, err = store.GetURLHistory(ctx, "a", "other")
+	assert.Equal(t, storage.ErrURLNotFound, err)
+
+	version, err := store.UpdateURL(ctx, "a", "owner", "http://a.com", at)
+	require.NoError(t, err)
+	assert.Equal(t, 2, version)
+	// the current destination makes no new version
+	version, err = store.UpdateURL(ctx, "a", "owner", "http://a.com", at)
+	require.NoError(t, err)
+	assert.Equal(t, 2, version)
+
+	u, err := store.GetURL(ctx, "a")
+	require.NoError(t, err)
+	assert.Equal(t, "http://a.com", u)
+
+	history, err := store.GetURLHistory(ctx, "a", "owner")
+	require.NoError(t, err)
+	assert.Equal(t, []storage.URLVersion{
+		{Version: 1, URL: "http://typo.com", ReplacedAt: at},
+		{Version: 2, URL: "http://a.com"},
+	}, history)
+
+	require.NoError(t, store.DeleteBatch(ctx, []string{"a"}, "owner"))
+	_, err = store.UpdateURL(ctx, "a", "owner", "http://b.com", at)
+	var deleted *storage.URLDeletedError
+	assert.ErrorAs(t, err, &deleted)
+}
@@ -0,0 +1,166 @@
+package inmemory
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// encrypted files start with encHeaderPrefix followed by the key id and
+// a newline, the rest is the nonce followed by AES-GCM ciphertext
+const encHeaderPrefix = "shorty-enc:v1:"
+
+// defaultKeyID is used for keys given without an id
+const defaultKeyID = "default"
+
+// Keyring holds AES keys by their ids, the first key is the current one
+// used for encryption, the others are only used to decrypt files written
+// before the key rotation
+type Keyring struct {
+	ids  []string
+	keys map[string][]byte
+}
+
+// ParseKeyring parses keys, one per line, as [id:]base64-encoded key,
+// keys must be 16, 24 or 32 bytes long (AES-128, AES-192, AES-256)
+// Empty lines and lines starting with # are ignored
+func ParseKeyring(r io.Reader) (*Keyring, error) {
+	kr := &Keyring{keys: make(map[string][]byte)}
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		id, encoded := defaultKeyID, line
+		if i := strings.LastIndex(line, ":"); i >= 0 {
+			id, encoded = line[:i], line[i+1:]
+		}
+		if id == "" || strings.ContainsAny(id, " \t\n") {
+			return nil, fmt.Errorf("Keyring: invalid key id %q", id)
+		}
+		if _, ok := kr.keys[id]; ok {
+			return nil, fmt.Errorf("Keyring: duplicate key id %q", id)
+		}
+
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("Keyring: key %q: %v", id, err)
+		}
+		if _, err := aes.NewCipher(key); err != nil {
+			return nil, fmt.Errorf("Keyring: key %q: %v", id, err)
+		}
+
+		kr.ids = append(kr.ids, id)
+		kr.keys[id] = key
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("Keyring: %v", err)
+	}
+
+	if len(kr.ids) == 0 {
+		return nil, errors.New("Keyring: no keys found")
+	}
+
+	return kr, nil
+}
+
+// LoadKeyring loads keys from keyFile (if not empty) preceded by envKey (if
+// not empty), so that a key given as env variable becomes the current one
+// Returns nil keyring if both are empty
+func LoadKeyring(keyFile string, envKey string) (*Keyring, error) {
+	var buf bytes.Buffer
+	buf.WriteString(envKey + "\n")
+
+	if keyFile != "" {
+		data, err := os.ReadFile(keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("Keyring: %v", err)
+		}
+		buf.Write(data)
+	}
+
+	if strings.TrimSpace(buf.String()) == "" {
+		return nil, nil
+	}
+
+	return ParseKeyring(&buf)
+}
+
+// CurrentID returns the id of the key used for encryption
+func (kr *Keyring) CurrentID() string {
+	return kr.ids[0]
+}
+
+// encrypt encrypts plaintext with the current key
+func (kr *Keyring) encrypt(plaintext []byte) ([]byte, error) {
+	id := kr.CurrentID()
+	header := []byte(encHeaderPrefix + id + "\n")
+
+	gcm, err := newGCM(kr.keys[id])
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	out := append(header, nonce...)
+	// the header is authenticated as well, so the key id can't be tampered with
+	return gcm.Seal(out, nonce, plaintext, header), nil
+}
+
+// decrypt decrypts data produced by encrypt with any key of the keyring
+func (kr *Keyring) decrypt(data []byte) ([]byte, error) {
+	nl := bytes.IndexByte(data, '\n')
+	if !isEncrypted(data) || nl < 0 {
+		return nil, errors.New("not an encrypted file")
+	}
+	header, body := data[:nl+1], data[nl+1:]
+	id := string(header[len(encHeaderPrefix):nl])
+
+	key, ok := kr.keys[id]
+	if !ok {
+		return nil, fmt.Errorf("encrypted with key %q which is not configured", id)
+	}
+
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(body) < gcm.NonceSize() {
+		return nil, errors.New("encrypted file is truncated")
+	}
+
+	plaintext, err := gcm.Open(nil, body[:gcm.NonceSize()], body[gcm.NonceSize():], header)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt with key %q: wrong key or corrupted file", id)
+	}
+
+	return plaintext, nil
+}
+
+func isEncrypted(data []byte) bool {
+	return bytes.HasPrefix(data, []byte(encHeaderPrefix))
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
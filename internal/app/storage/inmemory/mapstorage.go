@@ -1,8 +1,9 @@
 package inmemory
 
 import (
+	"container/list"
 	"context"
-	"strings"
+	"expvar"
 	"sync"
 
 	"github.com/sbxb/shorty/internal/app/logger"
@@ -10,20 +11,187 @@ import (
 	"github.com/sbxb/shorty/internal/app/url"
 )
 
+// EvictionPolicy defines what happens when MapStorage reaches its limits
+type EvictionPolicy string
+
+const (
+	// RejectPolicy rejects new records with storage.InsufficientStorageError,
+	// to be used when MapStorage is the only copy of the data
+	RejectPolicy EvictionPolicy = "reject"
+	// LRUPolicy evicts least recently accessed records, to be used when
+	// MapStorage is a cache
+	LRUPolicy EvictionPolicy = "lru"
+)
+
+// Limits bound the memory used by MapStorage, zero values mean no limit
+type Limits struct {
+	MaxRecords int
+	// MaxBytes is compared against an estimation of the memory used
+	MaxBytes int64
+	Policy   EvictionPolicy
+}
+
+// every record costs a map entry, a list element and a record struct in
+// addition to its strings
+const recordOverhead = 128
+
+// every previous version costs a URLVersion struct in addition to its url
+const versionOverhead = 48
+
+// metrics are published at /debug/vars as "inmemory"
+var (
+	metrics      = expvar.NewMap("inmemory")
+	recordsGauge = new(expvar.Int)
+	bytesGauge   = new(expvar.Int)
+)
+
+func init() {
+	metrics.Set("records", recordsGauge)
+	metrics.Set("bytes", bytesGauge)
+}
+
+// Usage reports the current MapStorage usage along with its limits
+type Usage struct {
+	Records    int   `json:"records"`
+	Bytes      int64 `json:"bytes"`
+	MaxRecords int   `json:"max_records"`
+	MaxBytes   int64 `json:"max_bytes"`
+}
+
+type record struct {
+	userID      string
+	workspaceID string // empty unless the url is shared within a workspace
+	deleted     bool
+	url         string
+	acl         *storage.ACL         // nil for public urls
+	password    string               // password hash, empty unless the url has one
+	history     []storage.URLVersion // previous destinations, never modified in place
+	meta        *storage.URLMeta     // nil unless the owner described the url
+
+	elem *list.Element // position in MapStorage.lru
+}
+
+func (r *record) size(id string) int64 {
+	size := int64(len(id)+len(r.userID)+len(r.workspaceID)+len(r.url)+len(r.password)) + recordOverhead
+	for _, v := range r.history {
+		size += int64(len(v.URL)) + versionOverhead
+	}
+	if r.meta != nil {
+		size += int64(len(r.meta.Title) + len(r.meta.Notes))
+		for _, tag := range r.meta.Tags {
+			size += int64(len(tag))
+		}
+	}
+	if r.acl != nil {
+		size += int64(len(r.acl.Visibility) + len(r.acl.WorkspaceID))
+		for _, userID := range r.acl.UserIDs {
+			size += int64(len(userID))
+		}
+	}
+
+	return size
+}
+
+// clone returns a copy of r to be re-put when the fields the size depends
+// on change
+func (r *record) clone() *record {
+	return &record{
+		userID:      r.userID,
+		workspaceID: r.workspaceID,
+		deleted:     r.deleted,
+		url:         r.url,
+		acl:         r.acl,
+		password:    r.password,
+		history:     r.history,
+		meta:        r.meta,
+	}
+}
+
 // MapStorage defines a simple in-memory storage implemented as a wrapper
 // around Go map
 type MapStorage struct {
 	sync.RWMutex
 
-	data map[string]string
+	data   map[string]*record
+	limits Limits
+	lru    *list.List // ids, front is the most recently accessed one
+	bytes  int64
+
+	maxUserLinks int
+	active       map[string]int // number of active records per user
+
+	apiKeys       apiKeys
+	users         users
+	recoveryCodes recoveryCodes
+	roles         map[string]string
+	workspaces    workspaces
+	transfers     map[string]*storage.Transfer
 }
 
 // MapStorage implements Storage interface
 var _ storage.Storage = (*MapStorage)(nil)
 
-func NewMapStorage() (*MapStorage, error) {
-	d := make(map[string]string)
-	return &MapStorage{data: d}, nil
+// Option configures optional MapStorage / FileMapStorage features
+type Option func(*options)
+
+type options struct {
+	limits       Limits
+	keyring      *Keyring
+	maxUserLinks int
+}
+
+func newOptions(opts []Option) options {
+	o := options{limits: Limits{Policy: RejectPolicy}}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	return o
+}
+
+// WithLimits bounds the number of records and memory used
+func WithLimits(limits Limits) Option {
+	return func(o *options) {
+		if limits.Policy == "" {
+			limits.Policy = RejectPolicy
+		}
+		o.limits = limits
+	}
+}
+
+// WithMaxUserLinks limits the number of active records per user, 0 means
+// no limit
+func WithMaxUserLinks(n int) Option {
+	return func(o *options) {
+		o.maxUserLinks = n
+	}
+}
+
+// WithKeyring makes FileMapStorage encrypt its file with the current key of
+// kr, MapStorage ignores this option
+func WithKeyring(kr *Keyring) Option {
+	return func(o *options) {
+		o.keyring = kr
+	}
+}
+
+func NewMapStorage(opts ...Option) (*MapStorage, error) {
+	o := newOptions(opts)
+	st := &MapStorage{
+		data:          make(map[string]*record),
+		limits:        o.limits,
+		lru:           list.New(),
+		maxUserLinks:  o.maxUserLinks,
+		active:        make(map[string]int),
+		apiKeys:       newAPIKeys(),
+		users:         newUsers(),
+		recoveryCodes: newRecoveryCodes(),
+		roles:         make(map[string]string),
+		workspaces:    newWorkspaces(),
+		transfers:     make(map[string]*storage.Transfer),
+	}
+
+	return st, nil
 }
 
 // AddURL saves both url and its id
@@ -35,8 +203,17 @@ func (st *MapStorage) AddURL(ctx context.Context, ue url.URLEntry, userID string
 		logger.Info("MapStorage: Repeated id found: ", ue.ShortURL)
 		return storage.NewIDConflictError(ue.ShortURL)
 	}
-	st.data[ue.ShortURL] = userID + "|false|" + ue.OriginalURL
-	logger.Debugf("AddURL [%s] :: [%s]", ue.ShortURL, st.data[ue.ShortURL])
+
+	if st.maxUserLinks > 0 && st.active[userID] >= st.maxUserLinks {
+		return storage.NewQuotaExceededError(st.maxUserLinks)
+	}
+
+	rec := &record{userID: userID, url: ue.OriginalURL}
+	if !st.makeRoom(1, rec.size(ue.ShortURL)) {
+		return storage.NewInsufficientStorageError()
+	}
+	st.put(ue.ShortURL, rec)
+	logger.Debugf("AddURL [%s] :: [%s]", ue.ShortURL, ue.OriginalURL)
 
 	return nil
 }
@@ -45,8 +222,31 @@ func (st *MapStorage) AddBatchURL(ctx context.Context, batch []url.BatchURLEntry
 	st.Lock()
 	defer st.Unlock()
 
+	// the batch is either stored as a whole or rejected, existing ids are
+	// skipped as they belong to their owners
+	records := make(map[string]*record, len(batch))
+	var size int64
 	for _, ue := range batch {
-		st.data[ue.ShortURL] = userID + "|false|" + ue.OriginalURL
+		if _, ok := st.data[ue.ShortURL]; ok {
+			continue
+		}
+		if _, ok := records[ue.ShortURL]; ok {
+			continue
+		}
+		rec := &record{userID: userID, url: ue.OriginalURL}
+		records[ue.ShortURL] = rec
+		size += rec.size(ue.ShortURL)
+	}
+
+	if st.maxUserLinks > 0 && st.active[userID]+len(records) > st.maxUserLinks {
+		return storage.NewQuotaExceededError(st.maxUserLinks)
+	}
+	if !st.makeRoom(len(records), size) {
+		return storage.NewInsufficientStorageError()
+	}
+
+	for id, rec := range records {
+		st.put(id, rec)
 	}
 
 	return nil
@@ -58,31 +258,42 @@ func (st *MapStorage) AddBatchURL(ctx context.Context, batch []url.BatchURLEntry
 // MapStorage implementation never returns non-nil error (except for records
 // marked as deleted)
 func (st *MapStorage) GetURL(ctx context.Context, id string) (string, error) {
-	st.RLock()
-	defer st.RUnlock()
-	res := st.data[id]
-	if res == "" {
-		return res, nil
+	// LRU policy reorders records on access
+	if st.limits.Policy == LRUPolicy {
+		st.Lock()
+		defer st.Unlock()
+	} else {
+		st.RLock()
+		defer st.RUnlock()
 	}
-	parts := strings.SplitN(res, "|", 3)
-	if parts[1] == "true" {
+
+	rec, ok := st.data[id]
+	if !ok {
+		return "", nil
+	}
+	if st.limits.Policy == LRUPolicy {
+		st.lru.MoveToFront(rec.elem)
+	}
+	if rec.deleted {
 		logger.Info("MapStorage: Deleted id found: ", id)
 		return "", storage.NewURLDeletedError(id)
 	}
 
-	return parts[2], nil
+	return rec.url, nil
 }
 
 func (st *MapStorage) GetUserURLs(ctx context.Context, userID string) ([]url.URLEntry, error) {
+	st.RLock()
+	defer st.RUnlock()
+
 	res := []url.URLEntry{}
-	for id, str := range st.data {
-		parts := strings.SplitN(str, "|", 3)
-		if parts[0] != userID {
+	for id, rec := range st.data {
+		if rec.userID != userID {
 			continue
 		}
 		entry := url.URLEntry{
 			ShortURL:    id,
-			OriginalURL: parts[2],
+			OriginalURL: rec.url,
 		}
 		res = append(res, entry)
 	}
@@ -94,26 +305,126 @@ func (st *MapStorage) DeleteBatch(ctx context.Context, ids []string, userID stri
 	st.Lock()
 	defer st.Unlock()
 
-	idHash := make(map[string]struct{}, len(ids))
 	for _, id := range ids {
-		idHash[id] = struct{}{}
-	}
-
-	for id, str := range st.data {
-		if _, ok := idHash[id]; !ok {
+		rec, ok := st.data[id]
+		if !ok {
 			continue
 		}
-		parts := strings.SplitN(str, "|", 3)
-		if parts[0] != userID || parts[1] != "false" {
+		if !st.canDelete(rec, userID) || rec.deleted {
 			logger.Debugf("MapStorage : DeleteBatch: skip id %s", id)
 			continue
 		}
-		st.data[id] = parts[0] + "|true|" + parts[2]
+		rec.deleted = true
+		st.deactivate(rec.userID)
 		logger.Debugf("MapStorage : DeleteBatch: id %s marked deleted", id)
 	}
 	return nil
 }
 
+// CountUserURLs returns the number of active records of the user
+func (st *MapStorage) CountUserURLs(ctx context.Context, userID string) (int, error) {
+	st.RLock()
+	defer st.RUnlock()
+
+	return st.active[userID], nil
+}
+
+// ScanIDs calls fn for every id stored
+func (st *MapStorage) ScanIDs(ctx context.Context, fn func(id string)) error {
+	st.RLock()
+	defer st.RUnlock()
+
+	for id := range st.data {
+		fn(id)
+	}
+
+	return nil
+}
+
+// Usage returns the current usage and limits
+func (st *MapStorage) Usage() Usage {
+	st.RLock()
+	defer st.RUnlock()
+
+	return Usage{
+		Records:    len(st.data),
+		Bytes:      st.bytes,
+		MaxRecords: st.limits.MaxRecords,
+		MaxBytes:   st.limits.MaxBytes,
+	}
+}
+
 func (st *MapStorage) Close() error {
 	return nil
 }
+
+// put stores rec under id (replacing the existing record if any) regardless
+// of limits, the caller must hold the lock
+func (st *MapStorage) put(id string, rec *record) {
+	if old, ok := st.data[id]; ok {
+		st.remove(id, old)
+	}
+	rec.elem = st.lru.PushFront(id)
+	st.data[id] = rec
+	st.bytes += rec.size(id)
+	if !rec.deleted {
+		st.active[rec.userID]++
+	}
+	st.updateMetrics()
+}
+
+// remove deletes rec stored under id, the caller must hold the lock
+func (st *MapStorage) remove(id string, rec *record) {
+	st.lru.Remove(rec.elem)
+	delete(st.data, id)
+	st.bytes -= rec.size(id)
+	if !rec.deleted {
+		st.deactivate(rec.userID)
+	}
+	st.updateMetrics()
+}
+
+// makeRoom checks if records more records of size more bytes fit in
+// the limits, evicting least recently accessed records if the policy
+// allows, the caller must hold the lock. Deleted records are never evicted,
+// otherwise their ids could be taken again
+func (st *MapStorage) makeRoom(records int, size int64) bool {
+	fits := func() bool {
+		return (st.limits.MaxRecords == 0 || len(st.data)+records <= st.limits.MaxRecords) &&
+			(st.limits.MaxBytes == 0 || st.bytes+size <= st.limits.MaxBytes)
+	}
+
+	if fits() {
+		return true
+	}
+	if st.limits.Policy != LRUPolicy {
+		logger.Warning("MapStorage: limits reached, record rejected")
+		return false
+	}
+
+	for e := st.lru.Back(); e != nil && !fits(); {
+		prev := e.Prev()
+		id := e.Value.(string)
+		if rec := st.data[id]; !rec.deleted {
+			st.remove(id, rec)
+			metrics.Add("evictions", 1)
+			logger.Debugf("MapStorage: id %s evicted", id)
+		}
+		e = prev
+	}
+
+	return fits()
+}
+
+// deactivate decrements the number of active records of the user, the caller
+// must hold the lock
+func (st *MapStorage) deactivate(userID string) {
+	if st.active[userID]--; st.active[userID] <= 0 {
+		delete(st.active, userID)
+	}
+}
+
+func (st *MapStorage) updateMetrics() {
+	recordsGauge.Set(int64(len(st.data)))
+	bytesGauge.Set(st.bytes)
+}
@@ -2,6 +2,9 @@ package inmemory_test
 
 import (
 	"context"
+	"fmt"
+	"strings"
+	"sync"
 	"testing"
 
 	"github.com/sbxb/shorty/internal/app/storage"
@@ -101,3 +104,156 @@ func TestMemoryStore_Batch_Add_Delete(t *testing.T) {
 		require.ErrorAs(t, err, &deletedError)
 	}
 }
+
+func TestMemoryStore_Limits_Reject(t *testing.T) {
+	ctx := context.Background()
+	store, _ := inmemory.NewMapStorage(inmemory.WithLimits(inmemory.Limits{
+		MaxRecords: 1,
+		Policy:     inmemory.RejectPolicy,
+	}))
+
+	err := store.AddURL(ctx, url.URLEntry{ShortURL: "id1", OriginalURL: "http://example.com"}, "")
+	require.NoError(t, err)
+
+	err = store.AddURL(ctx, url.URLEntry{ShortURL: "id2", OriginalURL: "http://example.org"}, "")
+	var insufficientStorageError *storage.InsufficientStorageError
+	require.ErrorAs(t, err, &insufficientStorageError)
+
+	// the batch is rejected as a whole
+	err = store.AddBatchURL(ctx, []url.BatchURLEntry{
+		{ShortURL: "id1", OriginalURL: "http://example.com"},
+		{ShortURL: "id3", OriginalURL: "http://example.net"},
+	}, "")
+	require.ErrorAs(t, err, &insufficientStorageError)
+
+	urlReturned, _ := store.GetURL(ctx, "id1")
+	assert.Equal(t, urlReturned, "http://example.com")
+	assert.Equal(t, store.Usage().Records, 1)
+}
+
+func TestMemoryStore_Limits_EvictLRU(t *testing.T) {
+	ctx := context.Background()
+	store, _ := inmemory.NewMapStorage(inmemory.WithLimits(inmemory.Limits{
+		MaxRecords: 2,
+		Policy:     inmemory.LRUPolicy,
+	}))
+
+	_ = store.AddURL(ctx, url.URLEntry{ShortURL: "id1", OriginalURL: "http://example.com"}, "")
+	_ = store.AddURL(ctx, url.URLEntry{ShortURL: "id2", OriginalURL: "http://example.org"}, "")
+
+	// id1 becomes the most recently accessed one
+	_, _ = store.GetURL(ctx, "id1")
+
+	err := store.AddURL(ctx, url.URLEntry{ShortURL: "id3", OriginalURL: "http://example.net"}, "")
+	require.NoError(t, err)
+
+	urlReturned, _ := store.GetURL(ctx, "id2")
+	assert.Empty(t, urlReturned, "least recently accessed record should be evicted")
+
+	urlReturned, _ = store.GetURL(ctx, "id1")
+	assert.Equal(t, urlReturned, "http://example.com")
+
+	usage := store.Usage()
+	assert.Equal(t, usage.Records, 2)
+	assert.Equal(t, usage.MaxRecords, 2)
+}
+
+func TestMemoryStore_Limits_EvictLRU_Keeps_Deleted(t *testing.T) {
+	ctx := context.Background()
+	store, _ := inmemory.NewMapStorage(inmemory.WithLimits(inmemory.Limits{
+		MaxRecords: 2,
+		Policy:     inmemory.LRUPolicy,
+	}))
+
+	_ = store.AddURL(ctx, url.URLEntry{ShortURL: "id1", OriginalURL: "http://example.com"}, "")
+	_ = store.AddURL(ctx, url.URLEntry{ShortURL: "id2", OriginalURL: "http://example.org"}, "")
+	require.NoError(t, store.DeleteBatch(ctx, []string{"id1"}, ""))
+
+	// id1 is the least recently accessed one but id2 is evicted instead
+	err := store.AddURL(ctx, url.URLEntry{ShortURL: "id3", OriginalURL: "http://example.net"}, "")
+	require.NoError(t, err)
+
+	_, err = store.GetURL(ctx, "id1")
+	var deletedError *storage.URLDeletedError
+	require.ErrorAs(t, err, &deletedError)
+
+	// the deleted id cannot be taken again
+	err = store.AddURL(ctx, url.URLEntry{ShortURL: "id1", OriginalURL: "http://example.net"}, "")
+	require.Error(t, err)
+}
+
+func TestMemoryStore_Limits_Bytes(t *testing.T) {
+	ctx := context.Background()
+	store, _ := inmemory.NewMapStorage(inmemory.WithLimits(inmemory.Limits{
+		MaxBytes: 1024,
+		Policy:   inmemory.RejectPolicy,
+	}))
+
+	longURL := "http://example.com/" + strings.Repeat("a", 1024)
+	err := store.AddURL(ctx, url.URLEntry{ShortURL: "id1", OriginalURL: longURL}, "")
+	var insufficientStorageError *storage.InsufficientStorageError
+	require.ErrorAs(t, err, &insufficientStorageError)
+
+	err = store.AddURL(ctx, url.URLEntry{ShortURL: "id2", OriginalURL: "http://example.org"}, "")
+	require.NoError(t, err)
+	assert.Greater(t, store.Usage().Bytes, int64(0))
+}
+
+func TestMemoryStore_MaxUserLinks(t *testing.T) {
+	ctx := context.Background()
+	store, _ := inmemory.NewMapStorage(inmemory.WithMaxUserLinks(2))
+	var quotaExceededError *storage.QuotaExceededError
+
+	require.NoError(t, store.AddURL(ctx, url.URLEntry{ShortURL: "id1", OriginalURL: "http://example.com"}, "user"))
+
+	// the batch is rejected as a whole, ids already owned are not counted
+	err := store.AddBatchURL(ctx, []url.BatchURLEntry{
+		{ShortURL: "id1", OriginalURL: "http://example.com"},
+		{ShortURL: "id2", OriginalURL: "http://example.org"},
+		{ShortURL: "id3", OriginalURL: "http://example.net"},
+	}, "user")
+	require.ErrorAs(t, err, &quotaExceededError)
+	assert.Equal(t, 2, quotaExceededError.Limit)
+
+	require.NoError(t, store.AddBatchURL(ctx, []url.BatchURLEntry{
+		{ShortURL: "id1", OriginalURL: "http://example.com"},
+		{ShortURL: "id2", OriginalURL: "http://example.org"},
+	}, "user"))
+
+	err = store.AddURL(ctx, url.URLEntry{ShortURL: "id3", OriginalURL: "http://example.net"}, "user")
+	require.ErrorAs(t, err, &quotaExceededError)
+
+	// other users have their own quotas
+	require.NoError(t, store.AddURL(ctx, url.URLEntry{ShortURL: "id4", OriginalURL: "http://example.info"}, "other"))
+
+	// deleted links free the quota
+	require.NoError(t, store.DeleteBatch(ctx, []string{"id1"}, "user"))
+	count, _ := store.CountUserURLs(ctx, "user")
+	assert.Equal(t, 1, count)
+	require.NoError(t, store.AddURL(ctx, url.URLEntry{ShortURL: "id3", OriginalURL: "http://example.net"}, "user"))
+}
+
+func TestMemoryStore_MaxUserLinks_Concurrent(t *testing.T) {
+	ctx := context.Background()
+	store, _ := inmemory.NewMapStorage(inmemory.WithMaxUserLinks(10))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			batch := make([]url.BatchURLEntry, 0, 3)
+			for j := 0; j < 3; j++ {
+				batch = append(batch, url.BatchURLEntry{
+					ShortURL:    fmt.Sprintf("id%d-%d", i, j),
+					OriginalURL: "http://example.com",
+				})
+			}
+			store.AddBatchURL(ctx, batch, "user")
+		}(i)
+	}
+	wg.Wait()
+
+	count, _ := store.CountUserURLs(ctx, "user")
+	assert.Equal(t, 9, count)
+}
@@ -0,0 +1,92 @@
+package inmemory
+
+import (
+	"context"
+
+	"github.com/sbxb/shorty/internal/app/storage"
+)
+
+// urlMeta is the record of a url's meta
+type urlMeta struct {
+	ID   string
+	Meta storage.URLMeta
+}
+
+// MapStorage implements URLMetaStorage interface
+var _ storage.URLMetaStorage = (*MapStorage)(nil)
+
+func (st *MapStorage) GetURLMeta(ctx context.Context, id string, userID string) (storage.URLMeta, error) {
+	st.RLock()
+	defer st.RUnlock()
+
+	rec, ok := st.data[id]
+	if !ok || rec.userID != userID {
+		return storage.URLMeta{}, storage.ErrURLNotFound
+	}
+	if rec.meta == nil {
+		return storage.URLMeta{}, nil
+	}
+
+	return copyMeta(*rec.meta), nil
+}
+
+func (st *MapStorage) SetURLMeta(ctx context.Context, id string, userID string, meta storage.URLMeta) error {
+	st.Lock()
+	defer st.Unlock()
+
+	rec, ok := st.data[id]
+	if !ok || rec.userID != userID {
+		return storage.ErrURLNotFound
+	}
+	st.setMeta(id, rec, meta)
+
+	return nil
+}
+
+// setMeta re-puts rec with meta since its size depends on the meta, the
+// caller must hold the lock
+func (st *MapStorage) setMeta(id string, rec *record, meta storage.URLMeta) {
+	updated := rec.clone()
+	updated.meta = nil
+	if !meta.IsEmpty() {
+		meta = copyMeta(meta)
+		updated.meta = &meta
+	}
+	st.put(id, updated)
+}
+
+func (st *MapStorage) GetUserURLMeta(ctx context.Context, userID string) (map[string]storage.URLMeta, error) {
+	st.RLock()
+	defer st.RUnlock()
+
+	res := make(map[string]storage.URLMeta)
+	for id, rec := range st.data {
+		if rec.userID == userID && rec.meta != nil {
+			res[id] = copyMeta(*rec.meta)
+		}
+	}
+
+	return res, nil
+}
+
+func (st *MapStorage) GetUserTags(ctx context.Context, userID string) (map[string]int, error) {
+	st.RLock()
+	defer st.RUnlock()
+
+	res := make(map[string]int)
+	for _, rec := range st.data {
+		if rec.userID != userID || rec.meta == nil {
+			continue
+		}
+		for _, tag := range rec.meta.Tags {
+			res[tag]++
+		}
+	}
+
+	return res, nil
+}
+
+func copyMeta(meta storage.URLMeta) storage.URLMeta {
+	meta.Tags = append([]string(nil), meta.Tags...)
+	return meta
+}
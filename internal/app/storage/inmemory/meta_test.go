@@ -0,0 +1,51 @@
+package inmemory_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/sbxb/shorty/internal/app/storage"
+	"github.com/sbxb/shorty/internal/app/storage/inmemory"
+	"github.com/sbxb/shorty/internal/app/url"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemoryStore_Meta(t *testing.T) {
+	ctx := context.Background()
+	store, _ := inmemory.NewMapStorage()
+
+	require.NoError(t, store.AddURL(ctx, url.URLEntry{ShortURL: "a", OriginalURL: "http://a.com"}, "owner"))
+	require.NoError(t, store.AddURL(ctx, url.URLEntry{ShortURL: "b", OriginalURL: "http://b.com"}, "owner"))
+	require.NoError(t, store.AddURL(ctx, url.URLEntry{ShortURL: "c", OriginalURL: "http://c.com"}, "other"))
+
+	assert.Equal(t, storage.ErrURLNotFound, store.SetURLMeta(ctx, "a", "other", storage.URLMeta{Title: "A"}))
+	_, err := store.GetURLMeta(ctx, "a", "other")
+	assert.Equal(t, storage.ErrURLNotFound, err)
+
+	meta, err := store.GetURLMeta(ctx, "a", "owner")
+	require.NoError(t, err)
+	assert.True(t, meta.IsEmpty())
+
+	require.NoError(t, store.SetURLMeta(ctx, "a", "owner", storage.URLMeta{Title: "A", Notes: "n", Tags: []string{"docs", "go"}}))
+	require.NoError(t, store.SetURLMeta(ctx, "b", "owner", storage.URLMeta{Tags: []string{"docs"}}))
+	require.NoError(t, store.SetURLMeta(ctx, "c", "other", storage.URLMeta{Tags: []string{"docs"}}))
+
+	meta, err = store.GetURLMeta(ctx, "a", "owner")
+	require.NoError(t, err)
+	assert.Equal(t, storage.URLMeta{Title: "A", Notes: "n", Tags: []string{"docs", "go"}}, meta)
+
+	metas, err := store.GetUserURLMeta(ctx, "owner")
+	require.NoError(t, err)
+	assert.Len(t, metas, 2)
+	tags, err := store.GetUserTags(ctx, "owner")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int{"docs": 2, "go": 1}, tags)
+
+	// an empty meta clears the url's one
+	require.NoError(t, store.SetURLMeta(ctx, "b", "owner", storage.URLMeta{}))
+	tags, err = store.GetUserTags(ctx, "owner")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int{"docs": 1, "go": 1}, tags)
+}
@@ -0,0 +1,44 @@
+package inmemory
+
+import (
+	"context"
+
+	"github.com/sbxb/shorty/internal/app/storage"
+)
+
+// urlPassword is the record of a url's password hash
+type urlPassword struct {
+	ID   string
+	Hash string
+}
+
+// MapStorage implements LinkPasswordStorage interface
+var _ storage.LinkPasswordStorage = (*MapStorage)(nil)
+
+func (st *MapStorage) GetURLPassword(ctx context.Context, id string) (string, error) {
+	st.RLock()
+	defer st.RUnlock()
+
+	rec, ok := st.data[id]
+	if !ok {
+		return "", storage.ErrURLNotFound
+	}
+
+	return rec.password, nil
+}
+
+func (st *MapStorage) SetURLPassword(ctx context.Context, id string, userID string, hash string) error {
+	st.Lock()
+	defer st.Unlock()
+
+	rec, ok := st.data[id]
+	if !ok || rec.userID != userID {
+		return storage.ErrURLNotFound
+	}
+	// records are re-put since their size depends on the hash
+	updated := rec.clone()
+	updated.password = hash
+	st.put(id, updated)
+
+	return nil
+}
@@ -0,0 +1,53 @@
+package inmemory_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/sbxb/shorty/internal/app/storage"
+	"github.com/sbxb/shorty/internal/app/storage/inmemory"
+	"github.com/sbxb/shorty/internal/app/url"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemoryStore_Passwords(t *testing.T) {
+	ctx := context.Background()
+	store, _ := inmemory.NewMapStorage()
+
+	_, err := store.GetURLPassword(ctx, "a")
+	assert.Equal(t, storage.ErrURLNotFound, err)
+
+	require.NoError(t, store.AddURL(ctx, url.URLEntry{ShortURL: "a", OriginalURL: "http://a.com"}, "owner"))
+	hash, err := store.GetURLPassword(ctx, "a")
+	require.NoError(t, err)
+	assert.Empty(t, hash)
+
+	// only the owner sets the password
+	assert.Equal(t, storage.ErrURLNotFound, store.SetURLPassword(ctx, "a", "other", "hash"))
+	require.NoError(t, store.SetURLPassword(ctx, "a", "owner", "hash"))
+	hash, _ = store.GetURLPassword(ctx, "a")
+	assert.Equal(t, "hash", hash)
+
+	require.NoError(t, store.SetURLPassword(ctx, "a", "owner", ""))
+	hash, _ = store.GetURLPassword(ctx, "a")
+	assert.Empty(t, hash)
+}
+
+func TestMemoryStore_Passwords_ForeignBatch(t *testing.T) {
+	ctx := context.Background()
+	store, _ := inmemory.NewMapStorage()
+
+	require.NoError(t, store.AddURL(ctx, url.URLEntry{ShortURL: "a", OriginalURL: "http://a.com"}, "owner"))
+	require.NoError(t, store.SetURLPassword(ctx, "a", "owner", "hash"))
+
+	require.NoError(t, store.AddBatchURL(ctx, []url.BatchURLEntry{
+		{CorrelationID: "1", ShortURL: "a", OriginalURL: "http://a.com"},
+	}, "mallory"))
+
+	hash, err := store.GetURLPassword(ctx, "a")
+	require.NoError(t, err)
+	assert.Equal(t, "hash", hash)
+	assert.Equal(t, storage.ErrURLNotFound, store.SetURLPassword(ctx, "a", "mallory", ""))
+}
@@ -0,0 +1,59 @@
+package inmemory
+
+import (
+	"context"
+
+	"github.com/sbxb/shorty/internal/app/storage"
+)
+
+// recoveryCodes keeps recovery codes by their hashes along with the user
+// index, it's guarded by the MapStorage lock
+type recoveryCodes struct {
+	byHash map[string]*storage.RecoveryCode
+	byUser map[string]*storage.RecoveryCode
+}
+
+func newRecoveryCodes() recoveryCodes {
+	return recoveryCodes{
+		byHash: make(map[string]*storage.RecoveryCode),
+		byUser: make(map[string]*storage.RecoveryCode),
+	}
+}
+
+// put replaces the user's code if any
+func (rc recoveryCodes) put(code storage.RecoveryCode) {
+	if old, ok := rc.byUser[code.UserID]; ok {
+		delete(rc.byHash, old.Hash)
+	}
+	rc.byHash[code.Hash] = &code
+	rc.byUser[code.UserID] = &code
+}
+
+// MapStorage implements RecoveryCodeStorage interface
+var _ storage.RecoveryCodeStorage = (*MapStorage)(nil)
+
+func (st *MapStorage) AddRecoveryCode(ctx context.Context, code storage.RecoveryCode) error {
+	st.Lock()
+	defer st.Unlock()
+
+	if _, ok := st.recoveryCodes.byHash[code.Hash]; ok {
+		return storage.NewIDConflictError(code.Hash)
+	}
+	st.recoveryCodes.put(code)
+
+	return nil
+}
+
+func (st *MapStorage) RedeemRecoveryCode(ctx context.Context, hash string) (string, error) {
+	st.Lock()
+	defer st.Unlock()
+
+	code, ok := st.recoveryCodes.byHash[hash]
+	if !ok {
+		return "", storage.ErrRecoveryCodeNotFound
+	}
+	delete(st.recoveryCodes.byHash, hash)
+	delete(st.recoveryCodes.byUser, code.UserID)
+
+	return code.UserID, nil
+}
@@ -0,0 +1,31 @@
+package inmemory_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/sbxb/shorty/internal/app/storage"
+	"github.com/sbxb/shorty/internal/app/storage/inmemory"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemoryStore_RecoveryCodes(t *testing.T) {
+	ctx := context.Background()
+	store, _ := inmemory.NewMapStorage()
+
+	require.NoError(t, store.AddRecoveryCode(ctx, storage.RecoveryCode{UserID: "user", Hash: "h1"}))
+	// a new code replaces the previous one
+	require.NoError(t, store.AddRecoveryCode(ctx, storage.RecoveryCode{UserID: "user", Hash: "h2"}))
+	_, err := store.RedeemRecoveryCode(ctx, "h1")
+	assert.Equal(t, storage.ErrRecoveryCodeNotFound, err)
+
+	uid, err := store.RedeemRecoveryCode(ctx, "h2")
+	require.NoError(t, err)
+	assert.Equal(t, "user", uid)
+
+	// codes work once
+	_, err = store.RedeemRecoveryCode(ctx, "h2")
+	assert.Equal(t, storage.ErrRecoveryCodeNotFound, err)
+}
@@ -0,0 +1,117 @@
+package inmemory
+
+import (
+	"context"
+	"time"
+
+	"github.com/sbxb/shorty/internal/app/storage"
+)
+
+// MapStorage implements TransferStorage interface
+var _ storage.TransferStorage = (*MapStorage)(nil)
+
+func (st *MapStorage) AddTransfer(ctx context.Context, transfer storage.Transfer) error {
+	st.Lock()
+	defer st.Unlock()
+
+	if _, ok := st.transfers[transfer.ID]; ok {
+		return storage.NewIDConflictError(transfer.ID)
+	}
+	transfer.URLIDs = append([]string(nil), transfer.URLIDs...)
+	st.transfers[transfer.ID] = &transfer
+
+	return nil
+}
+
+func (st *MapStorage) GetUserTransfers(ctx context.Context, userID string) ([]storage.Transfer, error) {
+	st.RLock()
+	defer st.RUnlock()
+
+	res := []storage.Transfer{}
+	for _, t := range st.transfers {
+		if t.FromUserID == userID || st.isReceiver(t, userID) {
+			res = append(res, *t)
+		}
+	}
+
+	return res, nil
+}
+
+func (st *MapStorage) AcceptTransfer(ctx context.Context, id string, userID string, at time.Time) (storage.Transfer, error) {
+	st.Lock()
+	defer st.Unlock()
+
+	t, ok := st.transfers[id]
+	if !ok || t.Status != storage.TransferPending || !st.isReceiver(t, userID) {
+		return storage.Transfer{}, storage.ErrTransferNotFound
+	}
+
+	ids := []string{}
+	active := 0
+	for _, urlID := range t.URLIDs {
+		rec, ok := st.data[urlID]
+		if !ok || rec.userID != t.FromUserID {
+			continue
+		}
+		ids = append(ids, urlID)
+		// the owner of the workspace might be the sender
+		if !rec.deleted && rec.userID != userID {
+			active++
+		}
+	}
+	if st.maxUserLinks > 0 && st.active[userID]+active > st.maxUserLinks {
+		return storage.Transfer{}, storage.NewQuotaExceededError(st.maxUserLinks)
+	}
+
+	// records are re-put since their size depends on the user id
+	for _, urlID := range ids {
+		rec := st.data[urlID].clone()
+		rec.userID = userID
+		if t.ToWorkspaceID != "" {
+			rec.workspaceID = t.ToWorkspaceID
+		}
+		st.put(urlID, rec)
+	}
+	resolveTransfer(t, storage.TransferAccepted, userID, at)
+	t.Moved = len(ids)
+
+	return *t, nil
+}
+
+func (st *MapStorage) DeclineTransfer(ctx context.Context, id string, userID string, at time.Time) (storage.Transfer, error) {
+	st.Lock()
+	defer st.Unlock()
+
+	t, ok := st.transfers[id]
+	if !ok || t.Status != storage.TransferPending {
+		return storage.Transfer{}, storage.ErrTransferNotFound
+	}
+	switch {
+	case t.FromUserID == userID:
+		resolveTransfer(t, storage.TransferCancelled, userID, at)
+	case st.isReceiver(t, userID):
+		resolveTransfer(t, storage.TransferDeclined, userID, at)
+	default:
+		return storage.Transfer{}, storage.ErrTransferNotFound
+	}
+
+	return *t, nil
+}
+
+// isReceiver reports whether the user is the receiver of the transfer or
+// an owner of the receiving workspace, the caller must hold the lock
+func (st *MapStorage) isReceiver(t *storage.Transfer, userID string) bool {
+	if t.ToWorkspaceID != "" {
+		return st.workspaces.members[t.ToWorkspaceID][userID] == storage.WorkspaceOwner
+	}
+
+	return t.ToUserID == userID
+}
+
+// resolveTransfer sets the final status of the transfer, the caller must
+// hold the lock
+func resolveTransfer(t *storage.Transfer, status string, userID string, at time.Time) {
+	t.Status = status
+	t.ResolvedBy = userID
+	t.ResolvedAt = at
+}
@@ -0,0 +1,106 @@
+package inmemory_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/sbxb/shorty/internal/app/storage"
+	"github.com/sbxb/shorty/internal/app/storage/inmemory"
+	"github.com/sbxb/shorty/internal/app/url"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemoryStore_Transfers(t *testing.T) {
+	ctx := context.Background()
+	store, _ := inmemory.NewMapStorage(inmemory.WithMaxUserLinks(2))
+	now := time.Now()
+
+	require.NoError(t, store.AddURL(ctx, url.URLEntry{ShortURL: "a", OriginalURL: "http://a.com"}, "leaver"))
+	require.NoError(t, store.AddURL(ctx, url.URLEntry{ShortURL: "b", OriginalURL: "http://b.com"}, "leaver"))
+	require.NoError(t, store.AddURL(ctx, url.URLEntry{ShortURL: "c", OriginalURL: "http://c.com"}, "other"))
+	require.NoError(t, store.AddURL(ctx, url.URLEntry{ShortURL: "d", OriginalURL: "http://d.com"}, "receiver"))
+
+	transfer := storage.Transfer{
+		ID:         "t1",
+		FromUserID: "leaver",
+		ToUserID:   "receiver",
+		URLIDs:     []string{"a", "b", "c"},
+		Status:     storage.TransferPending,
+	}
+	require.NoError(t, store.AddTransfer(ctx, transfer))
+
+	// only the receiver accepts
+	_, err := store.AcceptTransfer(ctx, "t1", "leaver", now)
+	assert.Equal(t, storage.ErrTransferNotFound, err)
+	// the receiver's quota applies
+	_, err = store.AcceptTransfer(ctx, "t1", "receiver", now)
+	var quotaErr *storage.QuotaExceededError
+	require.ErrorAs(t, err, &quotaErr)
+
+	require.NoError(t, store.DeleteBatch(ctx, []string{"b"}, "leaver"))
+	accepted, err := store.AcceptTransfer(ctx, "t1", "receiver", now)
+	require.NoError(t, err)
+	assert.Equal(t, storage.TransferAccepted, accepted.Status)
+	assert.Equal(t, "receiver", accepted.ResolvedBy)
+	assert.Equal(t, 2, accepted.Moved)
+
+	urls, _ := store.GetUserURLs(ctx, "leaver")
+	assert.Empty(t, urls)
+	urls, _ = store.GetUserURLs(ctx, "receiver")
+	assert.Len(t, urls, 3)
+	count, _ := store.CountUserURLs(ctx, "receiver")
+	assert.Equal(t, 2, count)
+
+	// the new owner deletes, the old one doesn't
+	require.NoError(t, store.DeleteBatch(ctx, []string{"a"}, "leaver"))
+	_, err = store.GetURL(ctx, "a")
+	assert.NoError(t, err)
+	require.NoError(t, store.DeleteBatch(ctx, []string{"a"}, "receiver"))
+	_, err = store.GetURL(ctx, "a")
+	assert.Error(t, err)
+
+	// resolved transfers are kept but can't be accepted again
+	_, err = store.AcceptTransfer(ctx, "t1", "receiver", now)
+	assert.Equal(t, storage.ErrTransferNotFound, err)
+	transfers, err := store.GetUserTransfers(ctx, "leaver")
+	require.NoError(t, err)
+	require.Len(t, transfers, 1)
+	assert.Equal(t, storage.TransferAccepted, transfers[0].Status)
+}
+
+func TestMemoryStore_Transfers_Workspace(t *testing.T) {
+	ctx := context.Background()
+	store, _ := inmemory.NewMapStorage()
+	now := time.Now()
+
+	require.NoError(t, store.AddWorkspace(ctx, storage.Workspace{ID: "ws"}, "owner"))
+	require.NoError(t, store.SetWorkspaceMember(ctx, storage.WorkspaceMember{WorkspaceID: "ws", UserID: "editor", Role: storage.WorkspaceEditor}))
+	require.NoError(t, store.AddURL(ctx, url.URLEntry{ShortURL: "a", OriginalURL: "http://a.com"}, "leaver"))
+
+	require.NoError(t, store.AddTransfer(ctx, storage.Transfer{ID: "t1", FromUserID: "leaver", ToWorkspaceID: "ws", URLIDs: []string{"a"}, Status: storage.TransferPending}))
+	require.NoError(t, store.AddTransfer(ctx, storage.Transfer{ID: "t2", FromUserID: "leaver", ToWorkspaceID: "ws", URLIDs: []string{"a"}, Status: storage.TransferPending}))
+
+	// the owners of the workspace receive its transfers
+	transfers, _ := store.GetUserTransfers(ctx, "editor")
+	assert.Empty(t, transfers)
+	transfers, _ = store.GetUserTransfers(ctx, "owner")
+	assert.Len(t, transfers, 2)
+	_, err := store.AcceptTransfer(ctx, "t1", "editor", now)
+	assert.Equal(t, storage.ErrTransferNotFound, err)
+
+	accepted, err := store.AcceptTransfer(ctx, "t1", "owner", now)
+	require.NoError(t, err)
+	assert.Equal(t, 1, accepted.Moved)
+	urls, _ := store.GetWorkspaceURLs(ctx, "ws")
+	assert.Equal(t, []url.URLEntry{{ShortURL: "a", OriginalURL: "http://a.com"}}, urls)
+
+	// the sender cancels, strangers can't
+	_, err = store.DeclineTransfer(ctx, "t2", "editor", now)
+	assert.Equal(t, storage.ErrTransferNotFound, err)
+	cancelled, err := store.DeclineTransfer(ctx, "t2", "leaver", now)
+	require.NoError(t, err)
+	assert.Equal(t, storage.TransferCancelled, cancelled.Status)
+}
@@ -0,0 +1,97 @@
+package inmemory
+
+import (
+	"context"
+
+	"github.com/sbxb/shorty/internal/app/storage"
+)
+
+// users keeps registered accounts by their ids along with the login index,
+// it's guarded by the MapStorage lock
+type users struct {
+	byID    map[string]*storage.User
+	byLogin map[string]*storage.User
+}
+
+func newUsers() users {
+	return users{
+		byID:    make(map[string]*storage.User),
+		byLogin: make(map[string]*storage.User),
+	}
+}
+
+func (us users) put(user storage.User) {
+	us.byID[user.ID] = &user
+	us.byLogin[user.Login] = &user
+}
+
+// MapStorage implements UserStorage interface
+var _ storage.UserStorage = (*MapStorage)(nil)
+
+func (st *MapStorage) AddUser(ctx context.Context, user storage.User) error {
+	st.Lock()
+	defer st.Unlock()
+
+	if _, ok := st.users.byLogin[user.Login]; ok {
+		return storage.ErrLoginTaken
+	}
+	if _, ok := st.users.byID[user.ID]; ok {
+		return storage.NewIDConflictError(user.ID)
+	}
+	st.users.put(user)
+
+	return nil
+}
+
+func (st *MapStorage) GetUserByLogin(ctx context.Context, login string) (storage.User, error) {
+	st.RLock()
+	defer st.RUnlock()
+
+	user, ok := st.users.byLogin[login]
+	if !ok {
+		return storage.User{}, storage.ErrUserNotFound
+	}
+
+	return *user, nil
+}
+
+func (st *MapStorage) GetUser(ctx context.Context, id string) (storage.User, error) {
+	st.RLock()
+	defer st.RUnlock()
+
+	user, ok := st.users.byID[id]
+	if !ok {
+		return storage.User{}, storage.ErrUserNotFound
+	}
+
+	return *user, nil
+}
+
+// ClaimURLs moves the records of fromUserID to toUserID, deleted ones
+// included
+func (st *MapStorage) ClaimURLs(ctx context.Context, fromUserID string, toUserID string) (int, error) {
+	st.Lock()
+	defer st.Unlock()
+
+	if fromUserID == toUserID {
+		return 0, nil
+	}
+	if st.maxUserLinks > 0 && st.active[toUserID]+st.active[fromUserID] > st.maxUserLinks {
+		return 0, storage.NewQuotaExceededError(st.maxUserLinks)
+	}
+
+	ids := []string{}
+	for id, rec := range st.data {
+		if rec.userID == fromUserID {
+			ids = append(ids, id)
+		}
+	}
+	// records are re-put since their size depends on the user id
+	for _, id := range ids {
+		rec := st.data[id].clone()
+		rec.userID = toUserID
+		st.put(id, rec)
+	}
+
+	return len(ids), nil
+}
@@ -0,0 +1,68 @@
+package inmemory_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/sbxb/shorty/internal/app/storage"
+	"github.com/sbxb/shorty/internal/app/storage/inmemory"
+	"github.com/sbxb/shorty/internal/app/url"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemoryStore_Users(t *testing.T) {
+	ctx := context.Background()
+	store, _ := inmemory.NewMapStorage()
+
+	user := storage.User{ID: "account", Login: "alice", PasswordHash: "hash", CreatedAt: time.Now()}
+	require.NoError(t, store.AddUser(ctx, user))
+	assert.Equal(t, storage.ErrLoginTaken, store.AddUser(ctx, storage.User{ID: "other", Login: "alice"}))
+
+	got, err := store.GetUserByLogin(ctx, "alice")
+	require.NoError(t, err)
+	assert.Equal(t, user, got)
+	got, err = store.GetUser(ctx, "account")
+	require.NoError(t, err)
+	assert.Equal(t, user, got)
+
+	_, err = store.GetUserByLogin(ctx, "bob")
+	assert.Equal(t, storage.ErrUserNotFound, err)
+	_, err = store.GetUser(ctx, "anonymous")
+	assert.Equal(t, storage.ErrUserNotFound, err)
+}
+
+func TestMemoryStore_ClaimURLs(t *testing.T) {
+	ctx := context.Background()
+	store, _ := inmemory.NewMapStorage(inmemory.WithMaxUserLinks(3))
+
+	require.NoError(t, store.AddURL(ctx, url.URLEntry{ShortURL: "a", OriginalURL: "http://a.com"}, "anonymous"))
+	require.NoError(t, store.AddURL(ctx, url.URLEntry{ShortURL: "b", OriginalURL: "http://b.com"}, "anonymous"))
+	require.NoError(t, store.AddURL(ctx, url.URLEntry{ShortURL: "c", OriginalURL: "http://c.com"}, "account"))
+	require.NoError(t, store.AddURL(ctx, url.URLEntry{ShortURL: "d", OriginalURL: "http://d.com"}, "account"))
+	require.NoError(t, store.DeleteBatch(ctx, []string{"b"}, "anonymous"))
+
+	claimed, err := store.ClaimURLs(ctx, "anonymous", "account")
+	require.NoError(t, err)
+	assert.Equal(t, 2, claimed)
+
+	urls, _ := store.GetUserURLs(ctx, "account")
+	assert.Len(t, urls, 4)
+	urls, _ = store.GetUserURLs(ctx, "anonymous")
+	assert.Empty(t, urls)
+	count, _ := store.CountUserURLs(ctx, "account")
+	assert.Equal(t, 3, count)
+
+	// deleted links stay deleted
+	_, err = store.GetURL(ctx, "b")
+	assert.Error(t, err)
+
+	// the quota is respected
+	require.NoError(t, store.AddURL(ctx, url.URLEntry{ShortURL: "e", OriginalURL: "http://e.com"}, "anonymous"))
+	_, err = store.ClaimURLs(ctx, "anonymous", "account")
+	assert.IsType(t, &storage.QuotaExceededError{}, err)
+	urls, _ = store.GetUserURLs(ctx, "anonymous")
+	assert.Len(t, urls, 1)
+}
@@ -0,0 +1,188 @@
+package inmemory
+
+import (
+	"context"
+
+	"github.com/sbxb/shorty/internal/app/storage"
+	"github.com/sbxb/shorty/internal/app/url"
+)
+
+// workspaces keeps workspaces along with their members' roles, it's guarded
+// by the MapStorage lock, urls refer to their workspaces by id
+type workspaces struct {
+	byID    map[string]*storage.Workspace
+	members map[string]map[string]string // workspace id -> user id -> role
+}
+
+func newWorkspaces() workspaces {
+	return workspaces{
+		byID:    make(map[string]*storage.Workspace),
+		members: make(map[string]map[string]string),
+	}
+}
+
+func (ws workspaces) put(workspace storage.Workspace) {
+	ws.byID[workspace.ID] = &workspace
+	if ws.members[workspace.ID] == nil {
+		ws.members[workspace.ID] = make(map[string]string)
+	}
+}
+
+// workspaceURL is the record of a url shared within a workspace
+type workspaceURL struct {
+	ID          string
+	WorkspaceID string
+}
+
+// MapStorage implements WorkspaceStorage interface
+var _ storage.WorkspaceStorage = (*MapStorage)(nil)
+
+func (st *MapStorage) AddWorkspace(ctx context.Context, ws storage.Workspace, ownerID string) error {
+	st.Lock()
+	defer st.Unlock()
+
+	if _, ok := st.workspaces.byID[ws.ID]; ok {
+		return storage.NewIDConflictError(ws.ID)
+	}
+	st.workspaces.put(ws)
+	st.workspaces.members[ws.ID][ownerID] = storage.WorkspaceOwner
+
+	return nil
+}
+
+func (st *MapStorage) GetWorkspace(ctx context.Context, id string) (storage.Workspace, error) {
+	st.RLock()
+	defer st.RUnlock()
+
+	ws, ok := st.workspaces.byID[id]
+	if !ok {
+		return storage.Workspace{}, storage.ErrWorkspaceNotFound
+	}
+
+	return *ws, nil
+}
+
+func (st *MapStorage) GetUserWorkspaces(ctx context.Context, userID string) ([]storage.UserWorkspace, error) {
+	st.RLock()
+	defer st.RUnlock()
+
+	res := []storage.UserWorkspace{}
+	for id, members := range st.workspaces.members {
+		if role, ok := members[userID]; ok {
+			res = append(res, storage.UserWorkspace{Workspace: *st.workspaces.byID[id], Role: role})
+		}
+	}
+
+	return res, nil
+}
+
+func (st *MapStorage) GetWorkspaceRole(ctx context.Context, id string, userID string) (string, error) {
+	st.RLock()
+	defer st.RUnlock()
+
+	members, ok := st.workspaces.members[id]
+	if !ok {
+		return "", storage.ErrWorkspaceNotFound
+	}
+
+	return members[userID], nil
+}
+
+func (st *MapStorage) GetWorkspaceMembers(ctx context.Context, id string) ([]storage.WorkspaceMember, error) {
+	st.RLock()
+	defer st.RUnlock()
+
+	members, ok := st.workspaces.members[id]
+	if !ok {
+		return nil, storage.ErrWorkspaceNotFound
+	}
+
+	res := make([]storage.WorkspaceMember, 0, len(members))
+	for userID, role := range members {
+		res = append(res, storage.WorkspaceMember{WorkspaceID: id, UserID: userID, Role: role})
+	}
+
+	return res, nil
+}
+
+func (st *MapStorage) SetWorkspaceMember(ctx context.Context, member storage.WorkspaceMember) error {
+	st.Lock()
+	defer st.Unlock()
+
+	members, ok := st.workspaces.members[member.WorkspaceID]
+	if !ok {
+		return storage.ErrWorkspaceNotFound
+	}
+	members[member.UserID] = member.Role
+
+	return nil
+}
+
+func (st *MapStorage) RemoveWorkspaceMember(ctx context.Context, id string, userID string) error {
+	st.Lock()
+	defer st.Unlock()
+
+	members, ok := st.workspaces.members[id]
+	if !ok {
+		return storage.ErrWorkspaceNotFound
+	}
+	delete(members, userID)
+
+	return nil
+}
+
+func (st *MapStorage) AddURLsToWorkspace(ctx context.Context, id string, ids []string, userID string) (int, error) {
+	st.Lock()
+	defer st.Unlock()
+
+	if _, ok := st.workspaces.byID[id]; !ok {
+		return 0, storage.ErrWorkspaceNotFound
+	}
+
+	moved := 0
+	for _, urlID := range ids {
+		rec, ok := st.data[urlID]
+		if !ok || rec.userID != userID || rec.workspaceID == id {
+			continue
+		}
+		// records are re-put since their size depends on the workspace id
+		updated := rec.clone()
+		updated.workspaceID = id
+		st.put(urlID, updated)
+		moved++
+	}
+
+	return moved, nil
+}
+
+func (st *MapStorage) GetWorkspaceURLs(ctx context.Context, id string) ([]url.URLEntry, error) {
+	st.RLock()
+	defer st.RUnlock()
+
+	if _, ok := st.workspaces.byID[id]; !ok {
+		return nil, storage.ErrWorkspaceNotFound
+	}
+
+	res := []url.URLEntry{}
+	for urlID, rec := range st.data {
+		if rec.workspaceID != id {
+			continue
+		}
+		res = append(res, url.URLEntry{ShortURL: urlID, OriginalURL: rec.url})
+	}
+
+	return res, nil
+}
+
+// canDelete reports whether the user is allowed to delete rec, the caller
+// must hold the lock
+func (st *MapStorage) canDelete(rec *record, userID string) bool {
+	if rec.userID == userID {
+		return true
+	}
+	if rec.workspaceID == "" {
+		return false
+	}
+
+	return storage.WorkspaceRoleAllows(st.workspaces.members[rec.workspaceID][userID], storage.WorkspaceEditor)
+}
@@ -0,0 +1,80 @@
+package inmemory_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/sbxb/shorty/internal/app/storage"
+	"github.com/sbxb/shorty/internal/app/storage/inmemory"
+	"github.com/sbxb/shorty/internal/app/url"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemoryStore_Workspaces(t *testing.T) {
+	ctx := context.Background()
+	store, _ := inmemory.NewMapStorage()
+
+	require.NoError(t, store.AddWorkspace(ctx, storage.Workspace{ID: "ws", Name: "Marketing"}, "owner"))
+	assert.Error(t, store.AddWorkspace(ctx, storage.Workspace{ID: "ws"}, "other"))
+	require.NoError(t, store.SetWorkspaceMember(ctx, storage.WorkspaceMember{WorkspaceID: "ws", UserID: "editor", Role: storage.WorkspaceEditor}))
+	require.NoError(t, store.SetWorkspaceMember(ctx, storage.WorkspaceMember{WorkspaceID: "ws", UserID: "viewer", Role: storage.WorkspaceViewer}))
+	assert.Equal(t, storage.ErrWorkspaceNotFound,
+		store.SetWorkspaceMember(ctx, storage.WorkspaceMember{WorkspaceID: "unknown", UserID: "editor", Role: storage.WorkspaceEditor}))
+
+	role, err := store.GetWorkspaceRole(ctx, "ws", "editor")
+	require.NoError(t, err)
+	assert.Equal(t, storage.WorkspaceEditor, role)
+	role, err = store.GetWorkspaceRole(ctx, "ws", "stranger")
+	require.NoError(t, err)
+	assert.Empty(t, role)
+	_, err = store.GetWorkspaceRole(ctx, "unknown", "editor")
+	assert.Equal(t, storage.ErrWorkspaceNotFound, err)
+
+	workspaces, err := store.GetUserWorkspaces(ctx, "viewer")
+	require.NoError(t, err)
+	require.Len(t, workspaces, 1)
+	assert.Equal(t, "Marketing", workspaces[0].Name)
+	assert.Equal(t, storage.WorkspaceViewer, workspaces[0].Role)
+
+	members, err := store.GetWorkspaceMembers(ctx, "ws")
+	require.NoError(t, err)
+	assert.Len(t, members, 3)
+
+	// only the links of the user are moved
+	require.NoError(t, store.AddURL(ctx, url.URLEntry{ShortURL: "a", OriginalURL: "http://a.com"}, "owner"))
+	require.NoError(t, store.AddURL(ctx, url.URLEntry{ShortURL: "b", OriginalURL: "http://b.com"}, "owner"))
+	require.NoError(t, store.AddURL(ctx, url.URLEntry{ShortURL: "c", OriginalURL: "http://c.com"}, "stranger"))
+	moved, err := store.AddURLsToWorkspace(ctx, "ws", []string{"a", "b", "c", "a"}, "owner")
+	require.NoError(t, err)
+	assert.Equal(t, 2, moved)
+	urls, err := store.GetWorkspaceURLs(ctx, "ws")
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []url.URLEntry{
+		{ShortURL: "a", OriginalURL: "http://a.com"},
+		{ShortURL: "b", OriginalURL: "http://b.com"},
+	}, urls)
+
+	// deletion is allowed to editors and owners of the workspace
+	require.NoError(t, store.DeleteBatch(ctx, []string{"a", "c"}, "viewer"))
+	require.NoError(t, store.DeleteBatch(ctx, []string{"c"}, "editor"))
+	_, err = store.GetURL(ctx, "a")
+	assert.NoError(t, err)
+	_, err = store.GetURL(ctx, "c")
+	assert.NoError(t, err)
+
+	require.NoError(t, store.DeleteBatch(ctx, []string{"a"}, "editor"))
+	_, err = store.GetURL(ctx, "a")
+	var deleted *storage.URLDeletedError
+	assert.ErrorAs(t, err, &deleted)
+	// the link is still counted against its creator
+	count, _ := store.CountUserURLs(ctx, "owner")
+	assert.Equal(t, 1, count)
+
+	// removed members lose access
+	require.NoError(t, store.RemoveWorkspaceMember(ctx, "ws", "editor"))
+	require.NoError(t, store.DeleteBatch(ctx, []string{"b"}, "editor"))
+	_, err = store.GetURL(ctx, "b")
+	assert.NoError(t, err)
+}
@@ -2,6 +2,7 @@ package storage
 
 import (
 	"context"
+	"errors"
 
 	"github.com/sbxb/shorty/internal/app/url"
 )
@@ -12,6 +13,26 @@ type Storage interface {
 	AddBatchURL(ctx context.Context, batch []url.BatchURLEntry, userID string) error
 	GetURL(ctx context.Context, id string) (string, error)
 	GetUserURLs(ctx context.Context, userID string) ([]url.URLEntry, error)
+	// CountUserURLs returns the number of active (not deleted) urls that
+	// belong to the user
+	CountUserURLs(ctx context.Context, userID string) (int, error)
 	DeleteBatch(ctx context.Context, ids []string, userID string) error
 	Close() error
 }
+
+// IDScanner is implemented by storages which are able to enumerate all
+// the short ids they have (deleted ones included), fn might be called more
+// than once for the same id
+type IDScanner interface {
+	ScanIDs(ctx context.Context, fn func(id string)) error
+}
+
+// Pinger is implemented by storages which are able to check their connection
+// to a data store
+type Pinger interface {
+	Ping() error
+}
+
+// ErrPingNotSupported is returned by wrappers around storages which are not
+// Pingers
+var ErrPingNotSupported = errors.New("storage does not support ping")
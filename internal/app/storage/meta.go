@@ -0,0 +1,30 @@
+package storage
+
+import "context"
+
+// URLMeta is what the owner of a url tells about it, tags are unique
+type URLMeta struct {
+	Title string
+	Notes string
+	Tags  []string `json:",omitempty"`
+}
+
+// IsEmpty reports whether there is nothing to keep
+func (m URLMeta) IsEmpty() bool {
+	return m.Title == "" && m.Notes == "" && len(m.Tags) == 0
+}
+
+// URLMetaStorage is implemented by storages which are able to keep titles,
+// notes and tags of urls
+type URLMetaStorage interface {
+	// GetURLMeta returns the meta of the url created by the user, or
+	// ErrURLNotFound
+	GetURLMeta(ctx context.Context, id string, userID string) (URLMeta, error)
+	// SetURLMeta replaces the meta of the url created by the user, or
+	// returns ErrURLNotFound
+	SetURLMeta(ctx context.Context, id string, userID string, meta URLMeta) error
+	// GetUserURLMeta returns the non-empty meta of the user's urls by id
+	GetUserURLMeta(ctx context.Context, userID string) (map[string]URLMeta, error)
+	// GetUserTags returns the number of the user's urls per tag
+	GetUserTags(ctx context.Context, userID string) (map[string]int, error)
+}
@@ -0,0 +1,15 @@
+package storage
+
+import "context"
+
+// LinkPasswordStorage is implemented by storages which are able to keep
+// password hashes of urls, a url with a password is only followed by those
+// who know it
+type LinkPasswordStorage interface {
+	// GetURLPassword returns the password hash of the url, empty for urls
+	// without a password, or ErrURLNotFound for unknown urls
+	GetURLPassword(ctx context.Context, id string) (string, error)
+	// SetURLPassword sets the password hash of the url created by the user,
+	// an empty hash removes the password
+	SetURLPassword(ctx context.Context, id string, userID string, hash string) error
+}
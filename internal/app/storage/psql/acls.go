@@ -0,0 +1,108 @@
+package psql
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+
+	"github.com/sbxb/shorty/internal/app/storage"
+)
+
+const aclTable = "url_acls"
+
+// DBStorage implements ACLStorage interface
+var _ storage.ACLStorage = (*DBStorage)(nil)
+
+// createACLTable keeps non-public ACLs only, urls without a row are public
+func createACLTable(db *sql.DB, urlTable string) error {
+	ACLsTableQuery := `CREATE TABLE IF NOT EXISTS ` + aclTable + ` (
+		url_id VARCHAR(512) primary key REFERENCES ` + urlTable + ` (url_id) ON DELETE CASCADE,
+		visibility VARCHAR(16) NOT NULL,
+		user_ids TEXT NOT NULL DEFAULT '[]',
+		workspace_id VARCHAR(64) REFERENCES ` + workspaceTable + ` (id) ON DELETE CASCADE
+	)`
+	if _, err := db.Exec(ACLsTableQuery); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (st *DBStorage) GetACL(ctx context.Context, id string) (storage.ACL, string, error) {
+	GetACLQuery := `SELECT u.user_id, COALESCE(a.visibility, ''), COALESCE(a.user_ids, '[]'),
+		COALESCE(a.workspace_id, '') FROM ` + st.urlTable + ` u
+		LEFT JOIN ` + aclTable + ` a ON a.url_id=u.url_id WHERE u.url_id=$1`
+
+	var (
+		acl     storage.ACL
+		owner   string
+		userIDs string
+	)
+	// ACLs are read from the primary, so that a tightened ACL is enforced
+	// regardless of replication lag
+	err := st.db.QueryRowContext(ctx, GetACLQuery, id).Scan(&owner, &acl.Visibility, &userIDs, &acl.WorkspaceID)
+	if err == sql.ErrNoRows {
+		return storage.ACL{}, "", storage.ErrURLNotFound
+	}
+	if err != nil {
+		return storage.ACL{}, "", asUnavailable(fmt.Errorf("DBStorage: GetACL: %w", err))
+	}
+	if acl.Visibility == "" {
+		return storage.ACL{Visibility: storage.VisibilityPublic}, owner, nil
+	}
+	if err := json.Unmarshal([]byte(userIDs), &acl.UserIDs); err != nil {
+		return storage.ACL{}, "", fmt.Errorf("DBStorage: GetACL: %w", err)
+	}
+	if len(acl.UserIDs) == 0 {
+		acl.UserIDs = nil
+	}
+
+	return acl, owner, nil
+}
+
+// SetACL checks the owner and replaces the ACL within a transaction holding
+// the url row
+func (st *DBStorage) SetACL(ctx context.Context, id string, userID string, acl storage.ACL) error {
+	tx, err := st.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("DBStorage: SetACL: %w", err)
+	}
+	defer tx.Rollback()
+
+	var owner string
+	err = tx.QueryRowContext(ctx, `SELECT user_id FROM `+st.urlTable+`
+		WHERE url_id=$1 FOR UPDATE`, id).Scan(&owner)
+	if err == sql.ErrNoRows || (err == nil && owner != userID) {
+		return storage.ErrURLNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("DBStorage: SetACL: %w", err)
+	}
+
+	if acl.IsPublic() {
+		_, err = tx.ExecContext(ctx, `DELETE FROM `+aclTable+` WHERE url_id=$1`, id)
+	} else {
+		var userIDs []byte
+		if userIDs, err = json.Marshal(append([]string{}, acl.UserIDs...)); err != nil {
+			return fmt.Errorf("DBStorage: SetACL: %w", err)
+		}
+		_, err = tx.ExecContext(ctx, `INSERT INTO `+aclTable+` (url_id, visibility, user_ids, workspace_id)
+			VALUES($1, $2, $3, NULLIF($4, ''))
+			ON CONFLICT (url_id) DO UPDATE SET visibility=EXCLUDED.visibility,
+			user_ids=EXCLUDED.user_ids, workspace_id=EXCLUDED.workspace_id`,
+			id, acl.Visibility, string(userIDs), acl.WorkspaceID)
+	}
+	if err != nil {
+		return fmt.Errorf("DBStorage: SetACL: %w", err)
+	}
+	// other instances evict the cached ACL
+	if err := notifyChanged(ctx, tx, OpUpdate, []string{id}); err != nil {
+		return fmt.Errorf("DBStorage: SetACL: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("DBStorage: SetACL: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,107 @@
+package psql
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/sbxb/shorty/internal/app/storage"
+)
+
+const roleTable = "roles"
+
+// DBStorage implements RoleStorage and AdminStorage interfaces
+var (
+	_ storage.RoleStorage  = (*DBStorage)(nil)
+	_ storage.AdminStorage = (*DBStorage)(nil)
+)
+
+func createRoleTable(db *sql.DB) error {
+	RolesTableQuery := `CREATE TABLE IF NOT EXISTS ` + roleTable + ` (
+		user_id VARCHAR(512) primary key,
+		role VARCHAR(32) NOT NULL
+	)`
+
+	_, err := db.Exec(RolesTableQuery)
+
+	return err
+}
+
+// GetRole always reads from the primary, so that a revoked role is never
+// used
+func (st *DBStorage) GetRole(ctx context.Context, userID string) (string, error) {
+	GetRoleQuery := `SELECT role FROM ` + roleTable + ` WHERE user_id=$1`
+
+	var role string
+	err := st.db.QueryRowContext(ctx, GetRoleQuery, userID).Scan(&role)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("DBStorage: GetRole: %w", err)
+	}
+
+	return role, nil
+}
+
+func (st *DBStorage) SetRole(ctx context.Context, userID string, role string) error {
+	SetRoleQuery := `INSERT INTO ` + roleTable + `(user_id, role) VALUES($1, $2)
+		ON CONFLICT (user_id) DO UPDATE SET role=EXCLUDED.role`
+
+	if _, err := st.db.ExecContext(ctx, SetRoleQuery, userID, role); err != nil {
+		return fmt.Errorf("DBStorage: SetRole: %w", err)
+	}
+
+	return nil
+}
+
+// DisableURLs works as DeleteBatch for urls of any user
+func (st *DBStorage) DisableURLs(ctx context.Context, ids []string) (int, error) {
+	tx, err := st.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("DBStorage: DisableURLs: %w", err)
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare(`UPDATE ` + st.urlTable + ` SET deleted=true
+		WHERE url_id=$1 AND deleted=false`)
+	if err != nil {
+		return 0, fmt.Errorf("DBStorage: DisableURLs: %w", err)
+	}
+	defer stmt.Close()
+
+	disabled := make([]string, 0, len(ids))
+	for _, id := range ids {
+		result, err := stmt.Exec(id)
+		if err != nil {
+			return 0, fmt.Errorf("DBStorage: DisableURLs: %w", err)
+		}
+		if rows, _ := result.RowsAffected(); rows > 0 {
+			disabled = append(disabled, id)
+		}
+	}
+
+	if err := notifyChanged(ctx, tx, OpDelete, disabled); err != nil {
+		return 0, fmt.Errorf("DBStorage: DisableURLs: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("DBStorage: DisableURLs: %w", err)
+	}
+
+	return len(disabled), nil
+}
+
+// Stats are read from a replica if any
+func (st *DBStorage) Stats(ctx context.Context) (storage.Stats, error) {
+	var stats storage.Stats
+	err := st.read(ctx, func(db *sql.DB) error {
+		return db.QueryRowContext(ctx, `SELECT COUNT(*), COUNT(*) FILTER (WHERE deleted),
+			COUNT(DISTINCT user_id) FROM `+st.urlTable).
+			Scan(&stats.URLs, &stats.DeletedURLs, &stats.Users)
+	})
+	if err != nil {
+		return stats, fmt.Errorf("DBStorage: Stats: %w", err)
+	}
+
+	return stats, nil
+}
@@ -0,0 +1,146 @@
+package psql
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/sbxb/shorty/internal/app/storage"
+)
+
+const apiKeyTable = "api_keys"
+
+// DBStorage implements APIKeyStorage interface
+var _ storage.APIKeyStorage = (*DBStorage)(nil)
+
+func createAPIKeyTable(db *sql.DB) error {
+	APIKeysTableQuery := `CREATE TABLE IF NOT EXISTS ` + apiKeyTable + ` (
+		id VARCHAR(64) primary key,
+		user_id VARCHAR(512) NOT NULL,
+		name TEXT NOT NULL,
+		prefix VARCHAR(64) NOT NULL,
+		hash VARCHAR(128) NOT NULL,
+		created_at TIMESTAMPTZ NOT NULL,
+		last_used_at TIMESTAMPTZ,
+		uses BIGINT NOT NULL DEFAULT 0,
+		UNIQUE (hash)
+	)`
+
+	if _, err := db.Exec(APIKeysTableQuery); err != nil {
+		return err
+	}
+
+	APIKeysIndexQuery := `CREATE INDEX IF NOT EXISTS ` + apiKeyTable + `_user_id_idx ON ` +
+		apiKeyTable + ` (user_id)`
+	if _, err := db.Exec(APIKeysIndexQuery); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (st *DBStorage) AddAPIKey(ctx context.Context, key storage.APIKey) error {
+	AddAPIKeyQuery := `INSERT INTO ` + apiKeyTable + `(id, user_id, name, prefix, hash, created_at)
+		VALUES($1, $2, $3, $4, $5, $6)`
+
+	_, err := st.db.ExecContext(ctx, AddAPIKeyQuery,
+		key.ID, key.UserID, key.Name, key.Prefix, key.Hash, key.CreatedAt)
+	if err != nil {
+		if strings.Contains(err.Error(), "SQLSTATE 23505") {
+			return storage.NewIDConflictError(key.ID)
+		}
+		return fmt.Errorf("DBStorage: AddAPIKey: %w", err)
+	}
+
+	return nil
+}
+
+// GetAPIKeyByHash always reads from the primary, so that a just revoked
+// key is never accepted
+func (st *DBStorage) GetAPIKeyByHash(ctx context.Context, hash string) (storage.APIKey, error) {
+	GetAPIKeyQuery := `SELECT id, user_id, name, prefix, hash, created_at, last_used_at, uses
+		FROM ` + apiKeyTable + ` WHERE hash=$1`
+
+	key, err := scanAPIKey(st.db.QueryRowContext(ctx, GetAPIKeyQuery, hash))
+	if err == sql.ErrNoRows {
+		return key, storage.ErrAPIKeyNotFound
+	}
+	if err != nil {
+		return key, fmt.Errorf("DBStorage: GetAPIKeyByHash: %w", err)
+	}
+
+	return key, nil
+}
+
+// GetUserAPIKeys returns the user's keys, the oldest first
+func (st *DBStorage) GetUserAPIKeys(ctx context.Context, userID string) ([]storage.APIKey, error) {
+	GetUserAPIKeysQuery := `SELECT id, user_id, name, prefix, hash, created_at, last_used_at, uses
+		FROM ` + apiKeyTable + ` WHERE user_id=$1 ORDER BY created_at`
+
+	rows, err := st.db.QueryContext(ctx, GetUserAPIKeysQuery, userID)
+	if err != nil {
+		return nil, fmt.Errorf("DBStorage: GetUserAPIKeys: %w", err)
+	}
+	defer rows.Close()
+
+	res := []storage.APIKey{}
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, fmt.Errorf("DBStorage: GetUserAPIKeys: %w", err)
+		}
+		res = append(res, key)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("DBStorage: GetUserAPIKeys: %w", err)
+	}
+
+	return res, nil
+}
+
+func (st *DBStorage) DeleteAPIKey(ctx context.Context, id string, userID string) error {
+	DeleteAPIKeyQuery := `DELETE FROM ` + apiKeyTable + ` WHERE id=$1 AND user_id=$2`
+
+	result, err := st.db.ExecContext(ctx, DeleteAPIKeyQuery, id, userID)
+	if err != nil {
+		return fmt.Errorf("DBStorage: DeleteAPIKey: %w", err)
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		return storage.ErrAPIKeyNotFound
+	}
+
+	return nil
+}
+
+func (st *DBStorage) TouchAPIKey(ctx context.Context, id string, usedAt time.Time) error {
+	TouchAPIKeyQuery := `UPDATE ` + apiKeyTable + ` SET last_used_at=$2, uses=uses+1
+		WHERE id=$1`
+
+	result, err := st.db.ExecContext(ctx, TouchAPIKeyQuery, id, usedAt)
+	if err != nil {
+		return fmt.Errorf("DBStorage: TouchAPIKey: %w", err)
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		return storage.ErrAPIKeyNotFound
+	}
+
+	return nil
+}
+
+// rowScanner is implemented by both *sql.Row and *sql.Rows
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanAPIKey(row rowScanner) (storage.APIKey, error) {
+	var key storage.APIKey
+	var lastUsedAt sql.NullTime
+
+	err := row.Scan(&key.ID, &key.UserID, &key.Name, &key.Prefix, &key.Hash,
+		&key.CreatedAt, &lastUsedAt, &key.Uses)
+	key.LastUsedAt = lastUsedAt.Time
+
+	return key, err
+}
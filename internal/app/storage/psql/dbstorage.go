@@ -3,10 +3,12 @@ package psql
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"strings"
 	"time"
 
+	"github.com/sbxb/shorty/internal/app/logger"
 	"github.com/sbxb/shorty/internal/app/storage"
 	"github.com/sbxb/shorty/internal/app/url"
 
@@ -15,9 +17,13 @@ import (
 
 // DBStorage defines a database storage implemented as a wrapper
 // around any database/sql implementation
+// Writes always go to the primary database, GetURL and GetUserURLs are
+// load-balanced across healthy replicas (if any)
 type DBStorage struct {
-	db       *sql.DB
-	urlTable string
+	db           *sql.DB
+	replicas     *replicaSet
+	urlTable     string
+	maxUserLinks int
 }
 
 // DBStorage implements Storage interface
@@ -27,32 +33,109 @@ var _ storage.Storage = (*DBStorage)(nil)
 // is considered unavailable
 const pingTimeout = 2 * time.Second
 
-func NewDBStorage(dsn string) (*DBStorage, error) {
+// maxConnectBackoff limits the delay between startup connection attempts
+const maxConnectBackoff = 30 * time.Second
+
+// Option configures optional DBStorage features
+type Option func(*options)
+
+type options struct {
+	replicaDSNs    []string
+	connectRetries int
+	connectBackoff time.Duration
+	maxUserLinks   int
+}
+
+// WithReplicas sets read-only replicas to serve GetURL and GetUserURLs
+func WithReplicas(dsns ...string) Option {
+	return func(o *options) {
+		o.replicaDSNs = append(o.replicaDSNs, dsns...)
+	}
+}
+
+// WithConnectRetry makes NewDBStorage retry the initial ping up to retries
+// times, the delay between attempts starts with backoff and doubles every
+// time up to maxConnectBackoff
+func WithConnectRetry(retries int, backoff time.Duration) Option {
+	return func(o *options) {
+		o.connectRetries = retries
+		o.connectBackoff = backoff
+	}
+}
+
+// WithMaxUserLinks limits the number of active urls per user, 0 means
+// no limit. Writes of the same user are serialized with an advisory lock,
+// so that concurrent requests can't exceed the limit
+func WithMaxUserLinks(n int) Option {
+	return func(o *options) {
+		o.maxUserLinks = n
+	}
+}
+
+func NewDBStorage(dsn string, opts ...Option) (*DBStorage, error) {
 	if dsn == "" {
 		return nil, fmt.Errorf("DBStorage: empty dsn")
 	}
 
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+
 	db, err := sql.Open("pgx", dsn)
 	if err != nil {
-		return nil, fmt.Errorf("DBStorage: Open: %v", err)
+		return nil, fmt.Errorf("DBStorage: Open: %w", err)
 	}
 
-	// ping the database before returning DBStorage instance
-	ctx, cancel := context.WithTimeout(context.Background(), pingTimeout)
-	defer cancel()
-	if err := db.PingContext(ctx); err != nil {
+	// ping the database before returning DBStorage instance, the database
+	// might be starting up as well, so give it a few more tries if allowed
+	if err := pingWithRetry(db, o.connectRetries, o.connectBackoff); err != nil {
 		db.Close()
-		return nil, fmt.Errorf("DBStorage: Ping: %v", err)
+		return nil, fmt.Errorf("DBStorage: Ping: %w", err)
 	}
 
 	// create all the necessary tables in the database
-	urlTable := "urls"
+	urlTable := defaultURLTable
 	if err := createTables(db, urlTable); err != nil {
 		db.Close()
-		return nil, fmt.Errorf("DBStorage: Create Tables: %v", err)
+		return nil, fmt.Errorf("DBStorage: Create Tables: %w", err)
 	}
 
-	return &DBStorage{db: db, urlTable: urlTable}, nil
+	replicas, err := newReplicaSet(o.replicaDSNs)
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("DBStorage: %w", err)
+	}
+	replicas.run(replicaCheckInterval)
+
+	return &DBStorage{
+		db:           db,
+		replicas:     replicas,
+		urlTable:     urlTable,
+		maxUserLinks: o.maxUserLinks,
+	}, nil
+}
+
+// defaultURLTable keeps urls, Listener reads it to catch up after a gap
+const defaultURLTable = "urls"
+
+func pingWithRetry(db *sql.DB, retries int, backoff time.Duration) error {
+	for attempt := 0; ; attempt++ {
+		ctx, cancel := context.WithTimeout(context.Background(), pingTimeout)
+		err := db.PingContext(ctx)
+		cancel()
+
+		if err == nil || attempt >= retries {
+			return err
+		}
+		logger.Warningf("DBStorage: Ping failed (attempt %d of %d), retrying in %v: %v",
+			attempt+1, retries+1, backoff, err)
+		time.Sleep(backoff)
+
+		if backoff *= 2; backoff > maxConnectBackoff {
+			backoff = maxConnectBackoff
+		}
+	}
 }
 
 func createTables(db *sql.DB, urlTable string) error {
@@ -69,7 +152,50 @@ func createTables(db *sql.DB, urlTable string) error {
 		return err
 	}
 
-	return nil
+	// user's urls are listed and counted
+	UserIndexQuery := `CREATE INDEX IF NOT EXISTS ` + urlTable + `_user_id_idx ON ` +
+		urlTable + ` (user_id)`
+	if _, err := db.Exec(UserIndexQuery); err != nil {
+		return err
+	}
+
+	if err := createAPIKeyTable(db); err != nil {
+		return err
+	}
+
+	if err := createUserTable(db); err != nil {
+		return err
+	}
+
+	if err := createRecoveryCodeTable(db); err != nil {
+		return err
+	}
+
+	if err := createRoleTable(db); err != nil {
+		return err
+	}
+
+	if err := createWorkspaceTables(db, urlTable); err != nil {
+		return err
+	}
+
+	if err := createTransferTable(db); err != nil {
+		return err
+	}
+
+	if err := createACLTable(db, urlTable); err != nil {
+		return err
+	}
+
+	if err := addPasswordColumn(db, urlTable); err != nil {
+		return err
+	}
+
+	if err := createHistoryTable(db, urlTable); err != nil {
+		return err
+	}
+
+	return createMetaTables(db, urlTable)
 }
 
 // tests use Truncate() to reset changes
@@ -87,57 +213,151 @@ func (st *DBStorage) AddURL(ctx context.Context, ue url.URLEntry, userID string)
 	AddURLQuery := `INSERT INTO ` + st.urlTable + `(url_id, user_id, original_url) 
 		VALUES($1, $2, $3)`
 
-	result, err := st.db.ExecContext(ctx, AddURLQuery, ue.ShortURL, userID, ue.OriginalURL)
+	// the quota is checked within a transaction holding the user's lock
+	var q querier = st.db
+	if st.maxUserLinks > 0 {
+		tx, err := st.beginUserTx(ctx, userID)
+		if err != nil {
+			return fmt.Errorf("DBStorage: AddURL: %w", err)
+		}
+		defer tx.Rollback()
+		q = tx
+	}
+
+	result, err := q.ExecContext(ctx, AddURLQuery, ue.ShortURL, userID, ue.OriginalURL)
 	if err != nil {
 		if strings.Contains(err.Error(), "SQLSTATE 23505") {
 			return storage.NewIDConflictError(ue.ShortURL)
 		}
-		return fmt.Errorf("DBStorage: AddURL: %v", err)
+		return fmt.Errorf("DBStorage: AddURL: %w", err)
 	}
 	rows, err := result.RowsAffected()
 	if err != nil {
-		return fmt.Errorf("DBStorage: AddURL: %v", err)
+		return fmt.Errorf("DBStorage: AddURL: %w", err)
 	}
 	if rows != 1 {
 		return fmt.Errorf("DBStorage: AddURL: expected to affect 1 row, affected %d", rows)
 	}
 
+	if tx, ok := q.(*sql.Tx); ok {
+		if err := st.checkQuota(ctx, tx, userID); err != nil {
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return fmt.Errorf("DBStorage: AddURL: %w", err)
+		}
+	}
+
+	// the url is stored already, so a failed notification is not an error,
+	// listeners will catch up on reconnection
+	if err := notifyChanged(ctx, st.db, OpCreate, []string{ue.ShortURL}); err != nil {
+		logger.Warningf("DBStorage: AddURL: %v", err)
+	}
+
 	return nil
 }
 
 func (st *DBStorage) AddBatchURL(ctx context.Context, batch []url.BatchURLEntry, userID string) error {
-	tx, err := st.db.BeginTx(ctx, nil)
+	tx, err := st.beginUserTx(ctx, userID)
 	if err != nil {
-		return fmt.Errorf("DBStorage: AddBatchURL: %v", err)
+		return fmt.Errorf("DBStorage: AddBatchURL: %w", err)
 	}
 	defer tx.Rollback()
 
 	stmt, err := tx.Prepare(`INSERT INTO ` + st.urlTable + `(url_id, user_id, original_url)
 		VALUES($1, $2, $3) ON CONFLICT(url_id) DO NOTHING`)
 	if err != nil {
-		return fmt.Errorf("DBStorage: AddBatchURL: %v", err)
+		return fmt.Errorf("DBStorage: AddBatchURL: %w", err)
 	}
 	defer stmt.Close()
 
+	ids := make([]string, 0, len(batch))
 	for _, e := range batch {
 		if _, err = stmt.Exec(e.ShortURL, userID, e.OriginalURL); err != nil {
-			return fmt.Errorf("DBStorage: AddBatchURL: %v", err)
+			return fmt.Errorf("DBStorage: AddBatchURL: %w", err)
 		}
+		ids = append(ids, e.ShortURL)
+	}
+
+	if err := st.checkQuota(ctx, tx, userID); err != nil {
+		return err
+	}
+
+	if err := notifyChanged(ctx, tx, OpCreate, ids); err != nil {
+		return fmt.Errorf("DBStorage: AddBatchURL: %w", err)
 	}
 
 	return tx.Commit()
 }
 
+// querier is implemented by both *sql.DB and *sql.Tx
+type querier interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// beginUserTx begins a transaction, if the user's quota is limited the
+// transaction takes the user's lock released on commit or rollback
+func (st *DBStorage) beginUserTx(ctx context.Context, userID string) (*sql.Tx, error) {
+	tx, err := st.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if st.maxUserLinks > 0 {
+		if _, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock(hashtext($1))`, userID); err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+	}
+
+	return tx, nil
+}
+
+// checkQuota fails with storage.QuotaExceededError if the urls added within
+// tx exceed the user's quota
+func (st *DBStorage) checkQuota(ctx context.Context, tx *sql.Tx, userID string) error {
+	if st.maxUserLinks == 0 {
+		return nil
+	}
+
+	count, err := countUserURLs(ctx, tx, st.urlTable, userID)
+	if err != nil {
+		return fmt.Errorf("DBStorage: checkQuota: %w", err)
+	}
+	if count > st.maxUserLinks {
+		return storage.NewQuotaExceededError(st.maxUserLinks)
+	}
+
+	return nil
+}
+
+func countUserURLs(ctx context.Context, q querier, urlTable string, userID string) (int, error) {
+	var count int
+	err := q.QueryRowContext(ctx, `SELECT COUNT(*) FROM `+urlTable+` WHERE
+		user_id=$1 AND deleted=false`, userID).Scan(&count)
+
+	return count, err
+}
+
 // GetURL searches for url by its id
 // Returns url found or an empty string for a nonexistent id (valid url is
 // never an empty string)
+// Ids missing on a replica are looked up on the primary, since they may
+// have just been created
 func (st *DBStorage) GetURL(ctx context.Context, id string) (string, error) {
 	var url string
 	var deleted bool
 
 	GetURLQuery := `SELECT original_url, deleted FROM ` + st.urlTable + ` WHERE 
 		url_id=$1`
-	err := st.db.QueryRowContext(ctx, GetURLQuery, id).Scan(&url, &deleted)
+	query := func(db *sql.DB) error {
+		return db.QueryRowContext(ctx, GetURLQuery, id).Scan(&url, &deleted)
+	}
+	err := st.read(ctx, query)
+	if err == sql.ErrNoRows && !storage.IsPrimaryRead(ctx) && len(st.replicas.replicas) > 0 {
+		err = query(st.db)
+	}
 
 	switch {
 	case deleted:
@@ -145,7 +365,7 @@ func (st *DBStorage) GetURL(ctx context.Context, id string) (string, error) {
 	case err == sql.ErrNoRows:
 		return "", nil
 	case err != nil:
-		return "", fmt.Errorf("DBStorage: GetURL: %v", err)
+		return "", fmt.Errorf("DBStorage: GetURL: %w", err)
 	default:
 		return url, nil
 	}
@@ -153,54 +373,128 @@ func (st *DBStorage) GetURL(ctx context.Context, id string) (string, error) {
 
 // GetUserURLs returns urls that belong to a particular user identified by userID
 func (st *DBStorage) GetUserURLs(ctx context.Context, userID string) ([]url.URLEntry, error) {
-	res := []url.URLEntry{}
+	var res []url.URLEntry
 
-	GetUserURLsQuery := `SELECT url_id, original_url FROM ` + st.urlTable + ` WHERE 
+	err := st.read(ctx, func(db *sql.DB) error {
+		res = []url.URLEntry{}
+		return getUserURLs(ctx, db, st.urlTable, userID, &res)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("DBStorage: GetUserURLs: %w", err)
+	}
+
+	return res, nil
+}
+
+func getUserURLs(ctx context.Context, db *sql.DB, urlTable string, userID string, res *[]url.URLEntry) error {
+	GetUserURLsQuery := `SELECT url_id, original_url FROM ` + urlTable + ` WHERE 
 		user_id=$1`
 
-	rows, err := st.db.QueryContext(ctx, GetUserURLsQuery, userID)
+	rows, err := db.QueryContext(ctx, GetUserURLsQuery, userID)
 	if err != nil {
-		return nil, fmt.Errorf("DBStorage: GetUserURLs: %v", err)
+		return err
 	}
 	defer rows.Close()
 
 	for rows.Next() {
 		var u url.URLEntry
-		err = rows.Scan(&u.ShortURL, &u.OriginalURL)
-		if err != nil {
-			return nil, fmt.Errorf("DBStorage: GetUserURLs: %v", err)
+		if err := rows.Scan(&u.ShortURL, &u.OriginalURL); err != nil {
+			return err
 		}
-		res = append(res, u)
+		*res = append(*res, u)
 	}
 
-	err = rows.Err()
+	return rows.Err()
+}
+
+// CountUserURLs returns the number of active urls of the user, it always
+// reads from the primary, since the result is compared against the quota
+func (st *DBStorage) CountUserURLs(ctx context.Context, userID string) (int, error) {
+	count, err := countUserURLs(ctx, st.db, st.urlTable, userID)
 	if err != nil {
-		return nil, fmt.Errorf("DBStorage: GetUserURLs: %v", err)
+		return 0, fmt.Errorf("DBStorage: CountUserURLs: %w", err)
 	}
 
-	return res, nil
+	return count, nil
+}
+
+// read runs a read-only query against a healthy replica falling back to
+// the primary if ctx requires read-your-writes, no replica is healthy or
+// the replica failed to run the query
+func (st *DBStorage) read(ctx context.Context, query func(db *sql.DB) error) error {
+	if !storage.IsPrimaryRead(ctx) {
+		if r := st.replicas.pick(); r != nil {
+			err := query(r.db)
+			if err == nil || errors.Is(err, sql.ErrNoRows) || ctx.Err() != nil {
+				return err
+			}
+			logger.Warningf("DBStorage: replica %s failed, retrying on primary: %v", r.name, err)
+			go r.check()
+		}
+	}
+
+	return query(st.db)
 }
 
+// ScanIDs calls fn for every id stored
+func (st *DBStorage) ScanIDs(ctx context.Context, fn func(id string)) error {
+	err := st.read(ctx, func(db *sql.DB) error {
+		rows, err := db.QueryContext(ctx, `SELECT url_id FROM `+st.urlTable)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var id string
+			if err := rows.Scan(&id); err != nil {
+				return err
+			}
+			fn(id)
+		}
+
+		return rows.Err()
+	})
+	if err != nil {
+		return fmt.Errorf("DBStorage: ScanIDs: %w", err)
+	}
+
+	return nil
+}
+
+// DeleteBatch marks as deleted urls created by the user as well as urls of
+// workspaces where the user is an editor or an owner
 func (st *DBStorage) DeleteBatch(ctx context.Context, ids []string, userID string) error {
 	tx, err := st.db.BeginTx(ctx, nil)
 	if err != nil {
-		return fmt.Errorf("DBStorage: DeleteBatch: %v", err)
+		return fmt.Errorf("DBStorage: DeleteBatch: %w", err)
 	}
 	defer tx.Rollback()
 
 	stmt, err := tx.Prepare(`UPDATE ` + st.urlTable + ` SET deleted=true
-		WHERE url_id=$1 AND user_id=$2 AND deleted=false`)
+		WHERE url_id=$1 AND deleted=false AND (user_id=$2 OR workspace_id IN (
+			SELECT workspace_id FROM ` + workspaceMemberTable + `
+			WHERE user_id=$2 AND role IN ($3, $4)))`)
 	if err != nil {
-		return fmt.Errorf("DBStorage: DeleteBatch: %v", err)
+		return fmt.Errorf("DBStorage: DeleteBatch: %w", err)
 	}
 	defer stmt.Close()
 
+	deleted := make([]string, 0, len(ids))
 	for _, id := range ids {
-		if _, err = stmt.Exec(id, userID); err != nil {
-			return fmt.Errorf("DBStorage: DeleteBatch: %v", err)
+		result, err := stmt.Exec(id, userID, storage.WorkspaceEditor, storage.WorkspaceOwner)
+		if err != nil {
+			return fmt.Errorf("DBStorage: DeleteBatch: %w", err)
+		}
+		if rows, _ := result.RowsAffected(); rows > 0 {
+			deleted = append(deleted, id)
 		}
 	}
 
+	if err := notifyChanged(ctx, tx, OpDelete, deleted); err != nil {
+		return fmt.Errorf("DBStorage: DeleteBatch: %w", err)
+	}
+
 	return tx.Commit()
 }
 
@@ -210,7 +504,7 @@ func (st *DBStorage) Ping() error {
 	defer cancel()
 
 	if err := st.db.PingContext(ctx); err != nil {
-		return fmt.Errorf("DBStorage: %v", err)
+		return fmt.Errorf("DBStorage: %w", err)
 	}
 
 	return nil
@@ -221,6 +515,10 @@ func (st *DBStorage) Close() error {
 		return nil
 	}
 
+	if err := st.replicas.close(); err != nil {
+		logger.Warningf("DBStorage: failed to close replicas: %v", err)
+	}
+
 	if err := st.db.Close(); err != nil {
 		return err
 	}
@@ -0,0 +1,59 @@
+package psql
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgconn"
+
+	"github.com/sbxb/shorty/internal/app/storage"
+)
+
+// unavailableRetryAfter is suggested to clients when the database could
+// not be reached
+const unavailableRetryAfter = 5 * time.Second
+
+// IsConnectionError reports whether err means the database could not be
+// reached (as opposed to errors caused by the query itself)
+func IsConnectionError(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) {
+		return false
+	}
+
+	if errors.Is(err, driver.ErrBadConn) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, context.DeadlineExceeded) ||
+		pgconn.Timeout(err) {
+		return true
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+
+	// Class 08 - Connection Exception, 57P01..57P03 - the server is shutting
+	// down or starting up
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		return strings.HasPrefix(pgErr.Code, "08") ||
+			pgErr.Code == "57P01" || pgErr.Code == "57P02" || pgErr.Code == "57P03"
+	}
+
+	return false
+}
+
+// asUnavailable replaces connection errors with storage.UnavailableError,
+// it's meant for calls made on every redirect which bypass the breaker
+func asUnavailable(err error) error {
+	if IsConnectionError(err) {
+		return storage.NewUnavailableError(unavailableRetryAfter)
+	}
+
+	return err
+}
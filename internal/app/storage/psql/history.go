@@ -0,0 +1,116 @@
+package psql
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/sbxb/shorty/internal/app/storage"
+)
+
+const historyTable = "url_history"
+
+// DBStorage implements URLHistoryStorage interface
+var _ storage.URLHistoryStorage = (*DBStorage)(nil)
+
+// createHistoryTable keeps previous versions of urls, the current one is
+// in the url table
+func createHistoryTable(db *sql.DB, urlTable string) error {
+	HistoryTableQuery := `CREATE TABLE IF NOT EXISTS ` + historyTable + ` (
+		url_id VARCHAR(512) NOT NULL REFERENCES ` + urlTable + ` (url_id) ON DELETE CASCADE,
+		version INT NOT NULL,
+		original_url TEXT NOT NULL,
+		replaced_at TIMESTAMPTZ NOT NULL,
+		PRIMARY KEY (url_id, version)
+	)`
+	_, err := db.Exec(HistoryTableQuery)
+
+	return err
+}
+
+// UpdateURL moves the current url to the history within a transaction
+// holding the url row, other instances are notified to evict it
+func (st *DBStorage) UpdateURL(ctx context.Context, id string, userID string, newURL string, at time.Time) (int, error) {
+	tx, err := st.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("DBStorage: UpdateURL: %w", err)
+	}
+	defer tx.Rollback()
+
+	var (
+		owner, current string
+		deleted        bool
+	)
+	err = tx.QueryRowContext(ctx, `SELECT user_id, original_url, deleted FROM `+st.urlTable+`
+		WHERE url_id=$1 FOR UPDATE`, id).Scan(&owner, &current, &deleted)
+	if err == sql.ErrNoRows || (err == nil && owner != userID) {
+		return 0, storage.ErrURLNotFound
+	}
+	if err != nil {
+		return 0, fmt.Errorf("DBStorage: UpdateURL: %w", err)
+	}
+	if deleted {
+		return 0, storage.NewURLDeletedError(id)
+	}
+
+	var previous int
+	err = tx.QueryRowContext(ctx, `SELECT COUNT(*) FROM `+historyTable+` WHERE url_id=$1`, id).Scan(&previous)
+	if err != nil {
+		return 0, fmt.Errorf("DBStorage: UpdateURL: %w", err)
+	}
+	if current == newURL {
+		return previous + 1, nil
+	}
+
+	_, err = tx.ExecContext(ctx, `INSERT INTO `+historyTable+` (url_id, version, original_url, replaced_at)
+		VALUES($1, $2, $3, $4)`, id, previous+1, current, at)
+	if err != nil {
+		return 0, fmt.Errorf("DBStorage: UpdateURL: %w", err)
+	}
+	_, err = tx.ExecContext(ctx, `UPDATE `+st.urlTable+` SET original_url=$1 WHERE url_id=$2`, newURL, id)
+	if err != nil {
+		return 0, fmt.Errorf("DBStorage: UpdateURL: %w", err)
+	}
+	if err := notifyChanged(ctx, tx, OpUpdate, []string{id}); err != nil {
+		return 0, fmt.Errorf("DBStorage: UpdateURL: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("DBStorage: UpdateURL: %w", err)
+	}
+
+	return previous + 2, nil
+}
+
+func (st *DBStorage) GetURLHistory(ctx context.Context, id string, userID string) ([]storage.URLVersion, error) {
+	var current string
+	err := st.db.QueryRowContext(ctx, `SELECT original_url FROM `+st.urlTable+`
+		WHERE url_id=$1 AND user_id=$2`, id, userID).Scan(&current)
+	if err == sql.ErrNoRows {
+		return nil, storage.ErrURLNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("DBStorage: GetURLHistory: %w", err)
+	}
+
+	rows, err := st.db.QueryContext(ctx, `SELECT version, original_url, replaced_at FROM `+historyTable+`
+		WHERE url_id=$1 ORDER BY version`, id)
+	if err != nil {
+		return nil, fmt.Errorf("DBStorage: GetURLHistory: %w", err)
+	}
+	defer rows.Close()
+
+	res := []storage.URLVersion{}
+	for rows.Next() {
+		var v storage.URLVersion
+		if err := rows.Scan(&v.Version, &v.URL, &v.ReplacedAt); err != nil {
+			return nil, fmt.Errorf("DBStorage: GetURLHistory: %w", err)
+		}
+		res = append(res, v)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("DBStorage: GetURLHistory: %w", err)
+	}
+
+	return append(res, storage.URLVersion{Version: len(res) + 1, URL: current}), nil
+}
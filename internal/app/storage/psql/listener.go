@@ -0,0 +1,187 @@
+package psql
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/sbxb/shorty/internal/app/logger"
+)
+
+// Invalidator is a local cache to be kept in sync with the database
+type Invalidator interface {
+	// Evict removes changed or deleted ids from the cache
+	Evict(ids ...string)
+	// Flush removes everything from the cache, it's called whenever
+	// some notifications might have been missed
+	Flush()
+}
+
+// Adder is an Invalidator which wants to know about created ids as well,
+// after a gap it's given the ids created meanwhile rather than flushed,
+// since flushing it means scanning the whole table
+type Adder interface {
+	Add(ids ...string)
+}
+
+// reconnection delay doubles after every failed attempt up to its maximum
+const (
+	minReconnectDelay = 1 * time.Second
+	maxReconnectDelay = 30 * time.Second
+)
+
+// Listener listens for url changes published by DBStorage instances (this
+// one included) and passes them to local caches
+type Listener struct {
+	dsn    string
+	caches []Invalidator
+
+	listened bool  // LISTEN succeeded at least once
+	lastID   int64 // the last url row known when LISTEN succeeded
+}
+
+// NewListener returns a listener for caches, they are expected to have
+// just been filled from the database
+func NewListener(dsn string, caches ...Invalidator) *Listener {
+	return &Listener{dsn: dsn, caches: caches}
+}
+
+// Run listens until ctx is done, reconnecting whenever the connection is
+// lost. Notifications sent while disconnected are lost, so every time
+// LISTEN succeeds again the caches catch up (see catchUp)
+func (l *Listener) Run(ctx context.Context) {
+	delay := minReconnectDelay
+
+	for {
+		err := l.listen(ctx, func(conn *pgx.Conn) error {
+			if err := l.onListen(ctx, conn); err != nil {
+				return err
+			}
+			delay = minReconnectDelay
+			return nil
+		})
+		if ctx.Err() != nil {
+			return
+		}
+		logger.Warningf("Listener: %v, reconnecting in %v", err, delay)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+
+		if delay *= 2; delay > maxReconnectDelay {
+			delay = maxReconnectDelay
+		}
+	}
+}
+
+// listen connects and processes notifications until an error occurs,
+// onListen is called as soon as LISTEN succeeded
+func (l *Listener) listen(ctx context.Context, onListen func(conn *pgx.Conn) error) error {
+	conn, err := pgx.Connect(ctx, l.dsn)
+	if err != nil {
+		return err
+	}
+	defer conn.Close(context.Background())
+
+	if _, err := conn.Exec(ctx, "LISTEN "+notifyChannel); err != nil {
+		return err
+	}
+	if err := onListen(conn); err != nil {
+		return err
+	}
+
+	for {
+		n, err := conn.WaitForNotification(ctx)
+		if err != nil {
+			return err
+		}
+
+		var event ChangeEvent
+		if err := json.Unmarshal([]byte(n.Payload), &event); err != nil {
+			logger.Warningf("Listener: bad payload %q, flushing caches: %v", n.Payload, err)
+			l.flush()
+			continue
+		}
+		logger.Debugf("Listener: %s %v", event.Op, event.IDs)
+		l.dispatch(event)
+	}
+}
+
+// onListen remembers the last url row, nothing was missed on the first
+// LISTEN, otherwise the caches catch up with the rows created since the
+// previous LISTEN
+func (l *Listener) onListen(ctx context.Context, conn *pgx.Conn) error {
+	if !l.listened {
+		if err := conn.QueryRow(ctx, `SELECT COALESCE(MAX(id), 0) FROM `+defaultURLTable).Scan(&l.lastID); err != nil {
+			return err
+		}
+		l.listened = true
+		logger.Info("Listener: listening")
+		return nil
+	}
+
+	rows, err := conn.Query(ctx, `SELECT id, url_id FROM `+defaultURLTable+` WHERE id>$1`, l.lastID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	lastID := l.lastID
+	var created []string
+	for rows.Next() {
+		var (
+			id    int64
+			urlID string
+		)
+		if err := rows.Scan(&id, &urlID); err != nil {
+			return err
+		}
+		if id > lastID {
+			lastID = id
+		}
+		created = append(created, urlID)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	logger.Infof("Listener: listening again, %d urls created meanwhile", len(created))
+	l.catchUp(created)
+	l.lastID = lastID
+
+	return nil
+}
+
+// catchUp passes ids created while disconnected to Adders and flushes the
+// other caches, since changes and deletions can't be told
+func (l *Listener) catchUp(created []string) {
+	for _, cache := range l.caches {
+		if adder, ok := cache.(Adder); ok {
+			if len(created) > 0 {
+				adder.Add(created...)
+			}
+		} else {
+			cache.Flush()
+		}
+	}
+}
+
+func (l *Listener) dispatch(event ChangeEvent) {
+	for _, cache := range l.caches {
+		if event.Op != OpCreate {
+			cache.Evict(event.IDs...)
+		} else if adder, ok := cache.(Adder); ok {
+			adder.Add(event.IDs...)
+		}
+	}
+}
+
+func (l *Listener) flush() {
+	for _, cache := range l.caches {
+		cache.Flush()
+	}
+}
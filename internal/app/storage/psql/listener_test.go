@@ -0,0 +1,40 @@
+package psql
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// testCache records what the listener asks it to do
+type testCache struct {
+	evicted []string
+	flushes int
+}
+
+func (c *testCache) Evict(ids ...string) { c.evicted = append(c.evicted, ids...) }
+func (c *testCache) Flush()              { c.flushes++ }
+
+type testFilter struct {
+	testCache
+	added []string
+}
+
+func (f *testFilter) Add(ids ...string) { f.added = append(f.added, ids...) }
+
+func TestListener_CatchUp(t *testing.T) {
+	cache := &testCache{}
+	filter := &testFilter{}
+	l := NewListener("", cache, filter)
+
+	// filters are only given the ids created meanwhile, never rebuilt
+	l.catchUp([]string{"a", "b"})
+	assert.Equal(t, 1, cache.flushes)
+	assert.Equal(t, []string{"a", "b"}, filter.added)
+	assert.Zero(t, filter.flushes)
+
+	l.dispatch(ChangeEvent{Op: OpCreate, IDs: []string{"c"}})
+	l.dispatch(ChangeEvent{Op: OpDelete, IDs: []string{"a"}})
+	assert.Equal(t, []string{"a", "b", "c"}, filter.added)
+	assert.Equal(t, []string{"a"}, cache.evicted)
+}
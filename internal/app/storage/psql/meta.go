@@ -0,0 +1,176 @@
+package psql
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/sbxb/shorty/internal/app/storage"
+)
+
+const tagTable = "url_tags"
+
+// DBStorage implements URLMetaStorage interface
+var _ storage.URLMetaStorage = (*DBStorage)(nil)
+
+// createMetaTables adds title and notes columns to the url table and keeps
+// tags in their own table, so that urls are counted per tag
+func createMetaTables(db *sql.DB, urlTable string) error {
+	MetaColumnsQuery := `ALTER TABLE ` + urlTable + `
+		ADD COLUMN IF NOT EXISTS title TEXT NOT NULL DEFAULT '',
+		ADD COLUMN IF NOT EXISTS notes TEXT NOT NULL DEFAULT ''`
+	if _, err := db.Exec(MetaColumnsQuery); err != nil {
+		return err
+	}
+
+	TagsTableQuery := `CREATE TABLE IF NOT EXISTS ` + tagTable + ` (
+		url_id VARCHAR(512) NOT NULL REFERENCES ` + urlTable + ` (url_id) ON DELETE CASCADE,
+		tag VARCHAR(64) NOT NULL,
+		PRIMARY KEY (url_id, tag)
+	)`
+	if _, err := db.Exec(TagsTableQuery); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (st *DBStorage) GetURLMeta(ctx context.Context, id string, userID string) (storage.URLMeta, error) {
+	var meta storage.URLMeta
+	err := st.db.QueryRowContext(ctx, `SELECT title, notes FROM `+st.urlTable+`
+		WHERE url_id=$1 AND user_id=$2`, id, userID).Scan(&meta.Title, &meta.Notes)
+	if err == sql.ErrNoRows {
+		return meta, storage.ErrURLNotFound
+	}
+	if err != nil {
+		return meta, fmt.Errorf("DBStorage: GetURLMeta: %w", err)
+	}
+
+	rows, err := st.db.QueryContext(ctx, `SELECT tag FROM `+tagTable+` WHERE url_id=$1 ORDER BY tag`, id)
+	if err != nil {
+		return meta, fmt.Errorf("DBStorage: GetURLMeta: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var tag string
+		if err := rows.Scan(&tag); err != nil {
+			return meta, fmt.Errorf("DBStorage: GetURLMeta: %w", err)
+		}
+		meta.Tags = append(meta.Tags, tag)
+	}
+	if err := rows.Err(); err != nil {
+		return meta, fmt.Errorf("DBStorage: GetURLMeta: %w", err)
+	}
+
+	return meta, nil
+}
+
+// SetURLMeta replaces the meta within a transaction, the url row update
+// checks the owner
+func (st *DBStorage) SetURLMeta(ctx context.Context, id string, userID string, meta storage.URLMeta) error {
+	tx, err := st.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("DBStorage: SetURLMeta: %w", err)
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, `UPDATE `+st.urlTable+` SET title=$1, notes=$2
+		WHERE url_id=$3 AND user_id=$4`, meta.Title, meta.Notes, id, userID)
+	if err != nil {
+		return fmt.Errorf("DBStorage: SetURLMeta: %w", err)
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		return storage.ErrURLNotFound
+	}
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM `+tagTable+` WHERE url_id=$1`, id); err != nil {
+		return fmt.Errorf("DBStorage: SetURLMeta: %w", err)
+	}
+	for _, tag := range meta.Tags {
+		_, err := tx.ExecContext(ctx, `INSERT INTO `+tagTable+` (url_id, tag) VALUES($1, $2)
+			ON CONFLICT DO NOTHING`, id, tag)
+		if err != nil {
+			return fmt.Errorf("DBStorage: SetURLMeta: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("DBStorage: SetURLMeta: %w", err)
+	}
+
+	return nil
+}
+
+func (st *DBStorage) GetUserURLMeta(ctx context.Context, userID string) (map[string]storage.URLMeta, error) {
+	res := make(map[string]storage.URLMeta)
+
+	rows, err := st.db.QueryContext(ctx, `SELECT url_id, title, notes FROM `+st.urlTable+`
+		WHERE user_id=$1 AND (title<>'' OR notes<>'')`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("DBStorage: GetUserURLMeta: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			id   string
+			meta storage.URLMeta
+		)
+		if err := rows.Scan(&id, &meta.Title, &meta.Notes); err != nil {
+			return nil, fmt.Errorf("DBStorage: GetUserURLMeta: %w", err)
+		}
+		res[id] = meta
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("DBStorage: GetUserURLMeta: %w", err)
+	}
+
+	tagRows, err := st.db.QueryContext(ctx, `SELECT t.url_id, t.tag FROM `+tagTable+` t
+		JOIN `+st.urlTable+` u ON u.url_id=t.url_id WHERE u.user_id=$1 ORDER BY t.url_id, t.tag`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("DBStorage: GetUserURLMeta: %w", err)
+	}
+	defer tagRows.Close()
+
+	for tagRows.Next() {
+		var id, tag string
+		if err := tagRows.Scan(&id, &tag); err != nil {
+			return nil, fmt.Errorf("DBStorage: GetUserURLMeta: %w", err)
+		}
+		meta := res[id]
+		meta.Tags = append(meta.Tags, tag)
+		res[id] = meta
+	}
+	if err := tagRows.Err(); err != nil {
+		return nil, fmt.Errorf("DBStorage: GetUserURLMeta: %w", err)
+	}
+
+	return res, nil
+}
+
+func (st *DBStorage) GetUserTags(ctx context.Context, userID string) (map[string]int, error) {
+	rows, err := st.db.QueryContext(ctx, `SELECT t.tag, COUNT(*) FROM `+tagTable+` t
+		JOIN `+st.urlTable+` u ON u.url_id=t.url_id WHERE u.user_id=$1 GROUP BY t.tag`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("DBStorage: GetUserTags: %w", err)
+	}
+	defer rows.Close()
+
+	res := make(map[string]int)
+	for rows.Next() {
+		var (
+			tag   string
+			count int
+		)
+		if err := rows.Scan(&tag, &count); err != nil {
+			return nil, fmt.Errorf("DBStorage: GetUserTags: %w", err)
+		}
+		res[tag] = count
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("DBStorage: GetUserTags: %w", err)
+	}
+
+	return res, nil
+}
@@ -0,0 +1,55 @@
+package psql
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+)
+
+// notifyChannel is the channel every DBStorage instance publishes url
+// changes to, see Listener
+const notifyChannel = "shorty_urls"
+
+// NOTIFY payload is limited to 8000 bytes, ids are sent in chunks
+const notifyChunkSize = 100
+
+// Operations published to notifyChannel, deleted urls are never restored
+// (their ids are never reused), so there is no restore operation
+const (
+	OpCreate = "create"
+	OpDelete = "delete"
+	OpUpdate = "update"
+)
+
+// ChangeEvent is a NOTIFY payload describing changed short urls
+type ChangeEvent struct {
+	Op  string   `json:"op"`
+	IDs []string `json:"ids"`
+}
+
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+// notifyChanged publishes a ChangeEvent, if db is a tx listeners are
+// notified if and only if the tx is committed
+func notifyChanged(ctx context.Context, db execer, op string, ids []string) error {
+	for beg := 0; beg < len(ids); beg += notifyChunkSize {
+		end := beg + notifyChunkSize
+		if end > len(ids) {
+			end = len(ids)
+		}
+
+		payload, err := json.Marshal(ChangeEvent{Op: op, IDs: ids[beg:end]})
+		if err != nil {
+			return fmt.Errorf("notify: %v", err)
+		}
+
+		if _, err := db.ExecContext(ctx, `SELECT pg_notify($1, $2)`, notifyChannel, string(payload)); err != nil {
+			return fmt.Errorf("notify: %v", err)
+		}
+	}
+
+	return nil
+}
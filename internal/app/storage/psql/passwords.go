@@ -0,0 +1,62 @@
+package psql
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/sbxb/shorty/internal/app/storage"
+)
+
+// DBStorage implements LinkPasswordStorage interface
+var _ storage.LinkPasswordStorage = (*DBStorage)(nil)
+
+// addPasswordColumn adds the password hash column, urls without a hash
+// have no password
+func addPasswordColumn(db *sql.DB, urlTable string) error {
+	PasswordColumnQuery := `ALTER TABLE ` + urlTable + ` ADD COLUMN IF NOT EXISTS
+		password_hash VARCHAR(128)`
+	_, err := db.Exec(PasswordColumnQuery)
+
+	return err
+}
+
+func (st *DBStorage) GetURLPassword(ctx context.Context, id string) (string, error) {
+	GetURLPasswordQuery := `SELECT COALESCE(password_hash, '') FROM ` + st.urlTable + ` WHERE url_id=$1`
+
+	// hashes are read from the primary, so that a password just set is
+	// checked regardless of replication lag
+	var hash string
+	err := st.db.QueryRowContext(ctx, GetURLPasswordQuery, id).Scan(&hash)
+	if err == sql.ErrNoRows {
+		return "", storage.ErrURLNotFound
+	}
+	if err != nil {
+		return "", asUnavailable(fmt.Errorf("DBStorage: GetURLPassword: %w", err))
+	}
+
+	return hash, nil
+}
+
+func (st *DBStorage) SetURLPassword(ctx context.Context, id string, userID string, hash string) error {
+	SetURLPasswordQuery := `UPDATE ` + st.urlTable + ` SET password_hash=NULLIF($1, '')
+		WHERE url_id=$2 AND user_id=$3`
+
+	result, err := st.db.ExecContext(ctx, SetURLPasswordQuery, hash, id, userID)
+	if err != nil {
+		return fmt.Errorf("DBStorage: SetURLPassword: %w", err)
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("DBStorage: SetURLPassword: %w", err)
+	}
+	if rows == 0 {
+		return storage.ErrURLNotFound
+	}
+	// other instances evict the cached hash
+	if err := notifyChanged(ctx, st.db, OpUpdate, []string{id}); err != nil {
+		return fmt.Errorf("DBStorage: SetURLPassword: %w", err)
+	}
+
+	return nil
+}
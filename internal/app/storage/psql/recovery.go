@@ -0,0 +1,57 @@
+package psql
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/sbxb/shorty/internal/app/storage"
+)
+
+const recoveryCodeTable = "recovery_codes"
+
+// DBStorage implements RecoveryCodeStorage interface
+var _ storage.RecoveryCodeStorage = (*DBStorage)(nil)
+
+func createRecoveryCodeTable(db *sql.DB) error {
+	RecoveryCodesTableQuery := `CREATE TABLE IF NOT EXISTS ` + recoveryCodeTable + ` (
+		user_id VARCHAR(512) primary key,
+		hash VARCHAR(128) NOT NULL,
+		created_at TIMESTAMPTZ NOT NULL,
+		UNIQUE (hash)
+	)`
+
+	_, err := db.Exec(RecoveryCodesTableQuery)
+
+	return err
+}
+
+// AddRecoveryCode replaces the user's code if any
+func (st *DBStorage) AddRecoveryCode(ctx context.Context, code storage.RecoveryCode) error {
+	AddRecoveryCodeQuery := `INSERT INTO ` + recoveryCodeTable + `(user_id, hash, created_at)
+		VALUES($1, $2, $3)
+		ON CONFLICT (user_id) DO UPDATE SET hash=EXCLUDED.hash, created_at=EXCLUDED.created_at`
+
+	if _, err := st.db.ExecContext(ctx, AddRecoveryCodeQuery, code.UserID, code.Hash, code.CreatedAt); err != nil {
+		return fmt.Errorf("DBStorage: AddRecoveryCode: %w", err)
+	}
+
+	return nil
+}
+
+// RedeemRecoveryCode deletes the code with a single statement, so that
+// concurrent requests can't redeem it twice
+func (st *DBStorage) RedeemRecoveryCode(ctx context.Context, hash string) (string, error) {
+	RedeemRecoveryCodeQuery := `DELETE FROM ` + recoveryCodeTable + ` WHERE hash=$1 RETURNING user_id`
+
+	var userID string
+	err := st.db.QueryRowContext(ctx, RedeemRecoveryCodeQuery, hash).Scan(&userID)
+	if err == sql.ErrNoRows {
+		return "", storage.ErrRecoveryCodeNotFound
+	}
+	if err != nil {
+		return "", fmt.Errorf("DBStorage: RedeemRecoveryCode: %w", err)
+	}
+
+	return userID, nil
+}
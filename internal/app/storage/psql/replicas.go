@@ -0,0 +1,138 @@
+package psql
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/sbxb/shorty/internal/app/logger"
+)
+
+// replicas are checked in the background every 5 seconds, an unhealthy
+// replica is taken out of rotation until it answers a ping again
+const replicaCheckInterval = 5 * time.Second
+
+// replica is a read-only database connection pool with its health flag
+type replica struct {
+	db      *sql.DB
+	name    string
+	healthy int32 // accessed atomically, 1 means healthy
+}
+
+func (r *replica) isHealthy() bool {
+	return atomic.LoadInt32(&r.healthy) == 1
+}
+
+func (r *replica) setHealthy(healthy bool) {
+	var v int32
+	if healthy {
+		v = 1
+	}
+	if old := atomic.SwapInt32(&r.healthy, v); old != v {
+		if healthy {
+			logger.Infof("DBStorage: replica %s is back in rotation", r.name)
+		} else {
+			logger.Warningf("DBStorage: replica %s is out of rotation", r.name)
+		}
+	}
+}
+
+func (r *replica) check() {
+	ctx, cancel := context.WithTimeout(context.Background(), pingTimeout)
+	defer cancel()
+
+	r.setHealthy(r.db.PingContext(ctx) == nil)
+}
+
+// replicaSet load-balances reads across healthy replicas in a round-robin
+// manner and runs background health checks
+type replicaSet struct {
+	replicas []*replica
+	next     uint32
+
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+// newReplicaSet opens all the replicas, unreachable ones are not considered
+// an error, they just start out of rotation
+func newReplicaSet(dsns []string) (*replicaSet, error) {
+	rs := &replicaSet{done: make(chan struct{})}
+
+	for i, dsn := range dsns {
+		db, err := sql.Open("pgx", dsn)
+		if err != nil {
+			rs.closeAll()
+			return nil, fmt.Errorf("replica #%d: Open: %v", i, err)
+		}
+		r := &replica{db: db, name: fmt.Sprintf("#%d", i)}
+		r.check()
+		rs.replicas = append(rs.replicas, r)
+	}
+
+	return rs, nil
+}
+
+// pick returns the next healthy replica or nil if there is none
+func (rs *replicaSet) pick() *replica {
+	healthy := make([]*replica, 0, len(rs.replicas))
+	for _, r := range rs.replicas {
+		if r.isHealthy() {
+			healthy = append(healthy, r)
+		}
+	}
+	if len(healthy) == 0 {
+		return nil
+	}
+
+	n := atomic.AddUint32(&rs.next, 1)
+
+	return healthy[int(n%uint32(len(healthy)))]
+}
+
+// run starts the health checking goroutine, it stops on close()
+func (rs *replicaSet) run(interval time.Duration) {
+	if len(rs.replicas) == 0 {
+		return
+	}
+
+	rs.wg.Add(1)
+	go func() {
+		defer rs.wg.Done()
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-rs.done:
+				return
+			case <-ticker.C:
+				for _, r := range rs.replicas {
+					r.check()
+				}
+			}
+		}
+	}()
+}
+
+func (rs *replicaSet) close() error {
+	close(rs.done)
+	rs.wg.Wait()
+
+	return rs.closeAll()
+}
+
+func (rs *replicaSet) closeAll() error {
+	var firstErr error
+	for _, r := range rs.replicas {
+		if err := r.db.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	return firstErr
+}
@@ -0,0 +1,30 @@
+package psql
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReplicaSet_Pick(t *testing.T) {
+	healthy1 := &replica{name: "#0", healthy: 1}
+	unhealthy := &replica{name: "#1"}
+	healthy2 := &replica{name: "#2", healthy: 1}
+
+	rs := &replicaSet{replicas: []*replica{healthy1, unhealthy, healthy2}}
+
+	picked := map[string]int{}
+	for i := 0; i < 10; i++ {
+		picked[rs.pick().name]++
+	}
+
+	assert.Equal(t, picked, map[string]int{"#0": 5, "#2": 5})
+}
+
+func TestReplicaSet_Pick_NoneHealthy(t *testing.T) {
+	rs := &replicaSet{replicas: []*replica{{name: "#0"}, {name: "#1"}}}
+	assert.Nil(t, rs.pick())
+
+	rs = &replicaSet{}
+	assert.Nil(t, rs.pick())
+}
@@ -0,0 +1,260 @@
+package psql
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/sbxb/shorty/internal/app/storage"
+)
+
+const transferTable = "transfers"
+
+// DBStorage implements TransferStorage interface
+var _ storage.TransferStorage = (*DBStorage)(nil)
+
+func createTransferTable(db *sql.DB) error {
+	TransfersTableQuery := `CREATE TABLE IF NOT EXISTS ` + transferTable + ` (
+		id VARCHAR(64) primary key,
+		from_user_id VARCHAR(512) NOT NULL,
+		to_user_id VARCHAR(512),
+		to_workspace_id VARCHAR(64) REFERENCES ` + workspaceTable + ` (id) ON DELETE CASCADE,
+		url_ids TEXT NOT NULL,
+		status VARCHAR(16) NOT NULL,
+		created_at TIMESTAMPTZ NOT NULL,
+		resolved_by VARCHAR(512),
+		resolved_at TIMESTAMPTZ,
+		moved INT NOT NULL DEFAULT 0
+	)`
+	if _, err := db.Exec(TransfersTableQuery); err != nil {
+		return err
+	}
+
+	// transfers are listed by both parties
+	for _, column := range []string{"from_user_id", "to_user_id", "to_workspace_id"} {
+		IndexQuery := `CREATE INDEX IF NOT EXISTS ` + transferTable + `_` + column + `_idx ON ` +
+			transferTable + ` (` + column + `)`
+		if _, err := db.Exec(IndexQuery); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+const transferColumns = `id, from_user_id, COALESCE(to_user_id, ''), COALESCE(to_workspace_id, ''),
+	url_ids, status, created_at, COALESCE(resolved_by, ''), resolved_at, moved`
+
+// scanTransfer reads a row selected with transferColumns
+func scanTransfer(row rowScanner) (storage.Transfer, error) {
+	var (
+		t          storage.Transfer
+		urlIDs     string
+		resolvedAt sql.NullTime
+	)
+	err := row.Scan(&t.ID, &t.FromUserID, &t.ToUserID, &t.ToWorkspaceID,
+		&urlIDs, &t.Status, &t.CreatedAt, &t.ResolvedBy, &resolvedAt, &t.Moved)
+	if err != nil {
+		return t, err
+	}
+	if err := json.Unmarshal([]byte(urlIDs), &t.URLIDs); err != nil {
+		return t, err
+	}
+	t.ResolvedAt = resolvedAt.Time
+
+	return t, nil
+}
+
+func (st *DBStorage) AddTransfer(ctx context.Context, transfer storage.Transfer) error {
+	AddTransferQuery := `INSERT INTO ` + transferTable + `(id, from_user_id, to_user_id,
+		to_workspace_id, url_ids, status, created_at)
+		VALUES($1, $2, NULLIF($3, ''), NULLIF($4, ''), $5, $6, $7)`
+
+	urlIDs, err := json.Marshal(transfer.URLIDs)
+	if err != nil {
+		return fmt.Errorf("DBStorage: AddTransfer: %w", err)
+	}
+
+	_, err = st.db.ExecContext(ctx, AddTransferQuery, transfer.ID, transfer.FromUserID,
+		transfer.ToUserID, transfer.ToWorkspaceID, string(urlIDs), transfer.Status, transfer.CreatedAt)
+	if err != nil {
+		if strings.Contains(err.Error(), "SQLSTATE 23505") {
+			return storage.NewIDConflictError(transfer.ID)
+		}
+		return fmt.Errorf("DBStorage: AddTransfer: %w", err)
+	}
+
+	return nil
+}
+
+func (st *DBStorage) GetUserTransfers(ctx context.Context, userID string) ([]storage.Transfer, error) {
+	GetUserTransfersQuery := `SELECT ` + transferColumns + ` FROM ` + transferTable + `
+		WHERE from_user_id=$1 OR to_user_id=$1 OR to_workspace_id IN (
+			SELECT workspace_id FROM ` + workspaceMemberTable + ` WHERE user_id=$1 AND role=$2)
+		ORDER BY created_at`
+
+	rows, err := st.db.QueryContext(ctx, GetUserTransfersQuery, userID, storage.WorkspaceOwner)
+	if err != nil {
+		return nil, fmt.Errorf("DBStorage: GetUserTransfers: %w", err)
+	}
+	defer rows.Close()
+
+	res := []storage.Transfer{}
+	for rows.Next() {
+		t, err := scanTransfer(rows)
+		if err != nil {
+			return nil, fmt.Errorf("DBStorage: GetUserTransfers: %w", err)
+		}
+		res = append(res, t)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("DBStorage: GetUserTransfers: %w", err)
+	}
+
+	return res, nil
+}
+
+// AcceptTransfer moves the urls within a transaction holding both the
+// transfer row and the receiver's lock
+func (st *DBStorage) AcceptTransfer(ctx context.Context, id string, userID string, at time.Time) (storage.Transfer, error) {
+	tx, err := st.beginUserTx(ctx, userID)
+	if err != nil {
+		return storage.Transfer{}, fmt.Errorf("DBStorage: AcceptTransfer: %w", err)
+	}
+	defer tx.Rollback()
+
+	t, err := st.lockPendingTransfer(ctx, tx, id)
+	if err != nil {
+		return t, err
+	}
+	receiver, err := st.isReceiver(ctx, tx, t, userID)
+	if err != nil {
+		return t, err
+	}
+	if !receiver {
+		return storage.Transfer{}, storage.ErrTransferNotFound
+	}
+
+	stmt, err := tx.PrepareContext(ctx, `UPDATE `+st.urlTable+` SET user_id=$1,
+		workspace_id=COALESCE(NULLIF($2, ''), workspace_id)
+		WHERE url_id=$3 AND user_id=$4`)
+	if err != nil {
+		return t, fmt.Errorf("DBStorage: AcceptTransfer: %w", err)
+	}
+	defer stmt.Close()
+
+	moved := 0
+	for _, urlID := range t.URLIDs {
+		result, err := stmt.ExecContext(ctx, userID, t.ToWorkspaceID, urlID, t.FromUserID)
+		if err != nil {
+			return t, fmt.Errorf("DBStorage: AcceptTransfer: %w", err)
+		}
+		if rows, _ := result.RowsAffected(); rows > 0 {
+			moved++
+		}
+	}
+	if err := st.checkQuota(ctx, tx, userID); err != nil {
+		return t, err
+	}
+
+	if err := resolveTransfer(ctx, tx, &t, storage.TransferAccepted, userID, at, moved); err != nil {
+		return t, fmt.Errorf("DBStorage: AcceptTransfer: %w", err)
+	}
+	// other instances evict cached ACLs, which carry the owner
+	if err := notifyChanged(ctx, tx, OpUpdate, t.URLIDs); err != nil {
+		return t, fmt.Errorf("DBStorage: AcceptTransfer: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return t, fmt.Errorf("DBStorage: AcceptTransfer: %w", err)
+	}
+
+	return t, nil
+}
+
+func (st *DBStorage) DeclineTransfer(ctx context.Context, id string, userID string, at time.Time) (storage.Transfer, error) {
+	tx, err := st.db.BeginTx(ctx, nil)
+	if err != nil {
+		return storage.Transfer{}, fmt.Errorf("DBStorage: DeclineTransfer: %w", err)
+	}
+	defer tx.Rollback()
+
+	t, err := st.lockPendingTransfer(ctx, tx, id)
+	if err != nil {
+		return t, err
+	}
+
+	status := storage.TransferCancelled
+	if t.FromUserID != userID {
+		receiver, err := st.isReceiver(ctx, tx, t, userID)
+		if err != nil {
+			return t, err
+		}
+		if !receiver {
+			return storage.Transfer{}, storage.ErrTransferNotFound
+		}
+		status = storage.TransferDeclined
+	}
+
+	if err := resolveTransfer(ctx, tx, &t, status, userID, at, 0); err != nil {
+		return t, fmt.Errorf("DBStorage: DeclineTransfer: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return t, fmt.Errorf("DBStorage: DeclineTransfer: %w", err)
+	}
+
+	return t, nil
+}
+
+// lockPendingTransfer reads the pending transfer locking it until tx ends
+func (st *DBStorage) lockPendingTransfer(ctx context.Context, tx *sql.Tx, id string) (storage.Transfer, error) {
+	row := tx.QueryRowContext(ctx, `SELECT `+transferColumns+` FROM `+transferTable+`
+		WHERE id=$1 AND status=$2 FOR UPDATE`, id, storage.TransferPending)
+	t, err := scanTransfer(row)
+	if err == sql.ErrNoRows {
+		return t, storage.ErrTransferNotFound
+	}
+	if err != nil {
+		return t, fmt.Errorf("DBStorage: lockPendingTransfer: %w", err)
+	}
+
+	return t, nil
+}
+
+// isReceiver reports whether the user is the receiver of the transfer or
+// an owner of the receiving workspace
+func (st *DBStorage) isReceiver(ctx context.Context, tx *sql.Tx, t storage.Transfer, userID string) (bool, error) {
+	if t.ToWorkspaceID == "" {
+		return t.ToUserID == userID, nil
+	}
+
+	var role string
+	err := tx.QueryRowContext(ctx, `SELECT role FROM `+workspaceMemberTable+`
+		WHERE workspace_id=$1 AND user_id=$2`, t.ToWorkspaceID, userID).Scan(&role)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("DBStorage: isReceiver: %w", err)
+	}
+
+	return role == storage.WorkspaceOwner, nil
+}
+
+// resolveTransfer stores the final status of the transfer within tx and
+// updates t accordingly
+func resolveTransfer(ctx context.Context, tx *sql.Tx, t *storage.Transfer, status string, userID string, at time.Time, moved int) error {
+	_, err := tx.ExecContext(ctx, `UPDATE `+transferTable+` SET status=$1, resolved_by=$2,
+		resolved_at=$3, moved=$4 WHERE id=$5`, status, userID, at, moved, t.ID)
+	if err != nil {
+		return err
+	}
+	t.Status = status
+	t.ResolvedBy = userID
+	t.ResolvedAt = at
+	t.Moved = moved
+
+	return nil
+}
@@ -0,0 +1,110 @@
+package psql
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/sbxb/shorty/internal/app/storage"
+)
+
+const userTable = "users"
+
+// DBStorage implements UserStorage interface
+var _ storage.UserStorage = (*DBStorage)(nil)
+
+func createUserTable(db *sql.DB) error {
+	UsersTableQuery := `CREATE TABLE IF NOT EXISTS ` + userTable + ` (
+		id VARCHAR(512) primary key,
+		login VARCHAR(64) NOT NULL,
+		password_hash TEXT NOT NULL,
+		created_at TIMESTAMPTZ NOT NULL,
+		UNIQUE (login)
+	)`
+
+	_, err := db.Exec(UsersTableQuery)
+
+	return err
+}
+
+func (st *DBStorage) AddUser(ctx context.Context, user storage.User) error {
+	AddUserQuery := `INSERT INTO ` + userTable + `(id, login, password_hash, created_at)
+		VALUES($1, $2, $3, $4)`
+
+	_, err := st.db.ExecContext(ctx, AddUserQuery, user.ID, user.Login, user.PasswordHash, user.CreatedAt)
+	if err != nil {
+		if strings.Contains(err.Error(), "SQLSTATE 23505") {
+			if strings.Contains(err.Error(), userTable+"_login_key") {
+				return storage.ErrLoginTaken
+			}
+			return storage.NewIDConflictError(user.ID)
+		}
+		return fmt.Errorf("DBStorage: AddUser: %w", err)
+	}
+
+	return nil
+}
+
+// GetUserByLogin always reads from the primary, so that a just created
+// account can log in
+func (st *DBStorage) GetUserByLogin(ctx context.Context, login string) (storage.User, error) {
+	user, err := st.getUser(ctx, `login=$1`, login)
+	if err != nil && err != storage.ErrUserNotFound {
+		return user, fmt.Errorf("DBStorage: GetUserByLogin: %w", err)
+	}
+
+	return user, err
+}
+
+func (st *DBStorage) GetUser(ctx context.Context, id string) (storage.User, error) {
+	user, err := st.getUser(ctx, `id=$1`, id)
+	if err != nil && err != storage.ErrUserNotFound {
+		return user, fmt.Errorf("DBStorage: GetUser: %w", err)
+	}
+
+	return user, err
+}
+
+func (st *DBStorage) getUser(ctx context.Context, where string, arg string) (storage.User, error) {
+	GetUserQuery := `SELECT id, login, password_hash, created_at FROM ` + userTable + ` WHERE ` + where
+
+	var user storage.User
+	err := st.db.QueryRowContext(ctx, GetUserQuery, arg).
+		Scan(&user.ID, &user.Login, &user.PasswordHash, &user.CreatedAt)
+	if err == sql.ErrNoRows {
+		return user, storage.ErrUserNotFound
+	}
+
+	return user, err
+}
+
+// ClaimURLs moves the urls of fromUserID to toUserID within a transaction
+// holding the lock of toUserID, so that its quota is respected
+func (st *DBStorage) ClaimURLs(ctx context.Context, fromUserID string, toUserID string) (int, error) {
+	if fromUserID == toUserID {
+		return 0, nil
+	}
+
+	tx, err := st.beginUserTx(ctx, toUserID)
+	if err != nil {
+		return 0, fmt.Errorf("DBStorage: ClaimURLs: %w", err)
+	}
+	defer tx.Rollback()
+
+	ClaimURLsQuery := `UPDATE ` + st.urlTable + ` SET user_id=$2 WHERE user_id=$1`
+	result, err := tx.ExecContext(ctx, ClaimURLsQuery, fromUserID, toUserID)
+	if err != nil {
+		return 0, fmt.Errorf("DBStorage: ClaimURLs: %w", err)
+	}
+	if err := st.checkQuota(ctx, tx, toUserID); err != nil {
+		return 0, err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("DBStorage: ClaimURLs: %w", err)
+	}
+
+	claimed, _ := result.RowsAffected()
+
+	return int(claimed), nil
+}
@@ -0,0 +1,276 @@
+package psql
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/sbxb/shorty/internal/app/storage"
+	"github.com/sbxb/shorty/internal/app/url"
+)
+
+const (
+	workspaceTable       = "workspaces"
+	workspaceMemberTable = "workspace_members"
+)
+
+// DBStorage implements WorkspaceStorage interface
+var _ storage.WorkspaceStorage = (*DBStorage)(nil)
+
+func createWorkspaceTables(db *sql.DB, urlTable string) error {
+	WorkspacesTableQuery := `CREATE TABLE IF NOT EXISTS ` + workspaceTable + ` (
+		id VARCHAR(64) primary key,
+		name VARCHAR(512) NOT NULL,
+		created_at TIMESTAMPTZ NOT NULL
+	)`
+	if _, err := db.Exec(WorkspacesTableQuery); err != nil {
+		return err
+	}
+
+	MembersTableQuery := `CREATE TABLE IF NOT EXISTS ` + workspaceMemberTable + ` (
+		workspace_id VARCHAR(64) NOT NULL REFERENCES ` + workspaceTable + ` (id) ON DELETE CASCADE,
+		user_id VARCHAR(512) NOT NULL,
+		role VARCHAR(32) NOT NULL,
+		PRIMARY KEY (workspace_id, user_id)
+	)`
+	if _, err := db.Exec(MembersTableQuery); err != nil {
+		return err
+	}
+
+	// user's workspaces are listed
+	MemberIndexQuery := `CREATE INDEX IF NOT EXISTS ` + workspaceMemberTable + `_user_id_idx ON ` +
+		workspaceMemberTable + ` (user_id)`
+	if _, err := db.Exec(MemberIndexQuery); err != nil {
+		return err
+	}
+
+	// urls created before workspaces were introduced belong to no workspace
+	URLsColumnQuery := `ALTER TABLE ` + urlTable + ` ADD COLUMN IF NOT EXISTS
+		workspace_id VARCHAR(64) REFERENCES ` + workspaceTable + ` (id) ON DELETE SET NULL`
+	if _, err := db.Exec(URLsColumnQuery); err != nil {
+		return err
+	}
+
+	URLsIndexQuery := `CREATE INDEX IF NOT EXISTS ` + urlTable + `_workspace_id_idx ON ` +
+		urlTable + ` (workspace_id)`
+	_, err := db.Exec(URLsIndexQuery)
+
+	return err
+}
+
+// AddWorkspace creates the workspace and its owner in a single transaction
+func (st *DBStorage) AddWorkspace(ctx context.Context, ws storage.Workspace, ownerID string) error {
+	tx, err := st.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("DBStorage: AddWorkspace: %w", err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `INSERT INTO `+workspaceTable+`(id, name, created_at)
+		VALUES($1, $2, $3)`, ws.ID, ws.Name, ws.CreatedAt)
+	if err != nil {
+		if strings.Contains(err.Error(), "SQLSTATE 23505") {
+			return storage.NewIDConflictError(ws.ID)
+		}
+		return fmt.Errorf("DBStorage: AddWorkspace: %w", err)
+	}
+
+	_, err = tx.ExecContext(ctx, `INSERT INTO `+workspaceMemberTable+`(workspace_id, user_id, role)
+		VALUES($1, $2, $3)`, ws.ID, ownerID, storage.WorkspaceOwner)
+	if err != nil {
+		return fmt.Errorf("DBStorage: AddWorkspace: %w", err)
+	}
+
+	return tx.Commit()
+}
+
+func (st *DBStorage) GetWorkspace(ctx context.Context, id string) (storage.Workspace, error) {
+	GetWorkspaceQuery := `SELECT id, name, created_at FROM ` + workspaceTable + ` WHERE id=$1`
+
+	var ws storage.Workspace
+	err := st.db.QueryRowContext(ctx, GetWorkspaceQuery, id).Scan(&ws.ID, &ws.Name, &ws.CreatedAt)
+	if err == sql.ErrNoRows {
+		return ws, storage.ErrWorkspaceNotFound
+	}
+	if err != nil {
+		return ws, fmt.Errorf("DBStorage: GetWorkspace: %w", err)
+	}
+
+	return ws, nil
+}
+
+func (st *DBStorage) GetUserWorkspaces(ctx context.Context, userID string) ([]storage.UserWorkspace, error) {
+	GetUserWorkspacesQuery := `SELECT w.id, w.name, w.created_at, m.role
+		FROM ` + workspaceTable + ` w JOIN ` + workspaceMemberTable + ` m ON m.workspace_id=w.id
+		WHERE m.user_id=$1 ORDER BY w.created_at`
+
+	rows, err := st.db.QueryContext(ctx, GetUserWorkspacesQuery, userID)
+	if err != nil {
+		return nil, fmt.Errorf("DBStorage: GetUserWorkspaces: %w", err)
+	}
+	defer rows.Close()
+
+	res := []storage.UserWorkspace{}
+	for rows.Next() {
+		var ws storage.UserWorkspace
+		if err := rows.Scan(&ws.ID, &ws.Name, &ws.CreatedAt, &ws.Role); err != nil {
+			return nil, fmt.Errorf("DBStorage: GetUserWorkspaces: %w", err)
+		}
+		res = append(res, ws)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("DBStorage: GetUserWorkspaces: %w", err)
+	}
+
+	return res, nil
+}
+
+// GetWorkspaceRole always reads from the primary, so that a removed member
+// is never let in
+func (st *DBStorage) GetWorkspaceRole(ctx context.Context, id string, userID string) (string, error) {
+	GetWorkspaceRoleQuery := `SELECT COALESCE(m.role, '') FROM ` + workspaceTable + ` w
+		LEFT JOIN ` + workspaceMemberTable + ` m ON m.workspace_id=w.id AND m.user_id=$2
+		WHERE w.id=$1`
+
+	var role string
+	err := st.db.QueryRowContext(ctx, GetWorkspaceRoleQuery, id, userID).Scan(&role)
+	if err == sql.ErrNoRows {
+		return "", storage.ErrWorkspaceNotFound
+	}
+	if err != nil {
+		return "", fmt.Errorf("DBStorage: GetWorkspaceRole: %w", err)
+	}
+
+	return role, nil
+}
+
+func (st *DBStorage) GetWorkspaceMembers(ctx context.Context, id string) ([]storage.WorkspaceMember, error) {
+	if _, err := st.GetWorkspace(ctx, id); err != nil {
+		return nil, err
+	}
+
+	GetMembersQuery := `SELECT user_id, role FROM ` + workspaceMemberTable + ` WHERE workspace_id=$1`
+
+	rows, err := st.db.QueryContext(ctx, GetMembersQuery, id)
+	if err != nil {
+		return nil, fmt.Errorf("DBStorage: GetWorkspaceMembers: %w", err)
+	}
+	defer rows.Close()
+
+	res := []storage.WorkspaceMember{}
+	for rows.Next() {
+		member := storage.WorkspaceMember{WorkspaceID: id}
+		if err := rows.Scan(&member.UserID, &member.Role); err != nil {
+			return nil, fmt.Errorf("DBStorage: GetWorkspaceMembers: %w", err)
+		}
+		res = append(res, member)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("DBStorage: GetWorkspaceMembers: %w", err)
+	}
+
+	return res, nil
+}
+
+func (st *DBStorage) SetWorkspaceMember(ctx context.Context, member storage.WorkspaceMember) error {
+	SetMemberQuery := `INSERT INTO ` + workspaceMemberTable + `(workspace_id, user_id, role)
+		VALUES($1, $2, $3)
+		ON CONFLICT (workspace_id, user_id) DO UPDATE SET role=EXCLUDED.role`
+
+	_, err := st.db.ExecContext(ctx, SetMemberQuery, member.WorkspaceID, member.UserID, member.Role)
+	if err != nil {
+		// the workspace doesn't exist
+		if strings.Contains(err.Error(), "SQLSTATE 23503") {
+			return storage.ErrWorkspaceNotFound
+		}
+		return fmt.Errorf("DBStorage: SetWorkspaceMember: %w", err)
+	}
+
+	return nil
+}
+
+func (st *DBStorage) RemoveWorkspaceMember(ctx context.Context, id string, userID string) error {
+	if _, err := st.GetWorkspace(ctx, id); err != nil {
+		return err
+	}
+
+	RemoveMemberQuery := `DELETE FROM ` + workspaceMemberTable + ` WHERE workspace_id=$1 AND user_id=$2`
+	if _, err := st.db.ExecContext(ctx, RemoveMemberQuery, id, userID); err != nil {
+		return fmt.Errorf("DBStorage: RemoveWorkspaceMember: %w", err)
+	}
+
+	return nil
+}
+
+func (st *DBStorage) AddURLsToWorkspace(ctx context.Context, id string, ids []string, userID string) (int, error) {
+	if _, err := st.GetWorkspace(ctx, id); err != nil {
+		return 0, err
+	}
+
+	tx, err := st.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("DBStorage: AddURLsToWorkspace: %w", err)
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare(`UPDATE ` + st.urlTable + ` SET workspace_id=$1
+		WHERE url_id=$2 AND user_id=$3 AND workspace_id IS DISTINCT FROM $1`)
+	if err != nil {
+		return 0, fmt.Errorf("DBStorage: AddURLsToWorkspace: %w", err)
+	}
+	defer stmt.Close()
+
+	moved := 0
+	for _, urlID := range ids {
+		result, err := stmt.Exec(id, urlID, userID)
+		if err != nil {
+			return 0, fmt.Errorf("DBStorage: AddURLsToWorkspace: %w", err)
+		}
+		if rows, _ := result.RowsAffected(); rows > 0 {
+			moved++
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("DBStorage: AddURLsToWorkspace: %w", err)
+	}
+
+	return moved, nil
+}
+
+// GetWorkspaceURLs reads from a replica if any, as GetUserURLs does
+func (st *DBStorage) GetWorkspaceURLs(ctx context.Context, id string) ([]url.URLEntry, error) {
+	if _, err := st.GetWorkspace(ctx, id); err != nil {
+		return nil, err
+	}
+
+	GetWorkspaceURLsQuery := `SELECT url_id, original_url FROM ` + st.urlTable + ` WHERE
+		workspace_id=$1`
+
+	var res []url.URLEntry
+	err := st.read(ctx, func(db *sql.DB) error {
+		res = []url.URLEntry{}
+
+		rows, err := db.QueryContext(ctx, GetWorkspaceURLsQuery, id)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var u url.URLEntry
+			if err := rows.Scan(&u.ShortURL, &u.OriginalURL); err != nil {
+				return err
+			}
+			res = append(res, u)
+		}
+
+		return rows.Err()
+	})
+	if err != nil {
+		return nil, fmt.Errorf("DBStorage: GetWorkspaceURLs: %w", err)
+	}
+
+	return res, nil
+}
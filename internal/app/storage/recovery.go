@@ -0,0 +1,28 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// RecoveryCode lets a user get back their user id on another device, only
+// the hash of the code itself is stored
+type RecoveryCode struct {
+	UserID    string
+	Hash      string
+	CreatedAt time.Time
+}
+
+// ErrRecoveryCodeNotFound is returned for unknown and already used codes
+var ErrRecoveryCodeNotFound = errors.New("recovery code not found")
+
+// RecoveryCodeStorage is implemented by storages which are able to keep
+// recovery codes, a user has at most one code at a time
+type RecoveryCodeStorage interface {
+	// AddRecoveryCode replaces the user's code if any
+	AddRecoveryCode(ctx context.Context, code RecoveryCode) error
+	// RedeemRecoveryCode removes the code and returns the user id it was
+	// issued for, so that every code works once
+	RedeemRecoveryCode(ctx context.Context, hash string) (string, error)
+}
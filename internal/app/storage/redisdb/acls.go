@@ -0,0 +1,77 @@
+package redisdb
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/go-redis/redis/v8"
+
+	"github.com/sbxb/shorty/internal/app/storage"
+)
+
+// RedisStorage implements ACLStorage interface
+var _ storage.ACLStorage = (*RedisStorage)(nil)
+
+// Non-public ACLs are kept as JSON in the "acl" field of the link hash, so
+// they share the link's TTL
+
+func (st *RedisStorage) GetACL(ctx context.Context, id string) (storage.ACL, string, error) {
+	values, err := st.client.HMGet(ctx, st.linkKey(id), "url", "user", "acl").Result()
+	if err != nil {
+		return storage.ACL{}, "", fmt.Errorf("RedisStorage: GetACL: %w", err)
+	}
+
+	if u, _ := values[0].(string); u == "" {
+		return storage.ACL{}, "", storage.ErrURLNotFound
+	}
+	owner, _ := values[1].(string)
+	data, _ := values[2].(string)
+	if data == "" {
+		return storage.ACL{Visibility: storage.VisibilityPublic}, owner, nil
+	}
+
+	var acl storage.ACL
+	if err := json.Unmarshal([]byte(data), &acl); err != nil {
+		return storage.ACL{}, "", fmt.Errorf("RedisStorage: GetACL: %w", err)
+	}
+
+	return acl, owner, nil
+}
+
+// setACLScript replaces the ACL of the link KEYS[1] if it belongs to the
+// user, an empty ACL removes the one stored, returns 0 if the link is
+// unknown or belongs to someone else
+// ARGV: user id, ACL as JSON or ""
+var setACLScript = redis.NewScript(`
+if redis.call("HGET", KEYS[1], "user") ~= ARGV[1] then
+	return 0
+end
+if ARGV[2] == "" then
+	redis.call("HDEL", KEYS[1], "acl")
+else
+	redis.call("HSET", KEYS[1], "acl", ARGV[2])
+end
+return 1
+`)
+
+func (st *RedisStorage) SetACL(ctx context.Context, id string, userID string, acl storage.ACL) error {
+	var data string
+	if !acl.IsPublic() {
+		b, err := json.Marshal(acl)
+		if err != nil {
+			return fmt.Errorf("RedisStorage: SetACL: %w", err)
+		}
+		data = string(b)
+	}
+
+	set, err := setACLScript.Run(ctx, st.client, []string{st.linkKey(id)}, userID, data).Int()
+	if err != nil {
+		return fmt.Errorf("RedisStorage: SetACL: %w", err)
+	}
+	if set == 0 {
+		return storage.ErrURLNotFound
+	}
+
+	return nil
+}
@@ -0,0 +1,39 @@
+package redisdb_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/sbxb/shorty/internal/app/storage"
+	"github.com/sbxb/shorty/internal/app/url"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRedisStorage_ACLs(t *testing.T) {
+	ctx := context.Background()
+	store, srv := newStore(t)
+
+	_, _, err := store.GetACL(ctx, "a")
+	assert.Equal(t, storage.ErrURLNotFound, err)
+
+	require.NoError(t, store.AddURL(ctx, url.URLEntry{ShortURL: "a", OriginalURL: "http://a.com"}, "owner"))
+	acl, owner, err := store.GetACL(ctx, "a")
+	require.NoError(t, err)
+	assert.Equal(t, storage.ACL{Visibility: storage.VisibilityPublic}, acl)
+	assert.Equal(t, "owner", owner)
+
+	restricted := storage.ACL{Visibility: storage.VisibilityWorkspace, WorkspaceID: "ws"}
+	assert.Equal(t, storage.ErrURLNotFound, store.SetACL(ctx, "a", "other", restricted))
+	assert.Equal(t, storage.ErrURLNotFound, store.SetACL(ctx, "unknown", "owner", restricted))
+	assert.False(t, srv.Exists("shorty:link:unknown"))
+	require.NoError(t, store.SetACL(ctx, "a", "owner", restricted))
+
+	acl, _, err = store.GetACL(ctx, "a")
+	require.NoError(t, err)
+	assert.Equal(t, restricted, acl)
+
+	require.NoError(t, store.SetACL(ctx, "a", "owner", storage.ACL{Visibility: storage.VisibilityPublic}))
+	assert.Equal(t, "", srv.HGet("shorty:link:a", "acl"))
+}
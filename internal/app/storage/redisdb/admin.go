@@ -0,0 +1,144 @@
+package redisdb
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/go-redis/redis/v8"
+
+	"github.com/sbxb/shorty/internal/app/storage"
+)
+
+// RedisStorage implements RoleStorage and AdminStorage interfaces
+var (
+	_ storage.RoleStorage  = (*RedisStorage)(nil)
+	_ storage.AdminStorage = (*RedisStorage)(nil)
+)
+
+func (st *RedisStorage) roleKey(userID string) string {
+	return st.prefix + "user:" + userID + ":role"
+}
+
+func (st *RedisStorage) GetRole(ctx context.Context, userID string) (string, error) {
+	role, err := st.client.Get(ctx, st.roleKey(userID)).Result()
+	if err == redis.Nil {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("RedisStorage: GetRole: %w", err)
+	}
+
+	return role, nil
+}
+
+func (st *RedisStorage) SetRole(ctx context.Context, userID string, role string) error {
+	if err := st.client.Set(ctx, st.roleKey(userID), role, 0).Err(); err != nil {
+		return fmt.Errorf("RedisStorage: SetRole: %w", err)
+	}
+
+	return nil
+}
+
+// disableURLsScript marks as deleted the links KEYS[1:n] whoever they belong
+// to dropping their ids ARGV from their owners' active sets KEYS[n+1:],
+// returns the number of links disabled
+var disableURLsScript = redis.NewScript(`
+local n, disabled = #KEYS / 2, 0
+for i = 1, n do
+	if redis.call("HGET", KEYS[i], "deleted") == "0" then
+		redis.call("HSET", KEYS[i], "deleted", "1")
+		redis.call("ZREM", KEYS[n + i], ARGV[i])
+		disabled = disabled + 1
+	end
+end
+return disabled
+`)
+
+func (st *RedisStorage) DisableURLs(ctx context.Context, ids []string) (int, error) {
+	if len(ids) == 0 {
+		return 0, nil
+	}
+
+	// owners never change, so their active sets are looked up beforehand
+	owners := make([]*redis.StringCmd, len(ids))
+	_, err := st.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, id := range ids {
+			owners[i] = pipe.HGet(ctx, st.linkKey(id), "user")
+		}
+		return nil
+	})
+	if err != nil && err != redis.Nil {
+		return 0, fmt.Errorf("RedisStorage: DisableURLs: %w", err)
+	}
+
+	keys := make([]string, 2*len(ids))
+	args := make([]interface{}, 0, len(ids))
+	for i, id := range ids {
+		keys[i] = st.linkKey(id)
+		// unknown links are skipped by the script anyway
+		keys[len(ids)+i] = st.activeKey(owners[i].Val())
+		args = append(args, id)
+	}
+
+	disabled, err := disableURLsScript.Run(ctx, st.client, keys, args...).Int()
+	if err != nil {
+		return 0, fmt.Errorf("RedisStorage: DisableURLs: %w", err)
+	}
+
+	return disabled, nil
+}
+
+// Stats scans all the links, it's meant for occasional use by admins
+func (st *RedisStorage) Stats(ctx context.Context) (storage.Stats, error) {
+	var stats storage.Stats
+	users := make(map[string]struct{})
+
+	prefix := st.linkKey("")
+	iter := st.client.Scan(ctx, 0, prefix+"*", 1000).Iterator()
+	keys := make([]string, 0, 1000)
+	flush := func() error {
+		cmds := make([]*redis.SliceCmd, len(keys))
+		_, err := st.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+			for i, key := range keys {
+				cmds[i] = pipe.HMGet(ctx, key, "url", "user", "deleted")
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, cmd := range cmds {
+			values := cmd.Val()
+			if u, _ := values[0].(string); u == "" {
+				// the link has expired
+				continue
+			}
+			stats.URLs++
+			if deleted, _ := values[2].(string); deleted == "1" {
+				stats.DeletedURLs++
+			}
+			user, _ := values[1].(string)
+			users[user] = struct{}{}
+		}
+		keys = keys[:0]
+		return nil
+	}
+
+	for iter.Next(ctx) {
+		keys = append(keys, iter.Val())
+		if len(keys) == cap(keys) {
+			if err := flush(); err != nil {
+				return stats, fmt.Errorf("RedisStorage: Stats: %w", err)
+			}
+		}
+	}
+	if err := iter.Err(); err != nil {
+		return stats, fmt.Errorf("RedisStorage: Stats: %w", err)
+	}
+	if err := flush(); err != nil {
+		return stats, fmt.Errorf("RedisStorage: Stats: %w", err)
+	}
+	stats.Users = len(users)
+
+	return stats, nil
+}
@@ -0,0 +1,45 @@
+package redisdb_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/sbxb/shorty/internal/app/storage"
+	"github.com/sbxb/shorty/internal/app/url"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRedisStorage_Admin(t *testing.T) {
+	ctx := context.Background()
+	store, _ := newStore(t)
+
+	role, err := store.GetRole(ctx, "user")
+	require.NoError(t, err)
+	assert.Empty(t, role)
+	require.NoError(t, store.SetRole(ctx, "user", "admin"))
+	role, _ = store.GetRole(ctx, "user")
+	assert.Equal(t, "admin", role)
+
+	require.NoError(t, store.AddURL(ctx, url.URLEntry{ShortURL: "a", OriginalURL: "http://a.com"}, "user"))
+	require.NoError(t, store.AddURL(ctx, url.URLEntry{ShortURL: "b", OriginalURL: "http://b.com"}, "other"))
+	require.NoError(t, store.AddURL(ctx, url.URLEntry{ShortURL: "c", OriginalURL: "http://c.com"}, ""))
+
+	disabled, err := store.DisableURLs(ctx, []string{"b", "b", "unknown"})
+	require.NoError(t, err)
+	assert.Equal(t, 1, disabled)
+
+	// disabled links free their owners' quota
+	count, err := store.CountUserURLs(ctx, "other")
+	require.NoError(t, err)
+	assert.Equal(t, 0, count)
+
+	_, err = store.GetURL(ctx, "b")
+	var deleted *storage.URLDeletedError
+	assert.ErrorAs(t, err, &deleted)
+
+	stats, err := store.Stats(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, storage.Stats{URLs: 3, DeletedURLs: 1, Users: 3}, stats)
+}
@@ -0,0 +1,190 @@
+package redisdb
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+
+	"github.com/sbxb/shorty/internal/app/storage"
+)
+
+// RedisStorage implements APIKeyStorage interface
+var _ storage.APIKeyStorage = (*RedisStorage)(nil)
+
+// Every API key is stored as a hash under apiKeyKey(id), the key id is
+// looked up by the key hash under apiKeyHashKey(hash), ids of the user's
+// keys are kept in a set under userAPIKeysKey(uid)
+
+func (st *RedisStorage) apiKeyKey(id string) string {
+	return st.prefix + "apikey:" + id
+}
+
+func (st *RedisStorage) apiKeyHashKey(hash string) string {
+	return st.prefix + "apikey-hash:" + hash
+}
+
+func (st *RedisStorage) userAPIKeysKey(userID string) string {
+	return st.prefix + "user:" + userID + ":apikeys"
+}
+
+// addAPIKeyScript creates the key KEYS[1] with its hash index KEYS[2] and
+// adds its id to the user's set KEYS[3], returns 0 on conflict
+// ARGV: id, user id, name, prefix, hash, created at (unix nanoseconds)
+var addAPIKeyScript = redis.NewScript(`
+if redis.call("EXISTS", KEYS[1]) == 1 or redis.call("EXISTS", KEYS[2]) == 1 then
+	return 0
+end
+redis.call("HSET", KEYS[1], "user", ARGV[2], "name", ARGV[3], "prefix", ARGV[4],
+	"hash", ARGV[5], "created", ARGV[6], "last_used", "0", "uses", "0")
+redis.call("SET", KEYS[2], ARGV[1])
+redis.call("SADD", KEYS[3], ARGV[1])
+return 1
+`)
+
+func (st *RedisStorage) AddAPIKey(ctx context.Context, key storage.APIKey) error {
+	added, err := addAPIKeyScript.Run(ctx, st.client,
+		[]string{st.apiKeyKey(key.ID), st.apiKeyHashKey(key.Hash), st.userAPIKeysKey(key.UserID)},
+		key.ID, key.UserID, key.Name, key.Prefix, key.Hash, key.CreatedAt.UnixNano(),
+	).Int()
+	if err != nil {
+		return fmt.Errorf("RedisStorage: AddAPIKey: %w", err)
+	}
+	if added == 0 {
+		return storage.NewIDConflictError(key.ID)
+	}
+
+	return nil
+}
+
+func (st *RedisStorage) GetAPIKeyByHash(ctx context.Context, hash string) (storage.APIKey, error) {
+	id, err := st.client.Get(ctx, st.apiKeyHashKey(hash)).Result()
+	if err == redis.Nil {
+		return storage.APIKey{}, storage.ErrAPIKeyNotFound
+	}
+	if err != nil {
+		return storage.APIKey{}, fmt.Errorf("RedisStorage: GetAPIKeyByHash: %w", err)
+	}
+
+	key, err := st.getAPIKey(ctx, id)
+	if err != nil {
+		return key, fmt.Errorf("RedisStorage: GetAPIKeyByHash: %w", err)
+	}
+
+	return key, nil
+}
+
+// GetUserAPIKeys returns the user's keys, the oldest first
+func (st *RedisStorage) GetUserAPIKeys(ctx context.Context, userID string) ([]storage.APIKey, error) {
+	ids, err := st.client.SMembers(ctx, st.userAPIKeysKey(userID)).Result()
+	if err != nil {
+		return nil, fmt.Errorf("RedisStorage: GetUserAPIKeys: %w", err)
+	}
+
+	res := make([]storage.APIKey, 0, len(ids))
+	for _, id := range ids {
+		key, err := st.getAPIKey(ctx, id)
+		if err == storage.ErrAPIKeyNotFound {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("RedisStorage: GetUserAPIKeys: %w", err)
+		}
+		res = append(res, key)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].CreatedAt.Before(res[j].CreatedAt)
+	})
+
+	return res, nil
+}
+
+func (st *RedisStorage) getAPIKey(ctx context.Context, id string) (storage.APIKey, error) {
+	values, err := st.client.HGetAll(ctx, st.apiKeyKey(id)).Result()
+	if err != nil {
+		return storage.APIKey{}, err
+	}
+	if len(values) == 0 {
+		return storage.APIKey{}, storage.ErrAPIKeyNotFound
+	}
+
+	key := storage.APIKey{
+		ID:        id,
+		UserID:    values["user"],
+		Name:      values["name"],
+		Prefix:    values["prefix"],
+		Hash:      values["hash"],
+		CreatedAt: parseUnixNano(values["created"]),
+	}
+	key.LastUsedAt = parseUnixNano(values["last_used"])
+	key.Uses, _ = strconv.ParseInt(values["uses"], 10, 64)
+
+	return key, nil
+}
+
+// parseUnixNano returns zero time for "0" and malformed values
+func parseUnixNano(s string) time.Time {
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n == 0 {
+		return time.Time{}
+	}
+
+	return time.Unix(0, n)
+}
+
+// deleteAPIKeyScript removes the key KEYS[1] along with its hash index
+// and its id from the user's set KEYS[2] if it belongs to ARGV[2], returns
+// 0 otherwise
+// ARGV: id, user id, hash index key prefix
+var deleteAPIKeyScript = redis.NewScript(`
+if redis.call("HGET", KEYS[1], "user") ~= ARGV[2] then
+	return 0
+end
+local hash = redis.call("HGET", KEYS[1], "hash")
+redis.call("DEL", KEYS[1], ARGV[3] .. hash)
+redis.call("SREM", KEYS[2], ARGV[1])
+return 1
+`)
+
+func (st *RedisStorage) DeleteAPIKey(ctx context.Context, id string, userID string) error {
+	deleted, err := deleteAPIKeyScript.Run(ctx, st.client,
+		[]string{st.apiKeyKey(id), st.userAPIKeysKey(userID)},
+		id, userID, st.apiKeyHashKey(""),
+	).Int()
+	if err != nil {
+		return fmt.Errorf("RedisStorage: DeleteAPIKey: %w", err)
+	}
+	if deleted == 0 {
+		return storage.ErrAPIKeyNotFound
+	}
+
+	return nil
+}
+
+// touchAPIKeyScript records a usage of the key KEYS[1] at ARGV[1] (unix
+// nanoseconds), returns 0 for nonexistent keys
+var touchAPIKeyScript = redis.NewScript(`
+if redis.call("EXISTS", KEYS[1]) == 0 then
+	return 0
+end
+redis.call("HSET", KEYS[1], "last_used", ARGV[1])
+redis.call("HINCRBY", KEYS[1], "uses", 1)
+return 1
+`)
+
+func (st *RedisStorage) TouchAPIKey(ctx context.Context, id string, usedAt time.Time) error {
+	touched, err := touchAPIKeyScript.Run(ctx, st.client,
+		[]string{st.apiKeyKey(id)}, usedAt.UnixNano(),
+	).Int()
+	if err != nil {
+		return fmt.Errorf("RedisStorage: TouchAPIKey: %w", err)
+	}
+	if touched == 0 {
+		return storage.ErrAPIKeyNotFound
+	}
+
+	return nil
+}
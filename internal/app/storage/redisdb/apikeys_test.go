@@ -0,0 +1,49 @@
+package redisdb_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/sbxb/shorty/internal/app/storage"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRedisStorage_APIKeys(t *testing.T) {
+	ctx := context.Background()
+	store, srv := newStore(t)
+	created := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	newer := storage.APIKey{ID: "k2", UserID: "user", Name: "CI", Prefix: "shk_2", Hash: "h2", CreatedAt: created.Add(time.Hour)}
+	older := storage.APIKey{ID: "k1", UserID: "user", Name: "laptop", Prefix: "shk_1", Hash: "h1", CreatedAt: created}
+	foreign := storage.APIKey{ID: "k3", UserID: "other", Prefix: "shk_3", Hash: "h3", CreatedAt: created}
+	for _, key := range []storage.APIKey{newer, older, foreign} {
+		require.NoError(t, store.AddAPIKey(ctx, key))
+	}
+	assert.Error(t, store.AddAPIKey(ctx, older))
+
+	keys, err := store.GetUserAPIKeys(ctx, "user")
+	require.NoError(t, err)
+	require.Len(t, keys, 2)
+	assert.Equal(t, "k1", keys[0].ID)
+	assert.Equal(t, "k2", keys[1].ID)
+	assert.True(t, keys[0].LastUsedAt.IsZero())
+
+	used := created.Add(2 * time.Hour)
+	require.NoError(t, store.TouchAPIKey(ctx, "k1", used))
+	require.NoError(t, store.TouchAPIKey(ctx, "k1", used))
+	key, err := store.GetAPIKeyByHash(ctx, "h1")
+	require.NoError(t, err)
+	assert.Equal(t, "laptop", key.Name)
+	assert.True(t, used.Equal(key.LastUsedAt))
+	assert.Equal(t, int64(2), key.Uses)
+
+	assert.Equal(t, storage.ErrAPIKeyNotFound, store.DeleteAPIKey(ctx, "k3", "user"))
+	require.NoError(t, store.DeleteAPIKey(ctx, "k1", "user"))
+	_, err = store.GetAPIKeyByHash(ctx, "h1")
+	assert.Equal(t, storage.ErrAPIKeyNotFound, err)
+	assert.False(t, srv.Exists("shorty:apikey:k1"))
+	assert.False(t, srv.Exists("shorty:apikey-hash:h1"))
+}
@@ -0,0 +1,102 @@
+package redisdb
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+
+	"github.com/sbxb/shorty/internal/app/storage"
+)
+
+// RedisStorage implements URLHistoryStorage interface
+var _ storage.URLHistoryStorage = (*RedisStorage)(nil)
+
+// historyKey holds previous versions of the link as a list of historyEntry
+// JSON, oldest first, it shares the link's TTL
+func (st *RedisStorage) historyKey(id string) string {
+	return st.prefix + "history:" + id
+}
+
+type historyEntry struct {
+	URL        string    `json:"url"`
+	ReplacedAt time.Time `json:"replaced_at"`
+}
+
+// updateURLScript points the link KEYS[1] to a new url appending the current
+// one to the history KEYS[2], returns the version of the new url, -1 if
+// the link is unknown or belongs to someone else and -2 if it's deleted
+// ARGV: user id, new url, replacement time in RFC 3339
+var updateURLScript = redis.NewScript(`
+if redis.call("HGET", KEYS[1], "user") ~= ARGV[1] then
+	return -1
+end
+if redis.call("HGET", KEYS[1], "deleted") == "1" then
+	return -2
+end
+local current = redis.call("HGET", KEYS[1], "url")
+local previous = redis.call("LLEN", KEYS[2])
+if current == ARGV[2] then
+	return previous + 1
+end
+redis.call("RPUSH", KEYS[2], cjson.encode({url = current, replaced_at = ARGV[3]}))
+redis.call("HSET", KEYS[1], "url", ARGV[2])
+local ttl = redis.call("PTTL", KEYS[1])
+if ttl > 0 then
+	redis.call("PEXPIRE", KEYS[2], ttl)
+end
+return previous + 2
+`)
+
+func (st *RedisStorage) UpdateURL(ctx context.Context, id string, userID string, newURL string, at time.Time) (int, error) {
+	version, err := updateURLScript.Run(ctx, st.client,
+		[]string{st.linkKey(id), st.historyKey(id)},
+		userID, newURL, at.UTC().Format(time.RFC3339Nano),
+	).Int()
+	if err != nil {
+		return 0, fmt.Errorf("RedisStorage: UpdateURL: %w", err)
+	}
+
+	switch version {
+	case -1:
+		return 0, storage.ErrURLNotFound
+	case -2:
+		return 0, storage.NewURLDeletedError(id)
+	}
+
+	return version, nil
+}
+
+func (st *RedisStorage) GetURLHistory(ctx context.Context, id string, userID string) ([]storage.URLVersion, error) {
+	var (
+		link    *redis.SliceCmd
+		entries *redis.StringSliceCmd
+	)
+	_, err := st.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		link = pipe.HMGet(ctx, st.linkKey(id), "url", "user")
+		entries = pipe.LRange(ctx, st.historyKey(id), 0, -1)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("RedisStorage: GetURLHistory: %w", err)
+	}
+
+	values := link.Val()
+	current, _ := values[0].(string)
+	if owner, _ := values[1].(string); current == "" || owner != userID {
+		return nil, storage.ErrURLNotFound
+	}
+
+	res := make([]storage.URLVersion, 0, len(entries.Val())+1)
+	for i, data := range entries.Val() {
+		var entry historyEntry
+		if err := json.Unmarshal([]byte(data), &entry); err != nil {
+			return nil, fmt.Errorf("RedisStorage: GetURLHistory: %w", err)
+		}
+		res = append(res, storage.URLVersion{Version: i + 1, URL: entry.URL, ReplacedAt: entry.ReplacedAt})
+	}
+
+	return append(res, storage.URLVersion{Version: len(res) + 1, URL: current}), nil
+}
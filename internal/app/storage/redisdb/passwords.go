@@ -0,0 +1,57 @@
+package redisdb
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/go-redis/redis/v8"
+
+	"github.com/sbxb/shorty/internal/app/storage"
+)
+
+// RedisStorage implements LinkPasswordStorage interface
+var _ storage.LinkPasswordStorage = (*RedisStorage)(nil)
+
+// Password hashes are kept in the "password" field of the link hash
+
+func (st *RedisStorage) GetURLPassword(ctx context.Context, id string) (string, error) {
+	values, err := st.client.HMGet(ctx, st.linkKey(id), "url", "password").Result()
+	if err != nil {
+		return "", fmt.Errorf("RedisStorage: GetURLPassword: %w", err)
+	}
+
+	if u, _ := values[0].(string); u == "" {
+		return "", storage.ErrURLNotFound
+	}
+	hash, _ := values[1].(string)
+
+	return hash, nil
+}
+
+// setPasswordScript sets the password hash of the link KEYS[1] if it
+// belongs to the user, an empty hash removes the one stored, returns 0 if
+// the link is unknown or belongs to someone else
+// ARGV: user id, hash or ""
+var setPasswordScript = redis.NewScript(`
+if redis.call("HGET", KEYS[1], "user") ~= ARGV[1] then
+	return 0
+end
+if ARGV[2] == "" then
+	redis.call("HDEL", KEYS[1], "password")
+else
+	redis.call("HSET", KEYS[1], "password", ARGV[2])
+end
+return 1
+`)
+
+func (st *RedisStorage) SetURLPassword(ctx context.Context, id string, userID string, hash string) error {
+	set, err := setPasswordScript.Run(ctx, st.client, []string{st.linkKey(id)}, userID, hash).Int()
+	if err != nil {
+		return fmt.Errorf("RedisStorage: SetURLPassword: %w", err)
+	}
+	if set == 0 {
+		return storage.ErrURLNotFound
+	}
+
+	return nil
+}
@@ -0,0 +1,34 @@
+package redisdb_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/sbxb/shorty/internal/app/storage"
+	"github.com/sbxb/shorty/internal/app/url"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRedisStorage_Passwords(t *testing.T) {
+	ctx := context.Background()
+	store, srv := newStore(t)
+
+	_, err := store.GetURLPassword(ctx, "a")
+	assert.Equal(t, storage.ErrURLNotFound, err)
+
+	require.NoError(t, store.AddURL(ctx, url.URLEntry{ShortURL: "a", OriginalURL: "http://a.com"}, "owner"))
+	hash, err := store.GetURLPassword(ctx, "a")
+	require.NoError(t, err)
+	assert.Empty(t, hash)
+
+	assert.Equal(t, storage.ErrURLNotFound, store.SetURLPassword(ctx, "a", "other", "hash"))
+	assert.Equal(t, storage.ErrURLNotFound, store.SetURLPassword(ctx, "unknown", "owner", "hash"))
+	require.NoError(t, store.SetURLPassword(ctx, "a", "owner", "hash"))
+	hash, _ = store.GetURLPassword(ctx, "a")
+	assert.Equal(t, "hash", hash)
+
+	require.NoError(t, store.SetURLPassword(ctx, "a", "owner", ""))
+	assert.Equal(t, "", srv.HGet("shorty:link:a", "password"))
+}
@@ -0,0 +1,82 @@
+package redisdb
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/go-redis/redis/v8"
+
+	"github.com/sbxb/shorty/internal/app/storage"
+)
+
+// RedisStorage implements RecoveryCodeStorage interface
+var _ storage.RecoveryCodeStorage = (*RedisStorage)(nil)
+
+// The user id a recovery code was issued for is stored under
+// recoveryKey(hash), the hash of the user's code is kept under
+// userRecoveryKey(uid)
+
+func (st *RedisStorage) recoveryKey(hash string) string {
+	return st.prefix + "recovery:" + hash
+}
+
+func (st *RedisStorage) userRecoveryKey(userID string) string {
+	return st.prefix + "user:" + userID + ":recovery"
+}
+
+// addRecoveryCodeScript stores the code KEYS[1] for the user ARGV[1]
+// replacing the user's code kept under KEYS[2] if any, returns 0 on conflict
+// ARGV: user id, hash, recovery key prefix
+var addRecoveryCodeScript = redis.NewScript(`
+if redis.call("EXISTS", KEYS[1]) == 1 then
+	return 0
+end
+local old = redis.call("GET", KEYS[2])
+if old then
+	redis.call("DEL", ARGV[3] .. old)
+end
+redis.call("SET", KEYS[1], ARGV[1])
+redis.call("SET", KEYS[2], ARGV[2])
+return 1
+`)
+
+func (st *RedisStorage) AddRecoveryCode(ctx context.Context, code storage.RecoveryCode) error {
+	added, err := addRecoveryCodeScript.Run(ctx, st.client,
+		[]string{st.recoveryKey(code.Hash), st.userRecoveryKey(code.UserID)},
+		code.UserID, code.Hash, st.recoveryKey(""),
+	).Int()
+	if err != nil {
+		return fmt.Errorf("RedisStorage: AddRecoveryCode: %w", err)
+	}
+	if added == 0 {
+		return storage.NewIDConflictError(code.Hash)
+	}
+
+	return nil
+}
+
+// redeemRecoveryCodeScript removes the code KEYS[1] along with the user's
+// reference to it and returns the user id, false if there is no such code
+// ARGV[1] is the user key prefix
+var redeemRecoveryCodeScript = redis.NewScript(`
+local uid = redis.call("GET", KEYS[1])
+if not uid then
+	return false
+end
+redis.call("DEL", KEYS[1], ARGV[1] .. uid .. ":recovery")
+return uid
+`)
+
+func (st *RedisStorage) RedeemRecoveryCode(ctx context.Context, hash string) (string, error) {
+	userID, err := redeemRecoveryCodeScript.Run(ctx, st.client,
+		[]string{st.recoveryKey(hash)}, st.prefix+"user:",
+	).Text()
+	if err == redis.Nil {
+		return "", storage.ErrRecoveryCodeNotFound
+	}
+	if err != nil {
+		return "", fmt.Errorf("RedisStorage: RedeemRecoveryCode: %w", err)
+	}
+
+	return userID, nil
+}
@@ -0,0 +1,30 @@
+package redisdb_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/sbxb/shorty/internal/app/storage"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRedisStorage_RecoveryCodes(t *testing.T) {
+	ctx := context.Background()
+	store, srv := newStore(t)
+
+	require.NoError(t, store.AddRecoveryCode(ctx, storage.RecoveryCode{UserID: "user", Hash: "h1"}))
+	require.NoError(t, store.AddRecoveryCode(ctx, storage.RecoveryCode{UserID: "user", Hash: "h2"}))
+	assert.Error(t, store.AddRecoveryCode(ctx, storage.RecoveryCode{UserID: "other", Hash: "h2"}))
+	_, err := store.RedeemRecoveryCode(ctx, "h1")
+	assert.Equal(t, storage.ErrRecoveryCodeNotFound, err)
+
+	uid, err := store.RedeemRecoveryCode(ctx, "h2")
+	require.NoError(t, err)
+	assert.Equal(t, "user", uid)
+	assert.False(t, srv.Exists("shorty:user:user:recovery"))
+
+	_, err = store.RedeemRecoveryCode(ctx, "h2")
+	assert.Equal(t, storage.ErrRecoveryCodeNotFound, err)
+}
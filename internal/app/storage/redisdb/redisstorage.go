@@ -0,0 +1,407 @@
+package redisdb
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+
+	"github.com/sbxb/shorty/internal/app/storage"
+	"github.com/sbxb/shorty/internal/app/url"
+)
+
+// RedisStorage defines a storage on top of any Redis-compatible server
+// Every link is stored as a hash {url, user, deleted} under linkKey(id),
+// ids of the links created by a user are kept in a set under userKey(uid)
+// and ids of the active ones in a sorted set under activeKey(uid) scored
+// by their expiry time, so that the user's quota is checked without
+// looking at every link. Expired ids are dropped from both lazily
+// Multi-key updates are done by Lua scripts getting all the keys they
+// touch in KEYS, so they are atomic
+type RedisStorage struct {
+	client       *redis.Client
+	prefix       string
+	linkTTL      time.Duration
+	maxUserLinks int
+	now          func() time.Time
+}
+
+// RedisStorage implements Storage interface
+var _ storage.Storage = (*RedisStorage)(nil)
+
+// if it takes more than 2 seconds to ping the server, then the server
+// is considered unavailable
+const pingTimeout = 2 * time.Second
+
+// Option configures optional RedisStorage features
+type Option func(*RedisStorage)
+
+// WithLinkTTL makes links expire after ttl using native Redis TTL,
+// expired links are just gone (as if they never existed)
+func WithLinkTTL(ttl time.Duration) Option {
+	return func(st *RedisStorage) {
+		st.linkTTL = ttl
+	}
+}
+
+// WithMaxUserLinks limits the number of active links per user, 0 means
+// no limit. The limit is checked by the scripts adding links, so that
+// concurrent requests can't exceed it
+func WithMaxUserLinks(n int) Option {
+	return func(st *RedisStorage) {
+		st.maxUserLinks = n
+	}
+}
+
+// WithPrefix sets the prefix for all the keys, "shorty:" by default
+func WithPrefix(prefix string) Option {
+	return func(st *RedisStorage) {
+		st.prefix = prefix
+	}
+}
+
+// NewRedisStorage connects to the server given as redis://[user:password@]host[:port][/db]
+func NewRedisStorage(redisURL string, opts ...Option) (*RedisStorage, error) {
+	if redisURL == "" {
+		return nil, fmt.Errorf("RedisStorage: empty url")
+	}
+
+	redisOpts, err := redis.ParseURL(redisURL)
+	if err != nil {
+		return nil, fmt.Errorf("RedisStorage: %w", err)
+	}
+
+	st := &RedisStorage{
+		client: redis.NewClient(redisOpts),
+		prefix: "shorty:",
+		now:    time.Now,
+	}
+	for _, opt := range opts {
+		opt(st)
+	}
+
+	if err := st.Ping(); err != nil {
+		st.client.Close()
+		return nil, err
+	}
+
+	return st, nil
+}
+
+func (st *RedisStorage) linkKey(id string) string {
+	return st.prefix + "link:" + id
+}
+
+func (st *RedisStorage) userKey(userID string) string {
+	return st.prefix + "user:" + userID + ":links"
+}
+
+func (st *RedisStorage) activeKey(userID string) string {
+	return st.prefix + "user:" + userID + ":active"
+}
+
+// expireAt returns the score of a link created now in the active set
+func (st *RedisStorage) expireAt(now time.Time) string {
+	if st.linkTTL <= 0 {
+		return "+inf"
+	}
+
+	return strconv.FormatInt(now.Add(st.linkTTL).UnixMilli(), 10)
+}
+
+// linksLua defines functions shared by the scripts:
+// countActive(activeKey, now) drops expired ids from the active set and
+// returns the number of ids left,
+// addLink(linkKey, userKey, activeKey, id, url, userID, ttl, expireAt)
+// creates the link and tracks its id, ttl in milliseconds (0 means no ttl)
+const linksLua = `
+local function countActive(activeKey, now)
+	redis.call("ZREMRANGEBYSCORE", activeKey, "-inf", now)
+	return redis.call("ZCARD", activeKey)
+end
+
+local function addLink(linkKey, userKey, activeKey, id, url, userID, ttl, expireAt)
+	redis.call("HSET", linkKey, "url", url, "user", userID, "deleted", "0")
+	redis.call("SADD", userKey, id)
+	redis.call("ZADD", activeKey, expireAt, id)
+	if tonumber(ttl) > 0 then
+		redis.call("PEXPIRE", linkKey, ttl)
+	end
+end
+`
+
+// addURLScript creates the link KEYS[1] unless it exists and tracks its id
+// in the user's sets KEYS[2] and KEYS[3], returns 0 on conflict and -1 if
+// the user's quota would be exceeded
+// ARGV: id, url, user id, ttl in milliseconds (0 means no ttl), max active
+// links (0 means no limit), now and expiry time in milliseconds
+var addURLScript = redis.NewScript(linksLua + `
+if redis.call("EXISTS", KEYS[1]) == 1 then
+	return 0
+end
+local maxLinks = tonumber(ARGV[5])
+if maxLinks > 0 and countActive(KEYS[3], ARGV[6]) >= maxLinks then
+	return -1
+end
+addLink(KEYS[1], KEYS[2], KEYS[3], ARGV[1], ARGV[2], ARGV[3], ARGV[4], ARGV[7])
+return 1
+`)
+
+// AddURL saves both url and its id
+func (st *RedisStorage) AddURL(ctx context.Context, ue url.URLEntry, userID string) error {
+	now := st.now()
+	added, err := addURLScript.Run(ctx, st.client,
+		[]string{st.linkKey(ue.ShortURL), st.userKey(userID), st.activeKey(userID)},
+		ue.ShortURL, ue.OriginalURL, userID, st.linkTTL.Milliseconds(),
+		st.maxUserLinks, now.UnixMilli(), st.expireAt(now),
+	).Int()
+	if err != nil {
+		return fmt.Errorf("RedisStorage: AddURL: %w", err)
+	}
+	switch added {
+	case 0:
+		return storage.NewIDConflictError(ue.ShortURL)
+	case -1:
+		return storage.NewQuotaExceededError(st.maxUserLinks)
+	}
+
+	return nil
+}
+
+// AddBatchURL saves the batch atomically skipping existing ids
+func (st *RedisStorage) AddBatchURL(ctx context.Context, batch []url.BatchURLEntry, userID string) error {
+	if len(batch) == 0 {
+		return nil
+	}
+
+	now := st.now()
+	keys := make([]string, 0, len(batch)+2)
+	args := make([]interface{}, 0, 2*len(batch)+5)
+	args = append(args, userID, st.linkTTL.Milliseconds(), st.maxUserLinks, now.UnixMilli(), st.expireAt(now))
+	for _, e := range batch {
+		keys = append(keys, st.linkKey(e.ShortURL))
+		args = append(args, e.ShortURL, e.OriginalURL)
+	}
+	keys = append(keys, st.userKey(userID), st.activeKey(userID))
+
+	added, err := addBatchScript.Run(ctx, st.client, keys, args...).Int()
+	if err != nil {
+		return fmt.Errorf("RedisStorage: AddBatchURL: %w", err)
+	}
+	if added == -1 {
+		return storage.NewQuotaExceededError(st.maxUserLinks)
+	}
+
+	return nil
+}
+
+// addBatchScript works as addURLScript for a batch of links, existing ones
+// are skipped, nothing is added if the user's quota would be exceeded
+// KEYS: link keys followed by the user's set keys
+// ARGV: user id, ttl in milliseconds, max active links, now and expiry time
+// in milliseconds, then id and url for every link
+var addBatchScript = redis.NewScript(linksLua + `
+local userKey, activeKey = KEYS[#KEYS - 1], KEYS[#KEYS]
+local links = #KEYS - 2
+local maxLinks = tonumber(ARGV[3])
+if maxLinks > 0 then
+	local new, seen = 0, {}
+	for i = 1, links do
+		if not seen[KEYS[i]] and redis.call("EXISTS", KEYS[i]) == 0 then
+			new = new + 1
+		end
+		seen[KEYS[i]] = true
+	end
+	if new > 0 and countActive(activeKey, ARGV[4]) + new > maxLinks then
+		return -1
+	end
+end
+for i = 1, links do
+	if redis.call("EXISTS", KEYS[i]) == 0 then
+		addLink(KEYS[i], userKey, activeKey, ARGV[2 * i + 4], ARGV[2 * i + 5], ARGV[1], ARGV[2], ARGV[5])
+	end
+end
+return 1
+`)
+
+// GetURL searches for url by its id
+// Returns url found or an empty string for a nonexistent id (valid url is
+// never an empty string)
+func (st *RedisStorage) GetURL(ctx context.Context, id string) (string, error) {
+	values, err := st.client.HMGet(ctx, st.linkKey(id), "url", "deleted").Result()
+	if err != nil {
+		return "", fmt.Errorf("RedisStorage: GetURL: %w", err)
+	}
+
+	u, _ := values[0].(string)
+	if u == "" {
+		return "", nil
+	}
+	if deleted, _ := values[1].(string); deleted == "1" {
+		return "", storage.NewURLDeletedError(id)
+	}
+
+	return u, nil
+}
+
+// GetUserURLs returns urls that belong to a particular user identified by userID
+func (st *RedisStorage) GetUserURLs(ctx context.Context, userID string) ([]url.URLEntry, error) {
+	ids, err := st.client.SMembers(ctx, st.userKey(userID)).Result()
+	if err != nil {
+		return nil, fmt.Errorf("RedisStorage: GetUserURLs: %w", err)
+	}
+
+	cmds := make([]*redis.StringCmd, len(ids))
+	_, err = st.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, id := range ids {
+			cmds[i] = pipe.HGet(ctx, st.linkKey(id), "url")
+		}
+		return nil
+	})
+	if err != nil && err != redis.Nil {
+		return nil, fmt.Errorf("RedisStorage: GetUserURLs: %w", err)
+	}
+
+	res := make([]url.URLEntry, 0, len(ids))
+	var expired []string
+	for i, cmd := range cmds {
+		u, err := cmd.Result()
+		if err == redis.Nil {
+			expired = append(expired, ids[i])
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("RedisStorage: GetUserURLs: %w", err)
+		}
+		res = append(res, url.URLEntry{ShortURL: ids[i], OriginalURL: u})
+	}
+	if err := st.forgetExpired(ctx, userID, expired); err != nil {
+		return nil, fmt.Errorf("RedisStorage: GetUserURLs: %w", err)
+	}
+
+	return res, nil
+}
+
+// forgetExpiredScript drops ids ARGV of the links KEYS[2:] which have expired
+// (unless created again meanwhile) from the user's set KEYS[1]
+var forgetExpiredScript = redis.NewScript(`
+for i = 2, #KEYS do
+	if redis.call("EXISTS", KEYS[i]) == 0 then
+		redis.call("SREM", KEYS[1], ARGV[i - 1])
+	end
+end
+return 1
+`)
+
+// forgetExpired drops expired ids from the user's set
+func (st *RedisStorage) forgetExpired(ctx context.Context, userID string, ids []string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+
+	keys := make([]string, 0, len(ids)+1)
+	args := make([]interface{}, 0, len(ids))
+	keys = append(keys, st.userKey(userID))
+	for _, id := range ids {
+		keys = append(keys, st.linkKey(id))
+		args = append(args, id)
+	}
+
+	return forgetExpiredScript.Run(ctx, st.client, keys, args...).Err()
+}
+
+// CountUserURLs returns the number of active links of the user
+func (st *RedisStorage) CountUserURLs(ctx context.Context, userID string) (int, error) {
+	count, err := countUserURLsScript.Run(ctx, st.client,
+		[]string{st.activeKey(userID)}, st.now().UnixMilli(),
+	).Int()
+	if err != nil {
+		return 0, fmt.Errorf("RedisStorage: CountUserURLs: %w", err)
+	}
+
+	return count, nil
+}
+
+// countUserURLsScript returns the number of active links of the user's
+// active set KEYS[1], ARGV[1] is now in milliseconds
+var countUserURLsScript = redis.NewScript(linksLua + `
+return countActive(KEYS[1], ARGV[1])
+`)
+
+// ScanIDs calls fn for every id stored
+func (st *RedisStorage) ScanIDs(ctx context.Context, fn func(id string)) error {
+	prefix := st.linkKey("")
+	iter := st.client.Scan(ctx, 0, prefix+"*", 1000).Iterator()
+	for iter.Next(ctx) {
+		fn(strings.TrimPrefix(iter.Val(), prefix))
+	}
+
+	if err := iter.Err(); err != nil {
+		return fmt.Errorf("RedisStorage: ScanIDs: %w", err)
+	}
+
+	return nil
+}
+
+// deleteBatchScript marks as deleted the links KEYS[:-1] which belong to
+// ARGV[1] dropping their ids ARGV[2:] from the user's active set KEYS[-1]
+var deleteBatchScript = redis.NewScript(`
+local activeKey = KEYS[#KEYS]
+for i = 1, #KEYS - 1 do
+	if redis.call("HGET", KEYS[i], "user") == ARGV[1] then
+		redis.call("HSET", KEYS[i], "deleted", "1")
+		redis.call("ZREM", activeKey, ARGV[i + 1])
+	end
+end
+return 1
+`)
+
+func (st *RedisStorage) DeleteBatch(ctx context.Context, ids []string, userID string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+
+	keys := make([]string, 0, len(ids)+1)
+	args := make([]interface{}, 0, len(ids)+1)
+	args = append(args, userID)
+	for _, id := range ids {
+		keys = append(keys, st.linkKey(id))
+		args = append(args, id)
+	}
+	keys = append(keys, st.activeKey(userID))
+
+	if err := deleteBatchScript.Run(ctx, st.client, keys, args...).Err(); err != nil {
+		return fmt.Errorf("RedisStorage: DeleteBatch: %w", err)
+	}
+
+	return nil
+}
+
+// Ping pings the server
+func (st *RedisStorage) Ping() error {
+	ctx, cancel := context.WithTimeout(context.Background(), pingTimeout)
+	defer cancel()
+
+	if err := st.client.Ping(ctx).Err(); err != nil {
+		return fmt.Errorf("RedisStorage: %w", err)
+	}
+
+	return nil
+}
+
+func (st *RedisStorage) Close() error {
+	if st.client == nil {
+		return nil
+	}
+
+	if err := st.client.Close(); err != nil {
+		return err
+	}
+
+	st.client = nil
+
+	return nil
+}
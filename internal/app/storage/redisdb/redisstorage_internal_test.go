@@ -0,0 +1,48 @@
+package redisdb
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+
+	"github.com/sbxb/shorty/internal/app/storage"
+	"github.com/sbxb/shorty/internal/app/url"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRedisStorage_Expired_Links_Forgotten(t *testing.T) {
+	srv := miniredis.RunT(t)
+	st, err := NewRedisStorage("redis://"+srv.Addr(), WithLinkTTL(time.Hour), WithMaxUserLinks(1))
+	require.NoError(t, err)
+	t.Cleanup(func() { st.Close() })
+
+	now := time.Now()
+	st.now = func() time.Time { return now }
+	ctx := context.Background()
+	var quotaExceededError *storage.QuotaExceededError
+
+	require.NoError(t, st.AddURL(ctx, url.URLEntry{ShortURL: "id1", OriginalURL: "http://example.com"}, "user"))
+	err = st.AddURL(ctx, url.URLEntry{ShortURL: "id2", OriginalURL: "http://example.org"}, "user")
+	require.ErrorAs(t, err, &quotaExceededError)
+
+	srv.FastForward(time.Hour)
+	now = now.Add(time.Hour)
+
+	// expired links free the quota
+	count, err := st.CountUserURLs(ctx, "user")
+	require.NoError(t, err)
+	assert.Equal(t, 0, count)
+	require.NoError(t, st.AddURL(ctx, url.URLEntry{ShortURL: "id2", OriginalURL: "http://example.org"}, "user"))
+
+	// and are dropped from the user's links once listed
+	urls, err := st.GetUserURLs(ctx, "user")
+	require.NoError(t, err)
+	assert.Len(t, urls, 1)
+	members, err := srv.Members(st.userKey("user"))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"id2"}, members)
+}
@@ -0,0 +1,175 @@
+package redisdb_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+
+	"github.com/sbxb/shorty/internal/app/storage"
+	"github.com/sbxb/shorty/internal/app/storage/redisdb"
+	"github.com/sbxb/shorty/internal/app/url"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newStore(t *testing.T, opts ...redisdb.Option) (*redisdb.RedisStorage, *miniredis.Miniredis) {
+	srv := miniredis.RunT(t)
+
+	store, err := redisdb.NewRedisStorage("redis://"+srv.Addr(), opts...)
+	require.NoError(t, err)
+	t.Cleanup(func() { store.Close() })
+
+	return store, srv
+}
+
+func TestRedisStorage_Add_then_Get(t *testing.T) {
+	entries := []url.URLEntry{
+		{
+			ShortURL:    "5agFZWrIb6Ej21QvYUNBL3",
+			OriginalURL: "http://example.com",
+		},
+		{
+			ShortURL:    "6EH6vwAy9dOyyNbopTS6M4",
+			OriginalURL: "http://example.org",
+		},
+	}
+
+	store, _ := newStore(t)
+
+	for _, ue := range entries {
+		err := store.AddURL(context.Background(), ue, "user")
+		require.NoError(t, err)
+
+		urlReturned, err := store.GetURL(context.Background(), ue.ShortURL)
+		require.NoError(t, err)
+		assert.Equal(t, urlReturned, ue.OriginalURL)
+	}
+
+	urls, err := store.GetUserURLs(context.Background(), "user")
+	require.NoError(t, err)
+	assert.ElementsMatch(t, urls, entries)
+}
+
+func TestRedisStorage_Get_Nonexistent(t *testing.T) {
+	store, _ := newStore(t)
+
+	urlReturned, err := store.GetURL(context.Background(), "nonexistent_id")
+	require.NoError(t, err)
+	assert.Empty(t, urlReturned)
+}
+
+func TestRedisStorage_Add_Record_Twice(t *testing.T) {
+	ue := url.URLEntry{
+		ShortURL:    "5agFZWrIb6Ej21QvYUNBL3",
+		OriginalURL: "http://example.com",
+	}
+
+	store, _ := newStore(t)
+
+	ctx := context.Background()
+	require.NoError(t, store.AddURL(ctx, ue, "")) // once
+	err := store.AddURL(ctx, ue, "")              // twice
+
+	var conflictError *storage.IDConflictError
+	require.ErrorAs(t, err, &conflictError)
+}
+
+func TestRedisStorage_Batch_Add_Delete(t *testing.T) {
+	batch := []url.BatchURLEntry{
+		{
+			ShortURL:    "5agFZWrIb6Ej21QvYUNBL3",
+			OriginalURL: "http://example.com",
+		},
+		{
+			ShortURL:    "6EH6vwAy9dOyyNbopTS6M4",
+			OriginalURL: "http://example.org",
+		},
+	}
+
+	store, _ := newStore(t)
+	ctx := context.Background()
+
+	require.NoError(t, store.AddBatchURL(ctx, batch, "user"))
+	require.NoError(t, store.AddBatchURL(ctx, batch, "user")) // existing ids are skipped
+
+	ids := make([]string, 0, len(batch))
+	for _, ue := range batch {
+		urlReturned, err := store.GetURL(ctx, ue.ShortURL)
+		require.NoError(t, err)
+		assert.Equal(t, urlReturned, ue.OriginalURL)
+		ids = append(ids, ue.ShortURL)
+	}
+
+	// somebody else's links are not deleted
+	require.NoError(t, store.DeleteBatch(ctx, ids, "another user"))
+	_, err := store.GetURL(ctx, ids[0])
+	require.NoError(t, err)
+
+	require.NoError(t, store.DeleteBatch(ctx, ids, "user"))
+	for _, id := range ids {
+		_, err = store.GetURL(ctx, id)
+		var deletedError *storage.URLDeletedError
+		require.ErrorAs(t, err, &deletedError)
+	}
+}
+
+func TestRedisStorage_Link_Expires(t *testing.T) {
+	ue := url.URLEntry{
+		ShortURL:    "5agFZWrIb6Ej21QvYUNBL3",
+		OriginalURL: "http://example.com",
+	}
+
+	store, srv := newStore(t, redisdb.WithLinkTTL(time.Hour))
+	ctx := context.Background()
+
+	require.NoError(t, store.AddURL(ctx, ue, "user"))
+
+	srv.FastForward(time.Hour)
+
+	urlReturned, err := store.GetURL(ctx, ue.ShortURL)
+	require.NoError(t, err)
+	assert.Empty(t, urlReturned)
+
+	urls, err := store.GetUserURLs(ctx, "user")
+	require.NoError(t, err)
+	assert.Empty(t, urls)
+
+	// the expired id is free again
+	require.NoError(t, store.AddURL(ctx, ue, "user"))
+}
+
+func TestRedisStorage_MaxUserLinks(t *testing.T) {
+	ctx := context.Background()
+	store, _ := newStore(t, redisdb.WithMaxUserLinks(2))
+	var quotaExceededError *storage.QuotaExceededError
+
+	require.NoError(t, store.AddURL(ctx, url.URLEntry{ShortURL: "id1", OriginalURL: "http://example.com"}, "user"))
+
+	// the batch is rejected as a whole, existing ids are not counted
+	err := store.AddBatchURL(ctx, []url.BatchURLEntry{
+		{ShortURL: "id1", OriginalURL: "http://example.com"},
+		{ShortURL: "id2", OriginalURL: "http://example.org"},
+		{ShortURL: "id3", OriginalURL: "http://example.net"},
+	}, "user")
+	require.ErrorAs(t, err, &quotaExceededError)
+
+	require.NoError(t, store.AddBatchURL(ctx, []url.BatchURLEntry{
+		{ShortURL: "id1", OriginalURL: "http://example.com"},
+		{ShortURL: "id2", OriginalURL: "http://example.org"},
+		{ShortURL: "id2", OriginalURL: "http://example.org"},
+	}, "user"))
+
+	err = store.AddURL(ctx, url.URLEntry{ShortURL: "id3", OriginalURL: "http://example.net"}, "user")
+	require.ErrorAs(t, err, &quotaExceededError)
+	assert.Equal(t, 2, quotaExceededError.Limit)
+
+	// deleted links free the quota
+	require.NoError(t, store.DeleteBatch(ctx, []string{"id1"}, "user"))
+	count, err := store.CountUserURLs(ctx, "user")
+	require.NoError(t, err)
+	assert.Equal(t, 1, count)
+	require.NoError(t, store.AddURL(ctx, url.URLEntry{ShortURL: "id3", OriginalURL: "http://example.net"}, "user"))
+}
@@ -0,0 +1,427 @@
+package spool
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/sbxb/shorty/internal/app/logger"
+	"github.com/sbxb/shorty/internal/app/storage"
+	"github.com/sbxb/shorty/internal/app/url"
+)
+
+// DefaultReplayInterval is how often Run checks the spool
+const DefaultReplayInterval = 5 * time.Second
+
+// metrics are published at /debug/vars as "spool"
+var metrics = expvar.NewMap("spool")
+
+// SpoolStorage defines a storage wrapper which keeps accepting writes while
+// the underlying storage is unavailable: failed writes are appended to
+// a local spool file and replayed in order once the storage is back
+// Short ids are deterministic (see url.ShortID), so a spooled write still
+// results in a valid short url. Conflicts and exceeded quotas are detected
+// on replay only, so a spooled write is always reported as a success.
+// A spooled id taken meanwhile is kept as it is, if it points to another
+// url (e.g. an edited link) the short url given to the client is wrong,
+// such writes are logged as errors and counted as "lost"
+type SpoolStorage struct {
+	storage.Storage
+
+	isUnavailable func(error) bool
+
+	// replayMu serializes replays, mu guards the rest and is not held
+	// while spooled writes are applied, so spooling goes on meanwhile
+	replayMu sync.Mutex
+	mu       sync.Mutex
+	file     *os.File
+	depth    int
+	pending  map[string]string // spooled id -> url, served by GetURL
+}
+
+// SpoolStorage implements Storage interface
+var _ storage.Storage = (*SpoolStorage)(nil)
+
+// record is a single spooled write, stored as a line of JSON
+type record struct {
+	UserID  string         `json:"user_id"`
+	Batch   bool           `json:"batch,omitempty"`
+	Entries []url.URLEntry `json:"entries"`
+}
+
+// New wraps store with a spool kept in filename, records left by the
+// previous run are loaded to be replayed. Writes failed with
+// storage.UnavailableError or an error accepted by isConnectionError
+// are spooled, unless made with storage.WithImmediateWrite
+func New(store storage.Storage, filename string, isConnectionError func(error) bool) (*SpoolStorage, error) {
+	f, err := os.OpenFile(filename, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0660)
+	if err != nil {
+		return nil, fmt.Errorf("SpoolStorage: %w", err)
+	}
+
+	st := &SpoolStorage{
+		Storage: store,
+		isUnavailable: func(err error) bool {
+			var unavailableError *storage.UnavailableError
+			return errors.As(err, &unavailableError) || isConnectionError(err)
+		},
+		file:    f,
+		pending: make(map[string]string),
+	}
+
+	records, err := st.readRecords()
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("SpoolStorage: %w", err)
+	}
+	for _, rec := range records {
+		st.track(rec)
+	}
+	st.setDepth(len(records))
+	if len(records) > 0 {
+		logger.Infof("SpoolStorage: %d spooled writes loaded from %s", len(records), filename)
+	}
+
+	return st, nil
+}
+
+func (st *SpoolStorage) AddURL(ctx context.Context, ue url.URLEntry, userID string) error {
+	err := st.Storage.AddURL(ctx, ue, userID)
+	if !st.isUnavailable(err) || storage.IsImmediateWrite(ctx) {
+		return err
+	}
+
+	return st.spool(record{UserID: userID, Entries: []url.URLEntry{ue}}, err)
+}
+
+func (st *SpoolStorage) AddBatchURL(ctx context.Context, batch []url.BatchURLEntry, userID string) error {
+	err := st.Storage.AddBatchURL(ctx, batch, userID)
+	if !st.isUnavailable(err) || storage.IsImmediateWrite(ctx) {
+		return err
+	}
+
+	rec := record{UserID: userID, Batch: true, Entries: make([]url.URLEntry, 0, len(batch))}
+	for _, e := range batch {
+		rec.Entries = append(rec.Entries, url.URLEntry{ShortURL: e.ShortURL, OriginalURL: e.OriginalURL})
+	}
+
+	return st.spool(rec, err)
+}
+
+// GetURL serves urls which are spooled but not replayed yet if the
+// underlying storage does not have them or is unavailable
+func (st *SpoolStorage) GetURL(ctx context.Context, id string) (string, error) {
+	u, err := st.Storage.GetURL(ctx, id)
+	if u != "" || (err != nil && !st.isUnavailable(err)) {
+		return u, err
+	}
+
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	if pu, ok := st.pending[id]; ok {
+		return pu, nil
+	}
+
+	return u, err
+}
+
+// Ping pings the underlying storage if it supports pinging
+func (st *SpoolStorage) Ping() error {
+	pinger, ok := st.Storage.(storage.Pinger)
+	if !ok {
+		return storage.ErrPingNotSupported
+	}
+
+	return pinger.Ping()
+}
+
+// Depth returns the number of spooled writes waiting for replay
+func (st *SpoolStorage) Depth() int {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	return st.depth
+}
+
+// Run replays the spool every interval until ctx is done
+func (st *SpoolStorage) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if st.Depth() == 0 {
+				continue
+			}
+			if err := st.Replay(ctx); err != nil {
+				logger.Warningf("SpoolStorage: replay stopped: %v", err)
+			}
+		}
+	}
+}
+
+// Replay applies spooled writes in order until the underlying storage
+// fails, the applied writes are removed from the spool. Conflicting
+// writes are dropped, since the short id is already taken
+// The spool is only locked to take the writes and to remove the applied
+// ones, writes spooled meanwhile are kept for the next replay
+func (st *SpoolStorage) Replay(ctx context.Context) error {
+	st.replayMu.Lock()
+	defer st.replayMu.Unlock()
+
+	st.mu.Lock()
+	if st.file == nil {
+		st.mu.Unlock()
+		return nil
+	}
+	records, err := st.readRecords()
+	st.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	applied := 0
+	var replayErr error
+	for _, rec := range records {
+		if replayErr = st.apply(ctx, rec); replayErr != nil {
+			break
+		}
+		applied++
+	}
+	if applied == 0 {
+		return replayErr
+	}
+
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	if st.file == nil {
+		return replayErr
+	}
+	// the spool is append-only, so the writes taken are still its head
+	records, err = st.readRecords()
+	if err != nil {
+		return err
+	}
+	left := records[:0]
+	if applied < len(records) {
+		left = records[applied:]
+	}
+	if err := st.rewrite(left); err != nil {
+		return err
+	}
+	st.pending = make(map[string]string, len(st.pending))
+	for _, rec := range left {
+		st.track(rec)
+	}
+	st.setDepth(len(left))
+	metrics.Add("replayed", int64(applied))
+	logger.Infof("SpoolStorage: %d spooled writes replayed, %d left", applied, len(left))
+
+	return replayErr
+}
+
+func (st *SpoolStorage) Close() error {
+	st.mu.Lock()
+	if st.file != nil {
+		if err := st.file.Close(); err != nil {
+			logger.Warningf("SpoolStorage: %v", err)
+		}
+		st.file = nil
+	}
+	st.mu.Unlock()
+
+	return st.Storage.Close()
+}
+
+// apply writes rec to the underlying storage, conflicts and exceeded
+// quotas are not errors
+func (st *SpoolStorage) apply(ctx context.Context, rec record) error {
+	if rec.Batch {
+		batch := make([]url.BatchURLEntry, 0, len(rec.Entries))
+		for _, ue := range rec.Entries {
+			batch = append(batch, url.BatchURLEntry{ShortURL: ue.ShortURL, OriginalURL: ue.OriginalURL})
+		}
+		err := st.Storage.AddBatchURL(ctx, batch, rec.UserID)
+		if isQuotaExceeded(err) {
+			logger.Infof("SpoolStorage: spooled batch of user %s exceeds the quota, dropped", rec.UserID)
+			metrics.Add("quota_exceeded", 1)
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		// existing ids are skipped by batches
+		for _, ue := range rec.Entries {
+			if err := st.checkStored(ctx, ue); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	for _, ue := range rec.Entries {
+		err := st.Storage.AddURL(ctx, ue, rec.UserID)
+		var conflictError *storage.IDConflictError
+		if errors.As(err, &conflictError) {
+			logger.Infof("SpoolStorage: spooled id %s already exists, dropped", ue.ShortURL)
+			metrics.Add("conflicts", 1)
+			if err := st.checkStored(ctx, ue); err != nil {
+				return err
+			}
+			continue
+		}
+		if isQuotaExceeded(err) {
+			logger.Infof("SpoolStorage: spooled id %s exceeds the quota of user %s, dropped", ue.ShortURL, rec.UserID)
+			metrics.Add("quota_exceeded", 1)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// checkStored logs an error if the id of the spooled ue points to another
+// url, deleted urls are not checked
+func (st *SpoolStorage) checkStored(ctx context.Context, ue url.URLEntry) error {
+	stored, err := st.Storage.GetURL(ctx, ue.ShortURL)
+	var deletedError *storage.URLDeletedError
+	if errors.As(err, &deletedError) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if stored != ue.OriginalURL {
+		logger.Errorf("SpoolStorage: spooled id %s for %s is taken by %s, the short url given is lost",
+			ue.ShortURL, ue.OriginalURL, stored)
+		metrics.Add("lost", 1)
+	}
+
+	return nil
+}
+
+func isQuotaExceeded(err error) bool {
+	var quotaExceededError *storage.QuotaExceededError
+
+	return errors.As(err, &quotaExceededError)
+}
+
+// spool durably appends rec to the spool file, cause is returned if
+// spooling failed
+func (st *SpoolStorage) spool(rec record, cause error) error {
+	line, err := json.Marshal(rec)
+	if err != nil {
+		return cause
+	}
+
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	if st.file == nil {
+		return cause
+	}
+
+	if _, err := st.file.Write(append(line, '\n')); err != nil {
+		logger.Errorf("SpoolStorage: failed to spool a write: %v", err)
+		return cause
+	}
+	if err := st.file.Sync(); err != nil {
+		logger.Errorf("SpoolStorage: failed to spool a write: %v", err)
+		return cause
+	}
+
+	st.track(rec)
+	st.setDepth(st.depth + 1)
+	logger.Debugf("SpoolStorage: write spooled: %v", cause)
+
+	return nil
+}
+
+func (st *SpoolStorage) readRecords() ([]record, error) {
+	if _, err := st.file.Seek(0, 0); err != nil {
+		return nil, err
+	}
+
+	var records []record
+	scanner := bufio.NewScanner(st.file)
+	scanner.Buffer(nil, 1<<24) // a batch may be long
+	for scanner.Scan() {
+		var rec record
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			// most likely a torn write, nothing was acknowledged for it
+			logger.Warningf("SpoolStorage: skip broken record: %v", err)
+			continue
+		}
+		records = append(records, rec)
+	}
+
+	return records, scanner.Err()
+}
+
+// rewrite atomically replaces the spool file content with records
+func (st *SpoolStorage) rewrite(records []record) error {
+	name := st.file.Name()
+	tmp, err := os.OpenFile(name+".tmp", os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0660)
+	if err != nil {
+		return err
+	}
+
+	w := bufio.NewWriter(tmp)
+	enc := json.NewEncoder(w)
+	for _, rec := range records {
+		if err := enc.Encode(rec); err != nil {
+			tmp.Close()
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Rename(name+".tmp", name); err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(name, os.O_RDWR|os.O_APPEND, 0660)
+	if err != nil {
+		return err
+	}
+	st.file.Close()
+	st.file = f
+
+	return nil
+}
+
+func (st *SpoolStorage) track(rec record) {
+	for _, ue := range rec.Entries {
+		st.pending[ue.ShortURL] = ue.OriginalURL
+	}
+}
+
+func (st *SpoolStorage) setDepth(depth int) {
+	st.depth = depth
+	d := new(expvar.Int)
+	d.Set(int64(depth))
+	metrics.Set("depth", d)
+}
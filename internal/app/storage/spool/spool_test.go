@@ -0,0 +1,245 @@
+package spool_test
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"testing"
+	"time"
+
+	"github.com/sbxb/shorty/internal/app/storage"
+	"github.com/sbxb/shorty/internal/app/storage/inmemory"
+	"github.com/sbxb/shorty/internal/app/storage/spool"
+	"github.com/sbxb/shorty/internal/app/url"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var errDown = errors.New("connection refused")
+
+func isDown(err error) bool {
+	return errors.Is(err, errDown)
+}
+
+// flakyStorage fails every write while down is true
+type flakyStorage struct {
+	*inmemory.MapStorage
+	down bool
+}
+
+func (st *flakyStorage) AddURL(ctx context.Context, ue url.URLEntry, userID string) error {
+	if st.down {
+		return errDown
+	}
+	return st.MapStorage.AddURL(ctx, ue, userID)
+}
+
+func (st *flakyStorage) AddBatchURL(ctx context.Context, batch []url.BatchURLEntry, userID string) error {
+	if st.down {
+		return errDown
+	}
+	return st.MapStorage.AddBatchURL(ctx, batch, userID)
+}
+
+var (
+	single = url.URLEntry{
+		ShortURL:    "5agFZWrIb6Ej21QvYUNBL3",
+		OriginalURL: "http://example.com",
+	}
+	batch = []url.BatchURLEntry{
+		{
+			CorrelationID: "456",
+			ShortURL:      "6EH6vwAy9dOyyNbopTS6M4",
+			OriginalURL:   "http://example.org",
+		},
+	}
+)
+
+func TestSpoolStorage_Spool_Then_Replay(t *testing.T) {
+	ctx := context.Background()
+	tmpFileName := t.TempDir() + "/" + "spool.jsonl"
+
+	ms, _ := inmemory.NewMapStorage() // NewMapStorage() never returns non-nil error
+	flaky := &flakyStorage{MapStorage: ms, down: true}
+
+	store, err := spool.New(flaky, tmpFileName, isDown)
+	require.NoError(t, err)
+
+	require.NoError(t, store.AddURL(ctx, single, "user"))
+	require.NoError(t, store.AddBatchURL(ctx, batch, "user"))
+	assert.Equal(t, store.Depth(), 2)
+
+	// spooled urls are served before replay
+	urlReturned, err := store.GetURL(ctx, single.ShortURL)
+	require.NoError(t, err)
+	assert.Equal(t, urlReturned, single.OriginalURL)
+
+	// still down, nothing is replayed
+	require.ErrorIs(t, store.Replay(ctx), errDown)
+	assert.Equal(t, store.Depth(), 2)
+
+	flaky.down = false
+	require.NoError(t, store.Replay(ctx))
+	assert.Equal(t, store.Depth(), 0)
+
+	urls, _ := ms.GetUserURLs(ctx, "user")
+	assert.Len(t, urls, 2)
+
+	store.Close()
+}
+
+func lost() int64 {
+	if v, ok := expvar.Get("spool").(*expvar.Map).Get("lost").(*expvar.Int); ok {
+		return v.Value()
+	}
+	return 0
+}
+
+func TestSpoolStorage_Replay_Counts_Taken_IDs(t *testing.T) {
+	ctx := context.Background()
+	tmpFileName := t.TempDir() + "/" + "spool.jsonl"
+
+	ms, _ := inmemory.NewMapStorage() // NewMapStorage() never returns non-nil error
+	flaky := &flakyStorage{MapStorage: ms, down: true}
+
+	store, err := spool.New(flaky, tmpFileName, isDown)
+	require.NoError(t, err)
+
+	require.NoError(t, store.AddURL(ctx, single, "user"))
+	require.NoError(t, store.AddBatchURL(ctx, batch, "user"))
+
+	// the ids are taken by other urls while the spooled writes wait
+	other := single
+	other.OriginalURL = "http://example.net"
+	require.NoError(t, ms.AddURL(ctx, other, "other"))
+	otherBatch := []url.BatchURLEntry{batch[0]}
+	otherBatch[0].OriginalURL = "http://example.net"
+	require.NoError(t, ms.AddBatchURL(ctx, otherBatch, "other"))
+
+	before := lost()
+	flaky.down = false
+	require.NoError(t, store.Replay(ctx))
+	assert.Equal(t, store.Depth(), 0)
+	assert.Equal(t, lost()-before, int64(2))
+
+	store.Close()
+}
+
+func TestSpoolStorage_Survives_Restart(t *testing.T) {
+	ctx := context.Background()
+	tmpFileName := t.TempDir() + "/" + "spool.jsonl"
+
+	ms, _ := inmemory.NewMapStorage() // NewMapStorage() never returns non-nil error
+	flaky := &flakyStorage{MapStorage: ms, down: true}
+
+	store, err := spool.New(flaky, tmpFileName, isDown)
+	require.NoError(t, err)
+	require.NoError(t, store.AddURL(ctx, single, "user"))
+	store.Close()
+
+	// the same id is taken while the spool was waiting
+	ms, _ = inmemory.NewMapStorage()
+	require.NoError(t, ms.AddURL(ctx, single, "someone else"))
+
+	store, err = spool.New(ms, tmpFileName, isDown)
+	require.NoError(t, err)
+	assert.Equal(t, store.Depth(), 1)
+
+	// the conflicting write is dropped
+	require.NoError(t, store.Replay(ctx))
+	assert.Equal(t, store.Depth(), 0)
+
+	store.Close()
+}
+
+func TestSpoolStorage_Immediate_Write(t *testing.T) {
+	ctx := storage.WithImmediateWrite(context.Background())
+	tmpFileName := t.TempDir() + "/" + "spool.jsonl"
+
+	ms, _ := inmemory.NewMapStorage() // NewMapStorage() never returns non-nil error
+	flaky := &flakyStorage{MapStorage: ms, down: true}
+	store, err := spool.New(flaky, tmpFileName, isDown)
+	require.NoError(t, err)
+
+	require.ErrorIs(t, store.AddURL(ctx, single, "user"), errDown)
+	require.ErrorIs(t, store.AddBatchURL(ctx, batch, "user"), errDown)
+	assert.Equal(t, store.Depth(), 0)
+
+	store.Close()
+}
+
+func TestSpoolStorage_Passes_Other_Errors(t *testing.T) {
+	ctx := context.Background()
+	tmpFileName := t.TempDir() + "/" + "spool.jsonl"
+
+	ms, _ := inmemory.NewMapStorage() // NewMapStorage() never returns non-nil error
+	store, err := spool.New(ms, tmpFileName, isDown)
+	require.NoError(t, err)
+
+	require.NoError(t, store.AddURL(ctx, single, "user"))
+	require.Error(t, store.AddURL(ctx, single, "user"))
+	assert.Equal(t, store.Depth(), 0)
+
+	store.Close()
+}
+
+// gatedStorage blocks writes of the gated id until release is closed and
+// fails the others
+type gatedStorage struct {
+	*inmemory.MapStorage
+	gated   string
+	entered chan struct{}
+	release chan struct{}
+}
+
+func (st *gatedStorage) AddURL(ctx context.Context, ue url.URLEntry, userID string) error {
+	if ue.ShortURL != st.gated {
+		return errDown
+	}
+	close(st.entered)
+	<-st.release
+	return st.MapStorage.AddURL(ctx, ue, userID)
+}
+
+func TestSpoolStorage_Spools_During_Replay(t *testing.T) {
+	ctx := context.Background()
+	tmpFileName := t.TempDir() + "/" + "spool.jsonl"
+
+	ms, _ := inmemory.NewMapStorage() // NewMapStorage() never returns non-nil error
+	gated := &gatedStorage{MapStorage: ms, gated: single.ShortURL, entered: make(chan struct{}), release: make(chan struct{})}
+	flaky := &flakyStorage{MapStorage: ms, down: true}
+
+	store, err := spool.New(flaky, tmpFileName, isDown)
+	require.NoError(t, err)
+	require.NoError(t, store.AddURL(ctx, single, "user"))
+	store.Close()
+
+	store, err = spool.New(gated, tmpFileName, isDown)
+	require.NoError(t, err)
+	defer store.Close()
+
+	replayed := make(chan error)
+	go func() { replayed <- store.Replay(ctx) }()
+	<-gated.entered
+
+	// writes are spooled while the replay waits for the storage
+	other := url.URLEntry{ShortURL: "other", OriginalURL: "http://other.com"}
+	spooled := make(chan error)
+	go func() { spooled <- store.AddURL(ctx, other, "user") }()
+	select {
+	case err := <-spooled:
+		require.NoError(t, err)
+	case <-time.After(time.Second):
+		close(gated.release)
+		<-replayed
+		t.Fatal("spooling is blocked by the replay")
+	}
+
+	close(gated.release)
+	require.NoError(t, <-replayed)
+	assert.Equal(t, 1, store.Depth())
+	urlReturned, err := store.GetURL(ctx, other.ShortURL)
+	require.NoError(t, err)
+	assert.Equal(t, other.OriginalURL, urlReturned)
+}
@@ -0,0 +1,53 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// Statuses of transfers, only pending ones can be accepted or declined
+const (
+	TransferPending   = "pending"
+	TransferAccepted  = "accepted"
+	TransferDeclined  = "declined"
+	TransferCancelled = "cancelled"
+)
+
+// Transfer is an offer to hand urls over to another user or to a workspace,
+// exactly one of ToUserID and ToWorkspaceID is set. Transfers are kept once
+// resolved, so that they serve as an audit trail
+type Transfer struct {
+	ID            string
+	FromUserID    string
+	ToUserID      string
+	ToWorkspaceID string
+	URLIDs        []string
+	Status        string
+	CreatedAt     time.Time
+	// ResolvedBy and ResolvedAt are set once the transfer is not pending,
+	// Moved is the number of urls moved on acceptance
+	ResolvedBy string
+	ResolvedAt time.Time
+	Moved      int
+}
+
+// ErrTransferNotFound is returned for unknown, resolved and foreign transfers
+var ErrTransferNotFound = errors.New("transfer not found")
+
+// TransferStorage is implemented by storages which are able to hand urls
+// over to other users and workspaces
+type TransferStorage interface {
+	AddTransfer(ctx context.Context, transfer Transfer) error
+	// GetUserTransfers returns transfers sent by the user, sent to the user
+	// and sent to workspaces the user owns
+	GetUserTransfers(ctx context.Context, userID string) ([]Transfer, error)
+	// AcceptTransfer atomically makes the user the owner of the transfer's
+	// urls still owned by the sender, moving them into the workspace if it's
+	// sent to one. The user must be the receiver or an owner of the receiving
+	// workspace. The receiver's quota applies
+	AcceptTransfer(ctx context.Context, id string, userID string, at time.Time) (Transfer, error)
+	// DeclineTransfer lets the receiver decline the transfer or the sender
+	// cancel it
+	DeclineTransfer(ctx context.Context, id string, userID string, at time.Time) (Transfer, error)
+}
@@ -0,0 +1,35 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// User is a registered account, ID is the user id links are owned by
+type User struct {
+	ID           string
+	Login        string
+	PasswordHash string
+	CreatedAt    time.Time
+}
+
+// ErrUserNotFound is returned for unknown logins and user ids
+var ErrUserNotFound = errors.New("user not found")
+
+// ErrLoginTaken is returned when an account with the same login exists
+var ErrLoginTaken = errors.New("login already taken")
+
+// UserStorage is implemented by storages which are able to keep registered
+// accounts
+type UserStorage interface {
+	// AddUser returns ErrLoginTaken if the login is in use
+	AddUser(ctx context.Context, user User) error
+	// GetUserByLogin and GetUser return ErrUserNotFound for unknown users
+	GetUserByLogin(ctx context.Context, login string) (User, error)
+	GetUser(ctx context.Context, id string) (User, error)
+	// ClaimURLs moves all the links of fromUserID to toUserID and returns
+	// the number of links moved, nothing is moved if the quota of toUserID
+	// would be exceeded
+	ClaimURLs(ctx context.Context, fromUserID string, toUserID string) (int, error)
+}
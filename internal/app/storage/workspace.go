@@ -0,0 +1,86 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/sbxb/shorty/internal/app/url"
+)
+
+// Roles of workspace members: owners manage members, editors add and delete
+// links, viewers list them, every role is allowed everything the lower ones
+// are
+const (
+	WorkspaceViewer = "viewer"
+	WorkspaceEditor = "editor"
+	WorkspaceOwner  = "owner"
+)
+
+var workspaceRoleRanks = map[string]int{
+	WorkspaceViewer: 0,
+	WorkspaceEditor: 1,
+	WorkspaceOwner:  2,
+}
+
+// ValidWorkspaceRole reports whether role is known
+func ValidWorkspaceRole(role string) bool {
+	_, ok := workspaceRoleRanks[role]
+	return ok
+}
+
+// WorkspaceRoleAllows reports whether role grants what required does,
+// unknown roles (non-members included) are granted nothing
+func WorkspaceRoleAllows(role, required string) bool {
+	rank, ok := workspaceRoleRanks[role]
+	if !ok {
+		return false
+	}
+
+	return rank >= workspaceRoleRanks[required]
+}
+
+// Workspace is a team sharing links, the links are still counted against
+// the quota of the members who created them
+type Workspace struct {
+	ID        string
+	Name      string
+	CreatedAt time.Time
+}
+
+// WorkspaceMember is a user's membership in a workspace
+type WorkspaceMember struct {
+	WorkspaceID string
+	UserID      string
+	Role        string
+}
+
+// UserWorkspace is a workspace as seen by one of its members
+type UserWorkspace struct {
+	Workspace
+	Role string
+}
+
+// ErrWorkspaceNotFound is returned for unknown workspaces
+var ErrWorkspaceNotFound = errors.New("workspace not found")
+
+// WorkspaceStorage is implemented by storages which are able to share links
+// within workspaces, their DeleteBatch lets owners and editors of the
+// workspace a url belongs to delete the url
+type WorkspaceStorage interface {
+	// AddWorkspace creates ws with ownerID as its only member
+	AddWorkspace(ctx context.Context, ws Workspace, ownerID string) error
+	// GetWorkspace returns ErrWorkspaceNotFound for unknown ids
+	GetWorkspace(ctx context.Context, id string) (Workspace, error)
+	GetUserWorkspaces(ctx context.Context, userID string) ([]UserWorkspace, error)
+	// GetWorkspaceRole returns an empty string for non-members
+	GetWorkspaceRole(ctx context.Context, id string, userID string) (string, error)
+	GetWorkspaceMembers(ctx context.Context, id string) ([]WorkspaceMember, error)
+	// SetWorkspaceMember adds the member or changes their role
+	SetWorkspaceMember(ctx context.Context, member WorkspaceMember) error
+	RemoveWorkspaceMember(ctx context.Context, id string, userID string) error
+	// AddURLsToWorkspace moves urls created by the user into the workspace,
+	// returns the number of urls moved
+	AddURLsToWorkspace(ctx context.Context, id string, ids []string, userID string) (int, error)
+	GetWorkspaceURLs(ctx context.Context, id string) ([]url.URLEntry, error)
+}
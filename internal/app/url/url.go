@@ -5,19 +5,203 @@ import (
 	"encoding/hex"
 	"math/big"
 	"strings"
+	"time"
 )
 
 type URLRequest struct {
 	URL string `json:"url"`
+	// Password (if any) is asked for before redirecting
+	Password string `json:"password,omitempty"`
+	// Title, Notes and Tags describe the url for its owner
+	Title string   `json:"title,omitempty"`
+	Notes string   `json:"notes,omitempty"`
+	Tags  []string `json:"tags,omitempty"`
 }
 
+// URLResponse carries the short url, Warning tells what was not stored
+// along with it (if anything)
 type URLResponse struct {
-	Result string `json:"result"`
+	Result  string `json:"result"`
+	Warning string `json:"warning,omitempty"`
+}
+
+// ErrorResponse is a JSON error, Limit is set when a limit is exceeded
+type ErrorResponse struct {
+	Error string `json:"error"`
+	Limit int    `json:"limit,omitempty"`
+}
+
+// QuotaResponse reports the user's quota and usage, zero limits mean
+// no limit
+type QuotaResponse struct {
+	MaxLinks     int `json:"max_links"`
+	ActiveLinks  int `json:"active_links"`
+	MaxBatchSize int `json:"max_batch_size"`
+}
+
+// TokenRequest asks for a bearer token with Scopes (all by default) valid
+// for ExpiresIn seconds (the maximum by default)
+type TokenRequest struct {
+	Scopes    []string `json:"scopes"`
+	ExpiresIn int64    `json:"expires_in"`
+}
+
+type TokenResponse struct {
+	Token     string   `json:"token"`
+	TokenType string   `json:"token_type"`
+	ExpiresIn int64    `json:"expires_in"`
+	Scopes    []string `json:"scopes"`
+}
+
+type APIKeyRequest struct {
+	Name string `json:"name"`
+}
+
+// APIKeyResponse describes a user's API key, Key is only returned once
+// on creation, LastUsedAt is omitted for keys never used
+type APIKeyResponse struct {
+	ID         string     `json:"id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	Key        string     `json:"key,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	Uses       int64      `json:"uses"`
+}
+
+// AccountRequest is used to sign up and log in, Claim moves the links of
+// the current anonymous user to the account
+type AccountRequest struct {
+	Login    string `json:"login"`
+	Password string `json:"password"`
+	Claim    bool   `json:"claim"`
+}
+
+// AccountResponse reports the account logged in and the number of links
+// claimed
+type AccountResponse struct {
+	Login   string `json:"login"`
+	Claimed int    `json:"claimed"`
+}
+
+// RecoveryResponse carries a recovery code, RecoveryRequest redeems it
+type RecoveryResponse struct {
+	Code string `json:"code"`
+}
+
+type RecoveryRequest struct {
+	Code string `json:"code"`
+}
+
+// StatsResponse describes the whole storage for admins
+type StatsResponse struct {
+	URLs        int `json:"urls"`
+	DeletedURLs int `json:"deleted_urls"`
+	Users       int `json:"users"`
+}
+
+type DisableResponse struct {
+	Disabled int `json:"disabled"`
+}
+
+type RoleRequest struct {
+	Role string `json:"role"`
+}
+
+type WorkspaceRequest struct {
+	Name string `json:"name"`
+}
+
+// WorkspaceResponse describes a workspace along with the role of the user
+// in it
+type WorkspaceResponse struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	Role      string    `json:"role"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type WorkspaceMemberResponse struct {
+	UserID string `json:"user_id"`
+	Role   string `json:"role"`
+}
+
+// TransferRequest offers urls to either a user or a workspace
+type TransferRequest struct {
+	URLs        []string `json:"urls"`
+	ToUser      string   `json:"to_user,omitempty"`
+	ToWorkspace string   `json:"to_workspace,omitempty"`
+}
+
+// TransferResponse describes a transfer, resolution fields are omitted
+// while it's pending
+type TransferResponse struct {
+	ID          string     `json:"id"`
+	FromUser    string     `json:"from_user"`
+	ToUser      string     `json:"to_user,omitempty"`
+	ToWorkspace string     `json:"to_workspace,omitempty"`
+	URLs        []string   `json:"urls"`
+	Status      string     `json:"status"`
+	CreatedAt   time.Time  `json:"created_at"`
+	ResolvedBy  string     `json:"resolved_by,omitempty"`
+	ResolvedAt  *time.Time `json:"resolved_at,omitempty"`
+	Moved       int        `json:"moved"`
+}
+
+// ACL restricts who is redirected by a url, it's both the request and
+// the response of /api/user/urls/{id}/acl
+type ACL struct {
+	Visibility string   `json:"visibility"`
+	Users      []string `json:"users,omitempty"`
+	Workspace  string   `json:"workspace,omitempty"`
+}
+
+// URLUpdateRequest changes the destination and the description of a url,
+// fields left out are not changed
+type URLUpdateRequest struct {
+	OriginalURL string    `json:"original_url,omitempty"`
+	Title       *string   `json:"title,omitempty"`
+	Notes       *string   `json:"notes,omitempty"`
+	Tags        *[]string `json:"tags,omitempty"`
+}
+
+// RollbackRequest points a url back to one of its versions
+type RollbackRequest struct {
+	Version int `json:"version"`
+}
+
+// URLVersionResponse describes a version of a url, the current one has no
+// replaced_at
+type URLVersionResponse struct {
+	Version     int        `json:"version"`
+	OriginalURL string     `json:"original_url"`
+	ReplacedAt  *time.Time `json:"replaced_at,omitempty"`
+}
+
+// URLUpdateResponse describes a url after it changed, the destination and
+// its version are only given if the destination changed, the description
+// if it changed
+type URLUpdateResponse struct {
+	ShortURL    string   `json:"short_url"`
+	OriginalURL string   `json:"original_url,omitempty"`
+	Version     int      `json:"version,omitempty"`
+	Title       string   `json:"title,omitempty"`
+	Notes       string   `json:"notes,omitempty"`
+	Tags        []string `json:"tags,omitempty"`
+}
+
+// TagResponse is the number of the user's urls tagged with Tag
+type TagResponse struct {
+	Tag   string `json:"tag"`
+	Count int    `json:"count"`
 }
 
 type URLEntry struct {
-	ShortURL    string `json:"short_url"`
-	OriginalURL string `json:"original_url"`
+	ShortURL    string   `json:"short_url"`
+	OriginalURL string   `json:"original_url"`
+	Title       string   `json:"title,omitempty"`
+	Notes       string   `json:"notes,omitempty"`
+	Tags        []string `json:"tags,omitempty"`
 }
 
 type BatchURLRequestEntry struct {